@@ -2,14 +2,29 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	usergrpc "web_backend_project/internal/delivery/grpc"
+	"web_backend_project/internal/domain"
+	"web_backend_project/pkg/apikey"
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+	"web_backend_project/pkg/classroom"
+	"web_backend_project/pkg/session"
 	pb "web_backend_project/proto"
+	"web_backend_project/transaction"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 type Server struct {
@@ -17,10 +32,14 @@ type Server struct {
 	pb.UnimplementedTransactionServiceServer
 	pb.UnimplementedUserServiceServer
 	pb.UnimplementedNotificationServiceServer
+
+	users      domain.UserUseCase
+	sessions   *session.Service
+	classrooms *classroom.Service
 }
 
-func NewServer() *Server {
-	return &Server{}
+func NewServer(users domain.UserUseCase, sessions *session.Service, classrooms *classroom.Service) *Server {
+	return &Server{users: users, sessions: sessions, classrooms: classrooms}
 }
 
 // Quiz Service Implementation
@@ -38,6 +57,10 @@ func (s *Server) CreateQuiz(ctx context.Context, req *pb.CreateQuizRequest) (*pb
 }
 
 func (s *Server) GetQuiz(ctx context.Context, req *pb.GetQuizRequest) (*pb.QuizResponse, error) {
+	// Квиз, выданный классам, открывают только их учителя и ученики
+	if err := s.classrooms.CheckQuizAccess(ctx, req.Id); err != nil {
+		return nil, classroomStatus(err)
+	}
 	// TODO: Implement database operations
 	return &pb.QuizResponse{}, nil
 }
@@ -57,6 +80,45 @@ func (s *Server) ListQuizzes(ctx context.Context, req *pb.ListQuizzesRequest) (*
 	return &pb.ListQuizzesResponse{}, nil
 }
 
+// ListAssignments возвращает задания классов ученика с его результатами
+func (s *Server) ListAssignments(ctx context.Context, req *pb.ListAssignmentsRequest) (*pb.ListAssignmentsResponse, error) {
+	assignments, err := s.classrooms.StudentAssignments(ctx, req.StudentId)
+	if err != nil {
+		return nil, classroomStatus(err)
+	}
+	resp := &pb.ListAssignmentsResponse{Assignments: make([]*pb.Assignment, 0, len(assignments))}
+	for _, assignment := range assignments {
+		item := &pb.Assignment{
+			Id:            assignment.ID.Hex(),
+			ClassroomId:   assignment.ClassroomID.Hex(),
+			ClassroomName: assignment.ClassroomName,
+			QuizId:        assignment.QuizID,
+			Title:         assignment.Title,
+			DueAt:         assignment.DueAt.Format(time.RFC3339),
+			Submitted:     assignment.Submitted,
+			Score:         int32(assignment.Score),
+			Late:          assignment.Late,
+		}
+		if assignment.SubmittedAt != nil {
+			item.SubmittedAt = assignment.SubmittedAt.Format(time.RFC3339)
+		}
+		resp.Assignments = append(resp.Assignments, item)
+	}
+	return resp, nil
+}
+
+// classroomStatus переводит ошибки классов в коды gRPC
+func classroomStatus(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, auth.ErrForbidden), errors.Is(err, classroom.ErrNotMember):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return fmt.Errorf("classroom error: %w", err)
+	}
+}
+
 // Transaction Service Implementation
 func (s *Server) CreateTransaction(ctx context.Context, req *pb.CreateTransactionRequest) (*pb.TransactionResponse, error) {
 	// TODO: Implement database operations
@@ -92,6 +154,30 @@ func (s *Server) ListTransactions(ctx context.Context, req *pb.ListTransactionsR
 	return &pb.ListTransactionsResponse{}, nil
 }
 
+// HasEntitlement проверяет право пользователя на продукт. Узнать о чужих
+// правах могут только обладатели права transactions.read и ключи API с ним.
+func (s *Server) HasEntitlement(ctx context.Context, req *pb.HasEntitlementRequest) (*pb.HasEntitlementResponse, error) {
+	claims := auth.FromContext(ctx)
+	if claims == nil {
+		return nil, status.Error(codes.Unauthenticated, auth.ErrUnauthenticated.Error())
+	}
+	self := !claims.IsAPIKey() && claims.UserID != "" && claims.UserID == req.UserId
+	if !self && !claims.Can(auth.PermTransactionsRead) {
+		return nil, status.Error(codes.PermissionDenied, auth.ErrForbidden.Error())
+	}
+
+	entitlement, err := transaction.ActiveEntitlement(ctx, req.UserId, req.Entitlement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check entitlement: %v", err)
+	}
+
+	resp := &pb.HasEntitlementResponse{HasEntitlement: entitlement != nil}
+	if entitlement != nil && entitlement.ExpiresAt != nil {
+		resp.ExpiresAt = entitlement.ExpiresAt.Format(time.RFC3339)
+	}
+	return resp, nil
+}
+
 // User Service Implementation
 func (s *Server) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.UserResponse, error) {
 	// TODO: Implement database operations
@@ -127,9 +213,139 @@ func (s *Server) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.L
 	return &pb.ListUsersResponse{}, nil
 }
 
+// AuthenticateUser открывает сессию и выдает access- и refresh-токены.
+// Пользователю с 2FA сначала возвращается challenge; второй вызов передает
+// его вместе с кодом.
 func (s *Server) AuthenticateUser(ctx context.Context, req *pb.AuthenticateUserRequest) (*pb.AuthenticateUserResponse, error) {
-	// TODO: Implement authentication logic
-	return &pb.AuthenticateUserResponse{}, nil
+	var tokens *session.Tokens
+	var err error
+	switch {
+	case req.Challenge != "":
+		if req.Code == "" {
+			return nil, status.Error(codes.InvalidArgument, "code is required")
+		}
+		tokens, err = s.sessions.CompleteLogin(ctx, req.Challenge, req.Code, clientFromContext(ctx))
+	case req.Username != "" && req.Password != "":
+		tokens, err = s.sessions.Login(ctx, req.Username, req.Password, clientFromContext(ctx))
+	default:
+		return nil, status.Error(codes.InvalidArgument, "username and password are required")
+	}
+	if errors.Is(err, session.ErrTooManyAttempts) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if errors.Is(err, session.ErrInvalidCredentials) || errors.Is(err, session.ErrInvalidChallenge) || errors.Is(err, session.ErrInvalidSecondFactor) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate: %v", err)
+	}
+	return s.tokensResponse(ctx, tokens)
+}
+
+// RefreshToken меняет refresh-токен на новую пару токенов
+func (s *Server) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.AuthenticateUserResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh token is required")
+	}
+
+	tokens, err := s.sessions.Refresh(ctx, req.RefreshToken, clientFromContext(ctx))
+	if errors.Is(err, session.ErrInvalidRefreshToken) || errors.Is(err, session.ErrTokenReused) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh session: %v", err)
+	}
+	return s.tokensResponse(ctx, tokens)
+}
+
+// ImpersonateUser выдает администратору токен от имени пользователя через
+// ту же выдачу токенов, что и AuthenticateUser; refresh-токена нет
+func (s *Server) ImpersonateUser(ctx context.Context, req *pb.ImpersonateUserRequest) (*pb.AuthenticateUserResponse, error) {
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	tokens, err := s.sessions.Impersonate(ctx, req.UserId, req.Reason, clientFromContext(ctx))
+	if err != nil {
+		return nil, impersonationStatus(err, "failed to impersonate user")
+	}
+	return s.tokensResponse(ctx, tokens)
+}
+
+// EndImpersonation закрывает сессию имперсонации текущего токена
+func (s *Server) EndImpersonation(ctx context.Context, req *pb.EndImpersonationRequest) (*pb.EndImpersonationResponse, error) {
+	if err := s.sessions.EndImpersonation(ctx); err != nil {
+		return nil, impersonationStatus(err, "failed to end impersonation")
+	}
+	return &pb.EndImpersonationResponse{Success: true}, nil
+}
+
+// impersonationStatus переводит ошибки имперсонации в коды gRPC
+func impersonationStatus(err error, message string) error {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, auth.ErrForbidden), errors.Is(err, session.ErrCannotImpersonate):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, session.ErrUserNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, session.ErrImpersonationReason), errors.Is(err, session.ErrNotImpersonating), errors.Is(err, session.ErrSessionNotFound):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return fmt.Errorf("%s: %v", message, err)
+	}
+}
+
+// tokensResponse дополняет токены профилем пользователя
+func (s *Server) tokensResponse(ctx context.Context, tokens *session.Tokens) (*pb.AuthenticateUserResponse, error) {
+	resp := &pb.AuthenticateUserResponse{
+		Token:                  tokens.AccessToken,
+		RefreshToken:           tokens.RefreshToken,
+		ExpiresAt:              tokens.ExpiresAt.Format(time.RFC3339),
+		SessionId:              tokens.SessionID,
+		SecondFactorRequired:   tokens.SecondFactorRequired,
+		Challenge:              tokens.Challenge,
+		TwoFactorSetupRequired: tokens.SetupRequired,
+	}
+	if tokens.SecondFactorRequired {
+		return resp, nil
+	}
+	id, err := primitive.ObjectIDFromHex(tokens.UserID)
+	if err != nil {
+		return resp, nil
+	}
+	if user, err := s.users.GetUserByID(auth.WithSystem(ctx), id); err == nil && user != nil {
+		resp.User = &pb.User{
+			Id:         user.ID.Hex(),
+			Username:   user.Username,
+			Email:      user.Email,
+			FirstName:  user.FirstName,
+			LastName:   user.LastName,
+			CreatedAt:  user.CreatedAt.Format(time.RFC3339),
+			UpdatedAt:  user.UpdatedAt.Format(time.RFC3339),
+			Role:       user.Role,
+			Age:        int32(user.Age),
+			Gender:     user.Gender,
+			IsVerified: user.IsVerified,
+		}
+	}
+	return resp, nil
+}
+
+// clientFromContext определяет устройство и IP gRPC-клиента
+func clientFromContext(ctx context.Context) session.Client {
+	var client session.Client
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if agent := md.Get("user-agent"); len(agent) > 0 {
+			client.Device = agent[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		client.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(client.IP); err == nil {
+			client.IP = host
+		}
+	}
+	return client
 }
 
 // Notification Service Implementation
@@ -164,17 +380,64 @@ func (s *Server) DeleteNotification(ctx context.Context, req *pb.DeleteNotificat
 	return &pb.DeleteNotificationResponse{Success: true}, nil
 }
 
-func StartGRPCServer(port int) error {
+// methodScopes — права ключей API на методы; NotificationService ключам недоступен,
+// вход и обновление токенов ключу не нужны
+var methodScopes = map[string]string{
+	pb.QuizService_CreateQuiz_FullMethodName:               apikey.ScopeQuizzesWrite,
+	pb.QuizService_GetQuiz_FullMethodName:                  apikey.ScopeQuizzesRead,
+	pb.QuizService_UpdateQuiz_FullMethodName:               apikey.ScopeQuizzesWrite,
+	pb.QuizService_DeleteQuiz_FullMethodName:               apikey.ScopeQuizzesWrite,
+	pb.QuizService_ListQuizzes_FullMethodName:              apikey.ScopeQuizzesRead,
+	pb.QuizService_ListAssignments_FullMethodName:          apikey.ScopeQuizzesRead,
+	pb.TransactionService_CreateTransaction_FullMethodName: apikey.ScopeTransactionsWrite,
+	pb.TransactionService_GetTransaction_FullMethodName:    apikey.ScopeTransactionsRead,
+	pb.TransactionService_UpdateTransaction_FullMethodName: apikey.ScopeTransactionsWrite,
+	pb.TransactionService_DeleteTransaction_FullMethodName: apikey.ScopeTransactionsWrite,
+	pb.TransactionService_ListTransactions_FullMethodName:  apikey.ScopeTransactionsRead,
+	pb.TransactionService_HasEntitlement_FullMethodName:    apikey.ScopeTransactionsRead,
+	pb.UserService_CreateUser_FullMethodName:               apikey.ScopeUsersWrite,
+	pb.UserService_GetUser_FullMethodName:                  apikey.ScopeUsersRead,
+	pb.UserService_UpdateUser_FullMethodName:               apikey.ScopeUsersWrite,
+	pb.UserService_DeleteUser_FullMethodName:               apikey.ScopeUsersWrite,
+	pb.UserService_ListUsers_FullMethodName:                apikey.ScopeUsersRead,
+}
+
+// auditInterceptor передает в журнал аудита автора, проверенного
+// auth.UnaryServerInterceptor; при имперсонации это администратор
+func auditInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	meta := audit.Meta{Actor: "anonymous", Source: audit.SourceGRPC, RequestID: audit.NewRequestID()}
+	if claims := auth.FromContext(ctx); claims != nil && claims.UserID != "" {
+		meta.Authenticated(claims)
+	}
+	return handler(audit.WithMeta(ctx, meta), req)
+}
+
+func StartGRPCServer(port int, users domain.UserUseCase, sessions *session.Service, classrooms *classroom.Service) error {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return fmt.Errorf("failed to listen: %v", err)
 	}
 
-	server := grpc.NewServer()
-	pb.RegisterQuizServiceServer(server, NewServer())
-	pb.RegisterTransactionServiceServer(server, NewServer())
-	pb.RegisterUserServiceServer(server, NewServer())
-	pb.RegisterNotificationServiceServer(server, NewServer())
+	// Платежи недоступны токенам имперсонации
+	auth.BlockWhileImpersonating(
+		pb.TransactionService_CreateTransaction_FullMethodName,
+		pb.TransactionService_UpdateTransaction_FullMethodName,
+	)
+	// user.UserService (FieldMask-обновление, подтверждение email, сброс
+	// пароля) работает на этом же сервере рядом с proto.UserService
+	scopes := map[string]string{}
+	for method, scope := range methodScopes {
+		scopes[method] = scope
+	}
+	for method, scope := range usergrpc.MethodScopes {
+		scopes[method] = scope
+	}
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(scopes), auditInterceptor))
+	pb.RegisterQuizServiceServer(server, NewServer(users, sessions, classrooms))
+	pb.RegisterTransactionServiceServer(server, NewServer(users, sessions, classrooms))
+	pb.RegisterUserServiceServer(server, NewServer(users, sessions, classrooms))
+	pb.RegisterNotificationServiceServer(server, NewServer(users, sessions, classrooms))
+	usergrpc.RegisterUserService(server, users)
 
 	log.Printf("Starting gRPC server on port %d", port)
 	if err := server.Serve(lis); err != nil {
@@ -2,15 +2,23 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 
 	"web_backend_project/internal/domain"
+	"web_backend_project/pkg/apikey"
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
 	pb "web_backend_project/web_backend_project/proto"
 )
 
@@ -25,10 +33,47 @@ type Server struct {
 func NewGRPCServer(userUseCase domain.UserUseCase) *Server {
 	return &Server{
 		userUseCase: userUseCase,
-		grpcServer:  grpc.NewServer(),
+		grpcServer: grpc.NewServer(grpc.ChainUnaryInterceptor(
+			auth.UnaryServerInterceptor(MethodScopes),
+			auditInterceptor,
+		)),
 	}
 }
 
+// MethodScopes — права ключей API на методы; подтверждение email и сброс
+// пароля выполняет сам пользователь, ключам они недоступны
+var MethodScopes = map[string]string{
+	pb.UserService_GetUsers_FullMethodName:   apikey.ScopeUsersRead,
+	pb.UserService_GetUser_FullMethodName:    apikey.ScopeUsersRead,
+	pb.UserService_CreateUser_FullMethodName: apikey.ScopeUsersWrite,
+	pb.UserService_UpdateUser_FullMethodName: apikey.ScopeUsersWrite,
+	pb.UserService_DeleteUser_FullMethodName: apikey.ScopeUsersWrite,
+}
+
+// auditInterceptor передает в журнал аудита автора, проверенного
+// auth.UnaryServerInterceptor, и идентификатор запроса из x-request-id
+func auditInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	meta := audit.Meta{Actor: "anonymous", Source: audit.SourceGRPC}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-request-id"); len(values) > 0 {
+			meta.RequestID = values[0]
+		}
+	}
+	if claims := auth.FromContext(ctx); claims != nil && claims.UserID != "" {
+		meta.Authenticated(claims)
+	}
+	if meta.RequestID == "" {
+		meta.RequestID = audit.NewRequestID()
+	}
+	return handler(audit.WithMeta(ctx, meta), req)
+}
+
+// RegisterUserService регистрирует user.UserService на уже настроенном
+// сервере; права ключей API для его методов — в MethodScopes
+func RegisterUserService(server grpc.ServiceRegistrar, userUseCase domain.UserUseCase) {
+	pb.RegisterUserServiceServer(server, &Server{userUseCase: userUseCase})
+}
+
 // Start запускает gRPC сервер на указанном порту
 func (s *Server) Start(port string) error {
 	lis, err := net.Listen("tcp", ":"+port)
@@ -54,6 +99,39 @@ func (s *Server) Stop() {
 	}
 }
 
+// toPBUser переводит пользователя в protobuf; секреты туда не попадают
+func toPBUser(user *domain.User) *pb.User {
+	return &pb.User{
+		Id:         user.ID.Hex(),
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		Username:   user.Username,
+		Email:      user.Email,
+		Role:       user.Role,
+		Age:        int32(user.Age),
+		Gender:     user.Gender,
+		IsVerified: user.IsVerified,
+		CreatedAt:  user.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:  user.UpdatedAt.Format(time.RFC3339),
+		Version:    user.Version,
+	}
+}
+
+func fromPBUser(user *pb.User) *domain.User {
+	if user == nil {
+		return &domain.User{}
+	}
+	// Роль и подтверждение адреса выставляет сервер
+	return &domain.User{
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Username:  user.Username,
+		Email:     user.Email,
+		Age:       int(user.Age),
+		Gender:    user.Gender,
+	}
+}
+
 // GetUsers обрабатывает запрос на получение списка пользователей
 func (s *Server) GetUsers(ctx context.Context, req *pb.GetUsersRequest) (*pb.GetUsersResponse, error) {
 	users, err := s.userUseCase.GetUsers(ctx, int(req.Page), int(req.Limit), req.Filter, req.SortBy, req.SortOrder)
@@ -62,14 +140,8 @@ func (s *Server) GetUsers(ctx context.Context, req *pb.GetUsersRequest) (*pb.Get
 	}
 
 	var pbUsers []*pb.User
-	for _, user := range users {
-		pbUsers = append(pbUsers, &pb.User{
-			Id:        user.ID.Hex(),
-			FirstName: user.FirstName,
-			LastName:  user.LastName,
-			Username:  user.Username,
-			Email:     user.Email,
-		})
+	for i := range users {
+		pbUsers = append(pbUsers, toPBUser(&users[i]))
 	}
 
 	return &pb.GetUsersResponse{
@@ -85,6 +157,9 @@ func (s *Server) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUs
 	}
 
 	user, err := s.userUseCase.GetUserByID(ctx, id)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error fetching user: %w", err)
 	}
@@ -94,24 +169,14 @@ func (s *Server) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUs
 	}
 
 	return &pb.GetUserResponse{
-		User: &pb.User{
-			Id:        user.ID.Hex(),
-			FirstName: user.FirstName,
-			LastName:  user.LastName,
-			Username:  user.Username,
-			Email:     user.Email,
-		},
+		User: toPBUser(user),
 	}, nil
 }
 
 // CreateUser обрабатывает запрос на создание пользователя
 func (s *Server) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.CreateUserResponse, error) {
-	user := &domain.User{
-		FirstName: req.User.FirstName,
-		LastName:  req.User.LastName,
-		Username:  req.User.Username,
-		Email:     req.User.Email,
-	}
+	user := fromPBUser(req.User)
+	user.Password = req.Password
 
 	id, err := s.userUseCase.CreateUser(ctx, user)
 	if err != nil {
@@ -123,27 +188,97 @@ func (s *Server) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb
 	}, nil
 }
 
-// UpdateUser обрабатывает запрос на обновление пользователя
+// maskFields переводит пути FieldMask в имена полей domain.UserPatch
+var maskFields = map[string]string{
+	"first_name": "firstName",
+	"last_name":  "lastName",
+	"username":   "username",
+	"email":      "email",
+	"password":   "password",
+	"age":        "age",
+	"gender":     "gender",
+}
+
+// userPatchFromRequest собирает patch по update_mask; без маски берутся все
+// заполненные поля
+func userPatchFromRequest(req *pb.UpdateUserRequest) (domain.UserPatch, error) {
+	user := req.User
+	if user == nil {
+		user = &pb.User{}
+	}
+	values := map[string]interface{}{
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"username":   user.Username,
+		"email":      user.Email,
+		"password":   req.Password,
+		"age":        user.Age,
+		"gender":     user.Gender,
+	}
+
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		for path, value := range values {
+			if value != "" && value != int32(0) {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	patch := domain.UserPatch{}
+	for _, path := range paths {
+		field, ok := maskFields[path]
+		if !ok {
+			return nil, fmt.Errorf("%w: field %q cannot be changed", domain.ErrInvalidPatch, path)
+		}
+		value := values[path]
+		if value == "" || value == int32(0) {
+			// Поле из маски без значения удаляется
+			value = nil
+		}
+		patch[field] = value
+	}
+	return patch, nil
+}
+
+// UpdateUser меняет только поля из update_mask и возвращает обновленного пользователя
 func (s *Server) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UpdateUserResponse, error) {
-	id, err := primitive.ObjectIDFromHex(req.User.Id)
+	id, err := primitive.ObjectIDFromHex(req.GetUser().GetId())
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+		return nil, status.Errorf(codes.InvalidArgument, "invalid user ID: %v", err)
 	}
 
-	user := &domain.User{
-		ID:        id,
-		FirstName: req.User.FirstName,
-		LastName:  req.User.LastName,
-		Username:  req.User.Username,
-		Email:     req.User.Email,
+	patch, err := userPatchFromRequest(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.CurrentPassword != "" {
+		patch[domain.CurrentPasswordField] = req.CurrentPassword
 	}
 
-	if err := s.userUseCase.UpdateUser(ctx, user); err != nil {
+	user, err := s.userUseCase.PatchUser(ctx, id, patch, req.ExpectedVersion)
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if errors.Is(err, auth.ErrForbidden) || errors.Is(err, auth.ErrImpersonationBlocked) || errors.Is(err, domain.ErrWrongPassword) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, domain.ErrVersionConflict) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, domain.ErrInvalidPatch) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
 		return nil, fmt.Errorf("error updating user: %w", err)
 	}
 
 	return &pb.UpdateUserResponse{
 		Success: true,
+		User:    toPBUser(user),
 	}, nil
 }
 
@@ -154,7 +289,20 @@ func (s *Server) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb
 		return nil, fmt.Errorf("invalid user ID: %w", err)
 	}
 
-	if err := s.userUseCase.DeleteUser(ctx, id); err != nil {
+	err = s.userUseCase.DeleteUser(ctx, id, req.ExpectedVersion)
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if errors.Is(err, auth.ErrForbidden) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, domain.ErrVersionConflict) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
 		return nil, fmt.Errorf("error deleting user: %w", err)
 	}
 
@@ -162,3 +310,90 @@ func (s *Server) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb
 		Success: true,
 	}, nil
 }
+
+// verificationStatus переводит ошибки подтверждения email в коды gRPC
+func verificationStatus(err error) error {
+	switch {
+	case errors.Is(err, domain.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, domain.ErrAlreadyVerified), errors.Is(err, domain.ErrOTPExpired):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, domain.ErrOTPCooldown), errors.Is(err, domain.ErrOTPLocked):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, domain.ErrInvalidOTP):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, domain.ErrVersionConflict):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return fmt.Errorf("error verifying email: %w", err)
+	}
+}
+
+// SendVerificationCode отправляет пользователю код подтверждения email
+func (s *Server) SendVerificationCode(ctx context.Context, req *pb.SendVerificationCodeRequest) (*pb.SendVerificationCodeResponse, error) {
+	if req.Email == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+
+	if err := s.userUseCase.SendVerificationCode(ctx, req.Email); err != nil {
+		return nil, verificationStatus(err)
+	}
+
+	return &pb.SendVerificationCodeResponse{
+		Success: true,
+	}, nil
+}
+
+// VerifyEmail подтверждает email кодом из письма
+func (s *Server) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*pb.VerifyEmailResponse, error) {
+	if req.Email == "" || req.Otp == "" {
+		return nil, status.Error(codes.InvalidArgument, "email and otp are required")
+	}
+
+	user, err := s.userUseCase.VerifyEmail(ctx, req.Email, req.Otp)
+	if err != nil {
+		return nil, verificationStatus(err)
+	}
+
+	return &pb.VerifyEmailResponse{
+		Success: true,
+		User:    toPBUser(user),
+	}, nil
+}
+
+// RequestPasswordReset отправляет ссылку для сброса пароля
+func (s *Server) RequestPasswordReset(ctx context.Context, req *pb.RequestPasswordResetRequest) (*pb.RequestPasswordResetResponse, error) {
+	if req.Email == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+
+	if err := s.userUseCase.RequestPasswordReset(ctx, req.Email); err != nil {
+		return nil, fmt.Errorf("error requesting password reset: %w", err)
+	}
+
+	return &pb.RequestPasswordResetResponse{
+		Success: true,
+	}, nil
+}
+
+// ResetPassword меняет пароль по токену из письма
+func (s *Server) ResetPassword(ctx context.Context, req *pb.ResetPasswordRequest) (*pb.ResetPasswordResponse, error) {
+	if req.Token == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "token and password are required")
+	}
+
+	err := s.userUseCase.ResetPassword(ctx, req.Token, req.Password)
+	if errors.Is(err, auth.ErrImpersonationBlocked) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, domain.ErrInvalidResetToken) || errors.Is(err, domain.ErrInvalidPatch) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error resetting password: %w", err)
+	}
+
+	return &pb.ResetPasswordResponse{
+		Success: true,
+	}, nil
+}
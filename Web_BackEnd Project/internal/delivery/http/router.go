@@ -45,6 +45,8 @@ func NewRouter(userHandler *UserHandler, emailHandler *EmailHandler) http.Handle
 		}
 	})
 
+	mux.HandleFunc("/users/patch", userHandler.PatchUser)
+
 	mux.HandleFunc("/users/delete", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodDelete {
 			userHandler.DeleteUser(w, r)
@@ -53,6 +55,20 @@ func NewRouter(userHandler *UserHandler, emailHandler *EmailHandler) http.Handle
 		}
 	})
 
+	mux.HandleFunc("/admin/users/trash", userHandler.ListDeletedUsers)
+	mux.HandleFunc("/admin/users/restore", userHandler.RestoreUser)
+
+	mux.HandleFunc("/users/verify/send", userHandler.SendVerificationCode)
+	mux.HandleFunc("/users/verify", userHandler.VerifyEmail)
+	mux.HandleFunc("/users/password/forgot", userHandler.RequestPasswordReset)
+	mux.HandleFunc("/users/password/reset", userHandler.ResetPassword)
+
+	mux.HandleFunc("/users/2fa/enroll", userHandler.BeginTwoFactorEnrollment)
+	mux.HandleFunc("/users/2fa/confirm", userHandler.ConfirmTwoFactorEnrollment)
+	mux.HandleFunc("/users/2fa/disable", userHandler.DisableTwoFactor)
+	mux.HandleFunc("/users/2fa/recovery-codes", userHandler.RegenerateRecoveryCodes)
+	mux.HandleFunc("/admin/users/2fa/reset", userHandler.ResetTwoFactor)
+
 	// Маршруты для email
 	mux.HandleFunc("/send-email", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
@@ -65,8 +81,9 @@ func NewRouter(userHandler *UserHandler, emailHandler *EmailHandler) http.Handle
 	// Настройка CORS
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "If-Match"},
+		ExposedHeaders:   []string{"ETag"},
 		AllowCredentials: true,
 	}).Handler(mux)
 
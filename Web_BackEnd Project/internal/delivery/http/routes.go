@@ -45,6 +45,8 @@ func SetupRoutes(userHandler *UserHandler) http.Handler {
 		}
 	})
 
+	mux.HandleFunc("/users/patch", userHandler.PatchUser)
+
 	mux.HandleFunc("/users/delete", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodDelete {
 			userHandler.DeleteUser(w, r)
@@ -53,11 +55,26 @@ func SetupRoutes(userHandler *UserHandler) http.Handler {
 		}
 	})
 
+	mux.HandleFunc("/admin/users/trash", userHandler.ListDeletedUsers)
+	mux.HandleFunc("/admin/users/restore", userHandler.RestoreUser)
+
+	mux.HandleFunc("/users/verify/send", userHandler.SendVerificationCode)
+	mux.HandleFunc("/users/verify", userHandler.VerifyEmail)
+	mux.HandleFunc("/users/password/forgot", userHandler.RequestPasswordReset)
+	mux.HandleFunc("/users/password/reset", userHandler.ResetPassword)
+
+	mux.HandleFunc("/users/2fa/enroll", userHandler.BeginTwoFactorEnrollment)
+	mux.HandleFunc("/users/2fa/confirm", userHandler.ConfirmTwoFactorEnrollment)
+	mux.HandleFunc("/users/2fa/disable", userHandler.DisableTwoFactor)
+	mux.HandleFunc("/users/2fa/recovery-codes", userHandler.RegenerateRecoveryCodes)
+	mux.HandleFunc("/admin/users/2fa/reset", userHandler.ResetTwoFactor)
+
 	// Configure CORS
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "If-Match"},
+		ExposedHeaders:   []string{"ETag"},
 		AllowCredentials: true,
 	}).Handler(mux)
 
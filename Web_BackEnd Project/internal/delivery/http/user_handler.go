@@ -1,16 +1,20 @@
 package http
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"web_backend_project/internal/domain"
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+	"web_backend_project/pkg/etag"
 )
 
 type UserHandler struct {
@@ -55,7 +59,11 @@ func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get users from use case
-	users, err := h.userUseCase.GetUsers(context.Background(), page, limit, filter, sortBy, sortOrder)
+	users, err := h.userUseCase.GetUsers(audit.FromHTTP(r), page, limit, filter, sortBy, sortOrder)
+	if errors.Is(err, auth.ErrUnauthenticated) || errors.Is(err, auth.ErrForbidden) {
+		writeWriteError(w, err, "fetching")
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error fetching users: %v", err), http.StatusInternalServerError)
 		return
@@ -78,7 +86,15 @@ func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.userUseCase.GetUserByID(context.Background(), id)
+	user, err := h.userUseCase.GetUserByID(audit.FromHTTP(r), id)
+	if errors.Is(err, auth.ErrUnauthenticated) || errors.Is(err, auth.ErrForbidden) {
+		writeWriteError(w, err, "fetching")
+		return
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error fetching user: %v", err), http.StatusInternalServerError)
 		return
@@ -89,10 +105,29 @@ func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	etag.Set(w, user.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
 
+// writeWriteError maps use case errors of a write to HTTP statuses
+func writeWriteError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrForbidden), errors.Is(err, auth.ErrImpersonationBlocked), errors.Is(err, domain.ErrWrongPassword):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, domain.ErrVersionConflict):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, domain.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, domain.ErrInvalidPatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, fmt.Sprintf("Error %s user: %v", action, err), http.StatusInternalServerError)
+	}
+}
+
 // CreateUser handles POST /users request
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -100,18 +135,13 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var userData map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&userData); err != nil {
+	var input domain.UserInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Convert map to domain.User
-	var user domain.User
-	userBytes, _ := json.Marshal(userData)
-	json.Unmarshal(userBytes, &user)
-
-	id, err := h.userUseCase.CreateUser(context.Background(), &user)
+	id, err := h.userUseCase.CreateUser(audit.FromHTTP(r), input.ToUser())
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error creating user: %v", err), http.StatusInternalServerError)
 		return
@@ -158,23 +188,77 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	expectedVersion, err := etag.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
 	// Convert map to domain.User
-	var user domain.User
+	var input domain.UserInput
 	userBytes, _ := json.Marshal(userData)
-	json.Unmarshal(userBytes, &user)
+	json.Unmarshal(userBytes, &input)
+	user := input.ToUser()
 	user.ID = id
 
-	if err := h.userUseCase.UpdateUser(context.Background(), &user); err != nil {
-		http.Error(w, fmt.Sprintf("Error updating user: %v", err), http.StatusInternalServerError)
+	updated, err := h.userUseCase.UpdateUser(audit.FromHTTP(r), user, expectedVersion)
+	if err != nil {
+		writeWriteError(w, err, "updating")
 		return
 	}
 
+	etag.Set(w, updated.Version)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "User updated successfully",
+		"version": updated.Version,
 	})
 }
 
+// PatchUser handles PATCH /users/patch?id= with a JSON Merge Patch body
+// (application/merge-patch+json) and returns the updated user. A password
+// change must also carry the current password in currentPassword.
+func (h *UserHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "application/merge-patch+json") && !strings.HasPrefix(contentType, "application/json") {
+		http.Error(w, "Content-Type must be application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, err := etag.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	var patch domain.UserPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Patch must be a JSON object", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userUseCase.PatchUser(audit.FromHTTP(r), id, patch, expectedVersion)
+	if err != nil {
+		writeWriteError(w, err, "updating")
+		return
+	}
+
+	etag.Set(w, user.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
 // DeleteUser handles DELETE /users request
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
@@ -189,8 +273,14 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.userUseCase.DeleteUser(context.Background(), id); err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting user: %v", err), http.StatusInternalServerError)
+	expectedVersion, err := etag.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	if err := h.userUseCase.DeleteUser(audit.FromHTTP(r), id, expectedVersion); err != nil {
+		writeWriteError(w, err, "deleting")
 		return
 	}
 
@@ -199,3 +289,362 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		"message": "User deleted successfully",
 	})
 }
+
+// ListDeletedUsers handles GET /admin/users/trash request
+func (h *UserHandler) ListDeletedUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	page, limit := 1, 20
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+		page = p
+	}
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	users, err := h.userUseCase.ListDeletedUsers(audit.FromHTTP(r), page, limit)
+	if errors.Is(err, auth.ErrUnauthenticated) || errors.Is(err, auth.ErrForbidden) {
+		writeWriteError(w, err, "listing")
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching deleted users: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// RestoreUser handles POST /admin/users/restore request
+func (h *UserHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userUseCase.RestoreUser(audit.FromHTTP(r), id)
+	if errors.Is(err, auth.ErrUnauthenticated) || errors.Is(err, auth.ErrForbidden) {
+		writeWriteError(w, err, "restoring")
+		return
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		http.Error(w, "User is not in the trash", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, domain.ErrUserPurging) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error restoring user: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	etag.Set(w, user.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// writeVerificationError maps email verification errors to HTTP statuses
+func writeVerificationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, domain.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, domain.ErrAlreadyVerified):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, domain.ErrOTPCooldown), errors.Is(err, domain.ErrOTPLocked):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, domain.ErrInvalidOTP), errors.Is(err, domain.ErrOTPExpired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, domain.ErrVersionConflict):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	default:
+		http.Error(w, fmt.Sprintf("Error verifying email: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// SendVerificationCode handles POST /users/verify/send request. The response
+// does not depend on whether the email is registered.
+func (h *UserHandler) SendVerificationCode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userUseCase.SendVerificationCode(audit.FromHTTP(r), input.Email); err != nil {
+		writeVerificationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "If the account exists and is not verified yet, a verification code has been sent"})
+}
+
+// VerifyEmail handles POST /users/verify request
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+		OTP   string `json:"otp"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Email == "" || input.OTP == "" {
+		http.Error(w, "Email and otp are required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userUseCase.VerifyEmail(audit.FromHTTP(r), input.Email, input.OTP)
+	if err != nil {
+		writeVerificationError(w, err)
+		return
+	}
+
+	etag.Set(w, user.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// RequestPasswordReset handles POST /users/password/forgot request.
+// The response does not reveal whether the email belongs to an account.
+func (h *UserHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userUseCase.RequestPasswordReset(audit.FromHTTP(r), input.Email); err != nil {
+		http.Error(w, fmt.Sprintf("Error requesting password reset: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If the email belongs to an account, a reset link has been sent",
+	})
+}
+
+// ResetPassword handles POST /users/password/reset request
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Token == "" || input.Password == "" {
+		http.Error(w, "Token and password are required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.userUseCase.ResetPassword(audit.FromHTTP(r), input.Token, input.Password)
+	switch {
+	case errors.Is(err, domain.ErrInvalidResetToken), errors.Is(err, domain.ErrInvalidPatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, auth.ErrImpersonationBlocked):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Error resetting password: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+}
+
+// writeTwoFactorError maps two-factor errors to HTTP statuses
+func writeTwoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, domain.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, domain.ErrTwoFactorEnabled), errors.Is(err, domain.ErrTwoFactorNotEnabled), errors.Is(err, domain.ErrNoTwoFactorEnrollment):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, domain.ErrInvalidTwoFactorCode):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, domain.ErrTwoFactorLocked):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, domain.ErrVersionConflict):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	default:
+		http.Error(w, fmt.Sprintf("Error updating two-factor authentication: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// currentUserID returns the ID of the user the bearer token belongs to
+func currentUserID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return primitive.NilObjectID, false
+	}
+	id, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		http.Error(w, "Invalid user ID in token", http.StatusUnauthorized)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// decodeCode reads {"code"} from the request body
+func decodeCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return "", false
+	}
+	return input.Code, true
+}
+
+// BeginTwoFactorEnrollment handles POST /users/2fa/enroll request. The
+// response carries the secret and the otpauth:// URI for the QR code.
+func (h *UserHandler) BeginTwoFactorEnrollment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.userUseCase.BeginTwoFactorEnrollment(audit.FromHTTP(r), id)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// ConfirmTwoFactorEnrollment handles POST /users/2fa/confirm request
+func (h *UserHandler) ConfirmTwoFactorEnrollment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.userUseCase.ConfirmTwoFactorEnrollment(audit.FromHTTP(r), id, code)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recoveryCodes": codes})
+}
+
+// DisableTwoFactor handles POST /users/2fa/disable request
+func (h *UserHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.userUseCase.DisableTwoFactor(audit.FromHTTP(r), id, code); err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes handles POST /users/2fa/recovery-codes request
+func (h *UserHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.userUseCase.RegenerateRecoveryCodes(audit.FromHTTP(r), id, code)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recoveryCodes": codes})
+}
+
+// ResetTwoFactor handles POST /admin/users/2fa/reset request
+func (h *UserHandler) ResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userUseCase.ResetTwoFactor(audit.FromHTTP(r), id); err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
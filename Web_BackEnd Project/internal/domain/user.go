@@ -2,36 +2,224 @@ package domain
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// User represents a user entity
+// User represents a user entity. It mirrors the Node userModel so both
+// services can work on the same documents. Password (a bcrypt hash) and the
+// OTP fields are secrets: they are read from and written to MongoDB but never
+// serialized to JSON. OTP is the plain code issued by Node; codes issued by
+// the Go service are only stored as OTPHash. A deleted user keeps its document with DeletedAt set
+// until the retention job purges it. Roles are the named permission sets
+// assigned through the roles API; the legacy Role string counts as one more role.
 type User struct {
-	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	FirstName string             `json:"firstName" bson:"firstName"`
-	LastName  string             `json:"lastName" bson:"lastName"`
-	Username  string             `json:"username" bson:"username"`
-	Email     string             `json:"email" bson:"email"`
-	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
-	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+	ID         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	FirstName  string             `json:"firstName" bson:"firstName"`
+	LastName   string             `json:"lastName" bson:"lastName"`
+	Username   string             `json:"username" bson:"username"`
+	Email      string             `json:"email" bson:"email"`
+	Password   string             `json:"-" bson:"password,omitempty"`
+	Role       string             `json:"role,omitempty" bson:"role,omitempty"`
+	Roles      []string           `json:"roles,omitempty" bson:"roles,omitempty"`
+	Age        int                `json:"age,omitempty" bson:"age,omitempty"`
+	Gender     string             `json:"gender,omitempty" bson:"gender,omitempty"`
+	IsVerified bool               `json:"isVerified" bson:"isVerified"`
+	OTP        int                `json:"-" bson:"otp,omitempty"`
+	OTPExpires *time.Time         `json:"-" bson:"otpExpires,omitempty"`
+	OTPHash    string             `json:"-" bson:"otpHash,omitempty"`
+	OTPSentAt  *time.Time         `json:"-" bson:"otpSentAt,omitempty"`
+	// OTPAttempts counts wrong codes; too many lock verification until OTPLockedUntil
+	OTPAttempts    int        `json:"-" bson:"otpAttempts,omitempty"`
+	OTPLockedUntil *time.Time `json:"-" bson:"otpLockedUntil,omitempty"`
+	// ResetTokenHash is the hash of the single outstanding password reset token
+	ResetTokenHash    string     `json:"-" bson:"resetTokenHash,omitempty"`
+	ResetTokenExpires *time.Time `json:"-" bson:"resetTokenExpires,omitempty"`
+	// TokensValidAfter revokes every token issued to the user before it
+	TokensValidAfter *time.Time `json:"-" bson:"tokensValidAfter,omitempty"`
+	// TwoFactorEnabled requires a TOTP or recovery code after the password.
+	// RecoveryCodes holds hashes only; TOTPLastStep blocks code replay.
+	TwoFactorEnabled     bool       `json:"twoFactorEnabled" bson:"twoFactorEnabled,omitempty"`
+	TOTPSecret           string     `json:"-" bson:"totpSecret,omitempty"`
+	TOTPPendingSecret    string     `json:"-" bson:"totpPendingSecret,omitempty"`
+	TOTPLastStep         int64      `json:"-" bson:"totpLastStep,omitempty"`
+	RecoveryCodes        []string   `json:"-" bson:"recoveryCodes,omitempty"`
+	TwoFactorAttempts    int        `json:"-" bson:"twoFactorAttempts,omitempty"`
+	TwoFactorLockedUntil *time.Time `json:"-" bson:"twoFactorLockedUntil,omitempty"`
+	Version              int64      `json:"version" bson:"version"`
+	CreatedAt            time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt            time.Time  `json:"updatedAt" bson:"updatedAt"`
+	DeletedAt            *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	// PurgingAt is set when the retention job claims a deleted user for purging
+	PurgingAt *time.Time `json:"purgingAt,omitempty" bson:"purgingAt,omitempty"`
 }
 
-// UserRepository represents the user repository contract
+// RoleNames returns the legacy Role together with Roles, without duplicates.
+func (u *User) RoleNames() []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, name := range append([]string{u.Role}, u.Roles...) {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// UserInput is a user as received from a client: unlike User it accepts a
+// plain-text password, which the use case hashes before storing.
+type UserInput struct {
+	User
+	Password string `json:"password"`
+}
+
+// ToUser returns the user with the plain-text password attached for hashing.
+// Roles and the verification state are never taken from the client.
+func (in UserInput) ToUser() *User {
+	user := in.User
+	user.Password = in.Password
+	user.Role, user.Roles = "", nil
+	user.IsVerified = false
+	return &user
+}
+
+var (
+	// ErrUserNotFound is returned when no user has the requested ID
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserPurging is returned when restoring a user the retention job has
+	// already started to purge
+	ErrUserPurging = errors.New("user is being purged and can no longer be restored")
+	// ErrInvalidPatch is returned when a partial update names a field that
+	// cannot be changed or carries an invalid value
+	ErrInvalidPatch = errors.New("invalid user patch")
+	// ErrVersionConflict is returned when the document changed since the
+	// version the caller expected
+	ErrVersionConflict = errors.New("user was modified by someone else")
+
+	// ErrAlreadyVerified is returned when a verified user asks for a code
+	ErrAlreadyVerified = errors.New("email is already verified")
+	// ErrInvalidOTP is returned for a wrong verification code
+	ErrInvalidOTP = errors.New("invalid verification code")
+	// ErrOTPExpired is returned when no code is pending or it has expired
+	ErrOTPExpired = errors.New("verification code has expired")
+	// ErrOTPLocked is returned after too many wrong codes
+	ErrOTPLocked = errors.New("too many wrong codes, verification is locked")
+	// ErrOTPCooldown is returned when a new code is requested too soon
+	ErrOTPCooldown = errors.New("a code was sent recently")
+	// ErrInvalidResetToken is returned for an unknown, used or expired
+	// password reset token
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+	// ErrInvalidCredentials is returned when the login or password is wrong
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrTwoFactorEnabled is returned when enrolling a user who already has 2FA
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTwoFactorNotEnabled is returned when 2FA is required for an operation but is off
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrNoTwoFactorEnrollment is returned when confirming without starting enrollment
+	ErrNoTwoFactorEnrollment = errors.New("no two-factor enrollment in progress")
+	// ErrInvalidTwoFactorCode is returned for a wrong TOTP or recovery code
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	// ErrTwoFactorLocked is returned after too many wrong two-factor codes
+	ErrTwoFactorLocked = errors.New("too many wrong two-factor codes, try again later")
+	// ErrWrongPassword is returned when a password change carries a wrong or
+	// missing current password
+	ErrWrongPassword = errors.New("current password is incorrect")
+)
+
+// UserPatch is a JSON Merge Patch (RFC 7396) of a user, keyed by JSON field
+// name. A nil value removes an optional field.
+type UserPatch map[string]interface{}
+
+// CurrentPasswordField carries the current password in a patch that changes
+// the password; it is checked and never stored.
+const CurrentPasswordField = "currentPassword"
+
+// PatchableUserFields lists the fields a partial update may touch and
+// whether they can be removed. Roles, verification and OTP have their own
+// flows; changing the email resets verification and revokes the user's tokens.
+var PatchableUserFields = map[string]bool{
+	"firstName": false,
+	"lastName":  false,
+	"username":  false,
+	"email":     false,
+	"password":  false,
+	"age":       true,
+	"gender":    true,
+}
+
+// UserRepository represents the user repository contract.
+// Writes take the version the caller last saw; nil skips the check.
+// Every successful write increments the version. Deleted users are only
+// visible through the trash methods.
 type UserRepository interface {
 	GetUsers(ctx context.Context, page, limit int, filter, sortBy, sortOrder string) ([]User, error)
 	GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error)
 	CreateUser(ctx context.Context, user *User) (primitive.ObjectID, error)
-	UpdateUser(ctx context.Context, user *User) error
-	DeleteUser(ctx context.Context, id primitive.ObjectID) error
+	UpdateUser(ctx context.Context, user *User, expectedVersion *int64) (*User, error)
+	PatchUser(ctx context.Context, id primitive.ObjectID, set map[string]interface{}, unset []string, expectedVersion *int64) (*User, error)
+	DeleteUser(ctx context.Context, id primitive.ObjectID, expectedVersion *int64) error
+	ListDeletedUsers(ctx context.Context, page, limit int) ([]User, error)
+	RestoreUser(ctx context.Context, id primitive.ObjectID) (*User, error)
+	// PurgeDeletedUsers removes users deleted before the cutoff together with
+	// their quiz results and returns the IDs it deleted. The users are claimed
+	// first and can't be restored from then on. beforeDelete, if set, runs
+	// with the IDs next; an error leaves the claimed users in the trash.
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, beforeDelete PurgeHook) ([]primitive.ObjectID, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
 }
 
+// PurgeHook is told which users are about to be purged, e.g. to publish an
+// event that other services must receive before the data is gone
+type PurgeHook func(ctx context.Context, ids []primitive.ObjectID) error
+
 // UserUseCase represents the user use case contract
 type UserUseCase interface {
 	GetUsers(ctx context.Context, page, limit int, filter, sortBy, sortOrder string) ([]User, error)
 	GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error)
 	CreateUser(ctx context.Context, user *User) (primitive.ObjectID, error)
-	UpdateUser(ctx context.Context, user *User) error
-	DeleteUser(ctx context.Context, id primitive.ObjectID) error
+	UpdateUser(ctx context.Context, user *User, expectedVersion *int64) (*User, error)
+	PatchUser(ctx context.Context, id primitive.ObjectID, patch UserPatch, expectedVersion *int64) (*User, error)
+	DeleteUser(ctx context.Context, id primitive.ObjectID, expectedVersion *int64) error
+	ListDeletedUsers(ctx context.Context, page, limit int) ([]User, error)
+	RestoreUser(ctx context.Context, id primitive.ObjectID) (*User, error)
+	PurgeDeletedUsers(ctx context.Context, retention time.Duration, beforeDelete PurgeHook) ([]primitive.ObjectID, error)
+	// SendVerificationCode emails a new one-time code to the user
+	SendVerificationCode(ctx context.Context, email string) error
+	// VerifyEmail checks the code and marks the user verified
+	VerifyEmail(ctx context.Context, email, code string) (*User, error)
+	// RequestPasswordReset emails a reset link; unknown emails are not an error
+	RequestPasswordReset(ctx context.Context, email string) error
+	// ResetPassword sets a new password and revokes the user's tokens
+	ResetPassword(ctx context.Context, token, password string) error
+	// Authenticate checks a username (or email) and password like the Node loginUser
+	Authenticate(ctx context.Context, login, password string) (*User, error)
+	// TokensValidAfter returns the time before which the user's tokens are revoked
+	TokensValidAfter(ctx context.Context, id primitive.ObjectID) (time.Time, error)
+	// BeginTwoFactorEnrollment creates a pending TOTP secret and its provisioning URI
+	BeginTwoFactorEnrollment(ctx context.Context, id primitive.ObjectID) (*TwoFactorEnrollment, error)
+	// ConfirmTwoFactorEnrollment enables 2FA once a code from the app matches
+	// and returns the recovery codes, which are shown only once
+	ConfirmTwoFactorEnrollment(ctx context.Context, id primitive.ObjectID, code string) ([]string, error)
+	// DisableTwoFactor turns 2FA off after checking a current code
+	DisableTwoFactor(ctx context.Context, id primitive.ObjectID, code string) error
+	// RegenerateRecoveryCodes replaces the recovery codes after checking a current code
+	RegenerateRecoveryCodes(ctx context.Context, id primitive.ObjectID, code string) ([]string, error)
+	// VerifySecondFactor checks a TOTP or a recovery code during login
+	VerifySecondFactor(ctx context.Context, id primitive.ObjectID, code string) error
+	// ResetTwoFactor turns another user's 2FA off (admin only)
+	ResetTwoFactor(ctx context.Context, id primitive.ObjectID) error
+	// AssignRoles replaces the user's roles and resets the legacy Role; the
+	// roles must already exist
+	AssignRoles(ctx context.Context, id primitive.ObjectID, roles []string) (*User, error)
+}
+
+// TwoFactorEnrollment is a TOTP secret waiting for its first code
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
 }
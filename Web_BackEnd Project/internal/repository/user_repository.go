@@ -13,6 +13,12 @@ import (
 	"web_backend_project/internal/domain"
 )
 
+// quizResultsCollection holds the Node QuizResult documents of the same database
+const quizResultsCollection = "quizresults"
+
+// notDeleted matches users that are not in the trash
+var notDeleted = bson.M{"$exists": false}
+
 type mongoUserRepository struct {
 	db         *mongo.Client
 	database   string
@@ -40,9 +46,10 @@ func (r *mongoUserRepository) GetUsers(ctx context.Context, page, limit int, fil
 		options.SetSort(bson.D{{Key: sortBy, Value: 1}})
 	}
 
-	filterQuery := bson.M{}
+	filterQuery := bson.M{"deletedAt": notDeleted}
 	if filter != "" {
 		filterQuery = bson.M{
+			"deletedAt": notDeleted,
 			"$or": []bson.M{
 				{"firstName": bson.M{"$regex": filter, "$options": "i"}},
 				{"lastName": bson.M{"$regex": filter, "$options": "i"}},
@@ -68,10 +75,10 @@ func (r *mongoUserRepository) GetUserByID(ctx context.Context, id primitive.Obje
 	collection := r.db.Database(r.database).Collection(r.collection)
 	var user domain.User
 
-	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	err := collection.FindOne(ctx, bson.M{"_id": id, "deletedAt": notDeleted}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user not found")
+			return nil, domain.ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -79,11 +86,40 @@ func (r *mongoUserRepository) GetUserByID(ctx context.Context, id primitive.Obje
 	return &user, nil
 }
 
+func (r *mongoUserRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	collection := r.db.Database(r.database).Collection(r.collection)
+	var user domain.User
+
+	err := collection.FindOne(ctx, bson.M{"email": email, "deletedAt": notDeleted}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *mongoUserRepository) GetUserByUsername(ctx context.Context, username string) (*domain.User, error) {
+	collection := r.db.Database(r.database).Collection(r.collection)
+	var user domain.User
+
+	err := collection.FindOne(ctx, bson.M{"username": username, "deletedAt": notDeleted}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (r *mongoUserRepository) CreateUser(ctx context.Context, user *domain.User) (primitive.ObjectID, error) {
 	collection := r.db.Database(r.database).Collection(r.collection)
 
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
+	user.Version = 1
 
 	result, err := collection.InsertOne(ctx, user)
 	if err != nil {
@@ -97,21 +133,209 @@ func (r *mongoUserRepository) CreateUser(ctx context.Context, user *domain.User)
 	return primitive.NilObjectID, fmt.Errorf("failed to get inserted ID")
 }
 
-func (r *mongoUserRepository) UpdateUser(ctx context.Context, user *domain.User) error {
+// versionFilter matches a live user only at the expected version. Documents
+// written before versioning have no version field and count as version 0.
+func versionFilter(id primitive.ObjectID, expectedVersion *int64) bson.M {
+	filter := bson.M{"_id": id, "deletedAt": notDeleted}
+	if expectedVersion == nil {
+		return filter
+	}
+	if *expectedVersion == 0 {
+		filter["$or"] = []bson.M{{"version": 0}, {"version": bson.M{"$exists": false}}}
+	} else {
+		filter["version"] = *expectedVersion
+	}
+	return filter
+}
+
+// casError tells a missing user apart from one that moved to another version
+func (r *mongoUserRepository) casError(ctx context.Context, id primitive.ObjectID) error {
+	collection := r.db.Database(r.database).Collection(r.collection)
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": id, "deletedAt": notDeleted})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return domain.ErrUserNotFound
+	}
+	return domain.ErrVersionConflict
+}
+
+// compareAndSwap applies update if the user is still at expectedVersion
+// and returns the updated document
+func (r *mongoUserRepository) compareAndSwap(ctx context.Context, id primitive.ObjectID, update bson.M, expectedVersion *int64) (*domain.User, error) {
 	collection := r.db.Database(r.database).Collection(r.collection)
+	update["$inc"] = bson.M{"version": 1}
+
+	var user domain.User
+	err := collection.FindOneAndUpdate(ctx, versionFilter(id, expectedVersion), update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, r.casError(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
 
+func (r *mongoUserRepository) UpdateUser(ctx context.Context, user *domain.User, expectedVersion *int64) (*domain.User, error) {
 	user.UpdatedAt = time.Now()
 
-	filter := bson.M{"_id": user.ID}
-	update := bson.M{"$set": user}
+	// Only the profile is replaced; OTP fields belong to the verification
+	// flow and roles to AssignRoles. The use case decides isVerified and
+	// tokensValidAfter when the email changes, and the password is only
+	// written when a new one was set.
+	set := bson.M{
+		"firstName":  user.FirstName,
+		"lastName":   user.LastName,
+		"username":   user.Username,
+		"email":      user.Email,
+		"age":        user.Age,
+		"gender":     user.Gender,
+		"isVerified": user.IsVerified,
+		"updatedAt":  user.UpdatedAt,
+	}
+	if user.Password != "" {
+		set["password"] = user.Password
+	}
+	if user.TokensValidAfter != nil {
+		set["tokensValidAfter"] = *user.TokensValidAfter
+	}
+
+	return r.compareAndSwap(ctx, user.ID, bson.M{"$set": set}, expectedVersion)
+}
 
-	_, err := collection.UpdateOne(ctx, filter, update)
+// PatchUser changes only the given fields and returns the updated document
+func (r *mongoUserRepository) PatchUser(ctx context.Context, id primitive.ObjectID, set map[string]interface{}, unset []string, expectedVersion *int64) (*domain.User, error) {
+	fields := bson.M{"updatedAt": time.Now()}
+	for field, value := range set {
+		fields[field] = value
+	}
+	update := bson.M{"$set": fields}
+	if len(unset) > 0 {
+		removed := bson.M{}
+		for _, field := range unset {
+			removed[field] = ""
+		}
+		update["$unset"] = removed
+	}
+
+	return r.compareAndSwap(ctx, id, update, expectedVersion)
+}
+
+// DeleteUser moves the user to the trash; the document stays until it is
+// restored or purged
+func (r *mongoUserRepository) DeleteUser(ctx context.Context, id primitive.ObjectID, expectedVersion *int64) error {
+	now := time.Now()
+	_, err := r.compareAndSwap(ctx, id, bson.M{"$set": bson.M{"deletedAt": now, "updatedAt": now}}, expectedVersion)
 	return err
 }
 
-func (r *mongoUserRepository) DeleteUser(ctx context.Context, id primitive.ObjectID) error {
+// ListDeletedUsers returns the trash, most recently deleted first
+func (r *mongoUserRepository) ListDeletedUsers(ctx context.Context, page, limit int) ([]domain.User, error) {
 	collection := r.db.Database(r.database).Collection(r.collection)
 
-	_, err := collection.DeleteOne(ctx, bson.M{"_id": id})
-	return err
+	opts := options.Find().
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit)).
+		SetSort(bson.D{{Key: "deletedAt", Value: -1}})
+	cursor, err := collection.Find(ctx, bson.M{"deletedAt": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []domain.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// RestoreUser takes the user out of the trash. A user the retention job has
+// started purging can no longer be restored.
+func (r *mongoUserRepository) RestoreUser(ctx context.Context, id primitive.ObjectID) (*domain.User, error) {
+	collection := r.db.Database(r.database).Collection(r.collection)
+
+	var user domain.User
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deletedAt": bson.M{"$exists": true}, "purgingAt": bson.M{"$exists": false}},
+		bson.M{
+			"$unset": bson.M{"deletedAt": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+			"$inc":   bson.M{"version": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		count, countErr := collection.CountDocuments(ctx, bson.M{"_id": id, "purgingAt": bson.M{"$exists": true}})
+		if countErr == nil && count > 0 {
+			return nil, domain.ErrUserPurging
+		}
+		return nil, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// PurgeDeletedUsers first claims the expired users by setting purgingAt, so
+// none of them can be restored once their data starts to go. It then runs
+// beforeDelete and removes quiz results before the users, so that a failed
+// run leaves the claimed users in the trash and the next run finishes the job.
+func (r *mongoUserRepository) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, beforeDelete domain.PurgeHook) ([]primitive.ObjectID, error) {
+	database := r.db.Database(r.database)
+	collection := database.Collection(r.collection)
+
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"deletedAt": bson.M{"$lt": deletedBefore}, "purgingAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"purgingAt": time.Now()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := bson.M{"deletedAt": bson.M{"$exists": true}, "purgingAt": bson.M{"$exists": true}}
+	cursor, err := collection.Find(ctx, claimed, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	if beforeDelete != nil {
+		if err := beforeDelete(ctx, ids); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := database.Collection(quizResultsCollection).DeleteMany(ctx, bson.M{"user": bson.M{"$in": ids}}); err != nil {
+		return nil, fmt.Errorf("failed to delete quiz results: %w", err)
+	}
+
+	purged := []primitive.ObjectID{}
+	for _, id := range ids {
+		res, err := collection.DeleteOne(ctx, bson.M{"_id": id, "purgingAt": bson.M{"$exists": true}})
+		if err != nil {
+			return purged, err
+		}
+		if res.DeletedCount > 0 {
+			purged = append(purged, id)
+		}
+	}
+	return purged, nil
 }
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 
@@ -52,3 +53,36 @@ func (s *emailService) SendEmail(to, subject, body string, attachment io.Reader,
 
 	return nil
 }
+
+// EmailNotificationsSubject — NATS-тема, письма из которой отправляет основной сервис
+const EmailNotificationsSubject = "email.notifications"
+
+// Publisher публикует сообщение в NATS; ему соответствует *nats.Conn
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+type natsEmailService struct {
+	publisher Publisher
+}
+
+// NewNATSEmailService создает email сервис, который не отправляет письма сам,
+// а передает их в тему email.notifications
+func NewNATSEmailService(publisher Publisher) EmailServiceInterface {
+	return &natsEmailService{publisher: publisher}
+}
+
+// SendEmail публикует письмо; вложения через NATS не передаются
+func (s *natsEmailService) SendEmail(to, subject, body string, attachment io.Reader, filename string) error {
+	if attachment != nil {
+		return fmt.Errorf("attachments cannot be sent through %s", EmailNotificationsSubject)
+	}
+	data, err := json.Marshal(map[string]string{"to": to, "subject": subject, "body": body})
+	if err != nil {
+		return err
+	}
+	if err := s.publisher.Publish(EmailNotificationsSubject, data); err != nil {
+		return fmt.Errorf("failed to publish email: %w", err)
+	}
+	return nil
+}
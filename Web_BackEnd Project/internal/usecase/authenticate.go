@@ -0,0 +1,40 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"web_backend_project/internal/domain"
+	"web_backend_project/pkg/auth"
+)
+
+// dummyPasswordHash сравнивается с паролем, когда пользователь не найден,
+// чтобы время ответа не выдавало существование логина
+const dummyPasswordHash = "$2a$10$vtiQM86ek9gtLkicesYLaO.lfNcK04LZtAwvRuJkXfoYkGv2ieOfG"
+
+// Authenticate проверяет логин и пароль. Как и Node loginUser, ищет по
+// username; логин с "@" ищется по email.
+func (u *userUseCase) Authenticate(ctx context.Context, login, password string) (*domain.User, error) {
+	login = strings.TrimSpace(login)
+
+	var user *domain.User
+	var err error
+	if strings.Contains(login, "@") {
+		user, err = u.userRepo.GetUserByEmail(ctx, login)
+	} else {
+		user, err = u.userRepo.GetUserByUsername(ctx, login)
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		auth.CheckPassword(dummyPasswordHash, password)
+		return nil, domain.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Password == "" || !auth.CheckPassword(user.Password, password) {
+		return nil, domain.ErrInvalidCredentials
+	}
+	return user, nil
+}
@@ -0,0 +1,193 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"web_backend_project/internal/domain"
+	"web_backend_project/pkg/auth"
+)
+
+// resetTokenTTL — срок действия ссылки для сброса пароля
+const resetTokenTTL = 30 * time.Minute
+
+// passwordResetEmail — письмо со ссылкой для сброса пароля
+var passwordResetEmail = template.Must(template.New("password_reset").Parse(`Hello {{.Name}},
+
+We received a request to reset the password for your account.
+Open the link below to choose a new password:
+
+{{.Link}}
+
+The link expires in {{.TTL}} and can be used only once.
+If you did not request a reset, ignore this email: your password stays the same.
+`))
+
+// tokensValidAfterKey — ключ Redis с моментом отзыва токенов пользователя
+func tokensValidAfterKey(id primitive.ObjectID) string {
+	return fmt.Sprintf("user:%s:tokens_valid_after", id.Hex())
+}
+
+// RequestPasswordReset выпускает новую ссылку для сброса пароля и отправляет
+// ее письмом. Для неизвестного email ответ тот же, что и для известного,
+// чтобы по нему нельзя было перебирать аккаунты.
+func (u *userUseCase) RequestPasswordReset(ctx context.Context, email string) error {
+	if u.emailService == nil {
+		return fmt.Errorf("email service is not configured")
+	}
+
+	user, err := u.userRepo.GetUserByEmail(ctx, strings.TrimSpace(email))
+	if errors.Is(err, domain.ErrUserNotFound) {
+		log.Printf("Password reset requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	secret, err := auth.GenerateToken()
+	if err != nil {
+		return err
+	}
+	// Идентификатор в токене позволяет найти пользователя без индекса по хэшу
+	token := user.ID.Hex() + "." + secret
+	expires := time.Now().Add(resetTokenTTL)
+
+	// Новая ссылка заменяет предыдущую: действует только последняя
+	updated, err := u.userRepo.PatchUser(ctx, user.ID, map[string]interface{}{
+		"resetTokenHash":    auth.HashToken(token),
+		"resetTokenExpires": expires,
+	}, nil, nil)
+	if err != nil {
+		return err
+	}
+	u.invalidateCache(ctx, updated.ID, updated.Version)
+	u.auditLog.Record(ctx, "user.password_reset_requested", userTarget(user.ID), nil, nil)
+
+	link := u.resetURL + "?token=" + url.QueryEscape(token)
+	var body bytes.Buffer
+	err = passwordResetEmail.Execute(&body, map[string]interface{}{
+		"Name": user.FirstName,
+		"Link": link,
+		"TTL":  resetTokenTTL,
+	})
+	if err != nil {
+		return err
+	}
+	return u.emailService.SendEmail(user.Email, "Reset your password", body.String(), nil, "")
+}
+
+// ResetPassword меняет пароль по токену из письма. Токен одноразовый:
+// запись идет по версии документа и удаляет хэш токена. После смены пароля
+// все ранее выданные токены пользователя отзываются.
+func (u *userUseCase) ResetPassword(ctx context.Context, token, password string) error {
+	if err := auth.ForbidImpersonation(ctx); err != nil {
+		return err
+	}
+	idHex, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return domain.ErrInvalidResetToken
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return domain.ErrInvalidResetToken
+	}
+	if password == "" {
+		return fmt.Errorf("%w: password is required", domain.ErrInvalidPatch)
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrInvalidPatch, err)
+	}
+
+	for attempt := 0; attempt < casRetries; attempt++ {
+		user, err := u.userRepo.GetUserByID(ctx, id)
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return domain.ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if user.ResetTokenHash == "" || user.ResetTokenExpires == nil || now.After(*user.ResetTokenExpires) ||
+			subtle.ConstantTimeCompare([]byte(user.ResetTokenHash), []byte(auth.HashToken(token))) != 1 {
+			return domain.ErrInvalidResetToken
+		}
+
+		updated, err := u.userRepo.PatchUser(ctx, id,
+			map[string]interface{}{"password": hash, "tokensValidAfter": now},
+			[]string{"resetTokenHash", "resetTokenExpires"},
+			&user.Version)
+		if errors.Is(err, domain.ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		u.invalidateCache(ctx, id, updated.Version)
+		u.cacheTokensValidAfter(ctx, id, now)
+		u.auditLog.Record(ctx, "user.password_reset", userTarget(id), nil, nil)
+		return nil
+	}
+	return domain.ErrVersionConflict
+}
+
+// TokensValidAfter возвращает момент последнего отзыва токенов пользователя.
+// Проверка идет на каждом авторизованном запросе, поэтому значение кэшируется
+// в Redis. Для удаленного пользователя отозваны все токены.
+func (u *userUseCase) TokensValidAfter(ctx context.Context, id primitive.ObjectID) (time.Time, error) {
+	if u.redisClient != nil {
+		var unix int64
+		if err := u.redisClient.Get(ctx, tokensValidAfterKey(id), &unix); err == nil {
+			return timeFromUnix(unix), nil
+		}
+	}
+
+	user, err := u.userRepo.GetUserByID(ctx, id)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return time.Now(), nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var validAfter time.Time
+	if user.TokensValidAfter != nil {
+		validAfter = *user.TokensValidAfter
+	}
+	u.cacheTokensValidAfter(ctx, id, validAfter)
+	return validAfter, nil
+}
+
+// cacheTokensValidAfter сохраняет момент отзыва в Redis; 0 означает, что отзыва не было
+func (u *userUseCase) cacheTokensValidAfter(ctx context.Context, id primitive.ObjectID, validAfter time.Time) {
+	if u.redisClient == nil {
+		return
+	}
+	var unix int64
+	if !validAfter.IsZero() {
+		unix = validAfter.Unix()
+	}
+	if err := u.redisClient.Set(ctx, tokensValidAfterKey(id), unix, u.cacheTTL); err != nil {
+		log.Printf("Failed to cache token revocation for user %s: %v", id.Hex(), err)
+	}
+}
+
+func timeFromUnix(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
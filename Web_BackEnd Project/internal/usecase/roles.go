@@ -0,0 +1,36 @@
+package usecase
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"web_backend_project/internal/domain"
+	"web_backend_project/pkg/auth"
+)
+
+// AssignRoles заменяет роли пользователя, включая старое поле role: оно
+// сбрасывается в роль по умолчанию, так что admin из него тоже отзывается.
+// Новые права попадут в токен при следующем обновлении сессии. Нужно право
+// roles.manage.
+func (u *userUseCase) AssignRoles(ctx context.Context, id primitive.ObjectID, roles []string) (*domain.User, error) {
+	if err := auth.Require(ctx, auth.PermRolesManage); err != nil {
+		return nil, err
+	}
+
+	before := u.snapshot(ctx, id)
+	set := map[string]interface{}{"role": auth.RoleUser}
+	var unset []string
+	if len(roles) > 0 {
+		set["roles"] = roles
+	} else {
+		unset = []string{"roles"}
+	}
+	updated, err := u.userRepo.PatchUser(ctx, id, set, unset, nil)
+	if err != nil {
+		return nil, err
+	}
+	u.invalidateCache(ctx, id, updated.Version)
+	u.auditLog.Record(ctx, "user.roles_assign", userTarget(id), before, updated)
+	return updated, nil
+}
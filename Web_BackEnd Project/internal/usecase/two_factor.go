@@ -0,0 +1,230 @@
+package usecase
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"web_backend_project/internal/domain"
+	"web_backend_project/pkg/auth"
+)
+
+const (
+	// totpIssuer — название сервиса в приложении-аутентификаторе
+	totpIssuer = "Web Backend Project"
+	// recoveryCodeCount — сколько кодов восстановления выдается за раз
+	recoveryCodeCount = 10
+)
+
+// twoFactorState — поля 2FA, которые очищаются при отключении
+var twoFactorState = []string{"totpSecret", "totpPendingSecret", "totpLastStep", "recoveryCodes", "twoFactorAttempts", "twoFactorLockedUntil"}
+
+// BeginTwoFactorEnrollment создает новый секрет. 2FA включится только после
+// ConfirmTwoFactorEnrollment, поэтому незавершенная настройка не блокирует вход.
+func (u *userUseCase) BeginTwoFactorEnrollment(ctx context.Context, id primitive.ObjectID) (*domain.TwoFactorEnrollment, error) {
+	user, err := u.userRepo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, domain.ErrTwoFactorEnabled
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	updated, err := u.userRepo.PatchUser(ctx, id, map[string]interface{}{"totpPendingSecret": secret}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	u.invalidateCache(ctx, id, updated.Version)
+
+	account := user.Email
+	if account == "" {
+		account = user.Username
+	}
+	return &domain.TwoFactorEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(totpIssuer, account, secret),
+	}, nil
+}
+
+// ConfirmTwoFactorEnrollment включает 2FA, если код из приложения подошел к
+// ожидающему секрету, и возвращает коды восстановления
+func (u *userUseCase) ConfirmTwoFactorEnrollment(ctx context.Context, id primitive.ObjectID, code string) ([]string, error) {
+	user, err := u.userRepo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, domain.ErrTwoFactorEnabled
+	}
+	if user.TOTPPendingSecret == "" {
+		return nil, domain.ErrNoTwoFactorEnrollment
+	}
+	step, ok := auth.ValidateTOTP(user.TOTPPendingSecret, code, time.Now(), 0)
+	if !ok {
+		return nil, domain.ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	updated, err := u.userRepo.PatchUser(ctx, id, map[string]interface{}{
+		"twoFactorEnabled": true,
+		"totpSecret":       user.TOTPPendingSecret,
+		"totpLastStep":     step,
+		"recoveryCodes":    hashes,
+	}, []string{"totpPendingSecret", "twoFactorAttempts", "twoFactorLockedUntil"}, &user.Version)
+	if err != nil {
+		return nil, err
+	}
+	u.invalidateCache(ctx, id, updated.Version)
+	u.auditLog.Record(ctx, "user.2fa_enable", userTarget(id), user, updated)
+	return codes, nil
+}
+
+// DisableTwoFactor отключает 2FA по действующему коду
+func (u *userUseCase) DisableTwoFactor(ctx context.Context, id primitive.ObjectID, code string) error {
+	if err := u.VerifySecondFactor(ctx, id, code); err != nil {
+		return err
+	}
+	return u.clearTwoFactor(ctx, id, "user.2fa_disable")
+}
+
+// ResetTwoFactor отключает 2FA пользователя, потерявшего и приложение, и
+// коды восстановления. Нужно право users.reset_2fa.
+func (u *userUseCase) ResetTwoFactor(ctx context.Context, id primitive.ObjectID) error {
+	if err := auth.Require(ctx, auth.PermUsersReset2FA); err != nil {
+		return err
+	}
+	return u.clearTwoFactor(ctx, id, "user.2fa_reset")
+}
+
+func (u *userUseCase) clearTwoFactor(ctx context.Context, id primitive.ObjectID, action string) error {
+	before := u.snapshot(ctx, id)
+	updated, err := u.userRepo.PatchUser(ctx, id, map[string]interface{}{"twoFactorEnabled": false}, twoFactorState, nil)
+	if err != nil {
+		return err
+	}
+	u.invalidateCache(ctx, id, updated.Version)
+	u.auditLog.Record(ctx, action, userTarget(id), before, updated)
+	return nil
+}
+
+// RegenerateRecoveryCodes выдает новый набор кодов; старые перестают действовать
+func (u *userUseCase) RegenerateRecoveryCodes(ctx context.Context, id primitive.ObjectID, code string) ([]string, error) {
+	if err := u.VerifySecondFactor(ctx, id, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	updated, err := u.userRepo.PatchUser(ctx, id, map[string]interface{}{"recoveryCodes": hashes}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	u.invalidateCache(ctx, id, updated.Version)
+	u.auditLog.Record(ctx, "user.2fa_recovery_codes", userTarget(id), nil, nil)
+	return codes, nil
+}
+
+// VerifySecondFactor принимает TOTP-код или один из кодов восстановления.
+// Использованный код восстановления удаляется, использованный шаг TOTP
+// запоминается. Неверные коды считаются так же, как при подтверждении email.
+func (u *userUseCase) VerifySecondFactor(ctx context.Context, id primitive.ObjectID, code string) error {
+	for attempt := 0; attempt < casRetries; attempt++ {
+		user, err := u.userRepo.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !user.TwoFactorEnabled || user.TOTPSecret == "" {
+			return domain.ErrTwoFactorNotEnabled
+		}
+
+		now := time.Now()
+		if user.TwoFactorLockedUntil != nil && now.Before(*user.TwoFactorLockedUntil) {
+			return fmt.Errorf("%w: locked for %s", domain.ErrTwoFactorLocked, user.TwoFactorLockedUntil.Sub(now).Round(time.Second))
+		}
+
+		set := map[string]interface{}{}
+		unset := []string{"twoFactorAttempts", "twoFactorLockedUntil"}
+		usedRecovery := false
+		if step, ok := auth.ValidateTOTP(user.TOTPSecret, code, now, user.TOTPLastStep); ok {
+			set["totpLastStep"] = step
+		} else if remaining, ok := consumeRecoveryCode(user.RecoveryCodes, code); ok {
+			set["recoveryCodes"] = remaining
+			usedRecovery = true
+		} else {
+			attempts := user.TwoFactorAttempts + 1
+			set = map[string]interface{}{"twoFactorAttempts": attempts}
+			unset = nil
+			if attempts >= maxOTPAttempts {
+				set["twoFactorLockedUntil"] = now.Add(otpLockout)
+				set["twoFactorAttempts"] = 0
+			}
+			_, err := u.userRepo.PatchUser(ctx, id, set, unset, &user.Version)
+			if errors.Is(err, domain.ErrVersionConflict) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if attempts >= maxOTPAttempts {
+				log.Printf("Two-factor verification locked for user %s", id.Hex())
+				u.auditLog.Record(ctx, "user.2fa_locked", userTarget(id), nil, nil)
+				return fmt.Errorf("%w: locked for %s", domain.ErrTwoFactorLocked, otpLockout)
+			}
+			return domain.ErrInvalidTwoFactorCode
+		}
+
+		// Запись по версии: один и тот же код не пройдет дважды даже параллельно
+		updated, err := u.userRepo.PatchUser(ctx, id, set, unset, &user.Version)
+		if errors.Is(err, domain.ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		u.invalidateCache(ctx, id, updated.Version)
+		if usedRecovery {
+			u.auditLog.Record(ctx, "user.2fa_recovery_code_used", userTarget(id), nil, nil)
+		}
+		return nil
+	}
+	return domain.ErrVersionConflict
+}
+
+// newRecoveryCodes возвращает коды для пользователя и их хэши для базы
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// consumeRecoveryCode ищет код среди хэшей и возвращает оставшиеся
+func consumeRecoveryCode(hashes []string, code string) ([]string, bool) {
+	hash := auth.HashToken(auth.NormalizeRecoveryCode(code))
+	for i, stored := range hashes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			remaining := append(append([]string{}, hashes[:i]...), hashes[i+1:]...)
+			return remaining, true
+		}
+	}
+	return nil, false
+}
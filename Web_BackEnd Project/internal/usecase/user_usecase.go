@@ -4,45 +4,93 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"web_backend_project/internal/domain"
+	"web_backend_project/internal/service"
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
 	"web_backend_project/pkg/cache"
 )
 
 type userUseCase struct {
-	userRepo    domain.UserRepository
-	redisClient *cache.RedisClient
-	cacheTTL    time.Duration
+	userRepo     domain.UserRepository
+	redisClient  *cache.RedisClient
+	cacheTTL     time.Duration
+	auditLog     *audit.Log
+	emailService service.EmailServiceInterface
+	resetURL     string
 }
 
-// NewUserUseCase creates a new instance of userUseCase
-func NewUserUseCase(userRepo domain.UserRepository, redisClient *cache.RedisClient, cacheTTL int) domain.UserUseCase {
+// NewUserUseCase creates a new instance of userUseCase. auditLog may be nil
+// to run without an audit trail; emailService delivers verification codes
+// and password reset links to the resetURL page.
+func NewUserUseCase(userRepo domain.UserRepository, redisClient *cache.RedisClient, cacheTTL int, auditLog *audit.Log, emailService service.EmailServiceInterface, resetURL string) domain.UserUseCase {
 	return &userUseCase{
-		userRepo:    userRepo,
-		redisClient: redisClient,
-		cacheTTL:    time.Duration(cacheTTL) * time.Second,
+		userRepo:     userRepo,
+		redisClient:  redisClient,
+		cacheTTL:     time.Duration(cacheTTL) * time.Second,
+		auditLog:     auditLog,
+		emailService: emailService,
+		resetURL:     resetURL,
 	}
 }
 
+// userTarget — идентификатор пользователя в журнале аудита
+func userTarget(id primitive.ObjectID) string {
+	return "user:" + id.Hex()
+}
+
+// snapshot читает пользователя до изменения для diff в журнале аудита
+func (u *userUseCase) snapshot(ctx context.Context, id primitive.ObjectID) *domain.User {
+	if u.auditLog == nil {
+		return nil
+	}
+	user, err := u.userRepo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// requireSelfOr пропускает самого пользователя id; остальным нужно право permission
+func requireSelfOr(ctx context.Context, id primitive.ObjectID, permission string) error {
+	if claims := auth.FromContext(ctx); claims != nil && !claims.IsAPIKey() && claims.UserID == id.Hex() {
+		return nil
+	}
+	return auth.Require(ctx, permission)
+}
+
+// GetUsers возвращает страницу пользователей. Нужно право users.read.
 func (u *userUseCase) GetUsers(ctx context.Context, page, limit int, filter, sortBy, sortOrder string) ([]domain.User, error) {
+	if err := auth.Require(ctx, auth.PermUsersRead); err != nil {
+		return nil, err
+	}
 	return u.userRepo.GetUsers(ctx, page, limit, filter, sortBy, sortOrder)
 }
 
+// GetUserByID возвращает пользователя из кэша или базы. Свой профиль
+// доступен каждому, чужой — с правом users.read.
 func (u *userUseCase) GetUserByID(ctx context.Context, id primitive.ObjectID) (*domain.User, error) {
+	if err := requireSelfOr(ctx, id, auth.PermUsersRead); err != nil {
+		return nil, err
+	}
 	// Формируем ключ кэша
 	cacheKey := fmt.Sprintf("user:%s", id.Hex())
 
 	// Проверяем наличие данных в кэше
-	var cachedUser domain.User
-	err := u.redisClient.Get(ctx, cacheKey, &cachedUser)
-	if err == nil {
-		log.Printf("Cache hit for key: %s", cacheKey)
-		return &cachedUser, nil
+	if u.redisClient != nil {
+		var cachedUser domain.User
+		err := u.redisClient.Get(ctx, cacheKey, &cachedUser)
+		if err == nil && !u.redisClient.IsStale(ctx, cacheKey, cachedUser.Version) {
+			log.Printf("Cache hit for key: %s", cacheKey)
+			return &cachedUser, nil
+		}
+		log.Printf("Cache miss for key: %s, error: %v", cacheKey, err)
 	}
-	log.Printf("Cache miss for key: %s, error: %v", cacheKey, err)
 
 	// Если данных нет в кэше, получаем из репозитория
 	user, err := u.userRepo.GetUserByID(ctx, id)
@@ -56,52 +104,309 @@ func (u *userUseCase) GetUserByID(ctx context.Context, id primitive.ObjectID) (*
 	}
 
 	// Сохраняем данные в кэш
-	if err := u.redisClient.Set(ctx, cacheKey, user, u.cacheTTL); err != nil {
-		log.Printf("Failed to cache user with key %s: %v", cacheKey, err)
-	} else {
-		log.Printf("User cached successfully with key: %s", cacheKey)
+	if u.redisClient != nil {
+		if err := u.redisClient.Set(ctx, cacheKey, user, u.cacheTTL); err != nil {
+			log.Printf("Failed to cache user with key %s: %v", cacheKey, err)
+		} else {
+			log.Printf("User cached successfully with key: %s", cacheKey)
+		}
 	}
 
 	return user, nil
 }
 
+// hashPassword заменяет пароль в открытом виде на bcrypt-хэш
+func hashPassword(user *domain.User) error {
+	if user.Password == "" {
+		return nil
+	}
+	hash, err := auth.HashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	return nil
+}
+
 func (u *userUseCase) CreateUser(ctx context.Context, user *domain.User) (primitive.ObjectID, error) {
-	// Здесь можно добавить бизнес-логику/валидацию перед созданием пользователя
-	return u.userRepo.CreateUser(ctx, user)
+	if user.Password == "" {
+		return primitive.NilObjectID, fmt.Errorf("password is required")
+	}
+	if err := hashPassword(user); err != nil {
+		return primitive.NilObjectID, err
+	}
+	// Подтвердить адрес можно только кодом из письма
+	user.IsVerified = false
+	user.OTP, user.OTPHash, user.OTPExpires, user.OTPSentAt = 0, "", nil, nil
+	user.OTPAttempts, user.OTPLockedUntil = 0, nil
+	user.ResetTokenHash, user.ResetTokenExpires, user.TokensValidAfter = "", nil, nil
+	user.TwoFactorEnabled, user.TOTPSecret, user.TOTPPendingSecret, user.RecoveryCodes = false, "", "", nil
+	user.TOTPLastStep, user.TwoFactorAttempts, user.TwoFactorLockedUntil = 0, 0, nil
+	// Роли назначаются только через AssignRoles
+	user.Role, user.Roles = auth.RoleUser, nil
+	id, err := u.userRepo.CreateUser(ctx, user)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	u.auditLog.Record(ctx, "user.create", userTarget(id), nil, user)
+	if err := u.SendVerificationCode(ctx, user.Email); err != nil {
+		log.Printf("Failed to send verification code to user %s: %v", id.Hex(), err)
+	}
+	return id, nil
 }
 
-func (u *userUseCase) UpdateUser(ctx context.Context, user *domain.User) error {
+// UpdateUser заменяет профиль целиком, поэтому пустые обязательные поля
+// отклоняются; для изменения отдельных полей есть PatchUser. Свой профиль
+// меняет сам пользователь, чужой — с правом users.update. Пароль так не
+// меняется: для этого есть PatchUser с текущим паролем и сброс по ссылке.
+func (u *userUseCase) UpdateUser(ctx context.Context, user *domain.User, expectedVersion *int64) (*domain.User, error) {
+	if err := requireSelfOr(ctx, user.ID, auth.PermUsersUpdate); err != nil {
+		return nil, err
+	}
+	if user.FirstName == "" || user.LastName == "" || user.Username == "" || user.Email == "" {
+		return nil, fmt.Errorf("%w: firstName, lastName, username and email are required; use a patch to change single fields", domain.ErrInvalidPatch)
+	}
+	if user.Password != "" {
+		return nil, fmt.Errorf("%w: change the password with a patch carrying %s or through the reset flow", domain.ErrInvalidPatch, domain.CurrentPasswordField)
+	}
+
+	current, err := u.userRepo.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	// Новый адрес подтверждается заново, старые токены отзываются; при
+	// имперсонации email не меняется
+	emailChanged := !strings.EqualFold(current.Email, user.Email)
+	if emailChanged {
+		if err := auth.ForbidImpersonation(ctx); err != nil {
+			return nil, err
+		}
+	}
+	user.IsVerified = current.IsVerified && !emailChanged
+	user.TokensValidAfter = nil
+	now := time.Now()
+	if emailChanged {
+		user.TokensValidAfter = &now
+	}
+
 	// Обновляем пользователя
-	err := u.userRepo.UpdateUser(ctx, user)
+	updated, err := u.userRepo.UpdateUser(ctx, user, expectedVersion)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Инвалидируем кэш
-	cacheKey := fmt.Sprintf("user:%s", user.ID.Hex())
-	if err := u.redisClient.Delete(ctx, cacheKey); err != nil {
-		log.Printf("Failed to invalidate user cache for key %s: %v", cacheKey, err)
-	} else {
-		log.Printf("Cache invalidated for key: %s", cacheKey)
+	u.invalidateCache(ctx, updated.ID, updated.Version)
+	u.auditLog.Record(ctx, "user.update", userTarget(updated.ID), current, updated)
+	if emailChanged {
+		u.credentialsChanged(ctx, updated, now, true)
 	}
+	return updated, nil
+}
 
+// checkCurrentPassword сверяет текущий пароль пользователя перед его сменой
+func (u *userUseCase) checkCurrentPassword(ctx context.Context, id primitive.ObjectID, password string) error {
+	user, err := u.userRepo.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if password == "" || user.Password == "" || !auth.CheckPassword(user.Password, password) {
+		return domain.ErrWrongPassword
+	}
 	return nil
 }
 
-func (u *userUseCase) DeleteUser(ctx context.Context, id primitive.ObjectID) error {
+// credentialsChanged запоминает момент отзыва токенов после смены email или
+// пароля и отправляет код подтверждения на новый адрес
+func (u *userUseCase) credentialsChanged(ctx context.Context, user *domain.User, revokedAt time.Time, emailChanged bool) {
+	u.cacheTokensValidAfter(ctx, user.ID, revokedAt)
+	if !emailChanged {
+		return
+	}
+	if err := u.SendVerificationCode(ctx, user.Email); err != nil {
+		log.Printf("Failed to send verification code to user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+func (u *userUseCase) DeleteUser(ctx context.Context, id primitive.ObjectID, expectedVersion *int64) error {
+	if err := auth.Require(ctx, auth.PermUsersDelete); err != nil {
+		return err
+	}
 	// Удаляем пользователя
-	err := u.userRepo.DeleteUser(ctx, id)
+	err := u.userRepo.DeleteUser(ctx, id, expectedVersion)
 	if err != nil {
 		return err
 	}
 
-	// Инвалидируем кэш
+	u.invalidateCache(ctx, id, -1)
+	u.auditLog.Record(ctx, "user.delete", userTarget(id), nil, nil)
+	return nil
+}
+
+func (u *userUseCase) ListDeletedUsers(ctx context.Context, page, limit int) ([]domain.User, error) {
+	if err := auth.Require(ctx, auth.PermUsersRestore); err != nil {
+		return nil, err
+	}
+	return u.userRepo.ListDeletedUsers(ctx, page, limit)
+}
+
+func (u *userUseCase) RestoreUser(ctx context.Context, id primitive.ObjectID) (*domain.User, error) {
+	if err := auth.Require(ctx, auth.PermUsersRestore); err != nil {
+		return nil, err
+	}
+	user, err := u.userRepo.RestoreUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.invalidateCache(ctx, user.ID, user.Version)
+	u.auditLog.Record(ctx, "user.restore", userTarget(user.ID), nil, nil)
+	return user, nil
+}
+
+// PurgeDeletedUsers окончательно удаляет пользователей, пролежавших в корзине
+// дольше retention
+func (u *userUseCase) PurgeDeletedUsers(ctx context.Context, retention time.Duration, beforeDelete domain.PurgeHook) ([]primitive.ObjectID, error) {
+	// При ошибке в ids остаются те, кого успели удалить: их удаление тоже
+	// попадает в журнал
+	ids, err := u.userRepo.PurgeDeletedUsers(ctx, time.Now().Add(-retention), beforeDelete)
+	for _, id := range ids {
+		u.auditLog.Record(ctx, "user.purge", userTarget(id), nil, nil)
+	}
+	return ids, err
+}
+
+// invalidateCache удаляет пользователя из кэша и запоминает его новую
+// версию: копия, которую параллельный запрос успеет положить в кэш после
+// удаления, будет распознана как устаревшая
+func (u *userUseCase) invalidateCache(ctx context.Context, id primitive.ObjectID, version int64) {
+	if u.redisClient == nil {
+		return
+	}
 	cacheKey := fmt.Sprintf("user:%s", id.Hex())
+	if err := u.redisClient.SetVersion(ctx, cacheKey, version, 24*time.Hour); err != nil {
+		log.Printf("Failed to record version for key %s: %v", cacheKey, err)
+	}
 	if err := u.redisClient.Delete(ctx, cacheKey); err != nil {
 		log.Printf("Failed to invalidate user cache for key %s: %v", cacheKey, err)
 	} else {
 		log.Printf("Cache invalidated for key: %s", cacheKey)
 	}
+}
 
-	return nil
+// PatchUser применяет JSON Merge Patch: меняет только перечисленные поля,
+// null удаляет необязательное поле. Возвращает обновленного пользователя.
+// Свой профиль меняет сам пользователь, чужой — с правом users.update.
+// Смена пароля требует текущий пароль в поле currentPassword; после смены
+// email или пароля токены пользователя отзываются, а новый адрес нужно
+// подтвердить заново.
+func (u *userUseCase) PatchUser(ctx context.Context, id primitive.ObjectID, patch domain.UserPatch, expectedVersion *int64) (*domain.User, error) {
+	if err := requireSelfOr(ctx, id, auth.PermUsersUpdate); err != nil {
+		return nil, err
+	}
+	currentPassword, _ := patch[domain.CurrentPasswordField].(string)
+	if len(patch) == 0 || (len(patch) == 1 && currentPassword != "") {
+		return nil, fmt.Errorf("%w: nothing to update", domain.ErrInvalidPatch)
+	}
+
+	set := map[string]interface{}{}
+	var unset []string
+	for field, value := range patch {
+		if field == domain.CurrentPasswordField {
+			continue
+		}
+		removable, ok := domain.PatchableUserFields[field]
+		if !ok {
+			return nil, fmt.Errorf("%w: field %q cannot be changed", domain.ErrInvalidPatch, field)
+		}
+
+		if value == nil {
+			if !removable {
+				return nil, fmt.Errorf("%w: field %q is required", domain.ErrInvalidPatch, field)
+			}
+			unset = append(unset, field)
+			continue
+		}
+
+		switch field {
+		case "age":
+			age, ok := patchInt(value)
+			if !ok || age < 0 || age > 150 {
+				return nil, fmt.Errorf("%w: age must be a whole number between 0 and 150", domain.ErrInvalidPatch)
+			}
+			set[field] = age
+		default:
+			text, ok := value.(string)
+			text = strings.TrimSpace(text)
+			if !ok || (text == "" && !removable) {
+				return nil, fmt.Errorf("%w: field %q must be a non-empty string", domain.ErrInvalidPatch, field)
+			}
+			if field == "email" && !strings.Contains(text, "@") {
+				return nil, fmt.Errorf("%w: invalid email", domain.ErrInvalidPatch)
+			}
+			if field == "password" {
+				if err := auth.ForbidImpersonation(ctx); err != nil {
+					return nil, err
+				}
+				if err := u.checkCurrentPassword(ctx, id, currentPassword); err != nil {
+					return nil, err
+				}
+				hash, err := auth.HashPassword(text)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %v", domain.ErrInvalidPatch, err)
+				}
+				text = hash
+			}
+			set[field] = text
+		}
+	}
+
+	before, err := u.userRepo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// Новый адрес подтверждается заново; смена email или пароля отзывает
+	// токены. Email, как и пароль, не меняется при имперсонации.
+	email, hasEmail := set["email"].(string)
+	emailChanged := hasEmail && !strings.EqualFold(email, before.Email)
+	if emailChanged {
+		if err := auth.ForbidImpersonation(ctx); err != nil {
+			return nil, err
+		}
+	}
+	_, passwordChanged := set["password"]
+	now := time.Now()
+	if emailChanged {
+		set["isVerified"] = false
+	}
+	if emailChanged || passwordChanged {
+		set["tokensValidAfter"] = now
+	}
+
+	user, err := u.userRepo.PatchUser(ctx, id, set, unset, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	u.invalidateCache(ctx, id, user.Version)
+	u.auditLog.Record(ctx, "user.patch", userTarget(id), before, user)
+	if emailChanged || passwordChanged {
+		u.credentialsChanged(ctx, user, now, emailChanged)
+	}
+	return user, nil
+}
+
+// patchInt принимает числа из JSON (float64) и из gRPC (int32)
+func patchInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case float64:
+		return int(v), v == float64(int(v))
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	}
+	return 0, false
 }
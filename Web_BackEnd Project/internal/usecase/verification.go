@@ -0,0 +1,178 @@
+package usecase
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"web_backend_project/internal/domain"
+	"web_backend_project/pkg/auth"
+)
+
+const (
+	// otpTTL совпадает со сроком кода в Node authController
+	otpTTL = 5 * time.Minute
+	// otpResendCooldown — минимальный интервал между письмами с кодом
+	otpResendCooldown = time.Minute
+	// maxOTPAttempts неверных кодов подряд блокируют проверку на otpLockout.
+	// Счетчик не сбрасывается новым кодом, иначе перебор обходил бы блокировку.
+	maxOTPAttempts = 5
+	otpLockout     = 15 * time.Minute
+	// casRetries — сколько раз повторять запись при параллельном изменении
+	casRetries = 3
+)
+
+// otpState — поля кода, которые очищаются после проверки или блокировки
+var otpState = []string{"otp", "otpHash", "otpExpires"}
+
+// SendVerificationCode генерирует новый код, сохраняет только его хэш и
+// отправляет код письмом. Для неизвестного, уже подтвержденного или временно
+// заблокированного email ответ тот же, что и при отправке, чтобы по нему
+// нельзя было перебирать аккаунты.
+func (u *userUseCase) SendVerificationCode(ctx context.Context, email string) error {
+	if u.emailService == nil {
+		return fmt.Errorf("email service is not configured")
+	}
+
+	for attempt := 0; attempt < casRetries; attempt++ {
+		user, err := u.userRepo.GetUserByEmail(ctx, strings.TrimSpace(email))
+		if errors.Is(err, domain.ErrUserNotFound) {
+			log.Printf("Verification code requested for unknown email")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if user.IsVerified {
+			log.Printf("Verification code requested for verified user %s", user.ID.Hex())
+			return nil
+		}
+
+		now := time.Now()
+		if locked, _ := otpLocked(user, now); locked {
+			log.Printf("Verification code requested for locked user %s", user.ID.Hex())
+			return nil
+		}
+		if user.OTPSentAt != nil && now.Sub(*user.OTPSentAt) < otpResendCooldown {
+			log.Printf("Verification code for user %s requested again within the cooldown", user.ID.Hex())
+			return nil
+		}
+
+		code, err := auth.GenerateOTP()
+		if err != nil {
+			return err
+		}
+		set := map[string]interface{}{
+			"otpHash":    auth.HashOTP(user.ID.Hex(), code),
+			"otpExpires": now.Add(otpTTL),
+			"otpSentAt":  now,
+		}
+		unset := []string{"otp"}
+		if user.OTPLockedUntil != nil {
+			// Блокировка истекла: попытки начинаются заново
+			unset = append(unset, "otpLockedUntil", "otpAttempts")
+		}
+
+		// Запись по версии: из двух параллельных запросов письмо уйдет одно
+		updated, err := u.userRepo.PatchUser(ctx, user.ID, set, unset, &user.Version)
+		if errors.Is(err, domain.ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		u.invalidateCache(ctx, updated.ID, updated.Version)
+
+		body := fmt.Sprintf("Your verification code is: %s. It will expire in %d minutes.", code, int(otpTTL.Minutes()))
+		return u.emailService.SendEmail(user.Email, "Verify your email", body, nil, "")
+	}
+	return domain.ErrVersionConflict
+}
+
+// VerifyEmail проверяет код и отмечает пользователя подтвержденным.
+// Каждая попытка записывается по версии документа, поэтому параллельные
+// запросы не могут перебрать коды в обход счетчика.
+func (u *userUseCase) VerifyEmail(ctx context.Context, email, code string) (*domain.User, error) {
+	code = strings.TrimSpace(code)
+
+	for attempt := 0; attempt < casRetries; attempt++ {
+		user, err := u.userRepo.GetUserByEmail(ctx, strings.TrimSpace(email))
+		if err != nil {
+			return nil, err
+		}
+		if user.IsVerified {
+			return nil, domain.ErrAlreadyVerified
+		}
+
+		now := time.Now()
+		if locked, wait := otpLocked(user, now); locked {
+			return nil, fmt.Errorf("%w: try again in %s", domain.ErrOTPLocked, wait)
+		}
+		if (user.OTPHash == "" && user.OTP == 0) || user.OTPExpires == nil || now.After(*user.OTPExpires) {
+			return nil, domain.ErrOTPExpired
+		}
+
+		if otpMatches(user, code) {
+			updated, err := u.userRepo.PatchUser(ctx, user.ID,
+				map[string]interface{}{"isVerified": true},
+				append([]string{"otpSentAt", "otpAttempts", "otpLockedUntil"}, otpState...),
+				&user.Version)
+			if errors.Is(err, domain.ErrVersionConflict) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			u.invalidateCache(ctx, updated.ID, updated.Version)
+			u.auditLog.Record(ctx, "user.verify_email", userTarget(updated.ID), user, updated)
+			return updated, nil
+		}
+
+		attempts := user.OTPAttempts + 1
+		set := map[string]interface{}{"otpAttempts": attempts}
+		var unset []string
+		if attempts >= maxOTPAttempts {
+			// Код сгорает: после блокировки нужно запросить новый
+			set["otpLockedUntil"] = now.Add(otpLockout)
+			unset = otpState
+		}
+		updated, err := u.userRepo.PatchUser(ctx, user.ID, set, unset, &user.Version)
+		if errors.Is(err, domain.ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		u.invalidateCache(ctx, updated.ID, updated.Version)
+
+		if attempts >= maxOTPAttempts {
+			log.Printf("Email verification locked for user %s after %d wrong codes", user.ID.Hex(), attempts)
+			u.auditLog.Record(ctx, "user.verification_locked", userTarget(user.ID), nil, nil)
+			return nil, fmt.Errorf("%w: try again in %s", domain.ErrOTPLocked, otpLockout)
+		}
+		return nil, domain.ErrInvalidOTP
+	}
+	return nil, domain.ErrVersionConflict
+}
+
+// otpLocked сообщает, действует ли блокировка, и сколько осталось ждать
+func otpLocked(user *domain.User, now time.Time) (bool, time.Duration) {
+	if user.OTPLockedUntil == nil || !now.Before(*user.OTPLockedUntil) {
+		return false, 0
+	}
+	return true, user.OTPLockedUntil.Sub(now).Round(time.Second)
+}
+
+// otpMatches проверяет код Go-сервиса по хэшу, а код, выданный Node, —
+// по открытому значению
+func otpMatches(user *domain.User, code string) bool {
+	if user.OTPHash != "" {
+		return auth.CheckOTP(user.OTPHash, user.ID.Hex(), code)
+	}
+	return subtle.ConstantTimeCompare([]byte(strconv.Itoa(user.OTP)), []byte(code)) == 1
+}
@@ -3,11 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -20,15 +24,31 @@ import (
 	"gopkg.in/gomail.v2"
 
 	"web_backend_project/grpc"
+	deliveryhttp "web_backend_project/internal/delivery/http"
+	"web_backend_project/internal/domain"
+	"web_backend_project/internal/repository"
+	"web_backend_project/internal/service"
+	"web_backend_project/internal/usecase"
+	"web_backend_project/pkg/apikey"
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
 	"web_backend_project/pkg/cache"
+	"web_backend_project/pkg/classroom"
+	"web_backend_project/pkg/etag"
+	"web_backend_project/pkg/privacy"
+	"web_backend_project/pkg/rbac"
+	"web_backend_project/pkg/session"
+	"web_backend_project/pkg/webhook"
 	"web_backend_project/quiz"
 	"web_backend_project/transaction"
 )
 
 var mainClient *mongo.Client
 var redisClient *cache.RedisClient
-var cacheTTL time.Duration
 var nc *nats.Conn // NATS connection
+var webhooks *webhook.Dispatcher
+var auditLog *audit.Log
+var userUseCase domain.UserUseCase
 
 func main() {
 	// Загрузка переменных окружения
@@ -73,6 +93,20 @@ func main() {
 	// Subscribe to NATS subject for email notifications
 	go subscribeToEmailNotifications()
 
+	// Журнал аудита общий для пользователей, вопросов и истории транзакций
+	auditLog = audit.NewLog(mainClient.Database("test"))
+	if err := auditLog.EnsureIndexes(context.Background()); err != nil {
+		log.Fatal("Error preparing audit log:", err)
+	}
+
+	// Диспетчер общий: события транзакций ставит в очередь сервис транзакций
+	webhooks = webhook.NewDispatcher(mainClient.Database("test"),
+		webhook.EventUserRegistered, webhook.EventTransactionPaid, webhook.EventTransactionRefunded)
+	if err := webhooks.EnsureIndexes(context.Background()); err != nil {
+		log.Fatal("Error preparing webhooks:", err)
+	}
+	go webhooks.Run(context.Background(), 5*time.Second)
+
 	// Инициализация Redis
 	redisClient, err = cache.NewRedisClient(redisAddr, redisPassword, redisDB)
 	if err != nil {
@@ -82,16 +116,14 @@ func main() {
 		defer redisClient.Close()
 	}
 
-	// Установка TTL для кэша
-	cacheTTL = time.Duration(cacheTTLSeconds) * time.Second
-
 	// Настройка CORS
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "If-Match", auth.APIKeyHeader},
+		ExposedHeaders:   []string{"ETag"},
 		AllowCredentials: true,
-	}).Handler(http.DefaultServeMux)
+	}).Handler(auth.ImpersonationGuard(http.DefaultServeMux))
 
 	// Настройка маршрутов
 	http.HandleFunc("/users", getUsers)
@@ -99,17 +131,139 @@ func main() {
 	http.HandleFunc("/users/create", createUser)
 	http.HandleFunc("/users/update", updateUser)
 	http.HandleFunc("/users/delete", deleteUser)
+
+	// Частичное обновление (JSON Merge Patch) идет через usecase-слой.
+	// Коды подтверждения и ссылки сброса пароля уходят письмом через email.notifications
+	resetURL := getEnv("PASSWORD_RESET_URL", getEnv("PUBLIC_BASE_URL", "http://localhost:8080")+"/reset-password")
+	userRepo := repository.NewMongoUserRepository(mainClient, "test", "users")
+	userUseCase = usecase.NewUserUseCase(userRepo, redisClient, cacheTTLSeconds, auditLog, service.NewNATSEmailService(nc), resetURL)
+	// Токены, выданные до сброса пароля, больше не принимаются
+	auth.AddRevocationCheck(func(ctx context.Context, claims *auth.Claims) error {
+		id, err := primitive.ObjectIDFromHex(claims.UserID)
+		if err != nil {
+			return nil
+		}
+		validAfter, err := userUseCase.TokensValidAfter(ctx, id)
+		if err != nil {
+			return err
+		}
+		if claims.IssuedBefore(validAfter) {
+			return auth.ErrRevokedToken
+		}
+		return nil
+	})
+	userHandler := deliveryhttp.NewUserHandler(userUseCase)
+	http.HandleFunc("/users/patch", userHandler.PatchUser)
+	// Подтверждение email кодом
+	http.HandleFunc("/users/verify/send", userHandler.SendVerificationCode)
+	http.HandleFunc("/users/verify", userHandler.VerifyEmail)
+	// Сброс пароля по одноразовой ссылке из письма
+	http.HandleFunc("/users/password/forgot", userHandler.RequestPasswordReset)
+	http.HandleFunc("/users/password/reset", userHandler.ResetPassword)
+	// Двухфакторная аутентификация (TOTP)
+	http.HandleFunc("/users/2fa/enroll", userHandler.BeginTwoFactorEnrollment)
+	http.HandleFunc("/users/2fa/confirm", userHandler.ConfirmTwoFactorEnrollment)
+	http.HandleFunc("/users/2fa/disable", userHandler.DisableTwoFactor)
+	http.HandleFunc("/users/2fa/recovery-codes", userHandler.RegenerateRecoveryCodes)
+	http.HandleFunc("/admin/users/2fa/reset", userHandler.ResetTwoFactor)
+	// Корзина удаленных пользователей (только для администраторов)
+	http.HandleFunc("/admin/users/trash", userHandler.ListDeletedUsers)
+	http.HandleFunc("/admin/users/restore", userHandler.RestoreUser)
+	js, err := nc.JetStream()
+	if err != nil {
+		log.Fatal("Error opening JetStream:", err)
+	}
+	if err := setupUsersStream(js); err != nil {
+		log.Fatal("Error preparing users stream:", err)
+	}
+	go runUserRetention(userUseCase, js, time.Hour)
+	go runRegistrationWebhooks(10 * time.Second)
 	http.HandleFunc("/send-email", sendEmailHandler)
-	http.HandleFunc("/demo/cache", cacheDemoHandler) // Эндпоинт для демонстрации кэширования
+	webhooks.RegisterRoutes(http.DefaultServeMux)
+	auditLog.RegisterRoutes(http.DefaultServeMux)
+
+	// Роли из именованных прав; права ролей пользователя попадают в access-токен
+	roles := rbac.NewService(mainClient.Database("test"), redisClient, rbac.Config{
+		Assign: func(ctx context.Context, userID string, names []string) error {
+			id, err := primitive.ObjectIDFromHex(userID)
+			if err != nil {
+				return rbac.ErrUserNotFound
+			}
+			_, err = userUseCase.AssignRoles(ctx, id, names)
+			if errors.Is(err, domain.ErrUserNotFound) {
+				return rbac.ErrUserNotFound
+			}
+			return err
+		},
+		// Читается напрямую из репозитория: для проверки отзываемых ролей
+		// право users.read не нужно
+		Roles: func(ctx context.Context, userID string) ([]string, error) {
+			id, err := primitive.ObjectIDFromHex(userID)
+			if err != nil {
+				return nil, rbac.ErrUserNotFound
+			}
+			user, err := userRepo.GetUserByID(ctx, id)
+			if errors.Is(err, domain.ErrUserNotFound) {
+				return nil, rbac.ErrUserNotFound
+			}
+			if err != nil {
+				return nil, err
+			}
+			return user.RoleNames(), nil
+		},
+		Audit: auditLog,
+	})
+	roles.RegisterRoutes(http.DefaultServeMux)
+
+	// X-Forwarded-For учитывается только от этих прокси (через запятую, CIDR
+	// или адреса); без них IP клиента для блокировок — адрес соединения
+	if err := session.SetTrustedProxies(strings.Split(getEnv("TRUSTED_PROXIES", ""), ",")); err != nil {
+		log.Fatal("Error reading TRUSTED_PROXIES:", err)
+	}
+
+	// Сессии: access-токены с ротацией refresh-токенов
+	sessions := newSessionService(mainClient.Database("test"), roles)
+	if err := sessions.EnsureIndexes(context.Background()); err != nil {
+		log.Fatal("Error preparing sessions:", err)
+	}
+	auth.AddRevocationCheck(sessions.Check)
+	sessions.RegisterRoutes(http.DefaultServeMux)
+	go sessions.RunImpersonationSweep(context.Background(), time.Minute)
+	// Пароль, email и 2FA не меняются при имперсонации; смену пароля и email
+	// внутри обновления профиля проверяет use case
+	auth.BlockWhileImpersonating("/users/password", "/users/2fa")
+
+	// Ключи API для сервисов и партнеров: проверяются тем же auth.FromRequest,
+	// права ключа на /users — users:read и users:write
+	apiKeys := apikey.NewService(mainClient.Database("test"), auditLog)
+	auth.SetKeyResolver(apiKeys.Resolve)
+	auth.RegisterScope("users", "/users")
+	apiKeys.RegisterRoutes(http.DefaultServeMux)
+
+	// Классы: учитель выдает квизы, ученики вступают по коду приглашения
+	classrooms := classroom.NewService(mainClient.Database("test"), auditLog)
+	if err := classrooms.EnsureIndexes(context.Background()); err != nil {
+		log.Fatal("Error preparing classrooms:", err)
+	}
+	classrooms.RegisterRoutes(http.DefaultServeMux)
+
+	// Запросы субъектов данных: выгрузка и удаление персональных данных
+	privacyService, err := newPrivacyService(mainClient.Database("test"))
+	if err != nil {
+		log.Fatal("Error preparing privacy requests:", err)
+	}
+	privacyService.RegisterRoutes(http.DefaultServeMux)
+	go privacyService.Run(context.Background(), 10*time.Second)
 
-	// Запускаем все сервисы
+	// Запускаем все сервисы. Сервис транзакций подключается синхронно:
+	// gRPC HasEntitlement читает его базу.
+	transaction.StartTransactionService(auditLog, webhooks)
 	go func() {
-		if err := grpc.StartGRPCServer(50051); err != nil {
+		if err := grpc.StartGRPCServer(50051, userUseCase, sessions, classrooms); err != nil {
 			log.Fatalf("Failed to start gRPC server: %v", err)
 		}
 	}()
-	go transaction.StartTransactionService()
-	go quiz.StartQuizService()
+	go quiz.StartQuizService(auditLog, classrooms)
 
 	// Запускаем основной сервер
 	log.Printf("Starting main server on :8080")
@@ -118,7 +272,8 @@ func main() {
 	}
 }
 
-// getUserByID получает пользователя по ID с использованием кэширования Redis
+// getUserByID получает пользователя по ID через use case, который кэширует
+// его в Redis. Свой профиль доступен каждому, чужой — с правом users.read.
 func getUserByID(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -139,173 +294,22 @@ func getUserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Формируем ключ кэша
-	cacheKey := fmt.Sprintf("user:%s", idStr)
-
-	var user map[string]interface{}
-
-	// Проверяем наличие данных в кэше, если Redis доступен
-	if redisClient != nil {
-		// Пытаемся получить данные из кэша
-		err := redisClient.Get(r.Context(), cacheKey, &user)
-		if err == nil {
-			// Данные найдены в кэше, возвращаем их
-			fmt.Println("Cache hit for user:", idStr)
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("X-Cache", "HIT")
-			json.NewEncoder(w).Encode(user)
-			return
-		}
-		fmt.Println("Cache miss for user:", idStr, "Error:", err)
+	user, err := userUseCase.GetUserByID(audit.FromHTTP(r), id)
+	if err == nil && user == nil {
+		err = domain.ErrUserNotFound
 	}
-
-	// Данных нет в кэше или Redis недоступен, получаем из MongoDB
-	collection := mainClient.Database("test").Collection("users")
-	err = collection.FindOne(r.Context(), bson.M{"_id": id}).Decode(&user)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			http.Error(w, "User not found", http.StatusNotFound)
-		} else {
-			http.Error(w, fmt.Sprintf("Error fetching user: %v", err), http.StatusInternalServerError)
-		}
+		writeUserError(w, err, "fetching")
 		return
 	}
 
-	// Сохраняем данные в кэш, если Redis доступен
-	if redisClient != nil {
-		if err := redisClient.Set(r.Context(), cacheKey, user, cacheTTL); err != nil {
-			// Логируем ошибку, но продолжаем работу
-			fmt.Printf("Failed to cache user: %v\n", err)
-		} else {
-			fmt.Println("User cached with key:", cacheKey)
-		}
-	}
-
-	// Возвращаем данные клиенту
+	// domain.User не сериализует пароль и OTP
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Cache", "MISS")
+	etag.Set(w, user.Version)
 	json.NewEncoder(w).Encode(user)
 }
 
-// cacheDemoHandler демонстрирует работу кэша
-func cacheDemoHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Получаем ID пользователя из параметров запроса
-	userIDStr := r.URL.Query().Get("id")
-	if userIDStr == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
-		return
-	}
-
-	// Преобразуем ID в ObjectID
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
-	}
-
-	// Формируем ключ кэша
-	cacheKey := fmt.Sprintf("user:%s", userIDStr)
-
-	// Проверяем, есть ли данные в кэше
-	var cachedUser map[string]interface{}
-	cacheHit := false
-
-	if redisClient != nil {
-		err := redisClient.Get(r.Context(), cacheKey, &cachedUser)
-		cacheHit = err == nil
-	}
-
-	// Информация о состоянии кэша
-	cacheInfo := map[string]interface{}{
-		"cache_hit": cacheHit,
-		"cache_key": cacheKey,
-	}
-
-	if cacheHit {
-		// Если данные есть в кэше, удаляем их для демонстрации обоих сценариев
-		if redisClient != nil {
-			// Удаляем ключ из кэша
-			if err := redisClient.Delete(r.Context(), cacheKey); err != nil {
-				fmt.Printf("Failed to delete key from cache: %v\n", err)
-			} else {
-				fmt.Printf("Key %s deleted from cache for demo\n", cacheKey)
-			}
-		}
-	}
-
-	// Измеряем время первого запроса (без кэша)
-	start := time.Now()
-
-	// Получаем пользователя из БД
-	collection := mainClient.Database("test").Collection("users")
-	var user map[string]interface{}
-	err = collection.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user)
-
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			http.Error(w, "User not found", http.StatusNotFound)
-		} else {
-			http.Error(w, fmt.Sprintf("Error fetching user: %v", err), http.StatusInternalServerError)
-		}
-		return
-	}
-
-	// Время запроса без кэша
-	dbQueryTime := time.Since(start)
-
-	// Сохраняем в кэш
-	if redisClient != nil {
-		if err := redisClient.Set(r.Context(), cacheKey, user, cacheTTL); err != nil {
-			fmt.Printf("Failed to cache user for demo: %v\n", err)
-		} else {
-			fmt.Printf("User cached with key %s for demo\n", cacheKey)
-		}
-	}
-
-	// Измеряем время второго запроса (с кэшем)
-	start = time.Now()
-
-	if redisClient != nil {
-		var cachedUser map[string]interface{}
-		err = redisClient.Get(r.Context(), cacheKey, &cachedUser)
-		if err != nil {
-			fmt.Printf("Cache miss after setting: %v\n", err)
-		}
-	}
-
-	// Время запроса с кэшем
-	cacheQueryTime := time.Since(start)
-
-	// Формируем ответ с информацией о запросах
-	response := map[string]interface{}{
-		"user": user,
-		"cache_demo": map[string]interface{}{
-			"db_query": map[string]interface{}{
-				"duration_ms": dbQueryTime.Milliseconds(),
-				"cached":      false,
-			},
-			"cache_query": map[string]interface{}{
-				"duration_ms": cacheQueryTime.Milliseconds(),
-				"cached":      true,
-			},
-			"cache_info": cacheInfo,
-			"speed_improvement": map[string]interface{}{
-				"factor":         float64(dbQueryTime) / float64(cacheQueryTime),
-				"percent_faster": (1 - float64(cacheQueryTime)/float64(dbQueryTime)) * 100,
-			},
-		},
-	}
-
-	// Возвращаем ответ
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
+// getUsers возвращает страницу пользователей через use case. Нужно право users.read.
 func getUsers(w http.ResponseWriter, r *http.Request) {
 	page := 1
 	limit := 5
@@ -332,50 +336,15 @@ func getUsers(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Printf("Page: %d, Limit: %d, Filter: %s, Sort By: %s, Sort Order: %s\n", page, limit, filter, sortBy, sortOrder)
 
-	collection := mainClient.Database("test").Collection("users")
-	var users []map[string]interface{}
-
-	options := options.Find().SetSkip(int64((page - 1) * limit)).SetLimit(int64(limit))
-
-	if sortOrder == "desc" {
-		options.SetSort(bson.D{{Key: sortBy, Value: -1}})
-	} else {
-		options.SetSort(bson.D{{Key: sortBy, Value: 1}})
-	}
-
-	filterQuery := bson.M{}
-	if filter != "" {
-		filterQuery = bson.M{
-			"$or": []bson.M{
-				{"firstName": bson.M{"$regex": filter, "$options": "i"}},
-				{"lastName": bson.M{"$regex": filter, "$options": "i"}},
-				{"username": bson.M{"$regex": filter, "$options": "i"}},
-			},
-		}
-	}
-
-	fmt.Printf("Executing MongoDB query with filter: %+v\n", filterQuery)
-	cursor, err := collection.Find(context.Background(), filterQuery, options)
+	// Удаленные пользователи лежат в корзине и в список не попадают
+	users, err := userUseCase.GetUsers(audit.FromHTTP(r), page, limit, filter, sortBy, sortOrder)
 	if err != nil {
-		log.Println("Error querying MongoDB:", err)
-		http.Error(w, "Error fetching users", http.StatusInternalServerError)
+		log.Println("Error fetching users:", err)
+		writeUserError(w, err, "fetching")
 		return
 	}
-	defer cursor.Close(context.Background())
-
-	for cursor.Next(context.Background()) {
-		var user map[string]interface{}
-		if err := cursor.Decode(&user); err != nil {
-			log.Println("Error decoding user:", err)
-			continue
-		}
-		users = append(users, user)
-	}
-
-	if err := cursor.Err(); err != nil {
-		log.Println("Error iterating cursor:", err)
-		http.Error(w, "Error fetching users", http.StatusInternalServerError)
-		return
+	if users == nil {
+		users = []domain.User{}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -422,6 +391,7 @@ func sendEmailHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to send email", http.StatusInternalServerError)
 		return
 	}
+	recordSentEmail(to, subject, sentEmailDirect)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Email sent successfully"))
@@ -433,69 +403,89 @@ func createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user bson.M
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	var input domain.UserInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if input.Password == "" {
+		http.Error(w, "Password is required", http.StatusBadRequest)
+		return
+	}
 
-	collection := mainClient.Database("test").Collection("users")
-	result, err := collection.InsertOne(context.Background(), user)
+	// Use case хэширует пароль, выдает роль по умолчанию, пишет аудит и
+	// отправляет код подтверждения
+	user := input.ToUser()
+	id, err := userUseCase.CreateUser(audit.FromHTTP(r), user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeUserError(w, err, "creating")
 		return
 	}
 
+	// Событие user.registered поставит в очередь runRegistrationWebhooks
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id": result.InsertedID,
+		"id": id,
 	})
 }
 
+// updateUser меняет переданные поля профиля через use case (как PatchUser).
+// Поля, которые меняются только своими обработчиками (подтверждение email,
+// сброс пароля, 2FA, роли, удаление), молча пропускаются; для смены пароля
+// нужен текущий пароль в currentPassword.
 func updateUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	expectedVersion, err := etag.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
 	var user bson.M
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	id := user["_id"]
-	delete(user, "_id")
-
-	collection := mainClient.Database("test").Collection("users")
-	result, err := collection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": id},
-		bson.M{"$set": user},
-	)
+	idStr, _ := user["_id"].(string)
+	id, err := primitive.ObjectIDFromHex(idStr)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
 		return
 	}
 
-	// Инвалидация кэша при обновлении
-	if redisClient != nil && id != nil {
-		cacheKey := fmt.Sprintf("user:%v", id)
-		if err := redisClient.Delete(context.Background(), cacheKey); err != nil {
-			// Логируем ошибку, но продолжаем работу
-			fmt.Printf("Failed to invalidate user cache: %v\n", err)
-		} else {
-			fmt.Printf("Cache invalidated for key: %s\n", cacheKey)
+	patch := domain.UserPatch{}
+	for field, value := range user {
+		if _, ok := domain.PatchableUserFields[field]; ok || field == domain.CurrentPasswordField {
+			patch[field] = value
 		}
 	}
+	// Пустой пароль означает, что пароль не меняется
+	if password, ok := patch["password"].(string); ok && password == "" {
+		delete(patch, "password")
+	}
 
+	updated, err := userUseCase.PatchUser(audit.FromHTTP(r), id, patch, expectedVersion)
+	if err != nil {
+		writeUserError(w, err, "updating")
+		return
+	}
+
+	etag.Set(w, updated.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"matchedCount":  result.MatchedCount,
-		"modifiedCount": result.ModifiedCount,
+		"matchedCount":  1,
+		"modifiedCount": 1,
+		"version":       updated.Version,
 	})
 }
 
+// deleteUser переносит пользователя в корзину через use case; окончательно
+// его удалит runUserRetention. Нужно право users.delete.
 func deleteUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -514,30 +504,430 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collection := mainClient.Database("test").Collection("users")
-	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": id})
+	expectedVersion, err := etag.IfMatch(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
 		return
 	}
 
-	// Инвалидация кэша при удалении
-	if redisClient != nil {
-		cacheKey := fmt.Sprintf("user:%s", idStr)
-		if err := redisClient.Delete(context.Background(), cacheKey); err != nil {
-			// Логируем ошибку, но продолжаем работу
-			fmt.Printf("Failed to invalidate user cache: %v\n", err)
-		} else {
-			fmt.Printf("Cache invalidated for key: %s\n", cacheKey)
-		}
+	if err := userUseCase.DeleteUser(audit.FromHTTP(r), id, expectedVersion); err != nil {
+		writeUserError(w, err, "deleting")
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"deletedCount": result.DeletedCount,
+		"deletedCount": 1,
 	})
 }
 
+// writeUserError переводит ошибки use case пользователей в статусы HTTP
+func writeUserError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrForbidden), errors.Is(err, auth.ErrImpersonationBlocked), errors.Is(err, domain.ErrWrongPassword):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, domain.ErrVersionConflict):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, domain.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, domain.ErrInvalidPatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, fmt.Sprintf("Error %s user: %v", action, err), http.StatusInternalServerError)
+	}
+}
+
+// usersPurgedSubject — NATS-тема, по которой сервис транзакций обезличивает
+// транзакции окончательно удаленных пользователей
+const usersPurgedSubject = "users.purged"
+
+// usersStream хранит users.purged в JetStream: событие подтверждается
+// сервером до удаления пользователей и дождется сервиса транзакций, даже
+// если тот сейчас недоступен
+const usersStream = "USERS"
+
+// setupUsersStream создает поток usersStream, если его еще нет
+func setupUsersStream(js nats.JetStreamContext) error {
+	if _, err := js.StreamInfo(usersStream); !errors.Is(err, nats.ErrStreamNotFound) {
+		return err
+	}
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:     usersStream,
+		Subjects: []string{usersPurgedSubject},
+		Storage:  nats.FileStorage,
+	})
+	return err
+}
+
+// runUserRetention раз в interval окончательно удаляет пользователей, которые
+// пролежали в корзине дольше USER_RETENTION_DAYS (по умолчанию 30 дней)
+func runUserRetention(userUseCase domain.UserUseCase, js nats.JetStreamContext, interval time.Duration) {
+	days, err := strconv.Atoi(getEnv("USER_RETENTION_DAYS", "30"))
+	if err != nil || days <= 0 {
+		log.Printf("Invalid USER_RETENTION_DAYS, using 30")
+		days = 30
+	}
+	retention := time.Duration(days) * 24 * time.Hour
+
+	ctx := audit.WithMeta(context.Background(), audit.Meta{Actor: "user-retention", Source: audit.SourceSystem})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// Пользователи удаляются, только когда JetStream подтвердил событие;
+	// иначе они остаются в корзине до следующего запуска
+	publish := func(ctx context.Context, ids []primitive.ObjectID) error {
+		hexIDs := make([]string, len(ids))
+		for i, id := range ids {
+			hexIDs[i] = id.Hex()
+		}
+		data, _ := json.Marshal(map[string][]string{"ids": hexIDs})
+		msg := nats.NewMsg(usersPurgedSubject)
+		msg.Data = data
+		msg.Header.Set(audit.RequestIDHeader, audit.NewRequestID())
+		if _, err := js.PublishMsg(msg, nats.Context(ctx)); err != nil {
+			return fmt.Errorf("failed to publish purged users: %w", err)
+		}
+		return nil
+	}
+
+	for range ticker.C {
+		ids, err := userUseCase.PurgeDeletedUsers(ctx, retention, publish)
+		if err != nil {
+			log.Printf("User retention failed: %v", err)
+			continue
+		}
+		if len(ids) > 0 {
+			log.Printf("Purged %d deleted users", len(ids))
+		}
+	}
+}
+
+// registrationWindow — насколько давние регистрации без события подхватываются:
+// при первом запуске партнеры не получат события о давно созданных аккаунтах
+const registrationWindow = 24 * time.Hour
+
+// runRegistrationWebhooks раз в interval ставит в очередь user.registered для
+// новых пользователей. Пользователи регистрируются и здесь, и в Node, который
+// не знает о вебхуках, поэтому событие строится по документу в базе; отметка
+// registrationEventAt ставится в той же транзакции, что и постановка в очередь.
+func runRegistrationWebhooks(interval time.Duration) {
+	users := mainClient.Database("test").Collection("users")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := context.Background()
+		since := primitive.NewObjectIDFromTimestamp(time.Now().Add(-registrationWindow))
+		cursor, err := users.Find(ctx, bson.M{
+			"_id":                 bson.M{"$gte": since},
+			"registrationEventAt": bson.M{"$exists": false},
+			"deletedAt":           bson.M{"$exists": false},
+		}, options.Find().SetLimit(100))
+		if err != nil {
+			log.Println("Error loading new users for webhooks:", err)
+			continue
+		}
+		var registered []domain.User
+		if err := cursor.All(ctx, &registered); err != nil {
+			log.Println("Error loading new users for webhooks:", err)
+			continue
+		}
+		for _, user := range registered {
+			if err := enqueueRegistration(ctx, users, user); err != nil {
+				log.Printf("Error queueing user.registered webhook for %s: %v", user.ID.Hex(), err)
+			}
+		}
+	}
+}
+
+// enqueueRegistration отмечает пользователя и ставит событие в очередь одной
+// транзакцией; пароль и прочие поля партнерам не передаются
+func enqueueRegistration(ctx context.Context, users *mongo.Collection, user domain.User) error {
+	return mainClient.UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+			res, err := users.UpdateOne(sc,
+				bson.M{"_id": user.ID, "registrationEventAt": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"registrationEventAt": time.Now()}})
+			if err != nil || res.MatchedCount == 0 {
+				return nil, err
+			}
+			return nil, webhooks.Enqueue(sc, webhook.EventUserRegistered, user.ID.Hex(), map[string]interface{}{
+				"id":        user.ID,
+				"username":  user.Username,
+				"email":     user.Email,
+				"firstName": user.FirstName,
+				"lastName":  user.LastName,
+			})
+		})
+		return err
+	})
+}
+
+// newSessionService проверяет пароли, роли и 2FA через usecase-слой пользователей
+func newSessionService(db *mongo.Database, roles *rbac.Service) *session.Service {
+	requireAdmin2FA := getEnv("REQUIRE_ADMIN_2FA", "false") == "true"
+	return session.NewService(db, redisClient, session.Config{
+		Authenticate: func(ctx context.Context, login, password string) (string, error) {
+			user, err := userUseCase.Authenticate(ctx, login, password)
+			if errors.Is(err, domain.ErrInvalidCredentials) {
+				return "", session.ErrInvalidCredentials
+			}
+			if err != nil {
+				return "", err
+			}
+			return user.ID.Hex(), nil
+		},
+		Resolve: func(ctx context.Context, userID string) (session.Identity, error) {
+			id, err := primitive.ObjectIDFromHex(userID)
+			if err != nil {
+				return session.Identity{}, err
+			}
+			user, err := userUseCase.GetUserByID(auth.WithSystem(ctx), id)
+			if errors.Is(err, domain.ErrUserNotFound) || (err == nil && user == nil) {
+				return session.Identity{}, session.ErrUserNotFound
+			}
+			if err != nil {
+				return session.Identity{}, err
+			}
+			validAfter, err := userUseCase.TokensValidAfter(ctx, id)
+			if err != nil {
+				return session.Identity{}, err
+			}
+			identity := session.Identity{Role: user.Role, Roles: user.RoleNames(), TokensValidAfter: validAfter, SecondFactor: user.TwoFactorEnabled}
+			// Политика REQUIRE_ADMIN_2FA: без 2FA администратор входит с правами
+			// обычного пользователя, пока не подключит приложение
+			if requireAdmin2FA && !user.TwoFactorEnabled && containsRole(identity.Roles, auth.RoleAdmin) {
+				identity.Role = ""
+				identity.Roles = nil
+				identity.SetupRequired = true
+			}
+			identity.Permissions, err = roles.Resolve(ctx, identity.Roles)
+			if err != nil {
+				return session.Identity{}, err
+			}
+			return identity, nil
+		},
+		VerifySecondFactor: func(ctx context.Context, userID, code string) error {
+			id, err := primitive.ObjectIDFromHex(userID)
+			if err != nil {
+				return session.ErrInvalidChallenge
+			}
+			err = userUseCase.VerifySecondFactor(ctx, id, code)
+			if errors.Is(err, domain.ErrInvalidTwoFactorCode) || errors.Is(err, domain.ErrTwoFactorLocked) {
+				return fmt.Errorf("%w: %v", session.ErrInvalidSecondFactor, err)
+			}
+			return err
+		},
+		ResolveAccount:      resolveLoginAccount,
+		Audit:               auditLog,
+		NotifyLockout:       notifyLockout,
+		NotifyImpersonation: notifyImpersonation,
+	})
+}
+
+func containsRole(roles []string, name string) bool {
+	for _, role := range roles {
+		if role == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveLoginAccount находит ID пользователя по username или email без учета
+// регистра; по нему считается блокировка входа. Неизвестный логин — "".
+func resolveLoginAccount(ctx context.Context, login string) (string, error) {
+	pattern := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(strings.TrimSpace(login)) + "$", Options: "i"}
+	var user domain.User
+	err := mainClient.Database("test").Collection("users").FindOne(ctx, bson.M{
+		"$or":       []bson.M{{"username": pattern}, {"email": pattern}},
+		"deletedAt": bson.M{"$exists": false},
+	}, options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return user.ID.Hex(), nil
+}
+
+// notifyLockout пишет владельцу заблокированного аккаунта; account — ID
+// пользователя из resolveLoginAccount. Логин без владельца не уведомляется.
+func notifyLockout(ctx context.Context, account string, until time.Time) {
+	id, err := primitive.ObjectIDFromHex(account)
+	if err != nil {
+		return
+	}
+	var user domain.User
+	err = mainClient.Database("test").Collection("users").FindOne(ctx, bson.M{
+		"_id":       id,
+		"deletedAt": bson.M{"$exists": false},
+	}).Decode(&user)
+	if err != nil {
+		return
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\nWe noticed several failed attempts to sign in to your account, so sign-in is locked until %s.\n"+
+		"If this was you, wait and try again or reset your password. If not, we recommend resetting your password and enabling two-factor authentication.",
+		user.FirstName, until.Format(time.RFC1123))
+	sendEmail(user.Email, "Your account is temporarily locked", body, sentEmailNotification)
+}
+
+// notifyImpersonation сообщает пользователю, что администратор входил от его
+// имени; ID администратора в письмо не попадает
+func notifyImpersonation(ctx context.Context, impersonation session.Impersonation) {
+	id, err := primitive.ObjectIDFromHex(impersonation.UserID)
+	if err != nil {
+		return
+	}
+	var user domain.User
+	if err := mainClient.Database("test").Collection("users").FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+		return
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\nA member of our support team viewed your account from %s to %s.\nReason: %s\n"+
+		"Payments and password changes were not available during this time. If you did not ask for help, please contact support.",
+		user.FirstName, impersonation.StartedAt.Format(time.RFC1123), impersonation.EndedAt.Format(time.RFC1123), impersonation.Reason)
+	sendEmail(user.Email, "Support accessed your account", body, sentEmailNotification)
+}
+
+// newPrivacyService описывает, где лежат данные пользователя. Профиль идет
+// последним: по нему ищутся остальные разделы, а при удалении он обезличивается.
+func newPrivacyService(db *mongo.Database) (*privacy.Service, error) {
+	users := db.Collection("users")
+	quizResults := db.Collection("quizresults")
+	sentEmails := db.Collection("sent_emails")
+
+	emailSection := func(name, kind string) privacy.Section {
+		return privacy.Section{
+			Name: name,
+			Export: func(ctx context.Context, subject privacy.Subject) (interface{}, error) {
+				emails := []bson.M{}
+				if subject.Email == "" {
+					return emails, nil
+				}
+				// Тексты писем, записанные до того, как их перестали хранить, в выгрузку не попадают
+				cursor, err := sentEmails.Find(ctx, bson.M{"to": strings.ToLower(subject.Email), "kind": kind},
+					options.Find().SetProjection(bson.M{"body": 0}))
+				if err != nil {
+					return nil, err
+				}
+				err = cursor.All(ctx, &emails)
+				return emails, err
+			},
+			Erase: func(ctx context.Context, subject privacy.Subject) error {
+				if subject.Email == "" {
+					return nil
+				}
+				_, err := sentEmails.DeleteMany(ctx, bson.M{"to": strings.ToLower(subject.Email), "kind": kind})
+				return err
+			},
+		}
+	}
+
+	service, err := privacy.NewService(db, privacy.Config{
+		Resolve: func(ctx context.Context, userID string) (privacy.Subject, error) {
+			id, err := primitive.ObjectIDFromHex(userID)
+			if err != nil {
+				return privacy.Subject{}, mongo.ErrNoDocuments
+			}
+			var user domain.User
+			if err := users.FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+				return privacy.Subject{}, err
+			}
+			return privacy.Subject{UserID: userID, Email: user.Email}, nil
+		},
+		Deliver: func(subject privacy.Subject, link string, expires time.Time) {
+			body := fmt.Sprintf("Your personal data export is ready.\n\nDownload it here: %s\n\nThe link is valid until %s.",
+				link, expires.Format("2006-01-02 15:04 MST"))
+			sendEmail(subject.Email, "Your data export is ready", body, sentEmailDirect)
+		},
+		BaseURL:    getEnv("PUBLIC_BASE_URL", "http://localhost:8080"),
+		ArchiveTTL: 72 * time.Hour,
+		Audit:      auditLog,
+	},
+		privacy.Section{
+			Name: "quiz_results",
+			Export: func(ctx context.Context, subject privacy.Subject) (interface{}, error) {
+				id, _ := primitive.ObjectIDFromHex(subject.UserID)
+				results := []bson.M{}
+				cursor, err := quizResults.Find(ctx, bson.M{"user": id})
+				if err != nil {
+					return nil, err
+				}
+				err = cursor.All(ctx, &results)
+				return results, err
+			},
+			Erase: func(ctx context.Context, subject privacy.Subject) error {
+				id, _ := primitive.ObjectIDFromHex(subject.UserID)
+				_, err := quizResults.DeleteMany(ctx, bson.M{"user": id})
+				return err
+			},
+		},
+		privacy.Section{
+			Name: "transactions",
+			Export: func(ctx context.Context, subject privacy.Subject) (interface{}, error) {
+				return transaction.ExportUserTransactions(ctx, subject.UserID)
+			},
+			// Финансовые документы хранятся по закону, обезличивается только покупатель
+			Erase: func(ctx context.Context, subject privacy.Subject) error {
+				return transaction.EraseUserTransactions(ctx, subject.UserID)
+			},
+		},
+		privacy.Section{
+			Name: "webhook_deliveries",
+			Export: func(ctx context.Context, subject privacy.Subject) (interface{}, error) {
+				return webhooks.UserDeliveries(ctx, subject.UserID)
+			},
+			Erase: func(ctx context.Context, subject privacy.Subject) error {
+				return webhooks.EraseUser(ctx, subject.UserID)
+			},
+		},
+		emailSection("emails", sentEmailDirect),
+		emailSection("notifications", sentEmailNotification),
+		privacy.Section{
+			Name: "profile",
+			Export: func(ctx context.Context, subject privacy.Subject) (interface{}, error) {
+				id, _ := primitive.ObjectIDFromHex(subject.UserID)
+				var user domain.User
+				err := users.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+				return user, err
+			},
+			Erase: func(ctx context.Context, subject privacy.Subject) error {
+				id, _ := primitive.ObjectIDFromHex(subject.UserID)
+				now := time.Now()
+				// Документ остается удаленным обезличенным пользователем, чтобы
+				// ссылки на него из транзакций не повисли; позже его удалит runUserRetention
+				_, err := users.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+					"$set": bson.M{
+						"firstName":  "Deleted",
+						"lastName":   "User",
+						"username":   "deleted-" + subject.UserID,
+						"email":      "deleted-" + subject.UserID + "@erased.invalid",
+						"isVerified": false,
+						"erasedAt":   now,
+						"updatedAt":  now,
+					},
+					"$unset": bson.M{"password": "", "otp": "", "otpExpires": "", "otpHash": "", "resetTokenHash": "", "resetTokenExpires": "", "totpSecret": "", "totpPendingSecret": "", "recoveryCodes": "", "age": "", "gender": ""},
+					"$min":   bson.M{"deletedAt": now},
+					"$inc":   bson.M{"version": 1},
+				})
+				if err != nil {
+					return err
+				}
+				invalidateUserCache(id, -1)
+				return nil
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return service, service.EnsureIndexes(context.Background())
+}
+
 // Helper function to get environment variable with a default value
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -547,7 +937,7 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
-func sendEmail(to, subject, body string) {
+func sendEmail(to, subject, body, kind string) {
 	from := getEnv("EMAIL_USER", "")
 	password := getEnv("EMAIL_PASS", "")
 	if from == "" || password == "" {
@@ -564,6 +954,29 @@ func sendEmail(to, subject, body string) {
 	d := gomail.NewDialer("smtp.gmail.com", 587, from, password)
 	if err := d.DialAndSend(m); err != nil {
 		log.Println("Error sending notification email:", err)
+		return
+	}
+	recordSentEmail(to, subject, kind)
+}
+
+// Виды отправленных писем в журнале sent_emails
+const (
+	sentEmailDirect       = "email"
+	sentEmailNotification = "notification"
+)
+
+// recordSentEmail сохраняет отметку об отправленном письме: пользователь может
+// запросить выгрузку всех писем, которые ему отправлялись. Текст письма не
+// хранится — в нем бывают коды подтверждения и ссылки сброса пароля
+func recordSentEmail(to, subject, kind string) {
+	_, err := mainClient.Database("test").Collection("sent_emails").InsertOne(context.Background(), bson.M{
+		"to":      strings.ToLower(strings.TrimSpace(to)),
+		"subject": subject,
+		"kind":    kind,
+		"sent_at": time.Now(),
+	})
+	if err != nil {
+		log.Println("Error recording sent email:", err)
 	}
 }
 
@@ -575,11 +988,30 @@ func subscribeToEmailNotifications() {
 			return
 		}
 
-		// Process the email data (e.g., send an email)
-		log.Printf("Received email notification: %+v\n", emailData)
-		sendEmail(emailData["to"], emailData["subject"], emailData["body"])
+		// Process the email data (e.g., send an email); the body may carry
+		// codes and reset links, so only the subject is logged
+		log.Printf("Received email notification: %q\n", emailData["subject"])
+		sendEmail(emailData["to"], emailData["subject"], emailData["body"], sentEmailNotification)
 	})
 	if err != nil {
 		log.Println("Error subscribing to NATS subject:", err)
 	}
 }
+
+// invalidateUserCache удаляет пользователя из кэша и запоминает новую версию,
+// чтобы устаревшие копии не возвращались из кэша
+func invalidateUserCache(id primitive.ObjectID, version int64) {
+	if redisClient == nil {
+		return
+	}
+	cacheKey := fmt.Sprintf("user:%s", id.Hex())
+	if err := redisClient.SetVersion(context.Background(), cacheKey, version, 24*time.Hour); err != nil {
+		fmt.Printf("Failed to record user version: %v\n", err)
+	}
+	if err := redisClient.Delete(context.Background(), cacheKey); err != nil {
+		// Логируем ошибку, но продолжаем работу
+		fmt.Printf("Failed to invalidate user cache: %v\n", err)
+	} else {
+		fmt.Printf("Cache invalidated for key: %s\n", cacheKey)
+	}
+}
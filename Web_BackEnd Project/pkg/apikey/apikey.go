@@ -0,0 +1,240 @@
+package apikey
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+)
+
+// Права ключей: "<ресурс>:read" и "<ресурс>:write"; write включает read
+const (
+	ScopeUsersRead         = "users:read"
+	ScopeUsersWrite        = "users:write"
+	ScopeQuizzesRead       = "quizzes:read"
+	ScopeQuizzesWrite      = "quizzes:write"
+	ScopeTransactionsRead  = "transactions:read"
+	ScopeTransactionsWrite = "transactions:write"
+)
+
+// Scopes — все права, которые можно выдать ключу
+var Scopes = []string{
+	ScopeUsersRead, ScopeUsersWrite,
+	ScopeQuizzesRead, ScopeQuizzesWrite,
+	ScopeTransactionsRead, ScopeTransactionsWrite,
+}
+
+const (
+	// keyPrefix помечает ключи, чтобы их было легко найти в логах и репозиториях
+	keyPrefix = "wbk_"
+	// lastUsedInterval — как часто обновляется отметка последнего использования
+	lastUsedInterval = time.Minute
+)
+
+var (
+	// ErrKeyNotFound возвращается, если ключа с таким ID нет
+	ErrKeyNotFound = errors.New("api key not found")
+	// ErrUnknownScope возвращается при выдаче неизвестного права
+	ErrUnknownScope = errors.New("unknown scope")
+	// ErrNoScopes возвращается, если ключ создается без прав
+	ErrNoScopes = errors.New("at least one scope is required")
+)
+
+// Key — ключ API сервиса или партнера. Сам ключ показывается один раз при
+// создании, в базе хранится только его хэш.
+type Key struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	Hash       string             `bson:"hash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedBy  string             `bson:"created_by,omitempty" json:"createdBy,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"createdAt"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty" json:"revokedAt,omitempty"`
+}
+
+// Subject — идентификатор ключа в claims и журнале аудита
+func (k *Key) Subject() string {
+	return "apikey:" + k.ID.Hex()
+}
+
+// Service хранит ключи в коллекции api_keys
+type Service struct {
+	keys  *mongo.Collection
+	audit *audit.Log
+}
+
+// NewService создает сервис; auditLog может быть nil
+func NewService(db *mongo.Database, auditLog *audit.Log) *Service {
+	return &Service{keys: db.Collection("api_keys"), audit: auditLog}
+}
+
+// Create выпускает ключ с правами scopes. ttl = 0 — бессрочный ключ.
+// Возвращает запись и сам ключ, который больше нигде не сохраняется.
+func (s *Service) Create(ctx context.Context, name string, scopes []string, ttl time.Duration, createdBy string) (*Key, string, error) {
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := auth.GenerateToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &Key{
+		ID:        primitive.NewObjectID(),
+		Name:      strings.TrimSpace(name),
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expires := key.CreatedAt.Add(ttl)
+		key.ExpiresAt = &expires
+	}
+	plain := keyPrefix + key.ID.Hex() + "." + secret
+	key.Hash = auth.HashToken(plain)
+
+	if _, err := s.keys.InsertOne(ctx, key); err != nil {
+		return nil, "", fmt.Errorf("failed to store api key: %w", err)
+	}
+	s.audit.Record(ctx, "apikey.create", key.Subject(), nil, key)
+	return key, plain, nil
+}
+
+// List возвращает все ключи, новые первыми
+func (s *Service) List(ctx context.Context) ([]Key, error) {
+	cursor, err := s.keys.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	keys := []Key{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke отзывает ключ; запросы с ним сразу перестают проходить
+func (s *Service) Revoke(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	result, err := s.keys.UpdateOne(ctx,
+		bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrKeyNotFound
+	}
+	s.audit.Record(ctx, "apikey.revoke", "apikey:"+id.Hex(), nil, nil)
+	return nil
+}
+
+// Resolve проверяет ключ и возвращает его claims. Подключается через
+// auth.SetKeyResolver.
+func (s *Service) Resolve(ctx context.Context, plain string) (*auth.Claims, error) {
+	rest, ok := strings.CutPrefix(plain, keyPrefix)
+	if !ok {
+		return nil, auth.ErrInvalidToken
+	}
+	idHex, _, ok := strings.Cut(rest, ".")
+	if !ok {
+		return nil, auth.ErrInvalidToken
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+
+	var key Key
+	err = s.keys.FindOne(ctx, bson.M{"_id": id}).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, auth.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(auth.HashToken(plain))) != 1 {
+		return nil, auth.ErrInvalidToken
+	}
+	now := time.Now()
+	if key.RevokedAt != nil {
+		return nil, auth.ErrRevokedToken
+	}
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return nil, auth.ErrExpiredToken
+	}
+	s.touch(ctx, &key, now)
+
+	claims := &auth.Claims{
+		UserID:   key.Subject(),
+		Role:     auth.RoleService,
+		KeyID:    key.ID.Hex(),
+		Scopes:   key.Scopes,
+		IssuedAt: key.CreatedAt.Unix(),
+	}
+	if key.ExpiresAt != nil {
+		claims.ExpiresAt = key.ExpiresAt.Unix()
+	}
+	return claims, nil
+}
+
+// touch обновляет отметку последнего использования не чаще lastUsedInterval,
+// чтобы частые запросы не писали в базу каждый раз
+func (s *Service) touch(ctx context.Context, key *Key, now time.Time) {
+	if key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < lastUsedInterval {
+		return
+	}
+	_, err := s.keys.UpdateOne(ctx, bson.M{
+		"_id": key.ID,
+		"$or": []bson.M{
+			{"last_used_at": bson.M{"$exists": false}},
+			{"last_used_at": bson.M{"$lt": now.Add(-lastUsedInterval)}},
+		},
+	}, bson.M{"$set": bson.M{"last_used_at": now}})
+	if err != nil {
+		log.Printf("Failed to update api key last use: %v", err)
+	}
+}
+
+// normalizeScopes проверяет права и убирает повторы
+func normalizeScopes(scopes []string) ([]string, error) {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if seen[scope] {
+			continue
+		}
+		known := false
+		for _, candidate := range Scopes {
+			if candidate == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownScope, scope)
+		}
+		seen[scope] = true
+		result = append(result, scope)
+	}
+	if len(result) == 0 {
+		return nil, ErrNoScopes
+	}
+	return result, nil
+}
@@ -0,0 +1,94 @@
+package apikey
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+)
+
+// createdKey — ответ на создание: запись и ключ, который больше не покажется
+type createdKey struct {
+	*Key
+	Secret string `json:"key"`
+}
+
+// HandleKeys: GET — список ключей, POST {"name", "scopes", "expiresInDays"} —
+// новый ключ, DELETE ?id= — отзыв (нужно право apikeys.manage)
+func (s *Service) HandleKeys(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !claims.Can(auth.PermAPIKeysManage) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.List(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to load api keys", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	case http.MethodPost:
+		var body struct {
+			Name          string   `json:"name"`
+			Scopes        []string `json:"scopes"`
+			ExpiresInDays int      `json:"expiresInDays"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" || body.ExpiresInDays < 0 {
+			http.Error(w, "name and scopes are required", http.StatusBadRequest)
+			return
+		}
+
+		ttl := time.Duration(body.ExpiresInDays) * 24 * time.Hour
+		key, plain, err := s.Create(audit.FromHTTP(r), body.Name, body.Scopes, ttl, claims.UserID)
+		if errors.Is(err, ErrUnknownScope) || errors.Is(err, ErrNoScopes) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to create api key", http.StatusInternalServerError)
+			log.Println("Error creating api key:", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(createdKey{Key: key, Secret: plain})
+	case http.MethodDelete:
+		id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid api key ID", http.StatusBadRequest)
+			return
+		}
+		err = s.Revoke(audit.FromHTTP(r), id)
+		if errors.Is(err, ErrKeyNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to revoke api key", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RegisterRoutes подключает обработчики под /admin/api-keys
+func (s *Service) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/api-keys", s.HandleKeys)
+}
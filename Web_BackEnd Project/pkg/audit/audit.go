@@ -0,0 +1,422 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/auth"
+)
+
+// Источники изменений
+const (
+	SourceHTTP   = "http"
+	SourceGRPC   = "grpc"
+	SourceNATS   = "nats"
+	SourceSystem = "system"
+)
+
+// RequestIDHeader передает идентификатор запроса между сервисами
+const RequestIDHeader = "X-Request-ID"
+
+// redacted заменяет значения секретных полей в diff
+const redacted = `"[redacted]"`
+
+// secretFields никогда не попадают в журнал в открытом виде
+var secretFields = map[string]bool{
+	"password":          true,
+	"otp":               true,
+	"otpExpires":        true,
+	"otpHash":           true,
+	"resetTokenHash":    true,
+	"totpSecret":        true,
+	"totpPendingSecret": true,
+	"recoveryCodes":     true,
+	"card_token":        true,
+	"secret":            true,
+}
+
+// personal заменяет значения персональных данных в diff
+const personal = `"[personal data]"`
+
+// personalFields не попадают в журнал: записи цепочки нельзя изменить, поэтому
+// при удалении пользователя их уже не обезличить. В diff остается только факт
+// изменения поля.
+var personalFields = map[string]bool{
+	"email":      true,
+	"firstName":  true,
+	"lastName":   true,
+	"username":   true,
+	"age":        true,
+	"gender":     true,
+	"customer":   true,
+	"emailed_to": true,
+}
+
+// ignoredFields меняются при каждой записи и не несут смысла для аудита
+var ignoredFields = map[string]bool{
+	"updatedAt":  true,
+	"updated_at": true,
+}
+
+// ErrChainBusy возвращается, если запись не удалось добавить из-за постоянных
+// конфликтов с параллельными писателями
+var ErrChainBusy = errors.New("audit chain is busy")
+
+// Meta описывает, кто и откуда вносит изменение
+type Meta struct {
+	Actor     string
+	RequestID string
+	Source    string
+	// OnBehalfOf — пользователь, от имени которого действует Actor при имперсонации
+	OnBehalfOf string
+}
+
+// Authenticated записывает автора из проверенных claims. При имперсонации
+// автором считается администратор, а пользователь попадает в OnBehalfOf.
+func (m *Meta) Authenticated(claims *auth.Claims) {
+	m.Actor = claims.UserID
+	if claims.IsImpersonated() {
+		m.Actor, m.OnBehalfOf = claims.Actor, claims.UserID
+	}
+}
+
+type metaKey struct{}
+
+// WithMeta сохраняет Meta в контексте
+func WithMeta(ctx context.Context, meta Meta) context.Context {
+	return context.WithValue(ctx, metaKey{}, meta)
+}
+
+// MetaFrom достает Meta из контекста. Без Meta изменение считается системным.
+func MetaFrom(ctx context.Context) Meta {
+	meta, _ := ctx.Value(metaKey{}).(Meta)
+	if meta.Actor == "" {
+		meta.Actor = "system"
+	}
+	if meta.Source == "" {
+		meta.Source = SourceSystem
+	}
+	return meta
+}
+
+// FromHTTP возвращает контекст запроса с автором из Bearer-токена и
+// идентификатором запроса из X-Request-ID (или новым). Проверенные claims
+// тоже кладутся в контекст, чтобы use case мог вызвать auth.Require.
+func FromHTTP(r *http.Request) context.Context {
+	ctx := r.Context()
+	meta := Meta{Actor: "anonymous", RequestID: r.Header.Get(RequestIDHeader), Source: SourceHTTP}
+	if claims, err := auth.FromRequest(r); err == nil && claims.UserID != "" {
+		meta.Authenticated(claims)
+		ctx = auth.WithClaims(ctx, claims)
+	}
+	if meta.RequestID == "" {
+		meta.RequestID = NewRequestID()
+	}
+	return WithMeta(ctx, meta)
+}
+
+// NewRequestID генерирует случайный идентификатор запроса
+func NewRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Change — старое и новое значение поля. Значения хранятся в виде JSON,
+// чтобы хэш записи пересчитывался одинаково после чтения из MongoDB.
+type Change struct {
+	From string `bson:"from,omitempty" json:"from,omitempty"`
+	To   string `bson:"to,omitempty" json:"to,omitempty"`
+}
+
+// Record — одна запись журнала. Hash покрывает все поля записи и PrevHash,
+// поэтому изменение или удаление любой записи разрывает цепочку.
+type Record struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Seq       int64              `bson:"seq" json:"seq"`
+	At        time.Time          `bson:"at" json:"at"`
+	Actor     string             `bson:"actor" json:"actor"`
+	Action    string             `bson:"action" json:"action"`
+	Target    string             `bson:"target" json:"target"`
+	RequestID string             `bson:"request_id,omitempty" json:"requestId,omitempty"`
+	Source    string             `bson:"source" json:"source"`
+	Changes   map[string]Change  `bson:"changes,omitempty" json:"changes,omitempty"`
+	PrevHash  string             `bson:"prev_hash" json:"prevHash"`
+	Hash      string             `bson:"hash" json:"hash"`
+	// OnBehalfOf заполняется только при имперсонации
+	OnBehalfOf string `bson:"on_behalf_of,omitempty" json:"onBehalfOf,omitempty"`
+}
+
+// computeHash считает SHA-256 от канонического JSON записи без Hash
+func (rec *Record) computeHash() string {
+	data, _ := json.Marshal(struct {
+		Seq       int64             `json:"seq"`
+		At        string            `json:"at"`
+		Actor     string            `json:"actor"`
+		Action    string            `json:"action"`
+		Target    string            `json:"target"`
+		RequestID string            `json:"request_id"`
+		Source    string            `json:"source"`
+		Changes   map[string]Change `json:"changes"`
+		PrevHash  string            `json:"prev_hash"`
+		// Пустое поле не входит в JSON, поэтому хэши прежних записей не меняются
+		OnBehalfOf string `json:"on_behalf_of,omitempty"`
+	}{rec.Seq, rec.At.UTC().Format(time.RFC3339Nano), rec.Actor, rec.Action, rec.Target,
+		rec.RequestID, rec.Source, rec.Changes, rec.PrevHash, rec.OnBehalfOf})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Filter отбирает записи журнала; пустые поля не ограничивают выборку
+type Filter struct {
+	Actor  string
+	Target string
+	Action string
+	From   time.Time
+	To     time.Time
+}
+
+// Log — журнал аудита только на добавление. Методов изменения и удаления
+// записей нет намеренно.
+type Log struct {
+	records *mongo.Collection
+}
+
+// NewLog создает журнал в коллекции audit_log базы db
+func NewLog(db *mongo.Database) *Log {
+	return &Log{records: db.Collection("audit_log")}
+}
+
+// EnsureIndexes создает индексы: уникальный seq держит цепочку линейной
+func (l *Log) EnsureIndexes(ctx context.Context) error {
+	_, err := l.records.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "target", Value: 1}, {Key: "at", Value: -1}}},
+	})
+	return err
+}
+
+// Record добавляет запись о действии action над target. before и after —
+// состояние объекта до и после (nil для создания и удаления). Ошибка только
+// логируется: изменение уже произошло и откатывать его из-за журнала нельзя.
+func (l *Log) Record(ctx context.Context, action, target string, before, after interface{}) {
+	if l == nil {
+		return
+	}
+	if err := l.Append(ctx, action, target, Diff(before, after)); err != nil {
+		log.Printf("Failed to write audit record %s %s: %v", action, target, err)
+	}
+}
+
+// Append добавляет запись в конец цепочки. Параллельные писатели
+// конкурируют за следующий seq; проигравший перечитывает хвост и повторяет.
+func (l *Log) Append(ctx context.Context, action, target string, changes map[string]Change) error {
+	meta := MetaFrom(ctx)
+	for attempt := 0; attempt < 10; attempt++ {
+		var last Record
+		err := l.records.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		rec := Record{
+			Seq: last.Seq + 1,
+			// MongoDB хранит время с точностью до миллисекунд
+			At:        time.Now().UTC().Truncate(time.Millisecond),
+			Actor:     meta.Actor,
+			Action:    action,
+			Target:    target,
+			RequestID: meta.RequestID,
+			Source:    meta.Source,
+			Changes:   changes,
+			PrevHash:  last.Hash,
+		}
+		rec.OnBehalfOf = meta.OnBehalfOf
+		rec.Hash = rec.computeHash()
+
+		_, err = l.records.InsertOne(ctx, rec)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		return err
+	}
+	return ErrChainBusy
+}
+
+// Query возвращает записи по фильтру, новые первыми
+func (l *Log) Query(ctx context.Context, filter Filter, limit int64) ([]Record, error) {
+	query := bson.M{}
+	if filter.Actor != "" {
+		query["actor"] = filter.Actor
+	}
+	if filter.Target != "" {
+		query["target"] = filter.Target
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	at := bson.M{}
+	if !filter.From.IsZero() {
+		at["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		at["$lt"] = filter.To
+	}
+	if len(at) > 0 {
+		query["at"] = at
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := l.records.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Verification — результат проверки цепочки
+type Verification struct {
+	Checked  int64  `json:"checked"`
+	Valid    bool   `json:"valid"`
+	BrokenAt int64  `json:"brokenAt,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Verify проходит цепочку от начала и находит первую измененную, удаленную
+// или вставленную не по порядку запись
+func (l *Log) Verify(ctx context.Context) (*Verification, error) {
+	cursor, err := l.records.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := &Verification{Valid: true}
+	prevHash := ""
+	for cursor.Next(ctx) {
+		var rec Record
+		if err := cursor.Decode(&rec); err != nil {
+			return nil, err
+		}
+		result.Checked++
+
+		reason := ""
+		switch {
+		case rec.Seq != result.Checked:
+			reason = fmt.Sprintf("expected seq %d", result.Checked)
+		case rec.PrevHash != prevHash:
+			reason = "previous hash does not match"
+		case rec.computeHash() != rec.Hash:
+			reason = "record hash does not match its contents"
+		}
+		if reason != "" {
+			result.Valid = false
+			result.BrokenAt = rec.Seq
+			result.Reason = reason
+			return result, nil
+		}
+		prevHash = rec.Hash
+	}
+	return result, cursor.Err()
+}
+
+// Diff сравнивает два состояния объекта поле за полем. Принимает структуры
+// с bson-тегами и bson.M; nil означает отсутствие объекта.
+func Diff(before, after interface{}) map[string]Change {
+	from, to := toDocument(before), toDocument(after)
+	changes := map[string]Change{}
+	for field := range from {
+		changes[field] = Change{}
+	}
+	for field := range to {
+		changes[field] = Change{}
+	}
+
+	for field := range changes {
+		if ignoredFields[field] || field == "_id" {
+			delete(changes, field)
+			continue
+		}
+		oldValue, newValue := encode(from, field), encode(to, field)
+		if oldValue == newValue {
+			delete(changes, field)
+			continue
+		}
+		if mask := maskFor(field); mask != "" {
+			if oldValue != "" {
+				oldValue = mask
+			}
+			if newValue != "" {
+				newValue = mask
+			}
+		}
+		changes[field] = Change{From: oldValue, To: newValue}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// maskFor возвращает замену для значений секретного или персонального поля
+func maskFor(field string) string {
+	switch {
+	case secretFields[field]:
+		return redacted
+	case personalFields[field]:
+		return personal
+	}
+	return ""
+}
+
+func toDocument(value interface{}) bson.M {
+	if value == nil {
+		return bson.M{}
+	}
+	// bson.M тоже проходит через Marshal: время и числа приводятся к тому
+	// же виду, что у документов, прочитанных из MongoDB
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return bson.M{}
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return bson.M{}
+	}
+	return doc
+}
+
+// encode возвращает JSON значения поля или пустую строку, если поля нет
+func encode(doc bson.M, field string) string {
+	value, ok := doc[field]
+	if !ok || value == nil {
+		return ""
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return strings.TrimSpace(fmt.Sprint(value))
+	}
+	return string(data)
+}
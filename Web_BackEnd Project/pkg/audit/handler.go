@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"web_backend_project/pkg/auth"
+)
+
+// requireAdmin пропускает только тех, у кого есть право audit.read
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if !claims.Can(auth.PermAuditRead) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func parseTime(w http.ResponseWriter, value, name string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		http.Error(w, name+" must be an RFC 3339 time", http.StatusBadRequest)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// HandleRecords: GET ?actor=&target=&action=&from=&to=&limit=&format=csv.
+// CSV выгружает выборку целиком для проверок соответствия.
+func (l *Log) HandleRecords(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	filter := Filter{Actor: q.Get("actor"), Target: q.Get("target"), Action: q.Get("action")}
+	var ok bool
+	if filter.From, ok = parseTime(w, q.Get("from"), "from"); !ok {
+		return
+	}
+	if filter.To, ok = parseTime(w, q.Get("to"), "to"); !ok {
+		return
+	}
+
+	csvExport := q.Get("format") == "csv"
+	limit := int64(100)
+	if csvExport {
+		limit = 0
+	}
+	if n, err := strconv.ParseInt(q.Get("limit"), 10, 64); err == nil && n > 0 {
+		limit = n
+	}
+
+	records, err := l.Query(r.Context(), filter, limit)
+	if err != nil {
+		http.Error(w, "Failed to load audit records", http.StatusInternalServerError)
+		return
+	}
+
+	if !csvExport {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"seq", "at", "actor", "action", "target", "source", "request_id", "changes", "prev_hash", "hash", "on_behalf_of"})
+	for _, rec := range records {
+		writer.Write([]string{
+			strconv.FormatInt(rec.Seq, 10),
+			rec.At.UTC().Format(time.RFC3339Nano),
+			rec.Actor,
+			rec.Action,
+			rec.Target,
+			rec.Source,
+			rec.RequestID,
+			formatChanges(rec.Changes),
+			rec.PrevHash,
+			rec.Hash,
+			rec.OnBehalfOf,
+		})
+	}
+	writer.Flush()
+}
+
+// formatChanges выводит изменения одной строкой: field: from -> to; ...
+func formatChanges(changes map[string]Change) string {
+	fields := make([]string, 0, len(changes))
+	for field := range changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		change := changes[field]
+		parts[i] = field + ": " + change.From + " -> " + change.To
+	}
+	return strings.Join(parts, "; ")
+}
+
+// HandleVerify: GET — проверка целостности цепочки
+func (l *Log) HandleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	result, err := l.Verify(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to verify audit log", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// RegisterRoutes подключает обработчики журнала под /audit/
+func (l *Log) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/audit/records", l.HandleRecords)
+	mux.HandleFunc("/audit/verify", l.HandleVerify)
+}
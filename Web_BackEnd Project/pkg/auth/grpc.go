@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type claimsKey struct{}
+
+// WithClaims кладет проверенные claims в контекст
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext возвращает claims, проверенные UnaryServerInterceptor, или nil
+func FromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsKey{}).(*Claims)
+	return claims
+}
+
+// UnaryServerInterceptor проверяет учетные данные из метаданных authorization
+// или x-api-key так же, как FromRequest. Вызовы без учетных данных проходят
+// анонимно. Ключу API нужно право из scopes для полного имени метода
+// ("/package.Service/Method"); методы без права ключам недоступны. Методы,
+// отмеченные BlockWhileImpersonating, недоступны токенам имперсонации.
+func UnaryServerInterceptor(scopes map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		authorization := firstValue(md, "authorization")
+		apiKey := firstValue(md, strings.ToLower(APIKeyHeader))
+		if authorization == "" && apiKey == "" {
+			return handler(ctx, req)
+		}
+
+		claims, err := Authenticate(ctx, authorization, apiKey)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if claims.IsAPIKey() {
+			scope := scopes[info.FullMethod]
+			if scope == "" || !claims.HasScope(scope) {
+				return nil, status.Error(codes.PermissionDenied, ErrInsufficientScope.Error())
+			}
+		}
+		if claims.IsImpersonated() && BlockedForImpersonation(http.MethodPost, info.FullMethod) {
+			return nil, status.Error(codes.PermissionDenied, ErrImpersonationBlocked.Error())
+		}
+		return handler(WithClaims(ctx, claims), req)
+	}
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return strings.TrimSpace(values[0])
+	}
+	return ""
+}
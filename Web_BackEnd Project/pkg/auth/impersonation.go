@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrImpersonationBlocked возвращается для опасных действий (оплата, смена
+// пароля), если токен выдан администратору от имени пользователя
+var ErrImpersonationBlocked = errors.New("action is not allowed while impersonating a user")
+
+var (
+	impersonationMu     sync.RWMutex
+	impersonationBlocks []string
+)
+
+// IsImpersonated сообщает, выдан ли токен администратору Actor от имени UserID
+func (c *Claims) IsImpersonated() bool {
+	return c != nil && c.Actor != ""
+}
+
+// BlockWhileImpersonating запрещает изменяющие запросы (все методы, кроме
+// GET и HEAD) к путям с указанными префиксами для токенов имперсонации.
+// Запрет проверяет ImpersonationGuard.
+func BlockWhileImpersonating(prefixes ...string) {
+	impersonationMu.Lock()
+	defer impersonationMu.Unlock()
+	impersonationBlocks = append(impersonationBlocks, prefixes...)
+}
+
+// BlockedForImpersonation сообщает, запрещен ли запрос токену имперсонации
+func BlockedForImpersonation(method, path string) bool {
+	if method == http.MethodGet || method == http.MethodHead {
+		return false
+	}
+	impersonationMu.RLock()
+	defer impersonationMu.RUnlock()
+	for _, prefix := range impersonationBlocks {
+		if matchPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForbidImpersonation возвращает ErrImpersonationBlocked, если в контексте
+// токен имперсонации. Use case вызывает его там, где опасное действие нельзя
+// отличить по пути, например смена пароля внутри обновления профиля.
+func ForbidImpersonation(ctx context.Context) error {
+	if FromContext(ctx).IsImpersonated() {
+		return ErrImpersonationBlocked
+	}
+	return nil
+}
+
+// ImpersonationGuard отвечает 403 на запросы, запрещенные
+// BlockWhileImpersonating. Оборачивает весь сервер: часть платежных
+// обработчиков не требует токена и сама бы запрет не проверила.
+func ImpersonationGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if BlockedForImpersonation(r.Method, r.URL.Path) && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			claims, err := ParseToken(Secret(), strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")))
+			if err == nil && claims.IsImpersonated() {
+				http.Error(w, ErrImpersonationBlocked.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// opaqueTokenBytes — 256 бит случайности, перебор такого токена невозможен
+const opaqueTokenBytes = 32
+
+// GenerateToken возвращает случайный непрозрачный токен для ссылок сброса
+// пароля и подобных одноразовых секретов
+func GenerateToken() (string, error) {
+	buf := make([]byte, opaqueTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken возвращает SHA-256 токена. В базе хранится только хэш, поэтому
+// утечка базы не дает действующих токенов
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+)
+
+// otpDigits совпадает с шестизначным кодом из Node authController
+const otpDigits = 6
+
+// GenerateOTP возвращает случайный шестизначный код
+func GenerateOTP() (string, error) {
+	max := big.NewInt(1_000_000)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate code: %w", err)
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n.Int64()), nil
+}
+
+// HashOTP возвращает HMAC кода, привязанный к subject: одинаковые коды
+// разных пользователей дают разные хэши
+func HashOTP(subject, code string) string {
+	mac := hmac.New(sha256.New, Secret())
+	mac.Write([]byte(subject + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckOTP сравнивает код с сохраненным хэшем за постоянное время
+func CheckOTP(hash, subject, code string) bool {
+	return hmac.Equal([]byte(hash), []byte(HashOTP(subject, code)))
+}
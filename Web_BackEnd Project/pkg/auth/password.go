@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost совпадает с bcrypt.hash(password, 10) в Node userModel,
+// поэтому хэши взаимозаменяемы между сервисами
+const passwordCost = 10
+
+// ErrPasswordHashed возвращается, если вместо пароля прислали готовый bcrypt-хэш
+var ErrPasswordHashed = errors.New("password must be sent in plain text")
+
+// HashPassword возвращает bcrypt-хэш пароля
+func HashPassword(password string) (string, error) {
+	if _, err := bcrypt.Cost([]byte(password)); err == nil {
+		return "", ErrPasswordHashed
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword сравнивает пароль с сохраненным хэшем
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
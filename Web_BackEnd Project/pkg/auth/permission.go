@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Права пользователей: "<ресурс>.<действие>". Роли составляются из прав
+// (см. pkg/rbac), итоговый набор попадает в access-токен.
+const (
+	PermUsersRead          = "users.read"
+	PermUsersUpdate        = "users.update"
+	PermUsersDelete        = "users.delete"
+	PermUsersRestore       = "users.restore"
+	PermUsersReset2FA      = "users.reset_2fa"
+	PermUsersImpersonate   = "users.impersonate"
+	PermQuizzesCreate      = "quizzes.create"
+	PermQuizzesPublish     = "quizzes.publish"
+	PermQuizzesDelete      = "quizzes.delete"
+	PermClassroomsManage   = "classrooms.manage"
+	PermClassroomsAdmin    = "classrooms.admin"
+	PermTransactionsRead   = "transactions.read"
+	PermTransactionsRefund = "transactions.refund"
+	PermProductsManage     = "products.manage"
+	PermPrivacyManage      = "privacy.manage"
+	PermSessionsManage     = "sessions.manage"
+	PermAuditRead          = "audit.read"
+	PermWebhooksManage     = "webhooks.manage"
+	PermAPIKeysManage      = "apikeys.manage"
+	PermRolesManage        = "roles.manage"
+)
+
+const (
+	// PermissionAll дает все права
+	PermissionAll = "*"
+	// RoleAdmin — встроенная роль со всеми правами
+	RoleAdmin = "admin"
+	// RoleUser — роль нового пользователя, без прав
+	RoleUser = "user"
+)
+
+// Permissions — все права, из которых можно составить роль
+var Permissions = []string{
+	PermUsersRead, PermUsersUpdate, PermUsersDelete, PermUsersRestore, PermUsersReset2FA, PermUsersImpersonate,
+	PermQuizzesCreate, PermQuizzesPublish, PermQuizzesDelete, PermClassroomsManage, PermClassroomsAdmin,
+	PermTransactionsRead, PermTransactionsRefund, PermProductsManage, PermPrivacyManage,
+	PermSessionsManage, PermAuditRead, PermWebhooksManage, PermAPIKeysManage, PermRolesManage,
+}
+
+var (
+	// ErrForbidden возвращается Require, если у вызывающего нет права
+	ErrForbidden = errors.New("permission denied")
+	// ErrUnauthenticated возвращается Require, если в контексте нет claims
+	ErrUnauthenticated = errors.New("authentication required")
+)
+
+// Can сообщает, есть ли у токена право permission. Роль admin и право "*"
+// дают все права. Ключу API право дает scope ресурса: read-права — "<ресурс>:read",
+// остальные — "<ресурс>:write".
+func (c *Claims) Can(permission string) bool {
+	if c == nil {
+		return false
+	}
+	if c.IsAPIKey() {
+		resource, action, _ := strings.Cut(permission, ".")
+		if action == "read" {
+			return c.HasScope(resource + ":read")
+		}
+		return c.HasScope(resource + ":write")
+	}
+	if c.IsAdmin() {
+		return true
+	}
+	for _, granted := range c.Permissions {
+		if granted == permission || granted == PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole сообщает, назначена ли пользователю роль name
+func (c *Claims) HasRole(name string) bool {
+	if c == nil {
+		return false
+	}
+	if c.Role == name {
+		return true
+	}
+	for _, role := range c.Roles {
+		if role == name {
+			return true
+		}
+	}
+	return false
+}
+
+type systemKey struct{}
+
+// WithSystem помечает контекст фоновой задачи сервиса: Require его пропускает
+func WithSystem(ctx context.Context) context.Context {
+	return context.WithValue(ctx, systemKey{}, true)
+}
+
+// Require проверяет право вызывающего по claims из контекста (их кладут
+// audit.FromHTTP и UnaryServerInterceptor). Use case вызывает его сам, чтобы
+// право проверялось одинаково для HTTP, gRPC и фоновых задач.
+func Require(ctx context.Context, permission string) error {
+	if system, _ := ctx.Value(systemKey{}).(bool); system {
+		return nil
+	}
+	claims := FromContext(ctx)
+	if claims == nil {
+		return ErrUnauthenticated
+	}
+	if !claims.Can(permission) {
+		return ErrForbidden
+	}
+	return nil
+}
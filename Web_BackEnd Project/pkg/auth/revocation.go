@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRevokedToken возвращается для отозванного токена: выданного до сброса
+// пароля или принадлежащего закрытой сессии
+var ErrRevokedToken = errors.New("token revoked")
+
+// RevocationCheck возвращает ErrRevokedToken, если токен с такими claims
+// больше не действует
+type RevocationCheck func(ctx context.Context, claims *Claims) error
+
+var (
+	revocationMu     sync.RWMutex
+	revocationChecks []RevocationCheck
+)
+
+// AddRevocationCheck подключает проверку отзыва к FromRequest
+func AddRevocationCheck(check RevocationCheck) {
+	revocationMu.Lock()
+	defer revocationMu.Unlock()
+	revocationChecks = append(revocationChecks, check)
+}
+
+// CheckRevoked прогоняет токен через все подключенные проверки
+func CheckRevoked(ctx context.Context, claims *Claims) error {
+	revocationMu.RLock()
+	checks := revocationChecks
+	revocationMu.RUnlock()
+
+	for _, check := range checks {
+		if err := check(ctx, claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IssuedBefore сообщает, выдан ли токен раньше момента t.
+// Нулевое t означает, что отзыва не было.
+func (c *Claims) IssuedBefore(t time.Time) bool {
+	return !t.IsZero() && c.IssuedAt < t.Unix()
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// APIKeyHeader — заголовок с ключом API; ключ можно передать и как
+	// "Authorization: ApiKey <key>"
+	APIKeyHeader = "X-API-Key"
+	// RoleService — роль в claims ключа API
+	RoleService = "service"
+)
+
+var (
+	// ErrInsufficientScope возвращается, если у ключа API нет нужного права
+	ErrInsufficientScope = errors.New("api key lacks required scope")
+	// ErrAPIKeysDisabled возвращается, если ключ прислали, а проверка ключей не подключена
+	ErrAPIKeysDisabled = errors.New("api keys are not accepted")
+)
+
+// KeyResolver проверяет ключ API и возвращает его claims: KeyID, Scopes и
+// Role = RoleService. Неизвестный ключ — ErrInvalidToken.
+type KeyResolver func(ctx context.Context, key string) (*Claims, error)
+
+// scopeRoute — ресурс, которому принадлежат пути с префиксом prefix
+type scopeRoute struct {
+	prefix   string
+	resource string
+}
+
+var (
+	scopeMu     sync.RWMutex
+	keyResolver KeyResolver
+	scopeRoutes []scopeRoute
+)
+
+// SetKeyResolver подключает проверку ключей API к FromRequest и Authenticate
+func SetKeyResolver(resolver KeyResolver) {
+	scopeMu.Lock()
+	defer scopeMu.Unlock()
+	keyResolver = resolver
+}
+
+// RegisterScope относит пути с указанными префиксами к ресурсу: GET и HEAD
+// требуют у ключа право "<resource>:read", остальные методы — "<resource>:write".
+// Пути, не отнесенные ни к одному ресурсу, ключам API недоступны.
+func RegisterScope(resource string, prefixes ...string) {
+	scopeMu.Lock()
+	defer scopeMu.Unlock()
+	for _, prefix := range prefixes {
+		scopeRoutes = append(scopeRoutes, scopeRoute{prefix: prefix, resource: resource})
+	}
+	// Самый длинный префикс проверяется первым
+	sort.SliceStable(scopeRoutes, func(i, j int) bool {
+		return len(scopeRoutes[i].prefix) > len(scopeRoutes[j].prefix)
+	})
+}
+
+// RequiredScope возвращает право, нужное ключу для запроса, или "", если
+// путь ключам недоступен
+func RequiredScope(method, path string) string {
+	scopeMu.RLock()
+	defer scopeMu.RUnlock()
+	for _, route := range scopeRoutes {
+		if !matchPrefix(path, route.prefix) {
+			continue
+		}
+		if method == http.MethodGet || method == http.MethodHead {
+			return route.resource + ":read"
+		}
+		return route.resource + ":write"
+	}
+	return ""
+}
+
+// matchPrefix сообщает, совпадает ли путь с prefix или лежит под ним
+func matchPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// IsAPIKey сообщает, выданы ли claims по ключу API, а не пользователю
+func (c *Claims) IsAPIKey() bool {
+	return c != nil && c.KeyID != ""
+}
+
+// HasScope сообщает, есть ли у ключа право scope; право "<resource>:write"
+// включает "<resource>:read". Для токенов пользователей права не ограничены.
+func (c *Claims) HasScope(scope string) bool {
+	if c == nil {
+		return false
+	}
+	if !c.IsAPIKey() {
+		return true
+	}
+	for _, granted := range c.Scopes {
+		if granted == scope {
+			return true
+		}
+		if resource, ok := strings.CutSuffix(scope, ":read"); ok && granted == resource+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+// CanActFor сообщает, можно ли работать с данными пользователя userID:
+// самому пользователю, администратору и ключу API (его права уже проверены
+// по маршруту)
+func (c *Claims) CanActFor(userID string) bool {
+	if c == nil {
+		return false
+	}
+	return c.IsAdmin() || c.IsAPIKey() || (userID != "" && c.UserID == userID)
+}
+
+// Authenticate проверяет учетные данные из заголовка Authorization
+// ("Bearer <token>" или "ApiKey <key>") или ключ из APIKeyHeader
+func Authenticate(ctx context.Context, authorization, apiKey string) (*Claims, error) {
+	if key, ok := strings.CutPrefix(authorization, "ApiKey "); ok && apiKey == "" {
+		apiKey = strings.TrimSpace(key)
+	}
+	if apiKey != "" {
+		scopeMu.RLock()
+		resolver := keyResolver
+		scopeMu.RUnlock()
+		if resolver == nil {
+			return nil, ErrAPIKeysDisabled
+		}
+		return resolver(ctx, apiKey)
+	}
+
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return nil, ErrMissingToken
+	}
+	claims, err := ParseToken(Secret(), strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer ")))
+	if err != nil {
+		return nil, err
+	}
+	// Служебный токен не заменяет access-токен
+	if claims.Purpose != "" {
+		return nil, ErrInvalidToken
+	}
+	// Ключ API не подделать подписанным токеном с kid
+	if claims.KeyID != "" {
+		return nil, ErrInvalidToken
+	}
+	if err := CheckRevoked(ctx, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SignLink добавляет к ссылке срок действия и подпись.
+// Такие ссылки можно отправлять по email: они работают без авторизации
+// до момента expires.
+func SignLink(secret []byte, link string, expires time.Time) (string, error) {
+	if len(secret) == 0 {
+		return "", fmt.Errorf("auth secret is not configured")
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("invalid link: %w", err)
+	}
+
+	query := u.Query()
+	query.Del("sig")
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", sign(secret, u.Path+"?"+query.Encode()))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// VerifyLink проверяет подпись и срок действия ссылки, созданной SignLink
+func VerifyLink(secret []byte, u *url.URL) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("auth secret is not configured")
+	}
+
+	query := u.Query()
+	signature := query.Get("sig")
+	if signature == "" {
+		return ErrMissingToken
+	}
+	query.Del("sig")
+
+	if !hmac.Equal([]byte(sign(secret, u.Path+"?"+query.Encode())), []byte(signature)) {
+		return ErrInvalidToken
+	}
+
+	exp, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if time.Now().Unix() >= exp {
+		return ErrExpiredToken
+	}
+
+	return nil
+}
+
+// IsSignedLink сообщает, содержит ли ссылка подпись
+func IsSignedLink(u *url.URL) bool {
+	return u.Query().Get("sig") != ""
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken возвращается, если токен поврежден или подпись не совпадает
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken возвращается, если срок действия токена истек
+	ErrExpiredToken = errors.New("token expired")
+	// ErrMissingToken возвращается, если в запросе нет заголовка Authorization
+	ErrMissingToken = errors.New("missing bearer token")
+)
+
+// Claims описывает данные, которые переносит access-токен
+type Claims struct {
+	UserID    string `json:"sub"`
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	// Roles и Permissions — роли пользователя и права, собранные из них при выдаче токена
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"perm,omitempty"`
+	// Actor — администратор, действующий от имени UserID (см. impersonation.go)
+	Actor string `json:"act,omitempty"`
+	// Purpose отличает служебные токены (например, шаг 2FA) от access-токенов
+	Purpose string `json:"pur,omitempty"`
+	// KeyID и Scopes заполняются только для ключей API
+	KeyID     string   `json:"kid,omitempty"`
+	Scopes    []string `json:"scp,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// IsAdmin сообщает, принадлежит ли токен администратору
+func (c *Claims) IsAdmin() bool {
+	return c != nil && !c.IsAPIKey() && c.HasRole(RoleAdmin)
+}
+
+// Secret возвращает ключ подписи из переменной окружения AUTH_SECRET
+func Secret() []byte {
+	return []byte(os.Getenv("AUTH_SECRET"))
+}
+
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// NewToken подписывает claims и возвращает компактный JWT (HS256)
+func NewToken(secret []byte, claims Claims) (string, error) {
+	if len(secret) == 0 {
+		return "", fmt.Errorf("auth secret is not configured")
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal claims: %w", err)
+	}
+
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + sign(secret, unsigned), nil
+}
+
+// ParseToken проверяет подпись и срок действия токена
+func ParseToken(secret []byte, token string) (*Claims, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("auth secret is not configured")
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, ErrInvalidToken
+	}
+
+	expected := sign(secret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.ExpiresAt != 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+// FromRequest извлекает и проверяет Bearer-токен из заголовка Authorization
+// или ключ API, включая отзыв через AddRevocationCheck. Ключу нужно право,
+// которое RequiredScope назначает пути запроса.
+func FromRequest(r *http.Request) (*Claims, error) {
+	claims, err := Authenticate(r.Context(), r.Header.Get("Authorization"), r.Header.Get(APIKeyHeader))
+	if err != nil {
+		return nil, err
+	}
+	if claims.IsAPIKey() {
+		scope := RequiredScope(r.Method, r.URL.Path)
+		if scope == "" || !claims.HasScope(scope) {
+			return nil, ErrInsufficientScope
+		}
+	}
+	return claims, nil
+}
+
+func sign(secret []byte, data string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
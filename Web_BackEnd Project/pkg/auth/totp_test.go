@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret — ключ "12345678901234567890" из приложения B RFC 6238 в base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Векторы SHA1 из RFC 6238; коды — последние шесть цифр восьмизначных из RFC
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		code, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", v.unix, err)
+		}
+		if code != v.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestValidateTOTPRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		at := time.Unix(v.unix, 0)
+		step, ok := ValidateTOTP(rfcSecret, v.code, at, 0)
+		if !ok {
+			t.Errorf("ValidateTOTP rejected the RFC code at %d", v.unix)
+			continue
+		}
+		if want := TOTPStep(at); step != want {
+			t.Errorf("ValidateTOTP at %d returned step %d, want %d", v.unix, step, want)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	at := time.Unix(1234567890, 0)
+	current := TOTPStep(at)
+
+	for _, tc := range []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"previous step", -1, true},
+		{"next step", 1, true},
+		{"two steps behind", -2, false},
+		{"two steps ahead", 2, false},
+	} {
+		code, err := TOTPCode(rfcSecret, current+tc.offset)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		step, ok := ValidateTOTP(rfcSecret, code, at, 0)
+		if ok != tc.ok {
+			t.Errorf("%s: ValidateTOTP ok = %v, want %v", tc.name, ok, tc.ok)
+		}
+		if ok && step != current+tc.offset {
+			t.Errorf("%s: ValidateTOTP step = %d, want %d", tc.name, step, current+tc.offset)
+		}
+	}
+}
+
+func TestValidateTOTPReplay(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	step, ok := ValidateTOTP(rfcSecret, "050471", at, 0)
+	if !ok {
+		t.Fatal("ValidateTOTP rejected the first use of the code")
+	}
+
+	// Повтор того же кода после успешного входа
+	if _, ok := ValidateTOTP(rfcSecret, "050471", at, step); ok {
+		t.Error("ValidateTOTP accepted a code for an already used step")
+	}
+	// Код предыдущего шага не проходит, если уже использован более поздний
+	previous, err := TOTPCode(rfcSecret, step-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ValidateTOTP(rfcSecret, previous, at, step); ok {
+		t.Error("ValidateTOTP accepted a code older than the last used step")
+	}
+	// Следующий шаг после использованного принимается
+	next, err := TOTPCode(rfcSecret, step+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := ValidateTOTP(rfcSecret, next, at, step); !ok || got != step+1 {
+		t.Errorf("ValidateTOTP(next step) = %d, %v; want %d, true", got, ok, step+1)
+	}
+}
+
+func TestValidateTOTPMalformed(t *testing.T) {
+	at := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082"} {
+		if _, ok := ValidateTOTP(rfcSecret, code, at, 0); ok {
+			t.Errorf("ValidateTOTP accepted %q", code)
+		}
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", at, 0); ok {
+		t.Error("ValidateTOTP accepted a code for an invalid secret")
+	}
+	// Пробелы вокруг кода допускаются
+	if _, ok := ValidateTOTP(rfcSecret, " 287082 ", at, 0); !ok {
+		t.Error("ValidateTOTP rejected a code with surrounding spaces")
+	}
+}
@@ -81,6 +81,80 @@ func (r *RedisClient) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// Exists сообщает, есть ли ключ в кэше. В отличие от Get отличает
+// отсутствие ключа от ошибки Redis
+func (r *RedisClient) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := r.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check key %s in Redis: %w", key, err)
+	}
+	return n > 0, nil
+}
+
+// TTL возвращает оставшееся время жизни ключа; 0 — ключа нет или срок не задан
+func (r *RedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.TTL(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get TTL of key %s from Redis: %w", key, err)
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// HitWindow отмечает событие в скользящем окне key и возвращает число
+// событий за последние window. Окно — sorted set с временем событий.
+func (r *RedisClient) HitWindow(ctx context.Context, key string, window time.Duration) (int64, error) {
+	now := time.Now()
+	var count *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprint(now.Add(-window).UnixNano()))
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixNano()), Member: now.UnixNano()})
+		count = pipe.ZCard(ctx, key)
+		pipe.Expire(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to update window %s in Redis: %w", key, err)
+	}
+	return count.Val(), nil
+}
+
+// CountWindow возвращает число событий в окне key за последние window
+func (r *RedisClient) CountWindow(ctx context.Context, key string, window time.Duration) (int64, error) {
+	min := fmt.Sprint(time.Now().Add(-window).UnixNano())
+	count, err := r.client.ZCount(ctx, key, min, "+inf").Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count window %s in Redis: %w", key, err)
+	}
+	return count, nil
+}
+
+// versionKey возвращает ключ, в котором хранится последняя версия документа key
+func versionKey(key string) string {
+	return key + ":version"
+}
+
+// SetVersion запоминает последнюю записанную версию документа.
+// Хранится дольше любой закэшированной копии, чтобы старые копии можно было распознать.
+func (r *RedisClient) SetVersion(ctx context.Context, key string, version int64, expiration time.Duration) error {
+	if err := r.client.Set(ctx, versionKey(key), version, expiration).Err(); err != nil {
+		return fmt.Errorf("failed to set version for %s in Redis: %w", key, err)
+	}
+	return nil
+}
+
+// IsStale сообщает, что копия с версией version старше последней записанной.
+// Если версия неизвестна, копия считается актуальной.
+func (r *RedisClient) IsStale(ctx context.Context, key string, version int64) bool {
+	latest, err := r.client.Get(ctx, versionKey(key)).Int64()
+	if err != nil {
+		return false
+	}
+	return version != latest
+}
+
 // Close закрывает соединение с Redis
 func (r *RedisClient) Close() error {
 	return r.client.Close()
@@ -0,0 +1,498 @@
+package classroom
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+)
+
+const (
+	// inviteCodeLength — длина кода приглашения; алфавит без похожих символов
+	inviteCodeLength = 8
+	inviteAlphabet   = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	// inviteAttempts — сколько раз генерируется новый код при совпадении
+	inviteAttempts = 5
+)
+
+var (
+	// ErrClassroomNotFound возвращается, если класса нет или он недоступен вызывающему
+	ErrClassroomNotFound = errors.New("classroom not found")
+	// ErrAssignmentNotFound возвращается, если задания нет или оно недоступно вызывающему
+	ErrAssignmentNotFound = errors.New("assignment not found")
+	// ErrInvalidInviteCode возвращается для неизвестного кода приглашения
+	ErrInvalidInviteCode = errors.New("invalid invite code")
+	// ErrNotMember возвращается, если ученик открывает квиз чужого класса
+	ErrNotMember = errors.New("quiz is assigned to classrooms you are not a member of")
+	// ErrInvalidInput возвращается для пустого названия, квиза или срока
+	ErrInvalidInput = errors.New("invalid classroom input")
+)
+
+// Classroom — класс учителя. Ученики вступают по InviteCode.
+type Classroom struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	TeacherID  string             `bson:"teacher_id" json:"teacherId"`
+	InviteCode string             `bson:"invite_code" json:"inviteCode,omitempty"`
+	Students   []string           `bson:"students" json:"students,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"createdAt"`
+}
+
+// Assignment — квиз, выданный классу со сроком сдачи
+type Assignment struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ClassroomID   primitive.ObjectID `bson:"classroom_id" json:"classroomId"`
+	ClassroomName string             `bson:"classroom_name" json:"classroomName"`
+	QuizID        string             `bson:"quiz_id" json:"quizId"`
+	Title         string             `bson:"title" json:"title"`
+	DueAt         time.Time          `bson:"due_at" json:"dueAt"`
+	AssignedBy    string             `bson:"assigned_by" json:"assignedBy"`
+	CreatedAt     time.Time          `bson:"created_at" json:"createdAt"`
+}
+
+// Result — последняя попытка ученика по заданию
+type Result struct {
+	StudentID   string     `json:"studentId"`
+	Submitted   bool       `json:"submitted"`
+	Score       int        `json:"score,omitempty"`
+	SubmittedAt *time.Time `json:"submittedAt,omitempty"`
+	Late        bool       `json:"late,omitempty"`
+}
+
+// StudentAssignment — задание в списке ученика вместе с его результатом
+type StudentAssignment struct {
+	Assignment
+	Result
+}
+
+// quizResult — документ Node QuizResult; quiz есть только у результатов,
+// сохраненных с указанием квиза
+type quizResult struct {
+	User       primitive.ObjectID `bson:"user"`
+	Quiz       string             `bson:"quiz,omitempty"`
+	TotalScore int                `bson:"totalScore"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+}
+
+// Service хранит классы и задания в MongoDB и читает результаты из
+// коллекции quizresults, которую пишет Node
+type Service struct {
+	classrooms  *mongo.Collection
+	assignments *mongo.Collection
+	results     *mongo.Collection
+	audit       *audit.Log
+}
+
+// NewService создает сервис над коллекциями базы db; auditLog может быть nil
+func NewService(db *mongo.Database, auditLog *audit.Log) *Service {
+	return &Service{
+		classrooms:  db.Collection("classrooms"),
+		assignments: db.Collection("classroom_assignments"),
+		results:     db.Collection("quizresults"),
+		audit:       auditLog,
+	}
+}
+
+// EnsureIndexes создает уникальный индекс кодов приглашения и индексы выборок
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	_, err := s.classrooms.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "invite_code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "teacher_id", Value: 1}}},
+		{Keys: bson.D{{Key: "students", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.assignments.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "classroom_id", Value: 1}, {Key: "due_at", Value: 1}}},
+		{Keys: bson.D{{Key: "quiz_id", Value: 1}}},
+	})
+	return err
+}
+
+// caller возвращает проверенные claims из контекста
+func caller(ctx context.Context) (*auth.Claims, error) {
+	claims := auth.FromContext(ctx)
+	if claims == nil {
+		return nil, auth.ErrUnauthenticated
+	}
+	return claims, nil
+}
+
+// Create создает класс; вызывающий становится его учителем.
+// Нужно право classrooms.manage.
+func (s *Service) Create(ctx context.Context, name string) (*Classroom, error) {
+	if err := auth.Require(ctx, auth.PermClassroomsManage); err != nil {
+		return nil, err
+	}
+	claims, err := caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidInput)
+	}
+
+	classroom := &Classroom{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		TeacherID: claims.UserID,
+		Students:  []string{},
+		CreatedAt: time.Now(),
+	}
+	for attempt := 0; ; attempt++ {
+		classroom.InviteCode, err = newInviteCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.classrooms.InsertOne(ctx, classroom)
+		if mongo.IsDuplicateKeyError(err) && attempt < inviteAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create classroom: %w", err)
+		}
+		break
+	}
+	s.audit.Record(ctx, "classroom.create", classroomTarget(classroom.ID), nil, classroom)
+	return classroom, nil
+}
+
+// List возвращает классы, где вызывающий учитель или ученик. Ученики не
+// видят код приглашения и состав класса.
+func (s *Service) List(ctx context.Context) ([]Classroom, error) {
+	claims, err := caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := s.classrooms.Find(ctx, bson.M{"$or": []bson.M{
+		{"teacher_id": claims.UserID},
+		{"students": claims.UserID},
+	}}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	classrooms := []Classroom{}
+	if err := cursor.All(ctx, &classrooms); err != nil {
+		return nil, err
+	}
+	for i := range classrooms {
+		if classrooms[i].TeacherID != claims.UserID {
+			classrooms[i].InviteCode = ""
+			classrooms[i].Students = nil
+		}
+	}
+	return classrooms, nil
+}
+
+// Join добавляет вызывающего в класс по коду приглашения
+func (s *Service) Join(ctx context.Context, code string) (*Classroom, error) {
+	claims, err := caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return nil, ErrInvalidInviteCode
+	}
+
+	var classroom Classroom
+	err = s.classrooms.FindOneAndUpdate(ctx,
+		bson.M{"invite_code": code, "teacher_id": bson.M{"$ne": claims.UserID}},
+		bson.M{"$addToSet": bson.M{"students": claims.UserID}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&classroom)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidInviteCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, "classroom.join", classroomTarget(classroom.ID), nil, map[string]string{"student": claims.UserID})
+	classroom.InviteCode, classroom.Students = "", nil
+	return &classroom, nil
+}
+
+// RemoveStudent исключает ученика из класса; может учитель класса или
+// обладатель права classrooms.admin
+func (s *Service) RemoveStudent(ctx context.Context, classroomID primitive.ObjectID, studentID string) error {
+	if _, err := s.teacherClassroom(ctx, classroomID); err != nil {
+		return err
+	}
+	_, err := s.classrooms.UpdateOne(ctx, bson.M{"_id": classroomID}, bson.M{"$pull": bson.M{"students": studentID}})
+	if err != nil {
+		return err
+	}
+	s.audit.Record(ctx, "classroom.remove_student", classroomTarget(classroomID), map[string]string{"student": studentID}, nil)
+	return nil
+}
+
+// Assign выдает классу квиз со сроком сдачи; может учитель класса или
+// обладатель права classrooms.admin
+func (s *Service) Assign(ctx context.Context, classroomID primitive.ObjectID, quizID, title string, dueAt time.Time) (*Assignment, error) {
+	classroom, err := s.teacherClassroom(ctx, classroomID)
+	if err != nil {
+		return nil, err
+	}
+	quizID = strings.TrimSpace(quizID)
+	if quizID == "" {
+		return nil, fmt.Errorf("%w: quizId is required", ErrInvalidInput)
+	}
+	now := time.Now()
+	if !dueAt.After(now) {
+		return nil, fmt.Errorf("%w: dueAt must be in the future", ErrInvalidInput)
+	}
+
+	claims, _ := caller(ctx)
+	assignment := &Assignment{
+		ID:            primitive.NewObjectID(),
+		ClassroomID:   classroom.ID,
+		ClassroomName: classroom.Name,
+		QuizID:        quizID,
+		Title:         strings.TrimSpace(title),
+		DueAt:         dueAt,
+		AssignedBy:    claims.UserID,
+		CreatedAt:     now,
+	}
+	if _, err := s.assignments.InsertOne(ctx, assignment); err != nil {
+		return nil, fmt.Errorf("failed to create assignment: %w", err)
+	}
+	s.audit.Record(ctx, "classroom.assign", classroomTarget(classroom.ID), nil, assignment)
+	return assignment, nil
+}
+
+// Assignments возвращает задания класса; доступны учителю и ученикам класса
+// и обладателям права classrooms.admin
+func (s *Service) Assignments(ctx context.Context, classroomID primitive.ObjectID) ([]Assignment, error) {
+	claims, err := caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var classroom Classroom
+	err = s.classrooms.FindOne(ctx, bson.M{"_id": classroomID}).Decode(&classroom)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrClassroomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if classroom.TeacherID != claims.UserID && !contains(classroom.Students, claims.UserID) && !claims.Can(auth.PermClassroomsAdmin) {
+		return nil, ErrClassroomNotFound
+	}
+	return s.findAssignments(ctx, bson.M{"classroom_id": classroomID})
+}
+
+// StudentAssignments возвращает задания всех классов ученика studentID с его
+// результатами. Свои задания видит сам ученик, чужие — администратор и ключ API.
+func (s *Service) StudentAssignments(ctx context.Context, studentID string) ([]StudentAssignment, error) {
+	claims, err := caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if studentID == "" {
+		studentID = claims.UserID
+	}
+	if !claims.CanActFor(studentID) {
+		return nil, auth.ErrForbidden
+	}
+
+	cursor, err := s.classrooms.Find(ctx, bson.M{"students": studentID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var classrooms []Classroom
+	if err := cursor.All(ctx, &classrooms); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, len(classrooms))
+	for i, classroom := range classrooms {
+		ids[i] = classroom.ID
+	}
+	if len(ids) == 0 {
+		return []StudentAssignment{}, nil
+	}
+
+	assignments, err := s.findAssignments(ctx, bson.M{"classroom_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]StudentAssignment, 0, len(assignments))
+	for _, assignment := range assignments {
+		res, err := s.result(ctx, assignment, studentID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, StudentAssignment{Assignment: assignment, Result: res})
+	}
+	return result, nil
+}
+
+// Results возвращает результаты всех учеников класса по заданию; доступны
+// учителю класса и обладателям права classrooms.admin
+func (s *Service) Results(ctx context.Context, assignmentID primitive.ObjectID) (*Assignment, []Result, error) {
+	var assignment Assignment
+	err := s.assignments.FindOne(ctx, bson.M{"_id": assignmentID}).Decode(&assignment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil, ErrAssignmentNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	classroom, err := s.teacherClassroom(ctx, assignment.ClassroomID)
+	if errors.Is(err, ErrClassroomNotFound) {
+		return nil, nil, ErrAssignmentNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]Result, 0, len(classroom.Students))
+	for _, studentID := range classroom.Students {
+		res, err := s.result(ctx, assignment, studentID)
+		if err != nil {
+			return nil, nil, err
+		}
+		results = append(results, res)
+	}
+	return &assignment, results, nil
+}
+
+// CheckQuizAccess разрешает открыть квиз, если он никому не выдан, либо
+// вызывающий — учитель или ученик класса, которому квиз выдан.
+// Обладатели права classrooms.admin и ключи API с правом на квизы проходят всегда.
+func (s *Service) CheckQuizAccess(ctx context.Context, quizID string) error {
+	cursor, err := s.assignments.Find(ctx, bson.M{"quiz_id": quizID}, options.Find().SetProjection(bson.M{"classroom_id": 1}))
+	if err != nil {
+		return err
+	}
+	var assignments []Assignment
+	if err := cursor.All(ctx, &assignments); err != nil {
+		return err
+	}
+	if len(assignments) == 0 {
+		return nil
+	}
+
+	claims, err := caller(ctx)
+	if err != nil {
+		return err
+	}
+	if claims.Can(auth.PermClassroomsAdmin) || claims.IsAPIKey() {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, len(assignments))
+	for i, assignment := range assignments {
+		ids[i] = assignment.ClassroomID
+	}
+	count, err := s.classrooms.CountDocuments(ctx, bson.M{
+		"_id": bson.M{"$in": ids},
+		"$or": []bson.M{{"teacher_id": claims.UserID}, {"students": claims.UserID}},
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// teacherClassroom возвращает класс, если вызывающий — его учитель или у него
+// есть право classrooms.admin
+func (s *Service) teacherClassroom(ctx context.Context, id primitive.ObjectID) (*Classroom, error) {
+	if err := auth.Require(ctx, auth.PermClassroomsManage); err != nil {
+		return nil, err
+	}
+	claims, err := caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var classroom Classroom
+	err = s.classrooms.FindOne(ctx, bson.M{"_id": id}).Decode(&classroom)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrClassroomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if classroom.TeacherID != claims.UserID && !claims.Can(auth.PermClassroomsAdmin) {
+		return nil, ErrClassroomNotFound
+	}
+	return &classroom, nil
+}
+
+func (s *Service) findAssignments(ctx context.Context, filter bson.M) ([]Assignment, error) {
+	cursor, err := s.assignments.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "due_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	assignments := []Assignment{}
+	if err := cursor.All(ctx, &assignments); err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+// result ищет последнюю попытку ученика по квизу задания после его выдачи.
+// Результаты без поля quiz не засчитываются: по ним нельзя понять, какой
+// квиз решался. Попытка после срока отмечается как Late.
+func (s *Service) result(ctx context.Context, assignment Assignment, studentID string) (Result, error) {
+	res := Result{StudentID: studentID}
+	userID, err := primitive.ObjectIDFromHex(studentID)
+	if err != nil {
+		return res, nil
+	}
+
+	var latest quizResult
+	err = s.results.FindOne(ctx, bson.M{
+		"user":      userID,
+		"quiz":      assignment.QuizID,
+		"createdAt": bson.M{"$gte": assignment.CreatedAt},
+	}, options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})).Decode(&latest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	res.Submitted = true
+	res.Score = latest.TotalScore
+	res.SubmittedAt = &latest.CreatedAt
+	res.Late = latest.CreatedAt.After(assignment.DueAt)
+	return res, nil
+}
+
+func newInviteCode() (string, error) {
+	buf := make([]byte, inviteCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate invite code: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = inviteAlphabet[int(b)%len(inviteAlphabet)]
+	}
+	return string(buf), nil
+}
+
+func classroomTarget(id primitive.ObjectID) string {
+	return "classroom:" + id.Hex()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package classroom
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+)
+
+// writeError переводит ошибки сервиса в статусы HTTP
+func writeError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrForbidden), errors.Is(err, ErrNotMember):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrClassroomNotFound), errors.Is(err, ErrAssignmentNotFound), errors.Is(err, ErrInvalidInviteCode):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+		log.Printf("Error trying to %s: %v", action, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func objectIDParam(w http.ResponseWriter, r *http.Request, name string) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get(name))
+	if err != nil {
+		http.Error(w, "Invalid "+name, http.StatusBadRequest)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// HandleClassrooms: GET — классы вызывающего, POST {"name"} — новый класс
+func (s *Service) HandleClassrooms(w http.ResponseWriter, r *http.Request) {
+	ctx := audit.FromHTTP(r)
+	switch r.Method {
+	case http.MethodGet:
+		classrooms, err := s.List(ctx)
+		if err != nil {
+			writeError(w, err, "load classrooms")
+			return
+		}
+		writeJSON(w, http.StatusOK, classrooms)
+	case http.MethodPost:
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		classroom, err := s.Create(ctx, body.Name)
+		if err != nil {
+			writeError(w, err, "create classroom")
+			return
+		}
+		writeJSON(w, http.StatusCreated, classroom)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleJoin: POST {"code"} добавляет вызывающего в класс
+func (s *Service) HandleJoin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	classroom, err := s.Join(audit.FromHTTP(r), body.Code)
+	if err != nil {
+		writeError(w, err, "join classroom")
+		return
+	}
+	writeJSON(w, http.StatusOK, classroom)
+}
+
+// HandleStudents: DELETE ?classroomId=&studentId= исключает ученика
+func (s *Service) HandleStudents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	classroomID, ok := objectIDParam(w, r, "classroomId")
+	if !ok {
+		return
+	}
+	studentID := r.URL.Query().Get("studentId")
+	if studentID == "" {
+		http.Error(w, "studentId is required", http.StatusBadRequest)
+		return
+	}
+	if err := s.RemoveStudent(audit.FromHTTP(r), classroomID, studentID); err != nil {
+		writeError(w, err, "remove student")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleAssignments: GET ?classroomId= — задания класса, GET без параметров —
+// задания вызывающего ученика с результатами, POST {"classroomId", "quizId",
+// "title", "dueAt"} — выдать квиз классу
+func (s *Service) HandleAssignments(w http.ResponseWriter, r *http.Request) {
+	ctx := audit.FromHTTP(r)
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Get("classroomId") == "" {
+			assignments, err := s.StudentAssignments(ctx, r.URL.Query().Get("studentId"))
+			if err != nil {
+				writeError(w, err, "load assignments")
+				return
+			}
+			writeJSON(w, http.StatusOK, assignments)
+			return
+		}
+		classroomID, ok := objectIDParam(w, r, "classroomId")
+		if !ok {
+			return
+		}
+		assignments, err := s.Assignments(ctx, classroomID)
+		if err != nil {
+			writeError(w, err, "load assignments")
+			return
+		}
+		writeJSON(w, http.StatusOK, assignments)
+	case http.MethodPost:
+		var body struct {
+			ClassroomID string    `json:"classroomId"`
+			QuizID      string    `json:"quizId"`
+			Title       string    `json:"title"`
+			DueAt       time.Time `json:"dueAt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid input; dueAt must be RFC 3339", http.StatusBadRequest)
+			return
+		}
+		classroomID, err := primitive.ObjectIDFromHex(body.ClassroomID)
+		if err != nil {
+			http.Error(w, "Invalid classroomId", http.StatusBadRequest)
+			return
+		}
+		assignment, err := s.Assign(ctx, classroomID, body.QuizID, body.Title, body.DueAt)
+		if err != nil {
+			writeError(w, err, "assign quiz")
+			return
+		}
+		writeJSON(w, http.StatusCreated, assignment)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleResults: GET ?id= — результаты учеников по заданию (для учителя)
+func (s *Service) HandleResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := objectIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	assignment, results, err := s.Results(audit.FromHTTP(r), id)
+	if err != nil {
+		writeError(w, err, "load results")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"assignment": assignment,
+		"results":    results,
+	})
+}
+
+// RegisterRoutes подключает обработчики под /classrooms
+func (s *Service) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/classrooms", s.HandleClassrooms)
+	mux.HandleFunc("/classrooms/join", s.HandleJoin)
+	mux.HandleFunc("/classrooms/students", s.HandleStudents)
+	mux.HandleFunc("/classrooms/assignments", s.HandleAssignments)
+	mux.HandleFunc("/classrooms/assignments/results", s.HandleResults)
+}
@@ -0,0 +1,40 @@
+package etag
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrPreconditionFailed возвращается, если заголовок If-Match нельзя разобрать
+var ErrPreconditionFailed = errors.New("If-Match must be an ETag returned by this API")
+
+// Format превращает версию документа в сильный ETag: "3"
+func Format(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// Set выставляет заголовок ETag ответа
+func Set(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", Format(version))
+}
+
+// IfMatch возвращает версию из заголовка If-Match.
+// Без заголовка или с "*" возвращает nil — проверка версии не нужна.
+func IfMatch(r *http.Request) (*int64, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return nil, nil
+	}
+	if strings.HasPrefix(value, "W/") || strings.Contains(value, ",") {
+		// Слабые теги и списки не подходят для сравнения версий
+		return nil, ErrPreconditionFailed
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+	if err != nil {
+		return nil, ErrPreconditionFailed
+	}
+	return &version, nil
+}
@@ -0,0 +1,157 @@
+package privacy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+)
+
+// requestView — запрос в ответе API со ссылкой на архив, пока она действует
+type requestView struct {
+	*Request
+	DownloadURL string `json:"downloadUrl,omitempty"`
+}
+
+// DownloadLink возвращает подписанную ссылку на архив, действующую до expires
+func (s *Service) DownloadLink(id primitive.ObjectID, expires time.Time) (string, error) {
+	return auth.SignLink(auth.Secret(), fmt.Sprintf("%s/privacy/download?id=%s", s.config.BaseURL, id.Hex()), expires)
+}
+
+// authorize пропускает самого пользователя и обладателей права privacy.manage
+func authorize(w http.ResponseWriter, r *http.Request, userID string) (*auth.Claims, bool) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	if userID != "" && claims.UserID != userID && !claims.Can(auth.PermPrivacyManage) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return nil, false
+	}
+	return claims, true
+}
+
+// HandleRequests: POST {"userId", "kind"} ставит запрос в очередь (userId
+// можно не указывать для своих данных), GET ?id= показывает его статус
+func (s *Service) HandleRequests(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var body struct {
+			UserID string `json:"userId"`
+			Kind   string `json:"kind"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		claims, ok := authorize(w, r, body.UserID)
+		if !ok {
+			return
+		}
+		if body.UserID == "" {
+			body.UserID = claims.UserID
+		}
+
+		req, err := s.Submit(audit.FromHTTP(r), body.UserID, body.Kind, claims.UserID)
+		switch {
+		case errors.Is(err, ErrUnknownKind):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrRequestInProgress):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, mongo.ErrNoDocuments):
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, "Failed to create request", http.StatusInternalServerError)
+			log.Println("Error creating privacy request:", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(requestView{Request: req})
+	case http.MethodGet:
+		id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid request ID", http.StatusBadRequest)
+			return
+		}
+		req, err := s.Get(r.Context(), id)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Request not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to load request", http.StatusInternalServerError)
+			return
+		}
+		if _, ok := authorize(w, r, req.UserID); !ok {
+			return
+		}
+
+		view := requestView{Request: req}
+		if req.ArchiveID != nil && req.ExpiresAt != nil && time.Now().Before(*req.ExpiresAt) {
+			if link, err := s.DownloadLink(req.ID, *req.ExpiresAt); err == nil {
+				view.DownloadURL = link
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(view)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleDownload отдает архив по подписанной ссылке
+func (s *Service) HandleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := auth.VerifyLink(auth.Secret(), r.URL); err != nil {
+		http.Error(w, "Invalid or expired link", http.StatusForbidden)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+	req, err := s.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	archive, err := s.OpenArchive(r.Context(), req)
+	if err != nil {
+		http.Error(w, "Archive is no longer available", http.StatusGone)
+		return
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archiveName(req)))
+	if _, err := io.Copy(w, archive); err != nil {
+		log.Println("Error streaming privacy archive:", err)
+	}
+}
+
+// RegisterRoutes подключает обработчики под /privacy/
+func (s *Service) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/privacy/requests", s.HandleRequests)
+	mux.HandleFunc("/privacy/download", s.HandleDownload)
+}
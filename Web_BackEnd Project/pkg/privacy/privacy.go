@@ -0,0 +1,405 @@
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/audit"
+)
+
+// Виды запросов субъекта данных
+const (
+	KindExport = "export"
+	KindErase  = "erase"
+)
+
+// Статусы запроса
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+const (
+	// lease — на столько запрос закрепляется за воркером; если воркер упал,
+	// запрос после этого подхватит другой
+	lease = 10 * time.Minute
+	// archiveBucket — GridFS-бакет с готовыми архивами
+	archiveBucket = "privacy_archives"
+)
+
+var (
+	// ErrUnknownKind возвращается для вида запроса, отличного от export и erase
+	ErrUnknownKind = errors.New("kind must be export or erase")
+	// ErrRequestInProgress возвращается, если такой же запрос уже выполняется
+	ErrRequestInProgress = errors.New("a request of this kind is already in progress")
+	// ErrArchiveExpired возвращается, если архив удален по истечении срока
+	ErrArchiveExpired = errors.New("archive has expired")
+)
+
+// Subject — пользователь, чьи данные выгружаются или стираются
+type Subject struct {
+	UserID string
+	Email  string
+}
+
+// Section — данные пользователя в одном хранилище
+type Section struct {
+	Name string
+	// Export возвращает данные для архива; результат сериализуется в JSON
+	Export func(ctx context.Context, subject Subject) (interface{}, error)
+	// Erase удаляет или обезличивает данные. nil — раздел хранится по закону
+	// и при удалении не меняется.
+	Erase func(ctx context.Context, subject Subject) error
+}
+
+// Config — зависимости сервиса
+type Config struct {
+	// Resolve находит пользователя по ID, в том числе удаленного
+	Resolve func(ctx context.Context, userID string) (Subject, error)
+	// Deliver отправляет пользователю ссылку на готовый архив; может быть nil
+	Deliver func(subject Subject, link string, expires time.Time)
+	// BaseURL — адрес сервиса, от которого строятся ссылки на архивы
+	BaseURL string
+	// ArchiveTTL — сколько архив и ссылка на него остаются доступными
+	ArchiveTTL time.Duration
+	// Audit записывает выполнение запросов; может быть nil
+	Audit *audit.Log
+}
+
+// Progress — сколько разделов уже обработано
+type Progress struct {
+	Done    int    `bson:"done" json:"done"`
+	Total   int    `bson:"total" json:"total"`
+	Section string `bson:"section,omitempty" json:"section,omitempty"`
+}
+
+// Request — запрос на выгрузку или удаление данных
+type Request struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID      string              `bson:"user_id" json:"userId"`
+	Email       string              `bson:"email,omitempty" json:"-"`
+	Kind        string              `bson:"kind" json:"kind"`
+	Status      string              `bson:"status" json:"status"`
+	RequestedBy string              `bson:"requested_by" json:"requestedBy"`
+	Progress    Progress            `bson:"progress" json:"progress"`
+	ArchiveID   *primitive.ObjectID `bson:"archive_id,omitempty" json:"-"`
+	ExpiresAt   *time.Time          `bson:"expires_at,omitempty" json:"expiresAt,omitempty"`
+	Error       string              `bson:"error,omitempty" json:"error,omitempty"`
+	LeaseUntil  time.Time           `bson:"lease_until" json:"-"`
+	CreatedAt   time.Time           `bson:"created_at" json:"createdAt"`
+	UpdatedAt   time.Time           `bson:"updated_at" json:"updatedAt"`
+	CompletedAt *time.Time          `bson:"completed_at,omitempty" json:"completedAt,omitempty"`
+}
+
+// Service выполняет запросы субъектов данных в фоне
+type Service struct {
+	requests *mongo.Collection
+	bucket   *gridfs.Bucket
+	sections []Section
+	config   Config
+}
+
+// NewService создает сервис; запросы хранятся в privacy_requests базы db.
+// Разделы обрабатываются в переданном порядке, поэтому профиль, по которому
+// ищутся остальные данные, стоит передавать последним.
+func NewService(db *mongo.Database, config Config, sections ...Section) (*Service, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(archiveBucket))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GridFS bucket: %w", err)
+	}
+	if config.ArchiveTTL <= 0 {
+		config.ArchiveTTL = 72 * time.Hour
+	}
+	return &Service{
+		requests: db.Collection("privacy_requests"),
+		bucket:   bucket,
+		sections: sections,
+		config:   config,
+	}, nil
+}
+
+// EnsureIndexes создает индексы для выборки очереди и истории пользователя
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	_, err := s.requests.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_until", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// Submit ставит запрос в очередь. Одновременно у пользователя может быть
+// только один незавершенный запрос каждого вида.
+func (s *Service) Submit(ctx context.Context, userID, kind, requestedBy string) (*Request, error) {
+	if kind != KindExport && kind != KindErase {
+		return nil, ErrUnknownKind
+	}
+	subject, err := s.config.Resolve(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := s.requests.CountDocuments(ctx, bson.M{
+		"user_id": userID,
+		"kind":    kind,
+		"status":  bson.M{"$in": []string{StatusQueued, StatusRunning}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if active > 0 {
+		return nil, ErrRequestInProgress
+	}
+
+	now := time.Now()
+	req := &Request{
+		UserID:      userID,
+		Email:       subject.Email,
+		Kind:        kind,
+		Status:      StatusQueued,
+		RequestedBy: requestedBy,
+		Progress:    Progress{Total: len(s.sections)},
+		LeaseUntil:  now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	res, err := s.requests.InsertOne(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	req.ID = res.InsertedID.(primitive.ObjectID)
+
+	s.config.Audit.Record(ctx, "privacy."+kind+"_requested", "user:"+userID, nil, nil)
+	return req, nil
+}
+
+// Get возвращает запрос по ID
+func (s *Service) Get(ctx context.Context, id primitive.ObjectID) (*Request, error) {
+	var req Request
+	if err := s.requests.FindOne(ctx, bson.M{"_id": id}).Decode(&req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// Run раз в interval выполняет запросы из очереди и удаляет просроченные архивы
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			req, err := s.claim(ctx)
+			if err == mongo.ErrNoDocuments {
+				break
+			}
+			if err != nil {
+				log.Println("Error claiming privacy request:", err)
+				break
+			}
+			s.process(ctx, req)
+		}
+
+		if err := s.expireArchives(ctx); err != nil {
+			log.Println("Error removing expired privacy archives:", err)
+		}
+	}
+}
+
+// claim забирает запрос из очереди или запрос упавшего воркера
+func (s *Service) claim(ctx context.Context) (*Request, error) {
+	now := time.Now()
+	var req Request
+	err := s.requests.FindOneAndUpdate(ctx,
+		bson.M{
+			"status":      bson.M{"$in": []string{StatusQueued, StatusRunning}},
+			"lease_until": bson.M{"$lte": now},
+		},
+		bson.M{"$set": bson.M{"status": StatusRunning, "lease_until": now.Add(lease), "updated_at": now}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetReturnDocument(options.After),
+	).Decode(&req)
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (s *Service) update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	set["updated_at"] = time.Now()
+	_, err := s.requests.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
+
+func (s *Service) process(ctx context.Context, req *Request) {
+	// Изменения в журнале аудита записываются от имени автора запроса
+	ctx = audit.WithMeta(ctx, audit.Meta{Actor: req.RequestedBy, RequestID: req.ID.Hex(), Source: audit.SourceSystem})
+	subject := Subject{UserID: req.UserID, Email: req.Email}
+
+	var err error
+	if req.Kind == KindExport {
+		err = s.export(ctx, req, subject)
+	} else {
+		err = s.erase(ctx, req, subject)
+	}
+	if err != nil {
+		log.Printf("Privacy request %s failed: %v", req.ID.Hex(), err)
+		if updateErr := s.update(ctx, req.ID, bson.M{"status": StatusFailed, "error": err.Error()}); updateErr != nil {
+			log.Printf("Error recording privacy request %s failure: %v", req.ID.Hex(), updateErr)
+		}
+	}
+}
+
+func (s *Service) advance(ctx context.Context, req *Request, done int, section string) {
+	if err := s.update(ctx, req.ID, bson.M{"progress": Progress{Done: done, Total: len(s.sections), Section: section}}); err != nil {
+		log.Printf("Error recording privacy request %s progress: %v", req.ID.Hex(), err)
+	}
+}
+
+// export собирает zip-архив с JSON-файлом на каждый раздел
+func (s *Service) export(ctx context.Context, req *Request, subject Subject) error {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for i, section := range s.sections {
+		s.advance(ctx, req, i, section.Name)
+
+		data, err := section.Export(ctx, subject)
+		if err != nil {
+			return fmt.Errorf("failed to export %s: %w", section.Name, err)
+		}
+		file, err := archive.Create(section.Name + ".json")
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", section.Name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	opts := options.GridFSUpload().SetMetadata(bson.M{"request_id": req.ID.Hex(), "user_id": req.UserID})
+	archiveID, err := s.bucket.UploadFromStream(archiveName(req), &buf, opts)
+	if err != nil {
+		return fmt.Errorf("failed to store archive: %w", err)
+	}
+
+	now := time.Now()
+	expires := now.Add(s.config.ArchiveTTL)
+	err = s.update(ctx, req.ID, bson.M{
+		"status":       StatusCompleted,
+		"archive_id":   archiveID,
+		"expires_at":   expires,
+		"completed_at": now,
+		"progress":     Progress{Done: len(s.sections), Total: len(s.sections)},
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.config.Deliver != nil {
+		link, err := s.DownloadLink(req.ID, expires)
+		if err != nil {
+			log.Printf("Error signing archive link for request %s: %v", req.ID.Hex(), err)
+		} else {
+			s.config.Deliver(subject, link, expires)
+		}
+	}
+	s.config.Audit.Record(ctx, "privacy.exported", "user:"+req.UserID, nil, nil)
+	return nil
+}
+
+// erase стирает разделы по порядку. Повторный запуск безопасен, поэтому
+// после сбоя запрос просто выполняется заново.
+func (s *Service) erase(ctx context.Context, req *Request, subject Subject) error {
+	for i, section := range s.sections {
+		s.advance(ctx, req, i, section.Name)
+		if section.Erase == nil {
+			continue
+		}
+		if err := section.Erase(ctx, subject); err != nil {
+			return fmt.Errorf("failed to erase %s: %w", section.Name, err)
+		}
+	}
+
+	now := time.Now()
+	_, err := s.requests.UpdateOne(ctx, bson.M{"_id": req.ID}, bson.M{
+		"$set": bson.M{
+			"status":       StatusCompleted,
+			"completed_at": now,
+			"updated_at":   now,
+			"progress":     Progress{Done: len(s.sections), Total: len(s.sections)},
+		},
+		// Адрес больше не нужен и сам является персональными данными
+		"$unset": bson.M{"email": ""},
+	})
+	if err != nil {
+		return err
+	}
+	// Адрес остается и в прошлых запросах пользователя
+	if _, err := s.requests.UpdateMany(ctx, bson.M{"user_id": req.UserID}, bson.M{"$unset": bson.M{"email": ""}}); err != nil {
+		return err
+	}
+
+	s.config.Audit.Record(ctx, "privacy.erased", "user:"+req.UserID, nil, nil)
+	return nil
+}
+
+// expireArchives удаляет архивы, срок которых истек
+func (s *Service) expireArchives(ctx context.Context) error {
+	cursor, err := s.requests.Find(ctx, bson.M{
+		"archive_id": bson.M{"$exists": true},
+		"expires_at": bson.M{"$lte": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	var expired []Request
+	if err := cursor.All(ctx, &expired); err != nil {
+		return err
+	}
+
+	for _, req := range expired {
+		if err := s.bucket.Delete(*req.ArchiveID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+		if _, err := s.requests.UpdateOne(ctx, bson.M{"_id": req.ID}, bson.M{"$unset": bson.M{"archive_id": ""}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenArchive открывает архив выполненного запроса на выгрузку
+func (s *Service) OpenArchive(ctx context.Context, req *Request) (io.ReadCloser, error) {
+	if req.ArchiveID == nil || (req.ExpiresAt != nil && time.Now().After(*req.ExpiresAt)) {
+		return nil, ErrArchiveExpired
+	}
+	return s.bucket.OpenDownloadStream(*req.ArchiveID)
+}
+
+func archiveName(req *Request) string {
+	return fmt.Sprintf("user-data-%s-%s.zip", req.UserID, req.ID.Hex())
+}
@@ -0,0 +1,110 @@
+package rbac
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+)
+
+// writeError переводит ошибки сервиса в статусы HTTP
+func writeError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrRoleNotFound), errors.Is(err, ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrBuiltinRole):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+		log.Printf("Error trying to %s: %v", action, err)
+	}
+}
+
+// HandleRoles: GET — список ролей, PUT {"name", "description", "permissions"}
+// — создание или замена роли, DELETE ?name= — удаление. Право roles.manage
+// проверяют методы сервиса.
+func (s *Service) HandleRoles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		roles, err := s.List(audit.FromHTTP(r))
+		if err != nil {
+			writeError(w, err, "load roles")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(roles)
+	case http.MethodPut:
+		var role Role
+		if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		saved, err := s.Save(audit.FromHTTP(r), role)
+		if err != nil {
+			writeError(w, err, "save role")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(saved)
+	case http.MethodDelete:
+		if err := s.Delete(audit.FromHTTP(r), r.URL.Query().Get("name")); err != nil {
+			writeError(w, err, "delete role")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandlePermissions: GET — все права, из которых составляются роли
+func (s *Service) HandlePermissions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := auth.Require(audit.FromHTTP(r), auth.PermRolesManage); err != nil {
+		writeError(w, err, "load permissions")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auth.Permissions)
+}
+
+// HandleAssign: PUT {"userId", "roles"} заменяет роли пользователя
+func (s *Service) HandleAssign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		UserID string   `json:"userId"`
+		Roles  []string `json:"roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.UserID == "" {
+		http.Error(w, "userId is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Assign(audit.FromHTTP(r), body.UserID, body.Roles); err != nil {
+		writeError(w, err, "assign roles")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegisterRoutes подключает обработчики под /admin/roles
+func (s *Service) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/roles", s.HandleRoles)
+	mux.HandleFunc("/admin/roles/permissions", s.HandlePermissions)
+	mux.HandleFunc("/admin/roles/assign", s.HandleAssign)
+}
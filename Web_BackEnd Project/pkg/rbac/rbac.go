@@ -0,0 +1,326 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+	"web_backend_project/pkg/cache"
+)
+
+// cacheTTL — сколько права роли живут в Redis без обращения к MongoDB
+const cacheTTL = 5 * time.Minute
+
+var (
+	// ErrRoleNotFound возвращается, если роли с таким именем нет
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrBuiltinRole возвращается при попытке изменить или удалить встроенную роль
+	ErrBuiltinRole = errors.New("built-in role cannot be changed")
+	// ErrInvalidRole возвращается для недопустимого имени или неизвестного права
+	ErrInvalidRole = errors.New("invalid role")
+	// ErrUserNotFound возвращается Config.Assign для неизвестного пользователя
+	ErrUserNotFound = errors.New("user not found")
+)
+
+var roleName = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+
+// Role — именованный набор прав
+type Role struct {
+	Name        string    `bson:"_id" json:"name"`
+	Description string    `bson:"description,omitempty" json:"description,omitempty"`
+	Permissions []string  `bson:"permissions" json:"permissions"`
+	Builtin     bool      `bson:"builtin,omitempty" json:"builtin,omitempty"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updatedAt"`
+}
+
+// adminRole встроена и всегда дает все права
+var adminRole = Role{Name: auth.RoleAdmin, Description: "Full access", Permissions: []string{auth.PermissionAll}, Builtin: true}
+
+// userRole встроена и прав не дает: она есть у каждого пользователя через
+// старое поле role, поэтому ее права получил бы каждый аккаунт
+var userRole = Role{Name: auth.RoleUser, Description: "Every account", Permissions: []string{}, Builtin: true}
+
+// builtinRole возвращает встроенную роль с таким именем
+func builtinRole(name string) (Role, bool) {
+	switch name {
+	case adminRole.Name:
+		return adminRole, true
+	case userRole.Name:
+		return userRole, true
+	}
+	return Role{}, false
+}
+
+// Config — зависимости сервиса
+type Config struct {
+	// Assign заменяет роли пользователя; вызывается после проверки, что роли
+	// существуют. Неизвестный пользователь — ErrUserNotFound.
+	Assign func(ctx context.Context, userID string, roles []string) error
+	// Roles возвращает текущие роли пользователя вместе со старым полем role.
+	// Неизвестный пользователь — ErrUserNotFound.
+	Roles func(ctx context.Context, userID string) ([]string, error)
+	// Audit записывает изменения ролей; может быть nil
+	Audit *audit.Log
+}
+
+// Service хранит роли в коллекции roles, а права ролей кэширует в Redis
+type Service struct {
+	roles  *mongo.Collection
+	redis  *cache.RedisClient
+	config Config
+}
+
+// NewService создает сервис. redis может быть nil — тогда права каждый раз
+// читаются из MongoDB.
+func NewService(db *mongo.Database, redis *cache.RedisClient, config Config) *Service {
+	return &Service{roles: db.Collection("roles"), redis: redis, config: config}
+}
+
+func cacheKey(name string) string { return "rbac:role:" + name }
+
+// Get возвращает роль по имени
+func (s *Service) Get(ctx context.Context, name string) (*Role, error) {
+	if role, ok := builtinRole(name); ok {
+		return &role, nil
+	}
+	var role Role
+	err := s.roles.FindOne(ctx, bson.M{"_id": name}).Decode(&role)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// List возвращает все роли, включая встроенные. Нужно право roles.manage.
+func (s *Service) List(ctx context.Context) ([]Role, error) {
+	if err := auth.Require(ctx, auth.PermRolesManage); err != nil {
+		return nil, err
+	}
+	cursor, err := s.roles.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	roles := []Role{adminRole, userRole}
+	var stored []Role
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, err
+	}
+	for _, role := range stored {
+		// Роль, сохраненная до того, как имя стало встроенным, не действует
+		if _, ok := builtinRole(role.Name); !ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+// Save создает или заменяет роль. Нужно право roles.manage.
+func (s *Service) Save(ctx context.Context, role Role) (*Role, error) {
+	if err := auth.Require(ctx, auth.PermRolesManage); err != nil {
+		return nil, err
+	}
+	if _, ok := builtinRole(role.Name); ok {
+		return nil, ErrBuiltinRole
+	}
+	if !roleName.MatchString(role.Name) {
+		return nil, fmt.Errorf("%w: name must be 2-32 lowercase letters, digits, '-' or '_'", ErrInvalidRole)
+	}
+	permissions, err := normalizePermissions(role.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireGrantable(ctx, permissions); err != nil {
+		return nil, err
+	}
+
+	before, _ := s.Get(ctx, role.Name)
+	role.Permissions = permissions
+	role.Builtin = false
+	role.UpdatedAt = time.Now()
+	_, err = s.roles.ReplaceOne(ctx, bson.M{"_id": role.Name}, role, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save role: %w", err)
+	}
+	s.invalidate(ctx, role.Name)
+	s.config.Audit.Record(ctx, "role.save", "role:"+role.Name, before, role)
+	return &role, nil
+}
+
+// Delete удаляет роль. Пользователи с этой ролью теряют ее права при
+// следующем обновлении токена. Нужно право roles.manage.
+func (s *Service) Delete(ctx context.Context, name string) error {
+	if err := auth.Require(ctx, auth.PermRolesManage); err != nil {
+		return err
+	}
+	if _, ok := builtinRole(name); ok {
+		return ErrBuiltinRole
+	}
+	result, err := s.roles.DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrRoleNotFound
+	}
+	s.invalidate(ctx, name)
+	s.config.Audit.Record(ctx, "role.delete", "role:"+name, nil, nil)
+	return nil
+}
+
+// Assign проверяет, что роли существуют, и назначает их пользователю через
+// Config.Assign. Выдать или отозвать можно только роль, все права которой
+// есть у вызывающего: иначе roles.manage позволял бы выдать себе admin или
+// снять его с администратора.
+func (s *Service) Assign(ctx context.Context, userID string, roles []string) error {
+	if err := auth.Require(ctx, auth.PermRolesManage); err != nil {
+		return err
+	}
+	current, err := s.config.Roles(ctx, userID)
+	if err != nil {
+		return err
+	}
+	kept := map[string]bool{userRole.Name: true}
+	for _, name := range roles {
+		kept[name] = true
+	}
+	for _, name := range current {
+		if kept[name] {
+			continue
+		}
+		role, err := s.Get(ctx, name)
+		if errors.Is(err, ErrRoleNotFound) {
+			// Удаленная роль прав не дает, отзывать нечего
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := requireGrantable(ctx, role.Permissions); err != nil {
+			return err
+		}
+	}
+
+	seen := map[string]bool{}
+	names := []string{}
+	for _, name := range roles {
+		if seen[name] {
+			continue
+		}
+		role, err := s.Get(ctx, name)
+		if err != nil {
+			if errors.Is(err, ErrRoleNotFound) {
+				return fmt.Errorf("%w: %q", ErrRoleNotFound, name)
+			}
+			return err
+		}
+		if err := requireGrantable(ctx, role.Permissions); err != nil {
+			return err
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return s.config.Assign(ctx, userID, names)
+}
+
+// Resolve собирает права ролей в один отсортированный набор. Неизвестные
+// роли (например, старое значение role из Node) прав не дают.
+func (s *Service) Resolve(ctx context.Context, roles []string) ([]string, error) {
+	set := map[string]bool{}
+	for _, name := range roles {
+		permissions, err := s.permissions(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, permission := range permissions {
+			set[permission] = true
+		}
+	}
+	if set[auth.PermissionAll] {
+		return []string{auth.PermissionAll}, nil
+	}
+	resolved := make([]string, 0, len(set))
+	for permission := range set {
+		resolved = append(resolved, permission)
+	}
+	sort.Strings(resolved)
+	return resolved, nil
+}
+
+// permissions возвращает права одной роли, сначала из Redis
+func (s *Service) permissions(ctx context.Context, name string) ([]string, error) {
+	if role, ok := builtinRole(name); ok {
+		return role.Permissions, nil
+	}
+	var permissions []string
+	if s.redis != nil && s.redis.Get(ctx, cacheKey(name), &permissions) == nil {
+		return permissions, nil
+	}
+
+	role, err := s.Get(ctx, name)
+	if errors.Is(err, ErrRoleNotFound) {
+		permissions = []string{}
+	} else if err != nil {
+		return nil, err
+	} else {
+		permissions = role.Permissions
+	}
+	if s.redis != nil {
+		if err := s.redis.Set(ctx, cacheKey(name), permissions, cacheTTL); err != nil {
+			log.Printf("Failed to cache role %s: %v", name, err)
+		}
+	}
+	return permissions, nil
+}
+
+func (s *Service) invalidate(ctx context.Context, name string) {
+	if s.redis == nil {
+		return
+	}
+	if err := s.redis.Delete(ctx, cacheKey(name)); err != nil {
+		log.Printf("Failed to invalidate role %s: %v", name, err)
+	}
+}
+
+// requireGrantable проверяет, что у вызывающего есть каждое из прав
+func requireGrantable(ctx context.Context, permissions []string) error {
+	for _, permission := range permissions {
+		if err := auth.Require(ctx, permission); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizePermissions проверяет права по auth.Permissions и убирает повторы
+func normalizePermissions(permissions []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, permission := range auth.Permissions {
+		known[permission] = true
+	}
+	seen := map[string]bool{}
+	result := []string{}
+	for _, permission := range permissions {
+		if !known[permission] {
+			return nil, fmt.Errorf("%w: unknown permission %q", ErrInvalidRole, permission)
+		}
+		if !seen[permission] {
+			seen[permission] = true
+			result = append(result, permission)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,414 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+)
+
+// sessionView — сессия в ответе API с отметкой текущей
+type sessionView struct {
+	Session
+	Current bool `json:"current"`
+}
+
+var (
+	trustedProxiesMu sync.RWMutex
+	trustedProxies   []*net.IPNet
+)
+
+// SetTrustedProxies задает сети обратных прокси (CIDR или отдельные адреса),
+// которым можно верить в X-Forwarded-For. Без них IP клиента — RemoteAddr.
+func SetTrustedProxies(proxies []string) error {
+	var networks []*net.IPNet
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		networks = append(networks, network)
+	}
+	trustedProxiesMu.Lock()
+	defer trustedProxiesMu.Unlock()
+	trustedProxies = networks
+	return nil
+}
+
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	trustedProxiesMu.RLock()
+	defer trustedProxiesMu.RUnlock()
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientFromRequest определяет устройство и IP клиента HTTP-запроса.
+// X-Forwarded-For учитывается, только если запрос пришел от доверенного
+// прокси: адреса читаются справа налево до первого недоверенного, так что
+// подставленные клиентом значения не подменяют IP.
+func ClientFromRequest(r *http.Request) Client {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if isTrustedProxy(ip) {
+		hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !isTrustedProxy(hop) {
+				break
+			}
+		}
+	}
+	return Client{Device: r.UserAgent(), IP: ip}
+}
+
+// writeThrottled отвечает 429 с заголовком Retry-After
+func writeThrottled(w http.ResponseWriter, err error) {
+	seconds := int(RetryAfter(err).Seconds() + 0.5)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
+
+func writeTokens(w http.ResponseWriter, tokens *Tokens) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// HandleLogin: POST {"username", "password"} открывает сессию
+func (s *Service) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Username == "" || body.Password == "" {
+		http.Error(w, "Username and password are required", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := s.Login(audit.FromHTTP(r), body.Username, body.Password, ClientFromRequest(r))
+	if errors.Is(err, ErrTooManyAttempts) {
+		writeThrottled(w, err)
+		return
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		log.Println("Error logging in:", err)
+		return
+	}
+	writeTokens(w, tokens)
+}
+
+// HandleSecondFactor: POST {"challenge", "code"} завершает вход с 2FA;
+// code — TOTP-код или код восстановления
+func (s *Service) HandleSecondFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Challenge == "" || body.Code == "" {
+		http.Error(w, "Challenge and code are required", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := s.CompleteLogin(audit.FromHTTP(r), body.Challenge, body.Code, ClientFromRequest(r))
+	if errors.Is(err, ErrTooManyAttempts) {
+		writeThrottled(w, err)
+		return
+	}
+	if errors.Is(err, ErrInvalidChallenge) || errors.Is(err, ErrInvalidSecondFactor) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		log.Println("Error completing login:", err)
+		return
+	}
+	writeTokens(w, tokens)
+}
+
+// HandleRefresh: POST {"refreshToken"} выдает новую пару токенов
+func (s *Service) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := s.Refresh(audit.FromHTTP(r), body.RefreshToken, ClientFromRequest(r))
+	if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrTokenReused) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		log.Println("Error refreshing session:", err)
+		return
+	}
+	writeTokens(w, tokens)
+}
+
+// HandleLogout: POST закрывает сессию текущего access-токена
+func (s *Service) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(claims.SessionID)
+	if err != nil {
+		http.Error(w, "Token is not bound to a session", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Revoke(audit.FromHTTP(r), claims.UserID, id, ReasonLogout); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleSessions: GET — список активных сессий, DELETE ?id= — закрыть одну
+// сессию, DELETE ?all=true — закрыть все сессии пользователя
+func (s *Service) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := s.List(r.Context(), claims.UserID)
+		if err != nil {
+			http.Error(w, "Failed to load sessions", http.StatusInternalServerError)
+			return
+		}
+		views := make([]sessionView, 0, len(sessions))
+		for _, session := range sessions {
+			views = append(views, sessionView{Session: session, Current: session.ID.Hex() == claims.SessionID})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(views)
+	case http.MethodDelete:
+		ctx := audit.FromHTTP(r)
+		if r.URL.Query().Get("all") == "true" {
+			revoked, err := s.RevokeAll(ctx, claims.UserID, ReasonLogoutAll)
+			if err != nil {
+				http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]int{"revoked": revoked})
+			return
+		}
+
+		id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid session ID", http.StatusBadRequest)
+			return
+		}
+		err = s.Revoke(ctx, claims.UserID, id, ReasonLogout)
+		if errors.Is(err, ErrSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleForceLogout: POST ?userId= закрывает все сессии пользователя
+// (нужно право sessions.manage)
+func (s *Service) HandleForceLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !claims.Can(auth.PermSessionsManage) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		http.Error(w, "userId is required", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := s.RevokeAll(audit.FromHTTP(r), userID, ReasonForced)
+	if err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"revoked": revoked})
+}
+
+// HandleUnlock: POST {"login", "ip"} снимает блокировку входа с аккаунта
+// и/или IP (нужно право sessions.manage)
+func (s *Service) HandleUnlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !claims.Can(auth.PermSessionsManage) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	var body struct {
+		Login string `json:"login"`
+		IP    string `json:"ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || (body.Login == "" && body.IP == "") {
+		http.Error(w, "login or ip is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Unlock(audit.FromHTTP(r), body.Login, body.IP); err != nil {
+		http.Error(w, "Failed to unlock: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleImpersonate: POST {"userId", "reason"} выдает токен от имени
+// пользователя (нужно право users.impersonate)
+func (s *Service) HandleImpersonate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		UserID string `json:"userId"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.UserID == "" {
+		http.Error(w, "userId is required", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := s.Impersonate(audit.FromHTTP(r), body.UserID, body.Reason, ClientFromRequest(r))
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrForbidden), errors.Is(err, ErrCannotImpersonate):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrImpersonationReason):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "Failed to impersonate user", http.StatusInternalServerError)
+		log.Println("Error impersonating user:", err)
+	default:
+		writeTokens(w, tokens)
+	}
+}
+
+// HandleEndImpersonation: POST завершает имперсонацию текущего токена
+func (s *Service) HandleEndImpersonation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := s.EndImpersonation(audit.FromHTTP(r))
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, ErrNotImpersonating), errors.Is(err, ErrSessionNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "Failed to end impersonation", http.StatusInternalServerError)
+		log.Println("Error ending impersonation:", err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RegisterRoutes подключает обработчики под /auth/ и /admin/sessions/
+func (s *Service) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/auth/login", s.HandleLogin)
+	mux.HandleFunc("/auth/login/2fa", s.HandleSecondFactor)
+	mux.HandleFunc("/auth/refresh", s.HandleRefresh)
+	mux.HandleFunc("/auth/logout", s.HandleLogout)
+	mux.HandleFunc("/auth/sessions", s.HandleSessions)
+	mux.HandleFunc("/admin/sessions/logout", s.HandleForceLogout)
+	mux.HandleFunc("/admin/sessions/unlock", s.HandleUnlock)
+	mux.HandleFunc("/admin/sessions/impersonate", s.HandleImpersonate)
+	mux.HandleFunc("/auth/impersonation/end", s.HandleEndImpersonation)
+}
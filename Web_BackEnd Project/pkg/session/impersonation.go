@@ -0,0 +1,227 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+)
+
+// Причины закрытия сессии имперсонации
+const (
+	ReasonImpersonationEnded   = "impersonation_ended"
+	ReasonImpersonationExpired = "impersonation_expired"
+)
+
+const (
+	// maxImpersonationTTL ограничивает Config.ImpersonationTTL
+	maxImpersonationTTL = time.Hour
+	// impersonationRetention — сколько сессия имперсонации хранится после
+	// конца, чтобы RunImpersonationSweep успел ее закрыть и уведомить пользователя
+	impersonationRetention = 24 * time.Hour
+)
+
+var (
+	// ErrCannotImpersonate возвращается для администратора, самого себя и
+	// пользователя с правами, которых нет у вызывающего
+	ErrCannotImpersonate = errors.New("this user cannot be impersonated")
+	// ErrImpersonationReason возвращается, если не указана причина имперсонации
+	ErrImpersonationReason = errors.New("impersonation reason is required")
+	// ErrNotImpersonating возвращается EndImpersonation для обычного токена
+	ErrNotImpersonating = errors.New("token is not an impersonation token")
+)
+
+// Impersonation — завершенная имперсонация для уведомления пользователя
+type Impersonation struct {
+	UserID    string
+	ActorID   string
+	Reason    string
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+// Impersonate открывает сессию от имени пользователя userID для вызывающего
+// с правом users.impersonate (роль admin). Токен выдается тем же issue, что
+// и при входе, но с Actor, без refresh-токена и на Config.ImpersonationTTL.
+// Опасные действия такому токену запрещены (см. auth.BlockWhileImpersonating).
+func (s *Service) Impersonate(ctx context.Context, userID, reason string, client Client) (*Tokens, error) {
+	actor := auth.FromContext(ctx)
+	if actor == nil {
+		return nil, auth.ErrUnauthenticated
+	}
+	// Ключ API и сама имперсонация не открывают новую имперсонацию
+	if actor.IsAPIKey() || actor.IsImpersonated() || !actor.Can(auth.PermUsersImpersonate) {
+		return nil, auth.ErrForbidden
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrImpersonationReason
+	}
+	if userID == actor.UserID {
+		return nil, fmt.Errorf("%w: cannot impersonate yourself", ErrCannotImpersonate)
+	}
+
+	identity, err := s.config.Resolve(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// SetupRequired получает только администратор без 2FA, у которого роли уже сняты
+	if containsString(identity.Roles, auth.RoleAdmin) || identity.Role == auth.RoleAdmin || identity.SetupRequired {
+		return nil, fmt.Errorf("%w: administrators cannot be impersonated", ErrCannotImpersonate)
+	}
+	// Имперсонация не должна давать прав больше, чем есть у самого вызывающего
+	for _, permission := range identity.Permissions {
+		if !actor.Can(permission) {
+			return nil, fmt.Errorf("%w: user has permission %q", ErrCannotImpersonate, permission)
+		}
+	}
+
+	now := time.Now()
+	ends := now.Add(s.config.ImpersonationTTL)
+	session := &Session{
+		ID:                  primitive.NewObjectID(),
+		UserID:              userID,
+		Device:              client.Device,
+		IP:                  client.IP,
+		CreatedAt:           now,
+		LastUsedAt:          now,
+		ExpiresAt:           ends.Add(impersonationRetention),
+		ImpersonatorID:      actor.UserID,
+		ImpersonationReason: reason,
+		ImpersonationEndsAt: &ends,
+	}
+	if _, err := s.sessions.InsertOne(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to create impersonation session: %w", err)
+	}
+
+	log.Printf("User %s started impersonating user %s: %s", actor.UserID, userID, reason)
+	s.config.Audit.Record(ctx, "session.impersonation_start", "user:"+userID, nil, session)
+	return s.issue(ctx, session, identity, "")
+}
+
+// EndImpersonation закрывает сессию имперсонации текущего токена, пишет
+// конец в журнал и уведомляет пользователя
+func (s *Service) EndImpersonation(ctx context.Context) error {
+	claims := auth.FromContext(ctx)
+	if claims == nil {
+		return auth.ErrUnauthenticated
+	}
+	if !claims.IsImpersonated() {
+		return ErrNotImpersonating
+	}
+	id, err := primitive.ObjectIDFromHex(claims.SessionID)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+	if err := s.Revoke(ctx, claims.UserID, id, ReasonImpersonationEnded); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+	return s.finishImpersonation(ctx, id)
+}
+
+// RunImpersonationSweep раз в interval завершает имперсонации, которые
+// истекли или были закрыты иначе (выход, отзыв всех сессий), чтобы о каждой
+// была запись в журнале и пользователь получил уведомление
+func (s *Service) RunImpersonationSweep(ctx context.Context, interval time.Duration) {
+	ctx = audit.WithMeta(ctx, audit.Meta{Actor: "impersonation-sweep", Source: audit.SourceSystem})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.sweepImpersonations(ctx); err != nil {
+			log.Println("Error finishing impersonation sessions:", err)
+		}
+	}
+}
+
+func (s *Service) sweepImpersonations(ctx context.Context) error {
+	cursor, err := s.sessions.Find(ctx, bson.M{
+		"impersonator_id":        bson.M{"$exists": true},
+		"impersonation_ended_at": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"revoked_at": bson.M{"$exists": true}},
+			{"impersonation_ends_at": bson.M{"$lte": time.Now()}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	var sessions []Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return err
+	}
+	for i := range sessions {
+		if sessions[i].RevokedAt == nil {
+			if err := s.revoke(ctx, &sessions[i], ReasonImpersonationExpired); err != nil {
+				log.Printf("Failed to close impersonation session %s: %v", sessions[i].ID.Hex(), err)
+				continue
+			}
+		}
+		if err := s.finishImpersonation(ctx, sessions[i].ID); err != nil {
+			log.Printf("Failed to finish impersonation session %s: %v", sessions[i].ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// finishImpersonation отмечает имперсонацию завершенной ровно один раз:
+// повторный вызов для той же сессии ничего не делает
+func (s *Service) finishImpersonation(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	var session Session
+	err := s.sessions.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "impersonator_id": bson.M{"$exists": true}, "impersonation_ended_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"impersonation_ended_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to finish impersonation: %w", err)
+	}
+
+	ended := now
+	if session.RevokedAt != nil && session.RevokedAt.Before(ended) {
+		ended = *session.RevokedAt
+	}
+	if session.ImpersonationEndsAt != nil && session.ImpersonationEndsAt.Before(ended) {
+		ended = *session.ImpersonationEndsAt
+	}
+	impersonation := Impersonation{
+		UserID:    session.UserID,
+		ActorID:   session.ImpersonatorID,
+		Reason:    session.ImpersonationReason,
+		StartedAt: session.CreatedAt,
+		EndedAt:   ended,
+	}
+	s.config.Audit.Record(ctx, "session.impersonation_end", "user:"+session.UserID, nil, impersonation)
+	if s.config.NotifyImpersonation != nil {
+		s.config.NotifyImpersonation(ctx, impersonation)
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
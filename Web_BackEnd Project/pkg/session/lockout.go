@@ -0,0 +1,241 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrTooManyAttempts возвращается, пока вход для аккаунта или IP задержан
+// или заблокирован. Срок ожидания можно узнать через RetryAfter.
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+
+// ThrottleError — отказ из-за перебора с временем, через которое можно повторить
+type ThrottleError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottleError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("%v: locked, try again in %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("%v: try again in %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottleError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// RetryAfter возвращает время ожидания из ThrottleError или 0
+func RetryAfter(err error) time.Duration {
+	var throttle *ThrottleError
+	if errors.As(err, &throttle) {
+		return throttle.RetryAfter
+	}
+	return 0
+}
+
+// LockoutPolicy — пороги защиты от перебора паролей. Неудачи считаются в
+// скользящем окне Window отдельно по аккаунту и по IP.
+type LockoutPolicy struct {
+	Window time.Duration
+	// DelayAfter неудач по аккаунту каждая следующая попытка ждет
+	// 1, 2, 4... секунд, но не больше MaxDelay
+	DelayAfter int
+	MaxDelay   time.Duration
+	// LockAfter неудач по аккаунту блокируют его на LockDuration
+	LockAfter    int
+	LockDuration time.Duration
+	// IPLockAfter неудач с одного IP блокируют этот IP на LockDuration
+	IPLockAfter int
+}
+
+func (p *LockoutPolicy) withDefaults() {
+	if p.Window <= 0 {
+		p.Window = 15 * time.Minute
+	}
+	if p.DelayAfter <= 0 {
+		p.DelayAfter = 3
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 30 * time.Second
+	}
+	if p.LockAfter <= 0 {
+		p.LockAfter = 10
+	}
+	if p.LockDuration <= 0 {
+		p.LockDuration = 15 * time.Minute
+	}
+	if p.IPLockAfter <= 0 {
+		p.IPLockAfter = 50
+	}
+}
+
+// Ключи Redis: окна неудач, задержка и блокировка
+func failuresKey(kind, value string) string { return "login:failures:" + kind + ":" + value }
+func delayKey(kind, value string) string    { return "login:delay:" + kind + ":" + value }
+func lockKey(kind, value string) string     { return "login:lock:" + kind + ":" + value }
+
+// accountKey — логин без регистра и пробелов: "Admin" и "admin " — один аккаунт
+func accountKey(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+// account возвращает ключ блокировки для логина: ID пользователя, если
+// Config.ResolveAccount его нашел, иначе нормализованный логин. Так username
+// и email одного пользователя делят общий счетчик неудач.
+func (s *Service) account(ctx context.Context, login string) string {
+	if login == "" {
+		return ""
+	}
+	if s.config.ResolveAccount != nil {
+		if id, err := s.config.ResolveAccount(ctx, login); err == nil && id != "" {
+			return id
+		}
+	}
+	return accountKey(login)
+}
+
+// checkThrottle отказывает, если аккаунт или IP заблокирован или не истекла
+// задержка после прошлой неудачи. account — ключ из Service.account; пустой
+// account или ip не проверяется.
+func (s *Service) checkThrottle(ctx context.Context, account, ip string) error {
+	if s.redis == nil {
+		return nil
+	}
+	type throttleCheck struct {
+		key    string
+		locked bool
+	}
+	var checks []throttleCheck
+	if account != "" {
+		checks = append(checks,
+			throttleCheck{lockKey("account", account), true},
+			throttleCheck{delayKey("account", account), false},
+		)
+	}
+	if ip != "" {
+		checks = append(checks, throttleCheck{lockKey("ip", ip), true})
+	}
+
+	for _, check := range checks {
+		ttl, err := s.redis.TTL(ctx, check.key)
+		if err != nil {
+			// Без Redis вход не блокируется: недоступный кэш не должен закрывать сервис
+			log.Printf("Failed to check login throttle: %v", err)
+			return nil
+		}
+		if ttl > 0 {
+			return &ThrottleError{RetryAfter: ttl, Locked: check.locked}
+		}
+	}
+	return nil
+}
+
+// recordFailure учитывает неудачную попытку, назначает задержку и блокирует
+// аккаунт или IP при превышении порогов. Пустой account или ip не учитывается.
+func (s *Service) recordFailure(ctx context.Context, account, ip string) {
+	if s.redis == nil {
+		return
+	}
+	policy := s.config.Lockout
+
+	if account != "" {
+		failures, err := s.redis.HitWindow(ctx, failuresKey("account", account), policy.Window)
+		if err != nil {
+			log.Printf("Failed to record login failure: %v", err)
+			return
+		}
+		switch {
+		case failures >= int64(policy.LockAfter):
+			s.lock(ctx, "account", account, failures)
+		case failures >= int64(policy.DelayAfter):
+			delay := time.Second << uint(failures-int64(policy.DelayAfter))
+			if delay > policy.MaxDelay || delay <= 0 {
+				delay = policy.MaxDelay
+			}
+			if err := s.redis.Set(ctx, delayKey("account", account), failures, delay); err != nil {
+				log.Printf("Failed to set login delay: %v", err)
+			}
+		}
+	}
+
+	if ip == "" {
+		return
+	}
+	ipFailures, err := s.redis.HitWindow(ctx, failuresKey("ip", ip), policy.Window)
+	if err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+		return
+	}
+	if ipFailures >= int64(policy.IPLockAfter) {
+		s.lock(ctx, "ip", ip, ipFailures)
+	}
+}
+
+// lock блокирует аккаунт или IP. Владельца аккаунта уведомляет Config.NotifyLockout.
+func (s *Service) lock(ctx context.Context, kind, value string, failures int64) {
+	policy := s.config.Lockout
+	key := lockKey(kind, value)
+	if locked, err := s.redis.Exists(ctx, key); err == nil && locked {
+		return
+	}
+	if err := s.redis.Set(ctx, key, failures, policy.LockDuration); err != nil {
+		log.Printf("Failed to lock %s %s: %v", kind, value, err)
+		return
+	}
+	// Окно обнуляется, чтобы после блокировки счет начался заново
+	s.redis.Delete(ctx, failuresKey(kind, value))
+
+	until := time.Now().Add(policy.LockDuration)
+	log.Printf("Login locked for %s %s after %d failures", kind, value, failures)
+	s.config.Audit.Record(ctx, "session.lockout", kind+":"+value, nil, map[string]interface{}{
+		"failures": failures,
+		"until":    until,
+	})
+	if kind == "account" && s.config.NotifyLockout != nil {
+		go s.config.NotifyLockout(context.Background(), value, until)
+	}
+}
+
+// clearFailures сбрасывает счетчик и задержку аккаунта после успешного входа
+func (s *Service) clearFailures(ctx context.Context, account string) {
+	if s.redis == nil {
+		return
+	}
+	s.redis.Delete(ctx, failuresKey("account", account))
+	s.redis.Delete(ctx, delayKey("account", account))
+}
+
+// Unlock снимает блокировку и задержку с аккаунта (login) или IP.
+// Вызывается администратором.
+func (s *Service) Unlock(ctx context.Context, login, ip string) error {
+	if s.redis == nil {
+		return fmt.Errorf("login throttling requires Redis")
+	}
+	var keys []string
+	target := ""
+	if login != "" {
+		account := s.account(ctx, login)
+		keys = append(keys, lockKey("account", account), delayKey("account", account), failuresKey("account", account))
+		target = "account:" + account
+	}
+	if ip != "" {
+		keys = append(keys, lockKey("ip", ip), failuresKey("ip", ip))
+		if target != "" {
+			target += ","
+		}
+		target += "ip:" + ip
+	}
+	for _, key := range keys {
+		if err := s.redis.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	s.config.Audit.Record(ctx, "session.unlock", target, nil, nil)
+	return nil
+}
@@ -0,0 +1,553 @@
+package session
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+	"web_backend_project/pkg/cache"
+)
+
+// Причины закрытия сессии
+const (
+	ReasonLogout      = "logout"
+	ReasonLogoutAll   = "logout_all"
+	ReasonForced      = "forced_by_admin"
+	ReasonReuse       = "refresh_token_reuse"
+	ReasonRevokedUser = "user_tokens_revoked"
+)
+
+const (
+	// usedHashesLimit — сколько прежних refresh-токенов сессии помнится для
+	// обнаружения повторного использования
+	usedHashesLimit = 100
+	// challengeTTL — сколько действует токен шага 2FA после верного пароля
+	challengeTTL = 5 * time.Minute
+	// PurposeSecondFactor — назначение токена шага 2FA
+	PurposeSecondFactor = "2fa"
+)
+
+var (
+	// ErrInvalidCredentials возвращается Authenticate при неверном логине или пароле
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrInvalidRefreshToken возвращается для неизвестного или истекшего refresh-токена
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrTokenReused возвращается, если предъявлен уже замененный refresh-токен;
+	// вся сессия при этом закрывается
+	ErrTokenReused = errors.New("refresh token reuse detected, session revoked")
+	// ErrSessionNotFound возвращается, если у пользователя нет такой активной сессии
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrInvalidChallenge возвращается для неверного или истекшего токена шага 2FA
+	ErrInvalidChallenge = errors.New("invalid or expired two-factor challenge")
+	// ErrInvalidSecondFactor возвращается VerifySecondFactor при неверном коде
+	ErrInvalidSecondFactor = errors.New("invalid two-factor code")
+	// ErrUserNotFound возвращается Config.Resolve для неизвестного пользователя
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// Identity — то, что нужно знать о пользователе при выдаче токена
+type Identity struct {
+	Role string
+	// Roles и Permissions — все роли пользователя и права, собранные из них
+	Roles       []string
+	Permissions []string
+	// TokensValidAfter — сессии, открытые раньше, считаются отозванными
+	TokensValidAfter time.Time
+	// SecondFactor — после пароля нужен код 2FA
+	SecondFactor bool
+	// SetupRequired — политика требует включить 2FA; до этого Role не выдается
+	SetupRequired bool
+}
+
+// Config — зависимости сервиса
+type Config struct {
+	// Authenticate проверяет логин и пароль и возвращает ID пользователя.
+	// Неверные данные — ErrInvalidCredentials.
+	Authenticate func(ctx context.Context, login, password string) (string, error)
+	// ResolveAccount находит ID пользователя по username или email, чтобы
+	// блокировка от перебора считалась по пользователю, а не по строке
+	// логина. Неизвестный логин — пустая строка. Может быть nil.
+	ResolveAccount func(ctx context.Context, login string) (string, error)
+	// Resolve находит пользователя при выдаче и обновлении токенов
+	Resolve func(ctx context.Context, userID string) (Identity, error)
+	// VerifySecondFactor проверяет код 2FA; неверный код — ErrInvalidSecondFactor
+	VerifySecondFactor func(ctx context.Context, userID, code string) error
+	// AccessTTL — срок жизни access-токена
+	AccessTTL time.Duration
+	// RefreshTTL — срок жизни сессии без обновления
+	RefreshTTL time.Duration
+	// Audit записывает входы и отзывы сессий; может быть nil
+	Audit *audit.Log
+	// Lockout — пороги защиты от перебора; нулевые поля получают значения по умолчанию
+	Lockout LockoutPolicy
+	// NotifyLockout сообщает владельцу о блокировке аккаунта; account — ID
+	// пользователя или, если логин никому не принадлежит, сам логин. Может быть nil.
+	NotifyLockout func(ctx context.Context, account string, until time.Time)
+	// ImpersonationTTL — срок токена имперсонации, не больше часа
+	ImpersonationTTL time.Duration
+	// NotifyImpersonation сообщает пользователю о завершенной имперсонации; может быть nil
+	NotifyImpersonation func(ctx context.Context, impersonation Impersonation)
+}
+
+// Client — устройство и адрес, с которых открыта сессия
+type Client struct {
+	Device string
+	IP     string
+}
+
+// Session — семейство refresh-токенов одного входа. При каждом обновлении
+// токен меняется, а прежний попадает в UsedHashes.
+type Session struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       string             `bson:"user_id" json:"userId"`
+	TokenHash    string             `bson:"token_hash" json:"-"`
+	UsedHashes   []string           `bson:"used_hashes,omitempty" json:"-"`
+	Device       string             `bson:"device,omitempty" json:"device,omitempty"`
+	IP           string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"createdAt"`
+	LastUsedAt   time.Time          `bson:"last_used_at" json:"lastUsedAt"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expiresAt"`
+	RevokedAt    *time.Time         `bson:"revoked_at,omitempty" json:"revokedAt,omitempty"`
+	RevokeReason string             `bson:"revoke_reason,omitempty" json:"revokeReason,omitempty"`
+	// Заполняются только для сессии имперсонации: кто и зачем ее открыл,
+	// когда истекает токен и когда имперсонация была завершена
+	ImpersonatorID       string     `bson:"impersonator_id,omitempty" json:"impersonatorId,omitempty"`
+	ImpersonationReason  string     `bson:"impersonation_reason,omitempty" json:"impersonationReason,omitempty"`
+	ImpersonationEndsAt  *time.Time `bson:"impersonation_ends_at,omitempty" json:"impersonationEndsAt,omitempty"`
+	ImpersonationEndedAt *time.Time `bson:"impersonation_ended_at,omitempty" json:"impersonationEndedAt,omitempty"`
+}
+
+// Tokens — пара токенов, выдаваемая при входе и обновлении. Если нужен
+// второй фактор, вместо них возвращается Challenge для CompleteLogin.
+type Tokens struct {
+	AccessToken          string    `json:"accessToken,omitempty"`
+	RefreshToken         string    `json:"refreshToken,omitempty"`
+	TokenType            string    `json:"tokenType,omitempty"`
+	ExpiresAt            time.Time `json:"expiresAt"`
+	SessionID            string    `json:"sessionId,omitempty"`
+	UserID               string    `json:"userId"`
+	SecondFactorRequired bool      `json:"secondFactorRequired,omitempty"`
+	Challenge            string    `json:"challenge,omitempty"`
+	SetupRequired        bool      `json:"twoFactorSetupRequired,omitempty"`
+}
+
+// Service хранит сессии в MongoDB, а состояние сессий для проверки каждого
+// запроса — в Redis
+type Service struct {
+	sessions *mongo.Collection
+	redis    *cache.RedisClient
+	config   Config
+}
+
+// NewService создает сервис; сессии хранятся в коллекции sessions базы db.
+// redis может быть nil — тогда каждая проверка идет в MongoDB.
+func NewService(db *mongo.Database, redis *cache.RedisClient, config Config) *Service {
+	if config.AccessTTL <= 0 {
+		config.AccessTTL = 15 * time.Minute
+	}
+	if config.RefreshTTL <= 0 {
+		config.RefreshTTL = 30 * 24 * time.Hour
+	}
+	config.Lockout.withDefaults()
+	if config.ImpersonationTTL <= 0 || config.ImpersonationTTL > maxImpersonationTTL {
+		config.ImpersonationTTL = 30 * time.Minute
+	}
+	if redis == nil {
+		log.Println("Warning: sessions run without Redis, login throttling is disabled")
+	}
+	return &Service{
+		sessions: db.Collection("sessions"),
+		redis:    redis,
+		config:   config,
+	}
+}
+
+// EnsureIndexes создает индекс для списка сессий пользователя, TTL-индекс,
+// удаляющий истекшие сессии, и индекс сессий имперсонации
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	_, err := s.sessions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "impersonator_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	return err
+}
+
+// activeKey и revokedKey — отметки в Redis. Их срок равен сроку access-токена:
+// дольше токены сессии не живут.
+func activeKey(id primitive.ObjectID) string  { return "session:active:" + id.Hex() }
+func revokedKey(id primitive.ObjectID) string { return "session:revoked:" + id.Hex() }
+
+// Login проверяет пароль и открывает новую сессию. Пользователю с 2FA
+// вместо токенов возвращается Challenge: сессия откроется в CompleteLogin.
+func (s *Service) Login(ctx context.Context, login, password string, client Client) (*Tokens, error) {
+	if s.config.Authenticate == nil {
+		return nil, fmt.Errorf("authentication is not configured")
+	}
+	// Блокировка проверяется до пароля: заблокированный аккаунт не подбирается
+	account := s.account(ctx, login)
+	if err := s.checkThrottle(ctx, account, client.IP); err != nil {
+		s.config.Audit.Record(ctx, "session.login_throttled", "login:"+accountKey(login), nil, nil)
+		return nil, err
+	}
+	userID, err := s.config.Authenticate(ctx, login, password)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			s.config.Audit.Record(ctx, "session.login_failed", "login:"+accountKey(login), nil, nil)
+			s.recordFailure(ctx, account, client.IP)
+		}
+		return nil, err
+	}
+	s.clearFailures(ctx, account)
+	identity, err := s.config.Resolve(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.SecondFactor {
+		now := time.Now()
+		expires := now.Add(challengeTTL)
+		challenge, err := auth.NewToken(auth.Secret(), auth.Claims{
+			UserID:    userID,
+			Purpose:   PurposeSecondFactor,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expires.Unix(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Tokens{UserID: userID, ExpiresAt: expires, SecondFactorRequired: true, Challenge: challenge}, nil
+	}
+	return s.open(ctx, userID, identity, client)
+}
+
+// CompleteLogin проверяет код 2FA для Challenge из Login и открывает сессию
+func (s *Service) CompleteLogin(ctx context.Context, challenge, code string, client Client) (*Tokens, error) {
+	claims, err := auth.ParseToken(auth.Secret(), challenge)
+	if err != nil || claims.Purpose != PurposeSecondFactor {
+		return nil, ErrInvalidChallenge
+	}
+	if s.config.VerifySecondFactor == nil {
+		return nil, fmt.Errorf("two-factor authentication is not configured")
+	}
+	// У кодов 2FA своя блокировка по аккаунту; здесь учитывается только IP
+	if err := s.checkThrottle(ctx, "", client.IP); err != nil {
+		return nil, err
+	}
+	if err := s.config.VerifySecondFactor(ctx, claims.UserID, code); err != nil {
+		if errors.Is(err, ErrInvalidSecondFactor) {
+			s.config.Audit.Record(ctx, "session.second_factor_failed", "user:"+claims.UserID, nil, nil)
+			s.recordFailure(ctx, "", client.IP)
+		}
+		return nil, err
+	}
+	identity, err := s.config.Resolve(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(ctx, claims.UserID, identity, client)
+}
+
+// open создает сессию и выдает первую пару токенов
+func (s *Service) open(ctx context.Context, userID string, identity Identity, client Client) (*Tokens, error) {
+	id := primitive.NewObjectID()
+	refresh, hash, err := newRefreshToken(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &Session{
+		ID:         id,
+		UserID:     userID,
+		TokenHash:  hash,
+		Device:     client.Device,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.config.RefreshTTL),
+	}
+	if _, err := s.sessions.InsertOne(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	s.config.Audit.Record(ctx, "session.login", sessionTarget(session.ID), nil, session)
+	return s.issue(ctx, session, identity, refresh)
+}
+
+// Refresh меняет refresh-токен на новую пару токенов. Старый токен после
+// этого недействителен; его повторное предъявление закрывает всю сессию.
+func (s *Service) Refresh(ctx context.Context, refreshToken string, client Client) (*Tokens, error) {
+	id, ok := sessionIDFromToken(refreshToken)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+	hash := auth.HashToken(refreshToken)
+
+	newToken, newHash, err := newRefreshToken(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	set := bson.M{"token_hash": newHash, "last_used_at": now, "expires_at": now.Add(s.config.RefreshTTL)}
+	if client.Device != "" {
+		set["device"] = client.Device
+	}
+	if client.IP != "" {
+		set["ip"] = client.IP
+	}
+
+	// Замена токена атомарна: из двух одновременных обновлений пройдет одно
+	var session Session
+	err = s.sessions.FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":        id,
+			"token_hash": hash,
+			"revoked_at": bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{
+			"$set":  set,
+			"$push": bson.M{"used_hashes": bson.M{"$each": []string{hash}, "$slice": -usedHashesLimit}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, s.rejectRefresh(ctx, id, hash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh session: %w", err)
+	}
+
+	identity, err := s.config.Resolve(ctx, session.UserID)
+	if err != nil {
+		s.revoke(ctx, &session, ReasonRevokedUser)
+		return nil, ErrInvalidRefreshToken
+	}
+	// Сброс пароля отзывает все сессии, открытые до него
+	if !identity.TokensValidAfter.IsZero() && session.CreatedAt.Before(identity.TokensValidAfter) {
+		s.revoke(ctx, &session, ReasonRevokedUser)
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return s.issue(ctx, &session, identity, newToken)
+}
+
+// rejectRefresh разбирает, почему токен не подошел. Токен, который уже был
+// заменен, означает утечку: сессия закрывается целиком.
+func (s *Service) rejectRefresh(ctx context.Context, id primitive.ObjectID, hash string) error {
+	var session Session
+	if err := s.sessions.FindOne(ctx, bson.M{"_id": id}).Decode(&session); err != nil {
+		return ErrInvalidRefreshToken
+	}
+	for _, used := range session.UsedHashes {
+		if subtle.ConstantTimeCompare([]byte(used), []byte(hash)) == 1 {
+			if session.RevokedAt == nil {
+				log.Printf("Refresh token reuse in session %s of user %s", session.ID.Hex(), session.UserID)
+				s.revoke(ctx, &session, ReasonReuse)
+			}
+			return ErrTokenReused
+		}
+	}
+	return ErrInvalidRefreshToken
+}
+
+// issue подписывает access-токен сессии и отмечает ее активной в Redis.
+// Токен сессии имперсонации несет Actor и живет до конца имперсонации.
+func (s *Service) issue(ctx context.Context, session *Session, identity Identity, refresh string) (*Tokens, error) {
+	now := time.Now()
+	expires := now.Add(s.config.AccessTTL)
+	if session.ImpersonationEndsAt != nil {
+		expires = *session.ImpersonationEndsAt
+	}
+	access, err := auth.NewToken(auth.Secret(), auth.Claims{
+		UserID:      session.UserID,
+		Role:        identity.Role,
+		Roles:       identity.Roles,
+		Permissions: identity.Permissions,
+		Actor:       session.ImpersonatorID,
+		SessionID:   session.ID.Hex(),
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   expires.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.markActive(ctx, session.ID)
+
+	return &Tokens{
+		AccessToken:   access,
+		RefreshToken:  refresh,
+		TokenType:     "Bearer",
+		ExpiresAt:     expires,
+		SessionID:     session.ID.Hex(),
+		UserID:        session.UserID,
+		SetupRequired: identity.SetupRequired,
+	}, nil
+}
+
+// List возвращает активные сессии пользователя, новые первыми
+func (s *Service) List(ctx context.Context, userID string) ([]Session, error) {
+	var validAfter time.Time
+	if identity, err := s.config.Resolve(ctx, userID); err == nil {
+		validAfter = identity.TokensValidAfter
+	}
+
+	filter := bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	if !validAfter.IsZero() {
+		filter["created_at"] = bson.M{"$gte": validAfter}
+	}
+	cursor, err := s.sessions.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Revoke закрывает одну сессию пользователя
+func (s *Service) Revoke(ctx context.Context, userID string, id primitive.ObjectID, reason string) error {
+	var session Session
+	err := s.sessions.FindOne(ctx, bson.M{"_id": id, "user_id": userID, "revoked_at": bson.M{"$exists": false}}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.revoke(ctx, &session, reason)
+}
+
+// RevokeAll закрывает все сессии пользователя и возвращает их число
+func (s *Service) RevokeAll(ctx context.Context, userID, reason string) (int, error) {
+	cursor, err := s.sessions.Find(ctx, bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+	var sessions []Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for i := range sessions {
+		if err := s.revoke(ctx, &sessions[i], reason); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// revoke помечает сессию закрытой в MongoDB и сразу же в Redis, чтобы ее
+// access-токены перестали приниматься
+func (s *Service) revoke(ctx context.Context, session *Session, reason string) error {
+	now := time.Now()
+	_, err := s.sessions.UpdateOne(ctx,
+		bson.M{"_id": session.ID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now, "revoke_reason": reason}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if s.redis != nil {
+		if err := s.redis.Set(ctx, revokedKey(session.ID), reason, s.config.AccessTTL); err != nil {
+			log.Printf("Failed to mark session %s revoked in Redis: %v", session.ID.Hex(), err)
+		}
+		s.redis.Delete(ctx, activeKey(session.ID))
+	}
+
+	before := *session
+	session.RevokedAt, session.RevokeReason = &now, reason
+	s.config.Audit.Record(ctx, "session.revoke", sessionTarget(session.ID), &before, session)
+	return nil
+}
+
+// Check — проверка отзыва для auth.AddRevocationCheck. Обычно ответ дает
+// Redis; MongoDB читается, только если отметки о сессии там нет.
+func (s *Service) Check(ctx context.Context, claims *auth.Claims) error {
+	if claims.SessionID == "" {
+		return nil
+	}
+	id, err := primitive.ObjectIDFromHex(claims.SessionID)
+	if err != nil {
+		return auth.ErrInvalidToken
+	}
+
+	if s.redis != nil {
+		if revoked, err := s.redis.Exists(ctx, revokedKey(id)); err == nil {
+			if revoked {
+				return auth.ErrRevokedToken
+			}
+			if active, err := s.redis.Exists(ctx, activeKey(id)); err == nil && active {
+				return nil
+			}
+		}
+	}
+
+	var session Session
+	err = s.sessions.FindOne(ctx, bson.M{"_id": id}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return auth.ErrRevokedToken
+	}
+	if err != nil {
+		return err
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return auth.ErrRevokedToken
+	}
+	s.markActive(ctx, id)
+	return nil
+}
+
+func (s *Service) markActive(ctx context.Context, id primitive.ObjectID) {
+	if s.redis == nil {
+		return
+	}
+	if err := s.redis.Set(ctx, activeKey(id), true, s.config.AccessTTL); err != nil {
+		log.Printf("Failed to mark session %s active in Redis: %v", id.Hex(), err)
+	}
+}
+
+// newRefreshToken возвращает токен вида "<ID сессии>.<секрет>" и его хэш.
+// По ID сессия находится без индекса по хэшу.
+func newRefreshToken(id primitive.ObjectID) (string, string, error) {
+	secret, err := auth.GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+	token := id.Hex() + "." + secret
+	return token, auth.HashToken(token), nil
+}
+
+// sessionIDFromToken извлекает ID сессии из refresh-токена "<id>.<секрет>"
+func sessionIDFromToken(token string) (primitive.ObjectID, bool) {
+	idHex, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return primitive.NilObjectID, false
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	return id, err == nil
+}
+
+// sessionTarget — идентификатор сессии в журнале аудита
+func sessionTarget(id primitive.ObjectID) string {
+	return "session:" + id.Hex()
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrUnsafeURL возвращается для адреса endpoint, который ведет во внутреннюю
+// сеть: доставка туда позволила бы партнеру обращаться к нашим сервисам
+var ErrUnsafeURL = errors.New("webhook url must point to a public http(s) address")
+
+// carrierNAT — общее адресное пространство провайдеров (RFC 6598), как и
+// частные сети, недоступно снаружи
+var carrierNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP сообщает, что адрес не внутренний: не loopback, не частная сеть
+// и не link-local, куда входит адрес метаданных облака 169.254.169.254
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || carrierNAT.Contains(ip))
+}
+
+// ValidateURL проверяет адрес endpoint при регистрации: схема http(s) и
+// только публичные адреса хоста
+func ValidateURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return ErrUnsafeURL
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsafeURL, err)
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return ErrUnsafeURL
+		}
+	}
+	return nil
+}
+
+// denyInternal не дает соединиться с внутренним адресом. Проверяется адрес,
+// с которым реально устанавливается соединение, поэтому ни смена DNS после
+// регистрации, ни редирект не приведут запрос во внутреннюю сеть.
+func denyInternal(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrUnsafeURL, host)
+	}
+	return nil
+}
+
+// newDeliveryClient — HTTP-клиент доставок, который ходит только на публичные адреса
+func newDeliveryClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: denyInternal}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,189 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/auth"
+)
+
+// requireAdmin пропускает только тех, у кого есть право webhooks.manage
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if !claims.Can(auth.PermWebhooksManage) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func objectIDParam(w http.ResponseWriter, r *http.Request, name string) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get(name))
+	if err != nil {
+		http.Error(w, "Invalid "+name, http.StatusBadRequest)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// HandleEndpoints: GET — список endpoints, POST {"url", "events"} — регистрация.
+// Секрет подписи возвращается только при регистрации.
+func (d *Dispatcher) HandleEndpoints(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		cursor, err := d.endpoints.Find(r.Context(), bson.M{})
+		if err != nil {
+			http.Error(w, "Failed to load endpoints", http.StatusInternalServerError)
+			return
+		}
+		endpoints := []Endpoint{}
+		if err := cursor.All(r.Context(), &endpoints); err != nil {
+			http.Error(w, "Failed to load endpoints", http.StatusInternalServerError)
+			return
+		}
+		for i := range endpoints {
+			endpoints[i].Secret = ""
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(endpoints)
+	case http.MethodPost:
+		var req struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		endpoint, err := d.Register(r.Context(), req.URL, req.Events)
+		if errors.Is(err, ErrUnknownEvent) || errors.Is(err, ErrUnsafeURL) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to register endpoint", http.StatusInternalServerError)
+			log.Println("Error registering webhook endpoint:", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(endpoint)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleEndpointState включает (?active=true) или отключает endpoint ?id=
+func (d *Dispatcher) HandleEndpointState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	id, ok := objectIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	err := d.SetActive(r.Context(), id, r.URL.Query().Get("active") == "true")
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Endpoint not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update endpoint", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeliveries возвращает последние доставки, с фильтрами ?endpoint_id= и ?status=
+func (d *Dispatcher) HandleDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	filter := bson.M{}
+	if r.URL.Query().Get("endpoint_id") != "" {
+		id, ok := objectIDParam(w, r, "endpoint_id")
+		if !ok {
+			return
+		}
+		filter["endpoint_id"] = id
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+
+	cursor, err := d.deliveries.Find(r.Context(), filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(200))
+	if err != nil {
+		http.Error(w, "Failed to load deliveries", http.StatusInternalServerError)
+		return
+	}
+	deliveries := []Delivery{}
+	if err := cursor.All(r.Context(), &deliveries); err != nil {
+		http.Error(w, "Failed to load deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// HandleReplay повторяет доставку ?id=
+func (d *Dispatcher) HandleReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	id, ok := objectIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+
+	err := d.Replay(r.Context(), id)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to replay delivery", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RegisterRoutes подключает обработчики к mux под префиксом /webhooks
+func (d *Dispatcher) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/webhooks/endpoints", d.HandleEndpoints)
+	mux.HandleFunc("/webhooks/endpoints/state", d.HandleEndpointState)
+	mux.HandleFunc("/webhooks/deliveries", d.HandleDeliveries)
+	mux.HandleFunc("/webhooks/deliveries/replay", d.HandleReplay)
+}
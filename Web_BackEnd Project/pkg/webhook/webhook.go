@@ -0,0 +1,435 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Типы событий, на которые можно подписаться
+const (
+	EventTransactionPaid     = "transaction.paid"
+	EventTransactionRefunded = "transaction.refunded"
+	EventUserRegistered      = "user.registered"
+)
+
+// Статусы доставки
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+const (
+	// SignatureHeader содержит "t=<unix time>,v1=<hex HMAC-SHA256 от "t.body">"
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	// MaxAttempts — после стольких неудачных попыток доставка считается мертвой
+	MaxAttempts = 8
+	// DisableAfter — столько неудачных попыток подряд отключают endpoint
+	DisableAfter = 20
+
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+	lease       = time.Minute
+)
+
+// ErrUnknownEvent возвращается при подписке на неподдерживаемый тип события
+var ErrUnknownEvent = errors.New("unknown event type")
+
+// Endpoint — адрес партнера и события, которые он хочет получать
+type Endpoint struct {
+	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	URL                 string             `bson:"url" json:"url"`
+	Secret              string             `bson:"secret" json:"secret,omitempty"`
+	Events              []string           `bson:"events" json:"events"`
+	Active              bool               `bson:"active" json:"active"`
+	ConsecutiveFailures int                `bson:"consecutive_failures" json:"consecutiveFailures"`
+	DisabledAt          *time.Time         `bson:"disabled_at,omitempty" json:"disabledAt,omitempty"`
+	CreatedAt           time.Time          `bson:"created_at" json:"createdAt"`
+}
+
+// Attempt — результат одной попытки доставки
+type Attempt struct {
+	At         time.Time `bson:"at" json:"at"`
+	StatusCode int       `bson:"status_code,omitempty" json:"statusCode,omitempty"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMS int64     `bson:"duration_ms" json:"durationMs"`
+}
+
+// Delivery — событие в очереди на отправку одному endpoint
+type Delivery struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	EndpointID    primitive.ObjectID `bson:"endpoint_id" json:"endpointId"`
+	EventID       string             `bson:"event_id" json:"eventId"`
+	UserID        string             `bson:"user_id,omitempty" json:"userId,omitempty"`
+	Event         string             `bson:"event" json:"event"`
+	Payload       string             `bson:"payload" json:"payload"`
+	Status        string             `bson:"status" json:"status"`
+	AttemptCount  int                `bson:"attempt_count" json:"attemptCount"`
+	Attempts      []Attempt          `bson:"attempts" json:"attempts"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at" json:"nextAttemptAt"`
+	CreatedAt     time.Time          `bson:"created_at" json:"createdAt"`
+}
+
+// Dispatcher хранит endpoints и очередь доставок в MongoDB и рассылает события
+type Dispatcher struct {
+	endpoints  *mongo.Collection
+	deliveries *mongo.Collection
+	events     []string
+	client     *http.Client
+}
+
+// NewDispatcher создает диспетчер для событий events в базе db
+func NewDispatcher(db *mongo.Database, events ...string) *Dispatcher {
+	return &Dispatcher{
+		endpoints:  db.Collection("webhook_endpoints"),
+		deliveries: db.Collection("webhook_deliveries"),
+		events:     events,
+		client:     newDeliveryClient(),
+	}
+}
+
+// EnsureIndexes создает индексы для выборки очереди
+func (d *Dispatcher) EnsureIndexes(ctx context.Context) error {
+	if _, err := d.endpoints.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "events", Value: 1}, {Key: "active", Value: 1}},
+	}); err != nil {
+		return err
+	}
+	_, err := d.deliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "endpoint_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	return err
+}
+
+// Supports сообщает, публикует ли диспетчер событие данного типа
+func (d *Dispatcher) Supports(event string) bool {
+	for _, e := range d.events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Register сохраняет endpoint и генерирует для него секрет подписи
+func (d *Dispatcher) Register(ctx context.Context, url string, events []string) (*Endpoint, error) {
+	if len(events) == 0 {
+		return nil, ErrUnknownEvent
+	}
+	for _, event := range events {
+		if !d.Supports(event) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownEvent, event)
+		}
+	}
+	if err := ValidateURL(ctx, url); err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	endpoint := &Endpoint{
+		URL:       url,
+		Secret:    "whsec_" + hex.EncodeToString(secret),
+		Events:    events,
+		Active:    true,
+		CreatedAt: time.Now(),
+	}
+	res, err := d.endpoints.InsertOne(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.ID = res.InsertedID.(primitive.ObjectID)
+	return endpoint, nil
+}
+
+// Enqueue ставит событие в очередь для всех активных подписчиков.
+// userID — пользователь, чьи данные несет событие: по нему доставки
+// выгружаются и удаляются по запросу о персональных данных.
+// Если ctx — mongo.SessionContext, событие попадет в очередь в той же
+// транзакции, что и изменение, которое его вызвало.
+func (d *Dispatcher) Enqueue(ctx context.Context, event, userID string, data interface{}) error {
+	cursor, err := d.endpoints.Find(ctx, bson.M{"events": event, "active": true})
+	if err != nil {
+		return err
+	}
+	var endpoints []Endpoint
+	if err := cursor.All(ctx, &endpoints); err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	eventID := primitive.NewObjectID().Hex()
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":         eventID,
+		"type":       event,
+		"created_at": now.UTC().Format(time.RFC3339),
+		"data":       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]interface{}, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		deliveries = append(deliveries, Delivery{
+			EndpointID:    endpoint.ID,
+			EventID:       eventID,
+			UserID:        userID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        StatusPending,
+			Attempts:      []Attempt{},
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	_, err = d.deliveries.InsertMany(ctx, deliveries)
+	return err
+}
+
+// Sign вычисляет значение заголовка SignatureHeader.
+// Партнер проверяет его, пересчитав HMAC-SHA256 от "<t>.<body>" своим секретом.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff — экспоненциальная задержка перед попыткой номер attempt (с 1)
+func backoff(attempt int) time.Duration {
+	delay := baseBackoff << uint(attempt-1)
+	if delay > maxBackoff || delay <= 0 {
+		return maxBackoff
+	}
+	return delay
+}
+
+// Run отправляет накопившиеся доставки каждые interval, пока ctx не отменен
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			delivery, err := d.claim(ctx)
+			if err == mongo.ErrNoDocuments {
+				break
+			}
+			if err != nil {
+				log.Println("Error claiming webhook delivery:", err)
+				break
+			}
+			if err := d.deliver(ctx, delivery); err != nil {
+				log.Printf("Error recording webhook delivery %s: %v", delivery.ID.Hex(), err)
+			}
+		}
+	}
+}
+
+// claim забирает одну готовую доставку и сдвигает ее срок на lease,
+// чтобы параллельные воркеры не отправили ее дважды
+func (d *Dispatcher) claim(ctx context.Context) (*Delivery, error) {
+	now := time.Now()
+	var delivery Delivery
+	err := d.deliveries.FindOneAndUpdate(ctx,
+		bson.M{"status": StatusPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}),
+	).Decode(&delivery)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *Delivery) error {
+	var endpoint Endpoint
+	if err := d.endpoints.FindOne(ctx, bson.M{"_id": delivery.EndpointID}).Decode(&endpoint); err != nil {
+		return err
+	}
+	if !endpoint.Active {
+		// Доставку можно будет повторить через Replay после включения endpoint
+		_, err := d.deliveries.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": bson.M{"status": StatusDead}})
+		return err
+	}
+
+	attempt := d.post(ctx, endpoint, delivery)
+	attemptCount := delivery.AttemptCount + 1
+
+	set := bson.M{}
+	if attempt.Error == "" {
+		set["status"] = StatusSucceeded
+	} else if attemptCount >= MaxAttempts {
+		set["status"] = StatusDead
+	} else {
+		set["next_attempt_at"] = time.Now().Add(backoff(attemptCount))
+	}
+	if _, err := d.deliveries.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{
+		"$set":  set,
+		"$inc":  bson.M{"attempt_count": 1},
+		"$push": bson.M{"attempts": attempt},
+	}); err != nil {
+		return err
+	}
+
+	if attempt.Error == "" {
+		_, err := d.endpoints.UpdateOne(ctx, bson.M{"_id": endpoint.ID}, bson.M{"$set": bson.M{"consecutive_failures": 0}})
+		return err
+	}
+
+	var updated Endpoint
+	err := d.endpoints.FindOneAndUpdate(ctx,
+		bson.M{"_id": endpoint.ID},
+		bson.M{"$inc": bson.M{"consecutive_failures": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		return err
+	}
+	if updated.Active && updated.ConsecutiveFailures >= DisableAfter {
+		log.Printf("Disabling webhook endpoint %s after %d failures", endpoint.URL, updated.ConsecutiveFailures)
+		_, err = d.endpoints.UpdateOne(ctx, bson.M{"_id": endpoint.ID}, bson.M{
+			"$set": bson.M{"active": false, "disabled_at": time.Now()},
+		})
+	}
+	return err
+}
+
+func (d *Dispatcher) post(ctx context.Context, endpoint Endpoint, delivery *Delivery) Attempt {
+	start := time.Now()
+	attempt := Attempt{At: start}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.DurationMS = time.Since(start).Milliseconds()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, start, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.DurationMS = time.Since(start).Milliseconds()
+		return attempt
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("endpoint responded %s", resp.Status)
+	}
+	attempt.DurationMS = time.Since(start).Milliseconds()
+	return attempt
+}
+
+// Replay заново ставит доставку в очередь, независимо от ее статуса.
+// История попыток сохраняется, счетчик попыток начинается заново.
+func (d *Dispatcher) Replay(ctx context.Context, deliveryID primitive.ObjectID) error {
+	res, err := d.deliveries.UpdateOne(ctx, bson.M{"_id": deliveryID}, bson.M{
+		"$set": bson.M{"status": StatusPending, "next_attempt_at": time.Now(), "attempt_count": 0},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// SetActive включает или отключает endpoint; включение сбрасывает счетчик ошибок
+func (d *Dispatcher) SetActive(ctx context.Context, endpointID primitive.ObjectID, active bool) error {
+	set := bson.M{"active": active}
+	update := bson.M{"$set": set}
+	if active {
+		set["consecutive_failures"] = 0
+		update["$unset"] = bson.M{"disabled_at": ""}
+	} else {
+		set["disabled_at"] = time.Now()
+	}
+
+	res, err := d.endpoints.UpdateOne(ctx, bson.M{"_id": endpointID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// userDeliveries — фильтр доставок с данными пользователя. Доставки,
+// поставленные в очередь до появления user_id, ищутся по его ID в payload.
+func userDeliveries(userID string) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"user_id": userID},
+		{"user_id": bson.M{"$exists": false}, "payload": bson.M{"$regex": regexp.QuoteMeta(`"` + userID + `"`)}},
+	}}
+}
+
+// UserDeliveries возвращает доставки с данными пользователя для выгрузки
+func (d *Dispatcher) UserDeliveries(ctx context.Context, userID string) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	if userID == "" {
+		return deliveries, nil
+	}
+	cursor, err := d.deliveries.Find(ctx, userDeliveries(userID), options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &deliveries)
+	return deliveries, err
+}
+
+// EraseUser удаляет доставки с данными пользователя, в том числе еще не
+// отправленные: после удаления данных партнеры их уже не получат
+func (d *Dispatcher) EraseUser(ctx context.Context, userID string) error {
+	if userID == "" {
+		return nil
+	}
+	_, err := d.deliveries.DeleteMany(ctx, userDeliveries(userID))
+	return err
+}
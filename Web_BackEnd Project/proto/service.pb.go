@@ -582,6 +582,211 @@ func (x *QuizResponse) GetQuiz() *Quiz {
 	return nil
 }
 
+// Assignments of the caller's classrooms; student_id is only for admins and API keys
+type ListAssignmentsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	StudentId     string                 `protobuf:"bytes,1,opt,name=student_id,json=studentId,proto3" json:"student_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAssignmentsRequest) Reset() {
+	*x = ListAssignmentsRequest{}
+	mi := &file_proto_service_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAssignmentsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAssignmentsRequest) ProtoMessage() {}
+
+func (x *ListAssignmentsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAssignmentsRequest.ProtoReflect.Descriptor instead.
+func (*ListAssignmentsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListAssignmentsRequest) GetStudentId() string {
+	if x != nil {
+		return x.StudentId
+	}
+	return ""
+}
+
+type ListAssignmentsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Assignments   []*Assignment          `protobuf:"bytes,1,rep,name=assignments,proto3" json:"assignments,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAssignmentsResponse) Reset() {
+	*x = ListAssignmentsResponse{}
+	mi := &file_proto_service_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAssignmentsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAssignmentsResponse) ProtoMessage() {}
+
+func (x *ListAssignmentsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAssignmentsResponse.ProtoReflect.Descriptor instead.
+func (*ListAssignmentsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListAssignmentsResponse) GetAssignments() []*Assignment {
+	if x != nil {
+		return x.Assignments
+	}
+	return nil
+}
+
+type Assignment struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	ClassroomId   string                 `protobuf:"bytes,2,opt,name=classroom_id,json=classroomId,proto3" json:"classroom_id,omitempty"`
+	ClassroomName string                 `protobuf:"bytes,3,opt,name=classroom_name,json=classroomName,proto3" json:"classroom_name,omitempty"`
+	QuizId        string                 `protobuf:"bytes,4,opt,name=quiz_id,json=quizId,proto3" json:"quiz_id,omitempty"`
+	Title         string                 `protobuf:"bytes,5,opt,name=title,proto3" json:"title,omitempty"`
+	DueAt         string                 `protobuf:"bytes,6,opt,name=due_at,json=dueAt,proto3" json:"due_at,omitempty"`
+	Submitted     bool                   `protobuf:"varint,7,opt,name=submitted,proto3" json:"submitted,omitempty"`
+	Score         int32                  `protobuf:"varint,8,opt,name=score,proto3" json:"score,omitempty"`
+	SubmittedAt   string                 `protobuf:"bytes,9,opt,name=submitted_at,json=submittedAt,proto3" json:"submitted_at,omitempty"`
+	Late          bool                   `protobuf:"varint,10,opt,name=late,proto3" json:"late,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Assignment) Reset() {
+	*x = Assignment{}
+	mi := &file_proto_service_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Assignment) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Assignment) ProtoMessage() {}
+
+func (x *Assignment) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Assignment.ProtoReflect.Descriptor instead.
+func (*Assignment) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *Assignment) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Assignment) GetClassroomId() string {
+	if x != nil {
+		return x.ClassroomId
+	}
+	return ""
+}
+
+func (x *Assignment) GetClassroomName() string {
+	if x != nil {
+		return x.ClassroomName
+	}
+	return ""
+}
+
+func (x *Assignment) GetQuizId() string {
+	if x != nil {
+		return x.QuizId
+	}
+	return ""
+}
+
+func (x *Assignment) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Assignment) GetDueAt() string {
+	if x != nil {
+		return x.DueAt
+	}
+	return ""
+}
+
+func (x *Assignment) GetSubmitted() bool {
+	if x != nil {
+		return x.Submitted
+	}
+	return false
+}
+
+func (x *Assignment) GetScore() int32 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *Assignment) GetSubmittedAt() string {
+	if x != nil {
+		return x.SubmittedAt
+	}
+	return ""
+}
+
+func (x *Assignment) GetLate() bool {
+	if x != nil {
+		return x.Late
+	}
+	return false
+}
+
 // Transaction Messages
 type CreateTransactionRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -595,7 +800,7 @@ type CreateTransactionRequest struct {
 
 func (x *CreateTransactionRequest) Reset() {
 	*x = CreateTransactionRequest{}
-	mi := &file_proto_service_proto_msgTypes[10]
+	mi := &file_proto_service_proto_msgTypes[13]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -607,7 +812,7 @@ func (x *CreateTransactionRequest) String() string {
 func (*CreateTransactionRequest) ProtoMessage() {}
 
 func (x *CreateTransactionRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[10]
+	mi := &file_proto_service_proto_msgTypes[13]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -620,7 +825,7 @@ func (x *CreateTransactionRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CreateTransactionRequest.ProtoReflect.Descriptor instead.
 func (*CreateTransactionRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{10}
+	return file_proto_service_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *CreateTransactionRequest) GetUserId() string {
@@ -660,7 +865,7 @@ type GetTransactionRequest struct {
 
 func (x *GetTransactionRequest) Reset() {
 	*x = GetTransactionRequest{}
-	mi := &file_proto_service_proto_msgTypes[11]
+	mi := &file_proto_service_proto_msgTypes[14]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -672,7 +877,7 @@ func (x *GetTransactionRequest) String() string {
 func (*GetTransactionRequest) ProtoMessage() {}
 
 func (x *GetTransactionRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[11]
+	mi := &file_proto_service_proto_msgTypes[14]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -685,7 +890,7 @@ func (x *GetTransactionRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetTransactionRequest.ProtoReflect.Descriptor instead.
 func (*GetTransactionRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{11}
+	return file_proto_service_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *GetTransactionRequest) GetId() string {
@@ -707,7 +912,7 @@ type UpdateTransactionRequest struct {
 
 func (x *UpdateTransactionRequest) Reset() {
 	*x = UpdateTransactionRequest{}
-	mi := &file_proto_service_proto_msgTypes[12]
+	mi := &file_proto_service_proto_msgTypes[15]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -719,7 +924,7 @@ func (x *UpdateTransactionRequest) String() string {
 func (*UpdateTransactionRequest) ProtoMessage() {}
 
 func (x *UpdateTransactionRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[12]
+	mi := &file_proto_service_proto_msgTypes[15]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -732,7 +937,7 @@ func (x *UpdateTransactionRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UpdateTransactionRequest.ProtoReflect.Descriptor instead.
 func (*UpdateTransactionRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{12}
+	return file_proto_service_proto_rawDescGZIP(), []int{15}
 }
 
 func (x *UpdateTransactionRequest) GetId() string {
@@ -772,7 +977,7 @@ type DeleteTransactionRequest struct {
 
 func (x *DeleteTransactionRequest) Reset() {
 	*x = DeleteTransactionRequest{}
-	mi := &file_proto_service_proto_msgTypes[13]
+	mi := &file_proto_service_proto_msgTypes[16]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -784,7 +989,7 @@ func (x *DeleteTransactionRequest) String() string {
 func (*DeleteTransactionRequest) ProtoMessage() {}
 
 func (x *DeleteTransactionRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[13]
+	mi := &file_proto_service_proto_msgTypes[16]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -797,7 +1002,7 @@ func (x *DeleteTransactionRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeleteTransactionRequest.ProtoReflect.Descriptor instead.
 func (*DeleteTransactionRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{13}
+	return file_proto_service_proto_rawDescGZIP(), []int{16}
 }
 
 func (x *DeleteTransactionRequest) GetId() string {
@@ -816,7 +1021,7 @@ type DeleteTransactionResponse struct {
 
 func (x *DeleteTransactionResponse) Reset() {
 	*x = DeleteTransactionResponse{}
-	mi := &file_proto_service_proto_msgTypes[14]
+	mi := &file_proto_service_proto_msgTypes[17]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -828,7 +1033,7 @@ func (x *DeleteTransactionResponse) String() string {
 func (*DeleteTransactionResponse) ProtoMessage() {}
 
 func (x *DeleteTransactionResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[14]
+	mi := &file_proto_service_proto_msgTypes[17]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -841,7 +1046,7 @@ func (x *DeleteTransactionResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeleteTransactionResponse.ProtoReflect.Descriptor instead.
 func (*DeleteTransactionResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{14}
+	return file_proto_service_proto_rawDescGZIP(), []int{17}
 }
 
 func (x *DeleteTransactionResponse) GetSuccess() bool {
@@ -862,7 +1067,7 @@ type ListTransactionsRequest struct {
 
 func (x *ListTransactionsRequest) Reset() {
 	*x = ListTransactionsRequest{}
-	mi := &file_proto_service_proto_msgTypes[15]
+	mi := &file_proto_service_proto_msgTypes[18]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -874,7 +1079,7 @@ func (x *ListTransactionsRequest) String() string {
 func (*ListTransactionsRequest) ProtoMessage() {}
 
 func (x *ListTransactionsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[15]
+	mi := &file_proto_service_proto_msgTypes[18]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -887,7 +1092,7 @@ func (x *ListTransactionsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ListTransactionsRequest.ProtoReflect.Descriptor instead.
 func (*ListTransactionsRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{15}
+	return file_proto_service_proto_rawDescGZIP(), []int{18}
 }
 
 func (x *ListTransactionsRequest) GetUserId() string {
@@ -921,7 +1126,7 @@ type ListTransactionsResponse struct {
 
 func (x *ListTransactionsResponse) Reset() {
 	*x = ListTransactionsResponse{}
-	mi := &file_proto_service_proto_msgTypes[16]
+	mi := &file_proto_service_proto_msgTypes[19]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -933,7 +1138,7 @@ func (x *ListTransactionsResponse) String() string {
 func (*ListTransactionsResponse) ProtoMessage() {}
 
 func (x *ListTransactionsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[16]
+	mi := &file_proto_service_proto_msgTypes[19]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -946,7 +1151,7 @@ func (x *ListTransactionsResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ListTransactionsResponse.ProtoReflect.Descriptor instead.
 func (*ListTransactionsResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{16}
+	return file_proto_service_proto_rawDescGZIP(), []int{19}
 }
 
 func (x *ListTransactionsResponse) GetTransactions() []*Transaction {
@@ -978,7 +1183,7 @@ type Transaction struct {
 
 func (x *Transaction) Reset() {
 	*x = Transaction{}
-	mi := &file_proto_service_proto_msgTypes[17]
+	mi := &file_proto_service_proto_msgTypes[20]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -990,7 +1195,7 @@ func (x *Transaction) String() string {
 func (*Transaction) ProtoMessage() {}
 
 func (x *Transaction) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[17]
+	mi := &file_proto_service_proto_msgTypes[20]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1003,7 +1208,7 @@ func (x *Transaction) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Transaction.ProtoReflect.Descriptor instead.
 func (*Transaction) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{17}
+	return file_proto_service_proto_rawDescGZIP(), []int{20}
 }
 
 func (x *Transaction) GetId() string {
@@ -1064,7 +1269,7 @@ type TransactionResponse struct {
 
 func (x *TransactionResponse) Reset() {
 	*x = TransactionResponse{}
-	mi := &file_proto_service_proto_msgTypes[18]
+	mi := &file_proto_service_proto_msgTypes[21]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1076,7 +1281,7 @@ func (x *TransactionResponse) String() string {
 func (*TransactionResponse) ProtoMessage() {}
 
 func (x *TransactionResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[18]
+	mi := &file_proto_service_proto_msgTypes[21]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1089,7 +1294,7 @@ func (x *TransactionResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use TransactionResponse.ProtoReflect.Descriptor instead.
 func (*TransactionResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{18}
+	return file_proto_service_proto_rawDescGZIP(), []int{21}
 }
 
 func (x *TransactionResponse) GetTransaction() *Transaction {
@@ -1099,33 +1304,29 @@ func (x *TransactionResponse) GetTransaction() *Transaction {
 	return nil
 }
 
-// User Messages
-type CreateUserRequest struct {
+type HasEntitlementRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
-	Email         string                 `protobuf:"bytes,2,opt,name=email,proto3" json:"email,omitempty"`
-	Password      string                 `protobuf:"bytes,3,opt,name=password,proto3" json:"password,omitempty"`
-	FirstName     string                 `protobuf:"bytes,4,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
-	LastName      string                 `protobuf:"bytes,5,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Entitlement   string                 `protobuf:"bytes,2,opt,name=entitlement,proto3" json:"entitlement,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *CreateUserRequest) Reset() {
-	*x = CreateUserRequest{}
-	mi := &file_proto_service_proto_msgTypes[19]
+func (x *HasEntitlementRequest) Reset() {
+	*x = HasEntitlementRequest{}
+	mi := &file_proto_service_proto_msgTypes[22]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *CreateUserRequest) String() string {
+func (x *HasEntitlementRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*CreateUserRequest) ProtoMessage() {}
+func (*HasEntitlementRequest) ProtoMessage() {}
 
-func (x *CreateUserRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[19]
+func (x *HasEntitlementRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[22]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1136,56 +1337,180 @@ func (x *CreateUserRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use CreateUserRequest.ProtoReflect.Descriptor instead.
-func (*CreateUserRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{19}
+// Deprecated: Use HasEntitlementRequest.ProtoReflect.Descriptor instead.
+func (*HasEntitlementRequest) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{22}
 }
 
-func (x *CreateUserRequest) GetUsername() string {
+func (x *HasEntitlementRequest) GetUserId() string {
 	if x != nil {
-		return x.Username
+		return x.UserId
 	}
 	return ""
 }
 
-func (x *CreateUserRequest) GetEmail() string {
+func (x *HasEntitlementRequest) GetEntitlement() string {
 	if x != nil {
-		return x.Email
+		return x.Entitlement
 	}
 	return ""
 }
 
-func (x *CreateUserRequest) GetPassword() string {
-	if x != nil {
-		return x.Password
-	}
-	return ""
+type HasEntitlementResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	HasEntitlement bool                   `protobuf:"varint,1,opt,name=has_entitlement,json=hasEntitlement,proto3" json:"has_entitlement,omitempty"`
+	ExpiresAt      string                 `protobuf:"bytes,2,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
 }
 
-func (x *CreateUserRequest) GetFirstName() string {
-	if x != nil {
-		return x.FirstName
-	}
-	return ""
+func (x *HasEntitlementResponse) Reset() {
+	*x = HasEntitlementResponse{}
+	mi := &file_proto_service_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
-func (x *CreateUserRequest) GetLastName() string {
-	if x != nil {
-		return x.LastName
-	}
-	return ""
+func (x *HasEntitlementResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-type GetUserRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
-}
+func (*HasEntitlementResponse) ProtoMessage() {}
 
-func (x *GetUserRequest) Reset() {
+func (x *HasEntitlementResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HasEntitlementResponse.ProtoReflect.Descriptor instead.
+func (*HasEntitlementResponse) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *HasEntitlementResponse) GetHasEntitlement() bool {
+	if x != nil {
+		return x.HasEntitlement
+	}
+	return false
+}
+
+func (x *HasEntitlementResponse) GetExpiresAt() string {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return ""
+}
+
+// User Messages
+type CreateUserRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Email         string                 `protobuf:"bytes,2,opt,name=email,proto3" json:"email,omitempty"`
+	Password      string                 `protobuf:"bytes,3,opt,name=password,proto3" json:"password,omitempty"`
+	FirstName     string                 `protobuf:"bytes,4,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
+	LastName      string                 `protobuf:"bytes,5,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
+	Age           int32                  `protobuf:"varint,6,opt,name=age,proto3" json:"age,omitempty"`
+	Gender        string                 `protobuf:"bytes,7,opt,name=gender,proto3" json:"gender,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateUserRequest) Reset() {
+	*x = CreateUserRequest{}
+	mi := &file_proto_service_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateUserRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateUserRequest) ProtoMessage() {}
+
+func (x *CreateUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateUserRequest.ProtoReflect.Descriptor instead.
+func (*CreateUserRequest) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *CreateUserRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *CreateUserRequest) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+func (x *CreateUserRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *CreateUserRequest) GetFirstName() string {
+	if x != nil {
+		return x.FirstName
+	}
+	return ""
+}
+
+func (x *CreateUserRequest) GetLastName() string {
+	if x != nil {
+		return x.LastName
+	}
+	return ""
+}
+
+func (x *CreateUserRequest) GetAge() int32 {
+	if x != nil {
+		return x.Age
+	}
+	return 0
+}
+
+func (x *CreateUserRequest) GetGender() string {
+	if x != nil {
+		return x.Gender
+	}
+	return ""
+}
+
+type GetUserRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetUserRequest) Reset() {
 	*x = GetUserRequest{}
-	mi := &file_proto_service_proto_msgTypes[20]
+	mi := &file_proto_service_proto_msgTypes[25]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1197,7 +1522,7 @@ func (x *GetUserRequest) String() string {
 func (*GetUserRequest) ProtoMessage() {}
 
 func (x *GetUserRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[20]
+	mi := &file_proto_service_proto_msgTypes[25]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1210,7 +1535,7 @@ func (x *GetUserRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetUserRequest.ProtoReflect.Descriptor instead.
 func (*GetUserRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{20}
+	return file_proto_service_proto_rawDescGZIP(), []int{25}
 }
 
 func (x *GetUserRequest) GetId() string {
@@ -1221,19 +1546,23 @@ func (x *GetUserRequest) GetId() string {
 }
 
 type UpdateUserRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
-	Email         string                 `protobuf:"bytes,3,opt,name=email,proto3" json:"email,omitempty"`
-	FirstName     string                 `protobuf:"bytes,4,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
-	LastName      string                 `protobuf:"bytes,5,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Id        string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Username  string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Email     string                 `protobuf:"bytes,3,opt,name=email,proto3" json:"email,omitempty"`
+	FirstName string                 `protobuf:"bytes,4,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
+	LastName  string                 `protobuf:"bytes,5,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
+	Age       int32                  `protobuf:"varint,6,opt,name=age,proto3" json:"age,omitempty"`
+	Gender    string                 `protobuf:"bytes,7,opt,name=gender,proto3" json:"gender,omitempty"`
+	// Empty keeps the current password
+	Password      string `protobuf:"bytes,8,opt,name=password,proto3" json:"password,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *UpdateUserRequest) Reset() {
 	*x = UpdateUserRequest{}
-	mi := &file_proto_service_proto_msgTypes[21]
+	mi := &file_proto_service_proto_msgTypes[26]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1245,7 +1574,7 @@ func (x *UpdateUserRequest) String() string {
 func (*UpdateUserRequest) ProtoMessage() {}
 
 func (x *UpdateUserRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[21]
+	mi := &file_proto_service_proto_msgTypes[26]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1258,7 +1587,7 @@ func (x *UpdateUserRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UpdateUserRequest.ProtoReflect.Descriptor instead.
 func (*UpdateUserRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{21}
+	return file_proto_service_proto_rawDescGZIP(), []int{26}
 }
 
 func (x *UpdateUserRequest) GetId() string {
@@ -1296,6 +1625,27 @@ func (x *UpdateUserRequest) GetLastName() string {
 	return ""
 }
 
+func (x *UpdateUserRequest) GetAge() int32 {
+	if x != nil {
+		return x.Age
+	}
+	return 0
+}
+
+func (x *UpdateUserRequest) GetGender() string {
+	if x != nil {
+		return x.Gender
+	}
+	return ""
+}
+
+func (x *UpdateUserRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
 type DeleteUserRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
@@ -1305,7 +1655,7 @@ type DeleteUserRequest struct {
 
 func (x *DeleteUserRequest) Reset() {
 	*x = DeleteUserRequest{}
-	mi := &file_proto_service_proto_msgTypes[22]
+	mi := &file_proto_service_proto_msgTypes[27]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1317,7 +1667,7 @@ func (x *DeleteUserRequest) String() string {
 func (*DeleteUserRequest) ProtoMessage() {}
 
 func (x *DeleteUserRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[22]
+	mi := &file_proto_service_proto_msgTypes[27]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1330,7 +1680,7 @@ func (x *DeleteUserRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeleteUserRequest.ProtoReflect.Descriptor instead.
 func (*DeleteUserRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{22}
+	return file_proto_service_proto_rawDescGZIP(), []int{27}
 }
 
 func (x *DeleteUserRequest) GetId() string {
@@ -1349,7 +1699,7 @@ type DeleteUserResponse struct {
 
 func (x *DeleteUserResponse) Reset() {
 	*x = DeleteUserResponse{}
-	mi := &file_proto_service_proto_msgTypes[23]
+	mi := &file_proto_service_proto_msgTypes[28]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1361,7 +1711,7 @@ func (x *DeleteUserResponse) String() string {
 func (*DeleteUserResponse) ProtoMessage() {}
 
 func (x *DeleteUserResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[23]
+	mi := &file_proto_service_proto_msgTypes[28]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1374,7 +1724,7 @@ func (x *DeleteUserResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeleteUserResponse.ProtoReflect.Descriptor instead.
 func (*DeleteUserResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{23}
+	return file_proto_service_proto_rawDescGZIP(), []int{28}
 }
 
 func (x *DeleteUserResponse) GetSuccess() bool {
@@ -1397,7 +1747,7 @@ type ListUsersRequest struct {
 
 func (x *ListUsersRequest) Reset() {
 	*x = ListUsersRequest{}
-	mi := &file_proto_service_proto_msgTypes[24]
+	mi := &file_proto_service_proto_msgTypes[29]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1409,7 +1759,7 @@ func (x *ListUsersRequest) String() string {
 func (*ListUsersRequest) ProtoMessage() {}
 
 func (x *ListUsersRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[24]
+	mi := &file_proto_service_proto_msgTypes[29]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1422,7 +1772,7 @@ func (x *ListUsersRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ListUsersRequest.ProtoReflect.Descriptor instead.
 func (*ListUsersRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{24}
+	return file_proto_service_proto_rawDescGZIP(), []int{29}
 }
 
 func (x *ListUsersRequest) GetPage() int32 {
@@ -1443,46 +1793,270 @@ func (x *ListUsersRequest) GetFilter() string {
 	if x != nil {
 		return x.Filter
 	}
-	return ""
+	return ""
+}
+
+func (x *ListUsersRequest) GetSortBy() string {
+	if x != nil {
+		return x.SortBy
+	}
+	return ""
+}
+
+func (x *ListUsersRequest) GetSortOrder() string {
+	if x != nil {
+		return x.SortOrder
+	}
+	return ""
+}
+
+type ListUsersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Users         []*User                `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	Total         int32                  `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUsersResponse) Reset() {
+	*x = ListUsersResponse{}
+	mi := &file_proto_service_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUsersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUsersResponse) ProtoMessage() {}
+
+func (x *ListUsersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUsersResponse.ProtoReflect.Descriptor instead.
+func (*ListUsersResponse) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *ListUsersResponse) GetUsers() []*User {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+func (x *ListUsersResponse) GetTotal() int32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+type User struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Email         string                 `protobuf:"bytes,3,opt,name=email,proto3" json:"email,omitempty"`
+	FirstName     string                 `protobuf:"bytes,4,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
+	LastName      string                 `protobuf:"bytes,5,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
+	CreatedAt     string                 `protobuf:"bytes,6,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt     string                 `protobuf:"bytes,7,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	Role          string                 `protobuf:"bytes,8,opt,name=role,proto3" json:"role,omitempty"`
+	Age           int32                  `protobuf:"varint,9,opt,name=age,proto3" json:"age,omitempty"`
+	Gender        string                 `protobuf:"bytes,10,opt,name=gender,proto3" json:"gender,omitempty"`
+	IsVerified    bool                   `protobuf:"varint,11,opt,name=is_verified,json=isVerified,proto3" json:"is_verified,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *User) Reset() {
+	*x = User{}
+	mi := &file_proto_service_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *User) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*User) ProtoMessage() {}
+
+func (x *User) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use User.ProtoReflect.Descriptor instead.
+func (*User) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *User) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *User) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *User) GetEmail() string {
+	if x != nil {
+		return x.Email
+	}
+	return ""
+}
+
+func (x *User) GetFirstName() string {
+	if x != nil {
+		return x.FirstName
+	}
+	return ""
+}
+
+func (x *User) GetLastName() string {
+	if x != nil {
+		return x.LastName
+	}
+	return ""
+}
+
+func (x *User) GetCreatedAt() string {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return ""
+}
+
+func (x *User) GetUpdatedAt() string {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return ""
+}
+
+func (x *User) GetRole() string {
+	if x != nil {
+		return x.Role
+	}
+	return ""
+}
+
+func (x *User) GetAge() int32 {
+	if x != nil {
+		return x.Age
+	}
+	return 0
+}
+
+func (x *User) GetGender() string {
+	if x != nil {
+		return x.Gender
+	}
+	return ""
+}
+
+func (x *User) GetIsVerified() bool {
+	if x != nil {
+		return x.IsVerified
+	}
+	return false
+}
+
+type UserResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          *User                  `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UserResponse) Reset() {
+	*x = UserResponse{}
+	mi := &file_proto_service_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserResponse) ProtoMessage() {}
+
+func (x *UserResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-func (x *ListUsersRequest) GetSortBy() string {
-	if x != nil {
-		return x.SortBy
-	}
-	return ""
+// Deprecated: Use UserResponse.ProtoReflect.Descriptor instead.
+func (*UserResponse) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{32}
 }
 
-func (x *ListUsersRequest) GetSortOrder() string {
+func (x *UserResponse) GetUser() *User {
 	if x != nil {
-		return x.SortOrder
+		return x.User
 	}
-	return ""
+	return nil
 }
 
-type ListUsersResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Users         []*User                `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
-	Total         int32                  `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+type AuthenticateUserRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Username string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Password string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	// Второй шаг входа с 2FA: challenge из первого ответа и TOTP-код
+	// или код восстановления
+	Challenge     string `protobuf:"bytes,3,opt,name=challenge,proto3" json:"challenge,omitempty"`
+	Code          string `protobuf:"bytes,4,opt,name=code,proto3" json:"code,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *ListUsersResponse) Reset() {
-	*x = ListUsersResponse{}
-	mi := &file_proto_service_proto_msgTypes[25]
+func (x *AuthenticateUserRequest) Reset() {
+	*x = AuthenticateUserRequest{}
+	mi := &file_proto_service_proto_msgTypes[33]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *ListUsersResponse) String() string {
+func (x *AuthenticateUserRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ListUsersResponse) ProtoMessage() {}
+func (*AuthenticateUserRequest) ProtoMessage() {}
 
-func (x *ListUsersResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[25]
+func (x *AuthenticateUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[33]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1493,53 +2067,68 @@ func (x *ListUsersResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ListUsersResponse.ProtoReflect.Descriptor instead.
-func (*ListUsersResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{25}
+// Deprecated: Use AuthenticateUserRequest.ProtoReflect.Descriptor instead.
+func (*AuthenticateUserRequest) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{33}
 }
 
-func (x *ListUsersResponse) GetUsers() []*User {
+func (x *AuthenticateUserRequest) GetUsername() string {
 	if x != nil {
-		return x.Users
+		return x.Username
 	}
-	return nil
+	return ""
 }
 
-func (x *ListUsersResponse) GetTotal() int32 {
+func (x *AuthenticateUserRequest) GetPassword() string {
 	if x != nil {
-		return x.Total
+		return x.Password
 	}
-	return 0
+	return ""
 }
 
-type User struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
-	Email         string                 `protobuf:"bytes,3,opt,name=email,proto3" json:"email,omitempty"`
-	FirstName     string                 `protobuf:"bytes,4,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
-	LastName      string                 `protobuf:"bytes,5,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
-	CreatedAt     string                 `protobuf:"bytes,6,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
-	UpdatedAt     string                 `protobuf:"bytes,7,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+func (x *AuthenticateUserRequest) GetChallenge() string {
+	if x != nil {
+		return x.Challenge
+	}
+	return ""
 }
 
-func (x *User) Reset() {
-	*x = User{}
-	mi := &file_proto_service_proto_msgTypes[26]
+func (x *AuthenticateUserRequest) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+type AuthenticateUserResponse struct {
+	state                  protoimpl.MessageState `protogen:"open.v1"`
+	Token                  string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	User                   *User                  `protobuf:"bytes,2,opt,name=user,proto3" json:"user,omitempty"`
+	RefreshToken           string                 `protobuf:"bytes,3,opt,name=refresh_token,json=refreshToken,proto3" json:"refresh_token,omitempty"`
+	ExpiresAt              string                 `protobuf:"bytes,4,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	SessionId              string                 `protobuf:"bytes,5,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	SecondFactorRequired   bool                   `protobuf:"varint,6,opt,name=second_factor_required,json=secondFactorRequired,proto3" json:"second_factor_required,omitempty"`
+	Challenge              string                 `protobuf:"bytes,7,opt,name=challenge,proto3" json:"challenge,omitempty"`
+	TwoFactorSetupRequired bool                   `protobuf:"varint,8,opt,name=two_factor_setup_required,json=twoFactorSetupRequired,proto3" json:"two_factor_setup_required,omitempty"`
+	unknownFields          protoimpl.UnknownFields
+	sizeCache              protoimpl.SizeCache
+}
+
+func (x *AuthenticateUserResponse) Reset() {
+	*x = AuthenticateUserResponse{}
+	mi := &file_proto_service_proto_msgTypes[34]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *User) String() string {
+func (x *AuthenticateUserResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*User) ProtoMessage() {}
+func (*AuthenticateUserResponse) ProtoMessage() {}
 
-func (x *User) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[26]
+func (x *AuthenticateUserResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[34]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1550,82 +2139,89 @@ func (x *User) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use User.ProtoReflect.Descriptor instead.
-func (*User) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{26}
+// Deprecated: Use AuthenticateUserResponse.ProtoReflect.Descriptor instead.
+func (*AuthenticateUserResponse) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{34}
 }
 
-func (x *User) GetId() string {
+func (x *AuthenticateUserResponse) GetToken() string {
 	if x != nil {
-		return x.Id
+		return x.Token
 	}
 	return ""
 }
 
-func (x *User) GetUsername() string {
+func (x *AuthenticateUserResponse) GetUser() *User {
 	if x != nil {
-		return x.Username
+		return x.User
 	}
-	return ""
+	return nil
 }
 
-func (x *User) GetEmail() string {
+func (x *AuthenticateUserResponse) GetRefreshToken() string {
 	if x != nil {
-		return x.Email
+		return x.RefreshToken
 	}
 	return ""
 }
 
-func (x *User) GetFirstName() string {
+func (x *AuthenticateUserResponse) GetExpiresAt() string {
 	if x != nil {
-		return x.FirstName
+		return x.ExpiresAt
 	}
 	return ""
 }
 
-func (x *User) GetLastName() string {
+func (x *AuthenticateUserResponse) GetSessionId() string {
 	if x != nil {
-		return x.LastName
+		return x.SessionId
 	}
 	return ""
 }
 
-func (x *User) GetCreatedAt() string {
+func (x *AuthenticateUserResponse) GetSecondFactorRequired() bool {
 	if x != nil {
-		return x.CreatedAt
+		return x.SecondFactorRequired
 	}
-	return ""
+	return false
 }
 
-func (x *User) GetUpdatedAt() string {
+func (x *AuthenticateUserResponse) GetChallenge() string {
 	if x != nil {
-		return x.UpdatedAt
+		return x.Challenge
 	}
 	return ""
 }
 
-type UserResponse struct {
+func (x *AuthenticateUserResponse) GetTwoFactorSetupRequired() bool {
+	if x != nil {
+		return x.TwoFactorSetupRequired
+	}
+	return false
+}
+
+type RefreshTokenRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	User          *User                  `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	RefreshToken  string                 `protobuf:"bytes,1,opt,name=refresh_token,json=refreshToken,proto3" json:"refresh_token,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *UserResponse) Reset() {
-	*x = UserResponse{}
-	mi := &file_proto_service_proto_msgTypes[27]
+func (x *RefreshTokenRequest) Reset() {
+	*x = RefreshTokenRequest{}
+	mi := &file_proto_service_proto_msgTypes[35]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *UserResponse) String() string {
+func (x *RefreshTokenRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UserResponse) ProtoMessage() {}
+func (*RefreshTokenRequest) ProtoMessage() {}
 
-func (x *UserResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[27]
+func (x *RefreshTokenRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[35]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1636,41 +2232,42 @@ func (x *UserResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UserResponse.ProtoReflect.Descriptor instead.
-func (*UserResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{27}
+// Deprecated: Use RefreshTokenRequest.ProtoReflect.Descriptor instead.
+func (*RefreshTokenRequest) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{35}
 }
 
-func (x *UserResponse) GetUser() *User {
+func (x *RefreshTokenRequest) GetRefreshToken() string {
 	if x != nil {
-		return x.User
+		return x.RefreshToken
 	}
-	return nil
+	return ""
 }
 
-type AuthenticateUserRequest struct {
+// Имперсонация: токен от имени пользователя без refresh-токена
+type ImpersonateUserRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
-	Password      string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *AuthenticateUserRequest) Reset() {
-	*x = AuthenticateUserRequest{}
-	mi := &file_proto_service_proto_msgTypes[28]
+func (x *ImpersonateUserRequest) Reset() {
+	*x = ImpersonateUserRequest{}
+	mi := &file_proto_service_proto_msgTypes[36]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *AuthenticateUserRequest) String() string {
+func (x *ImpersonateUserRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*AuthenticateUserRequest) ProtoMessage() {}
+func (*ImpersonateUserRequest) ProtoMessage() {}
 
-func (x *AuthenticateUserRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[28]
+func (x *ImpersonateUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[36]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1681,48 +2278,47 @@ func (x *AuthenticateUserRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use AuthenticateUserRequest.ProtoReflect.Descriptor instead.
-func (*AuthenticateUserRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{28}
+// Deprecated: Use ImpersonateUserRequest.ProtoReflect.Descriptor instead.
+func (*ImpersonateUserRequest) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{36}
 }
 
-func (x *AuthenticateUserRequest) GetUsername() string {
+func (x *ImpersonateUserRequest) GetUserId() string {
 	if x != nil {
-		return x.Username
+		return x.UserId
 	}
 	return ""
 }
 
-func (x *AuthenticateUserRequest) GetPassword() string {
+func (x *ImpersonateUserRequest) GetReason() string {
 	if x != nil {
-		return x.Password
+		return x.Reason
 	}
 	return ""
 }
 
-type AuthenticateUserResponse struct {
+// Завершает имперсонацию токена из метаданных authorization
+type EndImpersonationRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Token         string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
-	User          *User                  `protobuf:"bytes,2,opt,name=user,proto3" json:"user,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *AuthenticateUserResponse) Reset() {
-	*x = AuthenticateUserResponse{}
-	mi := &file_proto_service_proto_msgTypes[29]
+func (x *EndImpersonationRequest) Reset() {
+	*x = EndImpersonationRequest{}
+	mi := &file_proto_service_proto_msgTypes[37]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *AuthenticateUserResponse) String() string {
+func (x *EndImpersonationRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*AuthenticateUserResponse) ProtoMessage() {}
+func (*EndImpersonationRequest) ProtoMessage() {}
 
-func (x *AuthenticateUserResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[29]
+func (x *EndImpersonationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[37]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1733,23 +2329,53 @@ func (x *AuthenticateUserResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use AuthenticateUserResponse.ProtoReflect.Descriptor instead.
-func (*AuthenticateUserResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{29}
+// Deprecated: Use EndImpersonationRequest.ProtoReflect.Descriptor instead.
+func (*EndImpersonationRequest) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{37}
 }
 
-func (x *AuthenticateUserResponse) GetToken() string {
+type EndImpersonationResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EndImpersonationResponse) Reset() {
+	*x = EndImpersonationResponse{}
+	mi := &file_proto_service_proto_msgTypes[38]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EndImpersonationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EndImpersonationResponse) ProtoMessage() {}
+
+func (x *EndImpersonationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_service_proto_msgTypes[38]
 	if x != nil {
-		return x.Token
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
 	}
-	return ""
+	return mi.MessageOf(x)
 }
 
-func (x *AuthenticateUserResponse) GetUser() *User {
+// Deprecated: Use EndImpersonationResponse.ProtoReflect.Descriptor instead.
+func (*EndImpersonationResponse) Descriptor() ([]byte, []int) {
+	return file_proto_service_proto_rawDescGZIP(), []int{38}
+}
+
+func (x *EndImpersonationResponse) GetSuccess() bool {
 	if x != nil {
-		return x.User
+		return x.Success
 	}
-	return nil
+	return false
 }
 
 // Notification Messages
@@ -1765,7 +2391,7 @@ type SendEmailRequest struct {
 
 func (x *SendEmailRequest) Reset() {
 	*x = SendEmailRequest{}
-	mi := &file_proto_service_proto_msgTypes[30]
+	mi := &file_proto_service_proto_msgTypes[39]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1777,7 +2403,7 @@ func (x *SendEmailRequest) String() string {
 func (*SendEmailRequest) ProtoMessage() {}
 
 func (x *SendEmailRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[30]
+	mi := &file_proto_service_proto_msgTypes[39]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1790,7 +2416,7 @@ func (x *SendEmailRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SendEmailRequest.ProtoReflect.Descriptor instead.
 func (*SendEmailRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{30}
+	return file_proto_service_proto_rawDescGZIP(), []int{39}
 }
 
 func (x *SendEmailRequest) GetTo() string {
@@ -1831,7 +2457,7 @@ type SendEmailResponse struct {
 
 func (x *SendEmailResponse) Reset() {
 	*x = SendEmailResponse{}
-	mi := &file_proto_service_proto_msgTypes[31]
+	mi := &file_proto_service_proto_msgTypes[40]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1843,7 +2469,7 @@ func (x *SendEmailResponse) String() string {
 func (*SendEmailResponse) ProtoMessage() {}
 
 func (x *SendEmailResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[31]
+	mi := &file_proto_service_proto_msgTypes[40]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1856,7 +2482,7 @@ func (x *SendEmailResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SendEmailResponse.ProtoReflect.Descriptor instead.
 func (*SendEmailResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{31}
+	return file_proto_service_proto_rawDescGZIP(), []int{40}
 }
 
 func (x *SendEmailResponse) GetSuccess() bool {
@@ -1885,7 +2511,7 @@ type SendNotificationRequest struct {
 
 func (x *SendNotificationRequest) Reset() {
 	*x = SendNotificationRequest{}
-	mi := &file_proto_service_proto_msgTypes[32]
+	mi := &file_proto_service_proto_msgTypes[41]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1897,7 +2523,7 @@ func (x *SendNotificationRequest) String() string {
 func (*SendNotificationRequest) ProtoMessage() {}
 
 func (x *SendNotificationRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[32]
+	mi := &file_proto_service_proto_msgTypes[41]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1910,7 +2536,7 @@ func (x *SendNotificationRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SendNotificationRequest.ProtoReflect.Descriptor instead.
 func (*SendNotificationRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{32}
+	return file_proto_service_proto_rawDescGZIP(), []int{41}
 }
 
 func (x *SendNotificationRequest) GetUserId() string {
@@ -1951,7 +2577,7 @@ type SendNotificationResponse struct {
 
 func (x *SendNotificationResponse) Reset() {
 	*x = SendNotificationResponse{}
-	mi := &file_proto_service_proto_msgTypes[33]
+	mi := &file_proto_service_proto_msgTypes[42]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1963,7 +2589,7 @@ func (x *SendNotificationResponse) String() string {
 func (*SendNotificationResponse) ProtoMessage() {}
 
 func (x *SendNotificationResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[33]
+	mi := &file_proto_service_proto_msgTypes[42]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1976,7 +2602,7 @@ func (x *SendNotificationResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SendNotificationResponse.ProtoReflect.Descriptor instead.
 func (*SendNotificationResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{33}
+	return file_proto_service_proto_rawDescGZIP(), []int{42}
 }
 
 func (x *SendNotificationResponse) GetSuccess() bool {
@@ -2004,7 +2630,7 @@ type GetNotificationsRequest struct {
 
 func (x *GetNotificationsRequest) Reset() {
 	*x = GetNotificationsRequest{}
-	mi := &file_proto_service_proto_msgTypes[34]
+	mi := &file_proto_service_proto_msgTypes[43]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2016,7 +2642,7 @@ func (x *GetNotificationsRequest) String() string {
 func (*GetNotificationsRequest) ProtoMessage() {}
 
 func (x *GetNotificationsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[34]
+	mi := &file_proto_service_proto_msgTypes[43]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2029,7 +2655,7 @@ func (x *GetNotificationsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetNotificationsRequest.ProtoReflect.Descriptor instead.
 func (*GetNotificationsRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{34}
+	return file_proto_service_proto_rawDescGZIP(), []int{43}
 }
 
 func (x *GetNotificationsRequest) GetUserId() string {
@@ -2063,7 +2689,7 @@ type GetNotificationsResponse struct {
 
 func (x *GetNotificationsResponse) Reset() {
 	*x = GetNotificationsResponse{}
-	mi := &file_proto_service_proto_msgTypes[35]
+	mi := &file_proto_service_proto_msgTypes[44]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2075,7 +2701,7 @@ func (x *GetNotificationsResponse) String() string {
 func (*GetNotificationsResponse) ProtoMessage() {}
 
 func (x *GetNotificationsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[35]
+	mi := &file_proto_service_proto_msgTypes[44]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2088,7 +2714,7 @@ func (x *GetNotificationsResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetNotificationsResponse.ProtoReflect.Descriptor instead.
 func (*GetNotificationsResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{35}
+	return file_proto_service_proto_rawDescGZIP(), []int{44}
 }
 
 func (x *GetNotificationsResponse) GetNotifications() []*Notification {
@@ -2120,7 +2746,7 @@ type Notification struct {
 
 func (x *Notification) Reset() {
 	*x = Notification{}
-	mi := &file_proto_service_proto_msgTypes[36]
+	mi := &file_proto_service_proto_msgTypes[45]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2132,7 +2758,7 @@ func (x *Notification) String() string {
 func (*Notification) ProtoMessage() {}
 
 func (x *Notification) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[36]
+	mi := &file_proto_service_proto_msgTypes[45]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2145,7 +2771,7 @@ func (x *Notification) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Notification.ProtoReflect.Descriptor instead.
 func (*Notification) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{36}
+	return file_proto_service_proto_rawDescGZIP(), []int{45}
 }
 
 func (x *Notification) GetId() string {
@@ -2206,7 +2832,7 @@ type MarkNotificationAsReadRequest struct {
 
 func (x *MarkNotificationAsReadRequest) Reset() {
 	*x = MarkNotificationAsReadRequest{}
-	mi := &file_proto_service_proto_msgTypes[37]
+	mi := &file_proto_service_proto_msgTypes[46]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2218,7 +2844,7 @@ func (x *MarkNotificationAsReadRequest) String() string {
 func (*MarkNotificationAsReadRequest) ProtoMessage() {}
 
 func (x *MarkNotificationAsReadRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[37]
+	mi := &file_proto_service_proto_msgTypes[46]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2231,7 +2857,7 @@ func (x *MarkNotificationAsReadRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MarkNotificationAsReadRequest.ProtoReflect.Descriptor instead.
 func (*MarkNotificationAsReadRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{37}
+	return file_proto_service_proto_rawDescGZIP(), []int{46}
 }
 
 func (x *MarkNotificationAsReadRequest) GetNotificationId() string {
@@ -2250,7 +2876,7 @@ type MarkNotificationAsReadResponse struct {
 
 func (x *MarkNotificationAsReadResponse) Reset() {
 	*x = MarkNotificationAsReadResponse{}
-	mi := &file_proto_service_proto_msgTypes[38]
+	mi := &file_proto_service_proto_msgTypes[47]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2262,7 +2888,7 @@ func (x *MarkNotificationAsReadResponse) String() string {
 func (*MarkNotificationAsReadResponse) ProtoMessage() {}
 
 func (x *MarkNotificationAsReadResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[38]
+	mi := &file_proto_service_proto_msgTypes[47]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2275,7 +2901,7 @@ func (x *MarkNotificationAsReadResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MarkNotificationAsReadResponse.ProtoReflect.Descriptor instead.
 func (*MarkNotificationAsReadResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{38}
+	return file_proto_service_proto_rawDescGZIP(), []int{47}
 }
 
 func (x *MarkNotificationAsReadResponse) GetSuccess() bool {
@@ -2294,7 +2920,7 @@ type DeleteNotificationRequest struct {
 
 func (x *DeleteNotificationRequest) Reset() {
 	*x = DeleteNotificationRequest{}
-	mi := &file_proto_service_proto_msgTypes[39]
+	mi := &file_proto_service_proto_msgTypes[48]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2306,7 +2932,7 @@ func (x *DeleteNotificationRequest) String() string {
 func (*DeleteNotificationRequest) ProtoMessage() {}
 
 func (x *DeleteNotificationRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[39]
+	mi := &file_proto_service_proto_msgTypes[48]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2319,7 +2945,7 @@ func (x *DeleteNotificationRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeleteNotificationRequest.ProtoReflect.Descriptor instead.
 func (*DeleteNotificationRequest) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{39}
+	return file_proto_service_proto_rawDescGZIP(), []int{48}
 }
 
 func (x *DeleteNotificationRequest) GetNotificationId() string {
@@ -2338,7 +2964,7 @@ type DeleteNotificationResponse struct {
 
 func (x *DeleteNotificationResponse) Reset() {
 	*x = DeleteNotificationResponse{}
-	mi := &file_proto_service_proto_msgTypes[40]
+	mi := &file_proto_service_proto_msgTypes[49]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2350,7 +2976,7 @@ func (x *DeleteNotificationResponse) String() string {
 func (*DeleteNotificationResponse) ProtoMessage() {}
 
 func (x *DeleteNotificationResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_service_proto_msgTypes[40]
+	mi := &file_proto_service_proto_msgTypes[49]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2363,7 +2989,7 @@ func (x *DeleteNotificationResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeleteNotificationResponse.ProtoReflect.Descriptor instead.
 func (*DeleteNotificationResponse) Descriptor() ([]byte, []int) {
-	return file_proto_service_proto_rawDescGZIP(), []int{40}
+	return file_proto_service_proto_rawDescGZIP(), []int{49}
 }
 
 func (x *DeleteNotificationResponse) GetSuccess() bool {
@@ -2414,7 +3040,25 @@ const file_proto_service_proto_rawDesc = "" +
 	"\aoptions\x18\x03 \x03(\tR\aoptions\x12%\n" +
 	"\x0ecorrect_answer\x18\x04 \x01(\tR\rcorrectAnswer\"/\n" +
 	"\fQuizResponse\x12\x1f\n" +
-	"\x04quiz\x18\x01 \x01(\v2\v.proto.QuizR\x04quiz\"\x81\x01\n" +
+	"\x04quiz\x18\x01 \x01(\v2\v.proto.QuizR\x04quiz\"7\n" +
+	"\x16ListAssignmentsRequest\x12\x1d\n" +
+	"\n" +
+	"student_id\x18\x01 \x01(\tR\tstudentId\"N\n" +
+	"\x17ListAssignmentsResponse\x123\n" +
+	"\vassignments\x18\x01 \x03(\v2\x11.proto.AssignmentR\vassignments\"\x97\x02\n" +
+	"\n" +
+	"Assignment\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12!\n" +
+	"\fclassroom_id\x18\x02 \x01(\tR\vclassroomId\x12%\n" +
+	"\x0eclassroom_name\x18\x03 \x01(\tR\rclassroomName\x12\x17\n" +
+	"\aquiz_id\x18\x04 \x01(\tR\x06quizId\x12\x14\n" +
+	"\x05title\x18\x05 \x01(\tR\x05title\x12\x15\n" +
+	"\x06due_at\x18\x06 \x01(\tR\x05dueAt\x12\x1c\n" +
+	"\tsubmitted\x18\a \x01(\bR\tsubmitted\x12\x14\n" +
+	"\x05score\x18\b \x01(\x05R\x05score\x12!\n" +
+	"\fsubmitted_at\x18\t \x01(\tR\vsubmittedAt\x12\x12\n" +
+	"\x04late\x18\n" +
+	" \x01(\bR\x04late\"\x81\x01\n" +
 	"\x18CreateTransactionRequest\x12\x17\n" +
 	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x16\n" +
 	"\x06amount\x18\x02 \x01(\x01R\x06amount\x12\x12\n" +
@@ -2449,23 +3093,35 @@ const file_proto_service_proto_rawDesc = "" +
 	"\n" +
 	"updated_at\x18\a \x01(\tR\tupdatedAt\"K\n" +
 	"\x13TransactionResponse\x124\n" +
-	"\vtransaction\x18\x01 \x01(\v2\x12.proto.TransactionR\vtransaction\"\x9d\x01\n" +
+	"\vtransaction\x18\x01 \x01(\v2\x12.proto.TransactionR\vtransaction\"R\n" +
+	"\x15HasEntitlementRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12 \n" +
+	"\ventitlement\x18\x02 \x01(\tR\ventitlement\"`\n" +
+	"\x16HasEntitlementResponse\x12'\n" +
+	"\x0fhas_entitlement\x18\x01 \x01(\bR\x0ehasEntitlement\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x02 \x01(\tR\texpiresAt\"\xc7\x01\n" +
 	"\x11CreateUserRequest\x12\x1a\n" +
 	"\busername\x18\x01 \x01(\tR\busername\x12\x14\n" +
 	"\x05email\x18\x02 \x01(\tR\x05email\x12\x1a\n" +
 	"\bpassword\x18\x03 \x01(\tR\bpassword\x12\x1d\n" +
 	"\n" +
 	"first_name\x18\x04 \x01(\tR\tfirstName\x12\x1b\n" +
-	"\tlast_name\x18\x05 \x01(\tR\blastName\" \n" +
+	"\tlast_name\x18\x05 \x01(\tR\blastName\x12\x10\n" +
+	"\x03age\x18\x06 \x01(\x05R\x03age\x12\x16\n" +
+	"\x06gender\x18\a \x01(\tR\x06gender\" \n" +
 	"\x0eGetUserRequest\x12\x0e\n" +
-	"\x02id\x18\x01 \x01(\tR\x02id\"\x91\x01\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xd7\x01\n" +
 	"\x11UpdateUserRequest\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
 	"\busername\x18\x02 \x01(\tR\busername\x12\x14\n" +
 	"\x05email\x18\x03 \x01(\tR\x05email\x12\x1d\n" +
 	"\n" +
 	"first_name\x18\x04 \x01(\tR\tfirstName\x12\x1b\n" +
-	"\tlast_name\x18\x05 \x01(\tR\blastName\"#\n" +
+	"\tlast_name\x18\x05 \x01(\tR\blastName\x12\x10\n" +
+	"\x03age\x18\x06 \x01(\x05R\x03age\x12\x16\n" +
+	"\x06gender\x18\a \x01(\tR\x06gender\x12\x1a\n" +
+	"\bpassword\x18\b \x01(\tR\bpassword\"#\n" +
 	"\x11DeleteUserRequest\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\".\n" +
 	"\x12DeleteUserResponse\x12\x18\n" +
@@ -2479,7 +3135,7 @@ const file_proto_service_proto_rawDesc = "" +
 	"sort_order\x18\x05 \x01(\tR\tsortOrder\"L\n" +
 	"\x11ListUsersResponse\x12!\n" +
 	"\x05users\x18\x01 \x03(\v2\v.proto.UserR\x05users\x12\x14\n" +
-	"\x05total\x18\x02 \x01(\x05R\x05total\"\xc2\x01\n" +
+	"\x05total\x18\x02 \x01(\x05R\x05total\"\xa1\x02\n" +
 	"\x04User\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
 	"\busername\x18\x02 \x01(\tR\busername\x12\x14\n" +
@@ -2490,15 +3146,39 @@ const file_proto_service_proto_rawDesc = "" +
 	"\n" +
 	"created_at\x18\x06 \x01(\tR\tcreatedAt\x12\x1d\n" +
 	"\n" +
-	"updated_at\x18\a \x01(\tR\tupdatedAt\"/\n" +
+	"updated_at\x18\a \x01(\tR\tupdatedAt\x12\x12\n" +
+	"\x04role\x18\b \x01(\tR\x04role\x12\x10\n" +
+	"\x03age\x18\t \x01(\x05R\x03age\x12\x16\n" +
+	"\x06gender\x18\n" +
+	" \x01(\tR\x06gender\x12\x1f\n" +
+	"\vis_verified\x18\v \x01(\bR\n" +
+	"isVerified\"/\n" +
 	"\fUserResponse\x12\x1f\n" +
-	"\x04user\x18\x01 \x01(\v2\v.proto.UserR\x04user\"Q\n" +
+	"\x04user\x18\x01 \x01(\v2\v.proto.UserR\x04user\"\x83\x01\n" +
 	"\x17AuthenticateUserRequest\x12\x1a\n" +
 	"\busername\x18\x01 \x01(\tR\busername\x12\x1a\n" +
-	"\bpassword\x18\x02 \x01(\tR\bpassword\"Q\n" +
+	"\bpassword\x18\x02 \x01(\tR\bpassword\x12\x1c\n" +
+	"\tchallenge\x18\x03 \x01(\tR\tchallenge\x12\x12\n" +
+	"\x04code\x18\x04 \x01(\tR\x04code\"\xc3\x02\n" +
 	"\x18AuthenticateUserResponse\x12\x14\n" +
 	"\x05token\x18\x01 \x01(\tR\x05token\x12\x1f\n" +
-	"\x04user\x18\x02 \x01(\v2\v.proto.UserR\x04user\"p\n" +
+	"\x04user\x18\x02 \x01(\v2\v.proto.UserR\x04user\x12#\n" +
+	"\rrefresh_token\x18\x03 \x01(\tR\frefreshToken\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x04 \x01(\tR\texpiresAt\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x05 \x01(\tR\tsessionId\x124\n" +
+	"\x16second_factor_required\x18\x06 \x01(\bR\x14secondFactorRequired\x12\x1c\n" +
+	"\tchallenge\x18\a \x01(\tR\tchallenge\x129\n" +
+	"\x19two_factor_setup_required\x18\b \x01(\bR\x16twoFactorSetupRequired\":\n" +
+	"\x13RefreshTokenRequest\x12#\n" +
+	"\rrefresh_token\x18\x01 \x01(\tR\frefreshToken\"I\n" +
+	"\x16ImpersonateUserRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\"\x19\n" +
+	"\x17EndImpersonationRequest\"4\n" +
+	"\x18EndImpersonationResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\"p\n" +
 	"\x10SendEmailRequest\x12\x0e\n" +
 	"\x02to\x18\x01 \x01(\tR\x02to\x12\x18\n" +
 	"\asubject\x18\x02 \x01(\tR\asubject\x12\x12\n" +
@@ -2540,7 +3220,7 @@ const file_proto_service_proto_rawDesc = "" +
 	"\x19DeleteNotificationRequest\x12'\n" +
 	"\x0fnotification_id\x18\x01 \x01(\tR\x0enotificationId\"6\n" +
 	"\x1aDeleteNotificationResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess2\xc7\x02\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess2\x99\x03\n" +
 	"\vQuizService\x12;\n" +
 	"\n" +
 	"CreateQuiz\x12\x18.proto.CreateQuizRequest\x1a\x13.proto.QuizResponse\x125\n" +
@@ -2549,13 +3229,15 @@ const file_proto_service_proto_rawDesc = "" +
 	"UpdateQuiz\x12\x18.proto.UpdateQuizRequest\x1a\x13.proto.QuizResponse\x12A\n" +
 	"\n" +
 	"DeleteQuiz\x12\x18.proto.DeleteQuizRequest\x1a\x19.proto.DeleteQuizResponse\x12D\n" +
-	"\vListQuizzes\x12\x19.proto.ListQuizzesRequest\x1a\x1a.proto.ListQuizzesResponse2\xb1\x03\n" +
+	"\vListQuizzes\x12\x19.proto.ListQuizzesRequest\x1a\x1a.proto.ListQuizzesResponse\x12P\n" +
+	"\x0fListAssignments\x12\x1d.proto.ListAssignmentsRequest\x1a\x1e.proto.ListAssignmentsResponse2\x80\x04\n" +
 	"\x12TransactionService\x12P\n" +
 	"\x11CreateTransaction\x12\x1f.proto.CreateTransactionRequest\x1a\x1a.proto.TransactionResponse\x12J\n" +
 	"\x0eGetTransaction\x12\x1c.proto.GetTransactionRequest\x1a\x1a.proto.TransactionResponse\x12P\n" +
 	"\x11UpdateTransaction\x12\x1f.proto.UpdateTransactionRequest\x1a\x1a.proto.TransactionResponse\x12V\n" +
 	"\x11DeleteTransaction\x12\x1f.proto.DeleteTransactionRequest\x1a .proto.DeleteTransactionResponse\x12S\n" +
-	"\x10ListTransactions\x12\x1e.proto.ListTransactionsRequest\x1a\x1f.proto.ListTransactionsResponse2\x96\x03\n" +
+	"\x10ListTransactions\x12\x1e.proto.ListTransactionsRequest\x1a\x1f.proto.ListTransactionsResponse\x12M\n" +
+	"\x0eHasEntitlement\x12\x1c.proto.HasEntitlementRequest\x1a\x1d.proto.HasEntitlementResponse2\x8b\x05\n" +
 	"\vUserService\x12;\n" +
 	"\n" +
 	"CreateUser\x12\x18.proto.CreateUserRequest\x1a\x13.proto.UserResponse\x125\n" +
@@ -2565,7 +3247,10 @@ const file_proto_service_proto_rawDesc = "" +
 	"\n" +
 	"DeleteUser\x12\x18.proto.DeleteUserRequest\x1a\x19.proto.DeleteUserResponse\x12>\n" +
 	"\tListUsers\x12\x17.proto.ListUsersRequest\x1a\x18.proto.ListUsersResponse\x12S\n" +
-	"\x10AuthenticateUser\x12\x1e.proto.AuthenticateUserRequest\x1a\x1f.proto.AuthenticateUserResponse2\xc1\x03\n" +
+	"\x10AuthenticateUser\x12\x1e.proto.AuthenticateUserRequest\x1a\x1f.proto.AuthenticateUserResponse\x12K\n" +
+	"\fRefreshToken\x12\x1a.proto.RefreshTokenRequest\x1a\x1f.proto.AuthenticateUserResponse\x12Q\n" +
+	"\x0fImpersonateUser\x12\x1d.proto.ImpersonateUserRequest\x1a\x1f.proto.AuthenticateUserResponse\x12S\n" +
+	"\x10EndImpersonation\x12\x1e.proto.EndImpersonationRequest\x1a\x1f.proto.EndImpersonationResponse2\xc1\x03\n" +
 	"\x13NotificationService\x12>\n" +
 	"\tSendEmail\x12\x17.proto.SendEmailRequest\x1a\x18.proto.SendEmailResponse\x12S\n" +
 	"\x10SendNotification\x12\x1e.proto.SendNotificationRequest\x1a\x1f.proto.SendNotificationResponse\x12S\n" +
@@ -2585,7 +3270,7 @@ func file_proto_service_proto_rawDescGZIP() []byte {
 	return file_proto_service_proto_rawDescData
 }
 
-var file_proto_service_proto_msgTypes = make([]protoimpl.MessageInfo, 41)
+var file_proto_service_proto_msgTypes = make([]protoimpl.MessageInfo, 50)
 var file_proto_service_proto_goTypes = []any{
 	(*CreateQuizRequest)(nil),              // 0: proto.CreateQuizRequest
 	(*GetQuizRequest)(nil),                 // 1: proto.GetQuizRequest
@@ -2597,37 +3282,46 @@ var file_proto_service_proto_goTypes = []any{
 	(*Quiz)(nil),                           // 7: proto.Quiz
 	(*Question)(nil),                       // 8: proto.Question
 	(*QuizResponse)(nil),                   // 9: proto.QuizResponse
-	(*CreateTransactionRequest)(nil),       // 10: proto.CreateTransactionRequest
-	(*GetTransactionRequest)(nil),          // 11: proto.GetTransactionRequest
-	(*UpdateTransactionRequest)(nil),       // 12: proto.UpdateTransactionRequest
-	(*DeleteTransactionRequest)(nil),       // 13: proto.DeleteTransactionRequest
-	(*DeleteTransactionResponse)(nil),      // 14: proto.DeleteTransactionResponse
-	(*ListTransactionsRequest)(nil),        // 15: proto.ListTransactionsRequest
-	(*ListTransactionsResponse)(nil),       // 16: proto.ListTransactionsResponse
-	(*Transaction)(nil),                    // 17: proto.Transaction
-	(*TransactionResponse)(nil),            // 18: proto.TransactionResponse
-	(*CreateUserRequest)(nil),              // 19: proto.CreateUserRequest
-	(*GetUserRequest)(nil),                 // 20: proto.GetUserRequest
-	(*UpdateUserRequest)(nil),              // 21: proto.UpdateUserRequest
-	(*DeleteUserRequest)(nil),              // 22: proto.DeleteUserRequest
-	(*DeleteUserResponse)(nil),             // 23: proto.DeleteUserResponse
-	(*ListUsersRequest)(nil),               // 24: proto.ListUsersRequest
-	(*ListUsersResponse)(nil),              // 25: proto.ListUsersResponse
-	(*User)(nil),                           // 26: proto.User
-	(*UserResponse)(nil),                   // 27: proto.UserResponse
-	(*AuthenticateUserRequest)(nil),        // 28: proto.AuthenticateUserRequest
-	(*AuthenticateUserResponse)(nil),       // 29: proto.AuthenticateUserResponse
-	(*SendEmailRequest)(nil),               // 30: proto.SendEmailRequest
-	(*SendEmailResponse)(nil),              // 31: proto.SendEmailResponse
-	(*SendNotificationRequest)(nil),        // 32: proto.SendNotificationRequest
-	(*SendNotificationResponse)(nil),       // 33: proto.SendNotificationResponse
-	(*GetNotificationsRequest)(nil),        // 34: proto.GetNotificationsRequest
-	(*GetNotificationsResponse)(nil),       // 35: proto.GetNotificationsResponse
-	(*Notification)(nil),                   // 36: proto.Notification
-	(*MarkNotificationAsReadRequest)(nil),  // 37: proto.MarkNotificationAsReadRequest
-	(*MarkNotificationAsReadResponse)(nil), // 38: proto.MarkNotificationAsReadResponse
-	(*DeleteNotificationRequest)(nil),      // 39: proto.DeleteNotificationRequest
-	(*DeleteNotificationResponse)(nil),     // 40: proto.DeleteNotificationResponse
+	(*ListAssignmentsRequest)(nil),         // 10: proto.ListAssignmentsRequest
+	(*ListAssignmentsResponse)(nil),        // 11: proto.ListAssignmentsResponse
+	(*Assignment)(nil),                     // 12: proto.Assignment
+	(*CreateTransactionRequest)(nil),       // 13: proto.CreateTransactionRequest
+	(*GetTransactionRequest)(nil),          // 14: proto.GetTransactionRequest
+	(*UpdateTransactionRequest)(nil),       // 15: proto.UpdateTransactionRequest
+	(*DeleteTransactionRequest)(nil),       // 16: proto.DeleteTransactionRequest
+	(*DeleteTransactionResponse)(nil),      // 17: proto.DeleteTransactionResponse
+	(*ListTransactionsRequest)(nil),        // 18: proto.ListTransactionsRequest
+	(*ListTransactionsResponse)(nil),       // 19: proto.ListTransactionsResponse
+	(*Transaction)(nil),                    // 20: proto.Transaction
+	(*TransactionResponse)(nil),            // 21: proto.TransactionResponse
+	(*HasEntitlementRequest)(nil),          // 22: proto.HasEntitlementRequest
+	(*HasEntitlementResponse)(nil),         // 23: proto.HasEntitlementResponse
+	(*CreateUserRequest)(nil),              // 24: proto.CreateUserRequest
+	(*GetUserRequest)(nil),                 // 25: proto.GetUserRequest
+	(*UpdateUserRequest)(nil),              // 26: proto.UpdateUserRequest
+	(*DeleteUserRequest)(nil),              // 27: proto.DeleteUserRequest
+	(*DeleteUserResponse)(nil),             // 28: proto.DeleteUserResponse
+	(*ListUsersRequest)(nil),               // 29: proto.ListUsersRequest
+	(*ListUsersResponse)(nil),              // 30: proto.ListUsersResponse
+	(*User)(nil),                           // 31: proto.User
+	(*UserResponse)(nil),                   // 32: proto.UserResponse
+	(*AuthenticateUserRequest)(nil),        // 33: proto.AuthenticateUserRequest
+	(*AuthenticateUserResponse)(nil),       // 34: proto.AuthenticateUserResponse
+	(*RefreshTokenRequest)(nil),            // 35: proto.RefreshTokenRequest
+	(*ImpersonateUserRequest)(nil),         // 36: proto.ImpersonateUserRequest
+	(*EndImpersonationRequest)(nil),        // 37: proto.EndImpersonationRequest
+	(*EndImpersonationResponse)(nil),       // 38: proto.EndImpersonationResponse
+	(*SendEmailRequest)(nil),               // 39: proto.SendEmailRequest
+	(*SendEmailResponse)(nil),              // 40: proto.SendEmailResponse
+	(*SendNotificationRequest)(nil),        // 41: proto.SendNotificationRequest
+	(*SendNotificationResponse)(nil),       // 42: proto.SendNotificationResponse
+	(*GetNotificationsRequest)(nil),        // 43: proto.GetNotificationsRequest
+	(*GetNotificationsResponse)(nil),       // 44: proto.GetNotificationsResponse
+	(*Notification)(nil),                   // 45: proto.Notification
+	(*MarkNotificationAsReadRequest)(nil),  // 46: proto.MarkNotificationAsReadRequest
+	(*MarkNotificationAsReadResponse)(nil), // 47: proto.MarkNotificationAsReadResponse
+	(*DeleteNotificationRequest)(nil),      // 48: proto.DeleteNotificationRequest
+	(*DeleteNotificationResponse)(nil),     // 49: proto.DeleteNotificationResponse
 }
 var file_proto_service_proto_depIdxs = []int32{
 	8,  // 0: proto.CreateQuizRequest.questions:type_name -> proto.Question
@@ -2635,59 +3329,70 @@ var file_proto_service_proto_depIdxs = []int32{
 	7,  // 2: proto.ListQuizzesResponse.quizzes:type_name -> proto.Quiz
 	8,  // 3: proto.Quiz.questions:type_name -> proto.Question
 	7,  // 4: proto.QuizResponse.quiz:type_name -> proto.Quiz
-	17, // 5: proto.ListTransactionsResponse.transactions:type_name -> proto.Transaction
-	17, // 6: proto.TransactionResponse.transaction:type_name -> proto.Transaction
-	26, // 7: proto.ListUsersResponse.users:type_name -> proto.User
-	26, // 8: proto.UserResponse.user:type_name -> proto.User
-	26, // 9: proto.AuthenticateUserResponse.user:type_name -> proto.User
-	36, // 10: proto.GetNotificationsResponse.notifications:type_name -> proto.Notification
-	0,  // 11: proto.QuizService.CreateQuiz:input_type -> proto.CreateQuizRequest
-	1,  // 12: proto.QuizService.GetQuiz:input_type -> proto.GetQuizRequest
-	2,  // 13: proto.QuizService.UpdateQuiz:input_type -> proto.UpdateQuizRequest
-	3,  // 14: proto.QuizService.DeleteQuiz:input_type -> proto.DeleteQuizRequest
-	5,  // 15: proto.QuizService.ListQuizzes:input_type -> proto.ListQuizzesRequest
-	10, // 16: proto.TransactionService.CreateTransaction:input_type -> proto.CreateTransactionRequest
-	11, // 17: proto.TransactionService.GetTransaction:input_type -> proto.GetTransactionRequest
-	12, // 18: proto.TransactionService.UpdateTransaction:input_type -> proto.UpdateTransactionRequest
-	13, // 19: proto.TransactionService.DeleteTransaction:input_type -> proto.DeleteTransactionRequest
-	15, // 20: proto.TransactionService.ListTransactions:input_type -> proto.ListTransactionsRequest
-	19, // 21: proto.UserService.CreateUser:input_type -> proto.CreateUserRequest
-	20, // 22: proto.UserService.GetUser:input_type -> proto.GetUserRequest
-	21, // 23: proto.UserService.UpdateUser:input_type -> proto.UpdateUserRequest
-	22, // 24: proto.UserService.DeleteUser:input_type -> proto.DeleteUserRequest
-	24, // 25: proto.UserService.ListUsers:input_type -> proto.ListUsersRequest
-	28, // 26: proto.UserService.AuthenticateUser:input_type -> proto.AuthenticateUserRequest
-	30, // 27: proto.NotificationService.SendEmail:input_type -> proto.SendEmailRequest
-	32, // 28: proto.NotificationService.SendNotification:input_type -> proto.SendNotificationRequest
-	34, // 29: proto.NotificationService.GetNotifications:input_type -> proto.GetNotificationsRequest
-	37, // 30: proto.NotificationService.MarkNotificationAsRead:input_type -> proto.MarkNotificationAsReadRequest
-	39, // 31: proto.NotificationService.DeleteNotification:input_type -> proto.DeleteNotificationRequest
-	9,  // 32: proto.QuizService.CreateQuiz:output_type -> proto.QuizResponse
-	9,  // 33: proto.QuizService.GetQuiz:output_type -> proto.QuizResponse
-	9,  // 34: proto.QuizService.UpdateQuiz:output_type -> proto.QuizResponse
-	4,  // 35: proto.QuizService.DeleteQuiz:output_type -> proto.DeleteQuizResponse
-	6,  // 36: proto.QuizService.ListQuizzes:output_type -> proto.ListQuizzesResponse
-	18, // 37: proto.TransactionService.CreateTransaction:output_type -> proto.TransactionResponse
-	18, // 38: proto.TransactionService.GetTransaction:output_type -> proto.TransactionResponse
-	18, // 39: proto.TransactionService.UpdateTransaction:output_type -> proto.TransactionResponse
-	14, // 40: proto.TransactionService.DeleteTransaction:output_type -> proto.DeleteTransactionResponse
-	16, // 41: proto.TransactionService.ListTransactions:output_type -> proto.ListTransactionsResponse
-	27, // 42: proto.UserService.CreateUser:output_type -> proto.UserResponse
-	27, // 43: proto.UserService.GetUser:output_type -> proto.UserResponse
-	27, // 44: proto.UserService.UpdateUser:output_type -> proto.UserResponse
-	23, // 45: proto.UserService.DeleteUser:output_type -> proto.DeleteUserResponse
-	25, // 46: proto.UserService.ListUsers:output_type -> proto.ListUsersResponse
-	29, // 47: proto.UserService.AuthenticateUser:output_type -> proto.AuthenticateUserResponse
-	31, // 48: proto.NotificationService.SendEmail:output_type -> proto.SendEmailResponse
-	33, // 49: proto.NotificationService.SendNotification:output_type -> proto.SendNotificationResponse
-	35, // 50: proto.NotificationService.GetNotifications:output_type -> proto.GetNotificationsResponse
-	38, // 51: proto.NotificationService.MarkNotificationAsRead:output_type -> proto.MarkNotificationAsReadResponse
-	40, // 52: proto.NotificationService.DeleteNotification:output_type -> proto.DeleteNotificationResponse
-	32, // [32:53] is the sub-list for method output_type
-	11, // [11:32] is the sub-list for method input_type
-	11, // [11:11] is the sub-list for extension type_name
-	11, // [11:11] is the sub-list for extension extendee
-	0,  // [0:11] is the sub-list for field type_name
+	12, // 5: proto.ListAssignmentsResponse.assignments:type_name -> proto.Assignment
+	20, // 6: proto.ListTransactionsResponse.transactions:type_name -> proto.Transaction
+	20, // 7: proto.TransactionResponse.transaction:type_name -> proto.Transaction
+	31, // 8: proto.ListUsersResponse.users:type_name -> proto.User
+	31, // 9: proto.UserResponse.user:type_name -> proto.User
+	31, // 10: proto.AuthenticateUserResponse.user:type_name -> proto.User
+	45, // 11: proto.GetNotificationsResponse.notifications:type_name -> proto.Notification
+	0,  // 12: proto.QuizService.CreateQuiz:input_type -> proto.CreateQuizRequest
+	1,  // 13: proto.QuizService.GetQuiz:input_type -> proto.GetQuizRequest
+	2,  // 14: proto.QuizService.UpdateQuiz:input_type -> proto.UpdateQuizRequest
+	3,  // 15: proto.QuizService.DeleteQuiz:input_type -> proto.DeleteQuizRequest
+	5,  // 16: proto.QuizService.ListQuizzes:input_type -> proto.ListQuizzesRequest
+	10, // 17: proto.QuizService.ListAssignments:input_type -> proto.ListAssignmentsRequest
+	13, // 18: proto.TransactionService.CreateTransaction:input_type -> proto.CreateTransactionRequest
+	14, // 19: proto.TransactionService.GetTransaction:input_type -> proto.GetTransactionRequest
+	15, // 20: proto.TransactionService.UpdateTransaction:input_type -> proto.UpdateTransactionRequest
+	16, // 21: proto.TransactionService.DeleteTransaction:input_type -> proto.DeleteTransactionRequest
+	18, // 22: proto.TransactionService.ListTransactions:input_type -> proto.ListTransactionsRequest
+	22, // 23: proto.TransactionService.HasEntitlement:input_type -> proto.HasEntitlementRequest
+	24, // 24: proto.UserService.CreateUser:input_type -> proto.CreateUserRequest
+	25, // 25: proto.UserService.GetUser:input_type -> proto.GetUserRequest
+	26, // 26: proto.UserService.UpdateUser:input_type -> proto.UpdateUserRequest
+	27, // 27: proto.UserService.DeleteUser:input_type -> proto.DeleteUserRequest
+	29, // 28: proto.UserService.ListUsers:input_type -> proto.ListUsersRequest
+	33, // 29: proto.UserService.AuthenticateUser:input_type -> proto.AuthenticateUserRequest
+	35, // 30: proto.UserService.RefreshToken:input_type -> proto.RefreshTokenRequest
+	36, // 31: proto.UserService.ImpersonateUser:input_type -> proto.ImpersonateUserRequest
+	37, // 32: proto.UserService.EndImpersonation:input_type -> proto.EndImpersonationRequest
+	39, // 33: proto.NotificationService.SendEmail:input_type -> proto.SendEmailRequest
+	41, // 34: proto.NotificationService.SendNotification:input_type -> proto.SendNotificationRequest
+	43, // 35: proto.NotificationService.GetNotifications:input_type -> proto.GetNotificationsRequest
+	46, // 36: proto.NotificationService.MarkNotificationAsRead:input_type -> proto.MarkNotificationAsReadRequest
+	48, // 37: proto.NotificationService.DeleteNotification:input_type -> proto.DeleteNotificationRequest
+	9,  // 38: proto.QuizService.CreateQuiz:output_type -> proto.QuizResponse
+	9,  // 39: proto.QuizService.GetQuiz:output_type -> proto.QuizResponse
+	9,  // 40: proto.QuizService.UpdateQuiz:output_type -> proto.QuizResponse
+	4,  // 41: proto.QuizService.DeleteQuiz:output_type -> proto.DeleteQuizResponse
+	6,  // 42: proto.QuizService.ListQuizzes:output_type -> proto.ListQuizzesResponse
+	11, // 43: proto.QuizService.ListAssignments:output_type -> proto.ListAssignmentsResponse
+	21, // 44: proto.TransactionService.CreateTransaction:output_type -> proto.TransactionResponse
+	21, // 45: proto.TransactionService.GetTransaction:output_type -> proto.TransactionResponse
+	21, // 46: proto.TransactionService.UpdateTransaction:output_type -> proto.TransactionResponse
+	17, // 47: proto.TransactionService.DeleteTransaction:output_type -> proto.DeleteTransactionResponse
+	19, // 48: proto.TransactionService.ListTransactions:output_type -> proto.ListTransactionsResponse
+	23, // 49: proto.TransactionService.HasEntitlement:output_type -> proto.HasEntitlementResponse
+	32, // 50: proto.UserService.CreateUser:output_type -> proto.UserResponse
+	32, // 51: proto.UserService.GetUser:output_type -> proto.UserResponse
+	32, // 52: proto.UserService.UpdateUser:output_type -> proto.UserResponse
+	28, // 53: proto.UserService.DeleteUser:output_type -> proto.DeleteUserResponse
+	30, // 54: proto.UserService.ListUsers:output_type -> proto.ListUsersResponse
+	34, // 55: proto.UserService.AuthenticateUser:output_type -> proto.AuthenticateUserResponse
+	34, // 56: proto.UserService.RefreshToken:output_type -> proto.AuthenticateUserResponse
+	34, // 57: proto.UserService.ImpersonateUser:output_type -> proto.AuthenticateUserResponse
+	38, // 58: proto.UserService.EndImpersonation:output_type -> proto.EndImpersonationResponse
+	40, // 59: proto.NotificationService.SendEmail:output_type -> proto.SendEmailResponse
+	42, // 60: proto.NotificationService.SendNotification:output_type -> proto.SendNotificationResponse
+	44, // 61: proto.NotificationService.GetNotifications:output_type -> proto.GetNotificationsResponse
+	47, // 62: proto.NotificationService.MarkNotificationAsRead:output_type -> proto.MarkNotificationAsReadResponse
+	49, // 63: proto.NotificationService.DeleteNotification:output_type -> proto.DeleteNotificationResponse
+	38, // [38:64] is the sub-list for method output_type
+	12, // [12:38] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
 }
 
 func init() { file_proto_service_proto_init() }
@@ -2701,7 +3406,7 @@ func file_proto_service_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_service_proto_rawDesc), len(file_proto_service_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   41,
+			NumMessages:   50,
 			NumExtensions: 0,
 			NumServices:   4,
 		},
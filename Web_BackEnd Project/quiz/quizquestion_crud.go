@@ -3,19 +3,35 @@ package quiz
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+	"web_backend_project/pkg/classroom"
+	"web_backend_project/pkg/etag"
 )
 
 var quizClient *mongo.Client
 
-func StartQuizService() {
+// auditLog записывает изменения вопросов; nil отключает аудит
+var auditLog *audit.Log
+
+// classrooms проверяет доступ к квизам, выданным классам
+var classrooms *classroom.Service
+
+func StartQuizService(audits *audit.Log, classroomService *classroom.Service) {
+	auditLog = audits
+	classrooms = classroomService
+
 	// Подключение к MongoDB
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -38,10 +54,15 @@ func StartQuizService() {
 	// Настройка маршрутов
 	http.HandleFunc("/questions", handleQuestions)
 	http.HandleFunc("/questions/create", handleCreateQuestion)
+	http.HandleFunc("/questions/get", handleGetQuestion)
+	http.HandleFunc("/questions/update", handleUpdateQuestion)
+	http.HandleFunc("/questions/delete", handleDeleteQuestion)
+	// Ключам API вопросы доступны с правами quizzes:read и quizzes:write
+	auth.RegisterScope("quizzes", "/questions")
 
 	// Запуск сервера
 	fmt.Println("Quiz service started on :8082")
-	log.Fatal(http.ListenAndServe(":8082", nil))
+	log.Fatal(http.ListenAndServe(":8082", auth.ImpersonationGuard(http.DefaultServeMux)))
 }
 
 func handleQuestions(w http.ResponseWriter, r *http.Request) {
@@ -58,12 +79,27 @@ func handleQuestions(w http.ResponseWriter, r *http.Request) {
 	}
 	defer cursor.Close(context.Background())
 
-	var questions []bson.M
-	if err = cursor.All(context.Background(), &questions); err != nil {
+	var all []bson.M
+	if err = cursor.All(context.Background(), &all); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Вопросы квизов, выданных чужим классам, в список не попадают
+	ctx := audit.FromHTTP(r)
+	questions := make([]bson.M, 0, len(all))
+	for _, question := range all {
+		err := checkQuizAccess(ctx, question)
+		if errors.Is(err, classroom.ErrNotMember) || errors.Is(err, auth.ErrUnauthenticated) {
+			continue
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		questions = append(questions, question)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(questions)
 }
@@ -73,12 +109,21 @@ func handleCreateQuestion(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !requirePermission(w, r, auth.PermQuizzesCreate) {
+		return
+	}
 
 	var question bson.M
 	if err := json.NewDecoder(r.Body).Decode(&question); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !requirePublish(w, r, question) {
+		return
+	}
+
+	delete(question, "_id")
+	question["version"] = int64(1)
 
 	collection := quizClient.Database("quiz_db").Collection("questions")
 	result, err := collection.InsertOne(context.Background(), question)
@@ -87,8 +132,246 @@ func handleCreateQuestion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		auditLog.Record(audit.FromHTTP(r), "question.create", questionTarget(id), nil, question)
+	}
+
+	etag.Set(w, 1)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"id": result.InsertedID,
 	})
 }
+
+// questionID разбирает параметр id запроса
+func questionID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid question ID", http.StatusBadRequest)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// questionTarget — идентификатор вопроса в журнале аудита
+func questionTarget(id primitive.ObjectID) string {
+	return "question:" + id.Hex()
+}
+
+// checkQuizAccess проверяет доступ к квизу вопроса. Квиз задается полем
+// quizId вопроса, а вопрос без него считается отдельным квизом со своим ID.
+func checkQuizAccess(ctx context.Context, question bson.M) error {
+	if classrooms == nil {
+		return nil
+	}
+	quizID, _ := question["quizId"].(string)
+	if quizID == "" {
+		id, ok := question["_id"].(primitive.ObjectID)
+		if !ok {
+			return nil
+		}
+		quizID = id.Hex()
+	}
+	return classrooms.CheckQuizAccess(ctx, quizID)
+}
+
+// requirePermission отвечает 401 или 403, если у вызывающего нет права permission
+func requirePermission(w http.ResponseWriter, r *http.Request, permission string) bool {
+	err := auth.Require(audit.FromHTTP(r), permission)
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requirePublish проверяет право quizzes.publish, если запрос меняет поле published
+func requirePublish(w http.ResponseWriter, r *http.Request, question bson.M) bool {
+	if _, ok := question["published"]; !ok {
+		return true
+	}
+	return requirePermission(w, r, auth.PermQuizzesPublish)
+}
+
+// questionVersionFilter добавляет к фильтру ожидаемую версию из If-Match.
+// Вопросы, созданные до появления версий, считаются версией 0.
+func questionVersionFilter(id primitive.ObjectID, expectedVersion *int64) bson.M {
+	filter := bson.M{"_id": id}
+	if expectedVersion == nil {
+		return filter
+	}
+	if *expectedVersion == 0 {
+		filter["$or"] = []bson.M{{"version": 0}, {"version": bson.M{"$exists": false}}}
+	} else {
+		filter["version"] = *expectedVersion
+	}
+	return filter
+}
+
+// questionVersion достает версию из документа; без поля version это 0
+func questionVersion(question bson.M) int64 {
+	switch v := question["version"].(type) {
+	case int64:
+		return v
+	case int32:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// writeQuestionMismatch отвечает 404, если вопроса нет, и 412, если он уже изменен
+func writeQuestionMismatch(w http.ResponseWriter, collection *mongo.Collection, id primitive.ObjectID) {
+	count, err := collection.CountDocuments(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if count == 0 {
+		http.Error(w, "Question not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Question was modified by another request", http.StatusPreconditionFailed)
+}
+
+func handleGetQuestion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := questionID(w, r)
+	if !ok {
+		return
+	}
+
+	var question bson.M
+	collection := quizClient.Database("quiz_db").Collection("questions")
+	err := collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&question)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Question not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Квиз, выданный классам, открывают только их учителя и ученики
+	err = checkQuizAccess(audit.FromHTTP(r), question)
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, classroom.ErrNotMember):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	etag.Set(w, questionVersion(question))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(question)
+}
+
+func handleUpdateQuestion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requirePermission(w, r, auth.PermQuizzesCreate) {
+		return
+	}
+	id, ok := questionID(w, r)
+	if !ok {
+		return
+	}
+	expectedVersion, err := etag.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	var question bson.M
+	if err := json.NewDecoder(r.Body).Decode(&question); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	delete(question, "_id")
+	delete(question, "version")
+	if !requirePublish(w, r, question) {
+		return
+	}
+	if len(question) == 0 {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	collection := quizClient.Database("quiz_db").Collection("questions")
+	var before bson.M
+	collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&before)
+	var updated bson.M
+	err = collection.FindOneAndUpdate(
+		context.Background(),
+		questionVersionFilter(id, expectedVersion),
+		bson.M{"$set": question, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		writeQuestionMismatch(w, collection, id)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	auditLog.Record(audit.FromHTTP(r), "question.update", questionTarget(id), before, updated)
+
+	etag.Set(w, questionVersion(updated))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func handleDeleteQuestion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requirePermission(w, r, auth.PermQuizzesDelete) {
+		return
+	}
+	id, ok := questionID(w, r)
+	if !ok {
+		return
+	}
+	expectedVersion, err := etag.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	collection := quizClient.Database("quiz_db").Collection("questions")
+	var deleted bson.M
+	err = collection.FindOneAndDelete(context.Background(), questionVersionFilter(id, expectedVersion)).Decode(&deleted)
+	if err == mongo.ErrNoDocuments {
+		writeQuestionMismatch(w, collection, id)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	auditLog.Record(audit.FromHTTP(r), "question.delete", questionTarget(id), deleted, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deletedCount": 1,
+	})
+}
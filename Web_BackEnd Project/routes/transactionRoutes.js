@@ -1,7 +1,6 @@
 const express = require('express')
 const router = express.Router()
 const axios = require('axios')
-const User = require('../models/userModel')
 
 router.post('/create-transaction', async (req, res) => {
 	try {
@@ -30,19 +29,4 @@ router.post('/payment', async (req, res) => {
 	}
 })
 
-// Route to update user role
-router.post('/auth/updateRole', async (req, res) => {
-	const { userID, role } = req.body
-	try {
-		await User.findByIdAndUpdate(userID, { role })
-		req.session.user.role = role // Update session data
-		res
-			.status(200)
-			.json({ success: true, message: 'Role updated successfully' })
-	} catch (error) {
-		console.error('Error updating role:', error)
-		res.status(500).json({ success: false, message: 'Failed to update role' })
-	}
-})
-
 module.exports = router
@@ -0,0 +1,47 @@
+package transaction
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"web_backend_project/pkg/audit"
+)
+
+// auditLog records every change made through this service. A nil log
+// disables auditing.
+var auditLog *audit.Log
+
+// auditTransaction records action on a transaction with the diff between
+// before (nil for a new transaction) and the stored document
+func auditTransaction(ctx context.Context, action string, id primitive.ObjectID, before *Transaction) {
+	if auditLog == nil {
+		return
+	}
+	var from, to interface{}
+	if before != nil {
+		from = *before
+	}
+	if after, err := findTransaction(ctx, id); err == nil {
+		to = after
+	}
+	auditLog.Record(ctx, action, "transaction:"+id.Hex(), from, to)
+}
+
+// auditSubscription records action on a subscription with the diff between
+// before (nil for a new subscription) and the stored document
+func auditSubscription(ctx context.Context, action string, id primitive.ObjectID, before *Subscription) {
+	if auditLog == nil {
+		return
+	}
+	var from, to interface{}
+	if before != nil {
+		from = *before
+	}
+	var after Subscription
+	if err := subscriptions().FindOne(ctx, bson.M{"_id": id}).Decode(&after); err == nil {
+		to = after
+	}
+	auditLog.Record(ctx, action, "subscription:"+id.Hex(), from, to)
+}
@@ -0,0 +1,321 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"web_backend_project/pkg/auth"
+	"web_backend_project/pkg/webhook"
+)
+
+var orchestrator *SagaOrchestrator
+
+const checkoutSaga = "checkout"
+
+// newSagaRunner picks the step runner configured by SAGA_RUNNER ("inprocess" or "nats").
+func newSagaRunner() StepRunner {
+	if getEnv("SAGA_RUNNER", "inprocess") == "nats" {
+		return natsStepRunner{conn: natsConn, timeout: 30 * time.Second}
+	}
+	return inProcessRunner{}
+}
+
+// checkoutDefinition describes checkout from reserving the transaction to
+// notifying the customer. Data keys: transaction_id, card_token (removed
+// once authorize_payment has run), recurring ("true" for merchant-initiated
+// charges), saga_id (set by the orchestrator) and, once authorized,
+// authorization_id.
+func checkoutDefinition() SagaDefinition {
+	return SagaDefinition{
+		Name: checkoutSaga,
+		Steps: []SagaStep{
+			{Name: "reserve_transaction", Action: reserveTransaction, Compensate: releaseTransaction},
+			{Name: "authorize_payment", Action: authorizePayment, Compensate: voidPayment},
+			{Name: "capture_payment", Action: capturePayment, Compensate: refundPayment},
+			{Name: "generate_receipt", Action: generateReceiptStep},
+			{Name: "grant_entitlements", Action: grantEntitlementsStep, Compensate: revokeEntitlementsStep},
+			{Name: "notify_customer", Action: notifyCustomerStep},
+		},
+	}
+}
+
+func sagaTransactionID(data map[string]string) (primitive.ObjectID, error) {
+	return primitive.ObjectIDFromHex(data["transaction_id"])
+}
+
+func setTransactionStatus(ctx context.Context, id primitive.ObjectID, from []string, set bson.M) (bool, error) {
+	set["updated_at"] = time.Now()
+	res, err := client.Database(dbName).Collection(transactionCollection).UpdateOne(ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": from}},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// reserveTransaction moves the transaction into Processing so concurrent
+// payments for the same transaction can't both run.
+func reserveTransaction(ctx context.Context, data map[string]string) error {
+	id, err := sagaTransactionID(data)
+	if err != nil {
+		return err
+	}
+	ok, err := setTransactionStatus(ctx, id, []string{"Pending Payment", "Declined"}, bson.M{"status": "Processing"})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("transaction is not awaiting payment")
+	}
+	return nil
+}
+
+func releaseTransaction(ctx context.Context, data map[string]string) error {
+	id, err := sagaTransactionID(data)
+	if err != nil {
+		return err
+	}
+	_, err = setTransactionStatus(ctx, id, []string{"Processing", "Authorized"}, bson.M{"status": "Declined"})
+	return err
+}
+
+func authorizePayment(ctx context.Context, data map[string]string) error {
+	// The card token is only needed here; dropping it keeps it out of the
+	// persisted saga once the step is done, whatever its outcome
+	defer delete(data, "card_token")
+
+	id, err := sagaTransactionID(data)
+	if err != nil {
+		return err
+	}
+	transaction, err := findTransaction(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	amount, err := chargeAmount(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	authorize := gateway.Authorize
+	if data["recurring"] == "true" {
+		authorize = gateway.AuthorizeRecurring
+	}
+	// A resumed saga repeats this step if it stopped before saving the result;
+	// the key makes the gateway return the first authorization instead of a second one
+	result, err := authorize(ctx, authorizationKey(data), id.Hex(), data["card_token"], amount)
+	if err != nil {
+		return err
+	}
+	if !result.Approved {
+		data["decline_reason"] = result.DeclineReason
+		return fmt.Errorf("payment declined: %s", result.DeclineReason)
+	}
+
+	data["authorization_id"] = result.AuthorizationID
+	_, err = setTransactionStatus(ctx, id, []string{"Processing"}, bson.M{
+		"status":           "Authorized",
+		"card_last4":       result.Last4,
+		"authorization_id": result.AuthorizationID,
+		"total":            amount,
+	})
+	return err
+}
+
+// authorizationKey is the idempotency key of the saga's authorization. Sagas
+// started before saga_id was recorded authorize without one.
+func authorizationKey(data map[string]string) string {
+	if data["saga_id"] == "" {
+		return ""
+	}
+	return "saga:" + data["saga_id"] + ":authorize"
+}
+
+// voidPayment releases the authorization. Once capture_payment was undone the
+// authorization is already refunded and there is nothing left to release; if
+// the gateway captured but the saga failed before recording it, the money is
+// refunded instead.
+func voidPayment(ctx context.Context, data map[string]string) error {
+	if data["authorization_id"] == "" {
+		return nil
+	}
+	err := gateway.Void(ctx, data["authorization_id"])
+	if errors.Is(err, ErrAlreadyCaptured) {
+		return gateway.Refund(ctx, data["authorization_id"])
+	}
+	return err
+}
+
+// capturePayment marks the transaction Paid and queues the paid webhook
+// within the same MongoDB transaction.
+func capturePayment(ctx context.Context, data map[string]string) error {
+	id, err := sagaTransactionID(data)
+	if err != nil {
+		return err
+	}
+	if err := gateway.Capture(ctx, data["authorization_id"]); err != nil {
+		return err
+	}
+
+	return withMongoTransaction(ctx, func(sc mongo.SessionContext) error {
+		ok, err := setTransactionStatus(sc, id, []string{"Authorized"}, bson.M{"status": "Paid"})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return alreadyCaptured(sc, id)
+		}
+		return enqueueTransactionWebhook(sc, webhook.EventTransactionPaid, id)
+	})
+}
+
+// alreadyCaptured accepts a capture that a resumed saga repeats after the
+// status change committed; any other status means the transaction moved on
+// without this saga and must not be reported as paid.
+func alreadyCaptured(ctx context.Context, id primitive.ObjectID) error {
+	transaction, err := findTransaction(ctx, id)
+	if err != nil {
+		return err
+	}
+	if transaction.Status == "Paid" || transaction.Status == "Completed" {
+		return nil
+	}
+	return fmt.Errorf("transaction is %s, cannot mark it paid", transaction.Status)
+}
+
+func refundPayment(ctx context.Context, data map[string]string) error {
+	id, err := sagaTransactionID(data)
+	if err != nil {
+		return err
+	}
+	if err := gateway.Refund(ctx, data["authorization_id"]); err != nil {
+		return err
+	}
+	return markTransactionRefunded(ctx, id)
+}
+
+// markTransactionRefunded records a refund the gateway already made and tells webhook subscribers.
+func markTransactionRefunded(ctx context.Context, id primitive.ObjectID) error {
+	return withMongoTransaction(ctx, func(sc mongo.SessionContext) error {
+		ok, err := setTransactionStatus(sc, id, []string{"Paid", "Completed"}, bson.M{"status": "Refunded"})
+		if err != nil || !ok {
+			return err
+		}
+		return enqueueTransactionWebhook(sc, webhook.EventTransactionRefunded, id)
+	})
+}
+
+func generateReceiptStep(ctx context.Context, data map[string]string) error {
+	id, err := sagaTransactionID(data)
+	if err != nil {
+		return err
+	}
+	transaction, err := findTransaction(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := regenerateReceipt(ctx, transaction); err != nil {
+		return err
+	}
+
+	// The receipt email is queued in the same MongoDB transaction that
+	// completes the order, so it is sent exactly when the order completes
+	return withMongoTransaction(ctx, func(sc mongo.SessionContext) error {
+		ok, err := setTransactionStatus(sc, id, []string{"Paid"}, bson.M{"status": "Completed", "receipt_url": receiptURL(id.Hex())})
+		if err != nil || !ok {
+			return err
+		}
+		return insertOutboxEvent(sc, EventReceiptGenerated, id.Hex(), nil)
+	})
+}
+
+func grantEntitlementsStep(ctx context.Context, data map[string]string) error {
+	id, err := sagaTransactionID(data)
+	if err != nil {
+		return err
+	}
+	transaction, err := findTransaction(ctx, id)
+	if err != nil {
+		return err
+	}
+	return grantEntitlements(ctx, transaction)
+}
+
+func revokeEntitlementsStep(ctx context.Context, data map[string]string) error {
+	return revokeEntitlements(ctx, data["transaction_id"], "checkout_failed")
+}
+
+// notifyCustomerStep is kept for sagas persisted before generate_receipt
+// queued the receipt email itself: for those the transaction is Completed
+// without an event, so the step queues it.
+func notifyCustomerStep(ctx context.Context, data map[string]string) error {
+	count, err := client.Database(dbName).Collection(outboxCollection).CountDocuments(ctx,
+		bson.M{"type": EventReceiptGenerated, "aggregate_id": data["transaction_id"]})
+	if err != nil || count > 0 {
+		return err
+	}
+	return insertOutboxEvent(ctx, EventReceiptGenerated, data["transaction_id"], nil)
+}
+
+// sagaSecretKeys are data keys an operator never needs to see. Sagas
+// stuck before authorization finished still hold the card token.
+var sagaSecretKeys = []string{"card_token"}
+
+func redactSagaData(data map[string]string) {
+	for _, key := range sagaSecretKeys {
+		if _, ok := data[key]; ok {
+			data[key] = "[redacted]"
+		}
+	}
+}
+
+func handleStuckSagas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !claims.Can(auth.PermTransactionsRead) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	olderThan := 10 * time.Minute
+	if value := r.URL.Query().Get("older_than"); value != "" {
+		olderThan, err = time.ParseDuration(value)
+		if err != nil {
+			http.Error(w, "older_than must be a duration such as 15m", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sagas, err := orchestrator.Stuck(r.Context(), olderThan)
+	if err != nil {
+		http.Error(w, "Failed to load sagas", http.StatusInternalServerError)
+		log.Println("Error finding stuck sagas:", err)
+		return
+	}
+	for i := range sagas {
+		redactSagaData(sagas[i].Data)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sagas)
+}
@@ -0,0 +1,494 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+)
+
+var productEntitlementsCollection = "product_entitlements"
+var entitlementsCollection = "entitlements"
+
+// ProductEntitlements describes what buying one unit of a product grants
+// and what it costs. DurationDays of zero means the entitlements never
+// expire. Products without a price, such as subscription plans, can't be
+// bought through /transaction.
+type ProductEntitlements struct {
+	ProductID    string   `bson:"_id" json:"productId"`
+	Price        float64  `bson:"price,omitempty" json:"price,omitempty"`
+	Entitlements []string `bson:"entitlements" json:"entitlements"`
+	DurationDays int      `bson:"duration_days,omitempty" json:"durationDays,omitempty"`
+}
+
+// Entitlement is a single right granted to a user by a purchase.
+type Entitlement struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        string             `bson:"user_id" json:"userId"`
+	Name          string             `bson:"name" json:"name"`
+	ProductID     string             `bson:"product_id" json:"productId"`
+	TransactionID string             `bson:"transaction_id" json:"transactionId"`
+	GrantedAt     time.Time          `bson:"granted_at" json:"grantedAt"`
+	ExpiresAt     *time.Time         `bson:"expires_at,omitempty" json:"expiresAt,omitempty"`
+	RevokedAt     *time.Time         `bson:"revoked_at,omitempty" json:"revokedAt,omitempty"`
+	RevokeReason  string             `bson:"revoke_reason,omitempty" json:"revokeReason,omitempty"`
+}
+
+// defaultProductEntitlements is seeded into an empty catalog on startup.
+var defaultProductEntitlements = []ProductEntitlements{
+	{ProductID: "premiumAccess", Price: 1000, Entitlements: []string{"premium_quizzes", "ad_free"}, DurationDays: 30},
+}
+
+// maxItemQuantity bounds a cart line, and with it how far a purchase can
+// push out an entitlement's expiry.
+const maxItemQuantity = 100
+
+// ErrInvalidCart is returned for a cart that doesn't match the catalog.
+var ErrInvalidCart = errors.New("invalid cart")
+
+// priceCart prices the cart from the catalog. The price the client shows
+// must match the catalog, so a stale or forged cart is rejected rather than
+// charged at a different amount.
+func priceCart(ctx context.Context, items []CartItem) ([]CartItem, float64, error) {
+	if len(items) == 0 {
+		return nil, 0, fmt.Errorf("%w: cart is empty", ErrInvalidCart)
+	}
+	catalog := client.Database(dbName).Collection(productEntitlementsCollection)
+
+	priced := make([]CartItem, 0, len(items))
+	var total int64
+	for _, item := range items {
+		if item.Quantity < 1 || item.Quantity > maxItemQuantity {
+			return nil, 0, fmt.Errorf("%w: quantity of %s must be between 1 and %d", ErrInvalidCart, item.ID, maxItemQuantity)
+		}
+		var product ProductEntitlements
+		err := catalog.FindOne(ctx, bson.M{"_id": item.ID}).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			return nil, 0, fmt.Errorf("%w: unknown product %s", ErrInvalidCart, item.ID)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if toCents(product.Price) <= 0 {
+			return nil, 0, fmt.Errorf("%w: %s is not sold separately", ErrInvalidCart, item.ID)
+		}
+		if toCents(item.Price) != toCents(product.Price) {
+			return nil, 0, fmt.Errorf("%w: price of %s is %.2f", ErrInvalidCart, item.ID, product.Price)
+		}
+
+		item.Price = product.Price
+		priced = append(priced, item)
+		total += toCents(product.Price) * int64(item.Quantity)
+	}
+	return priced, float64(total) / 100, nil
+}
+
+// chargeAmount is what paying for the transaction costs. A subscription
+// charge is priced by the subscription that is waiting for it; anything else
+// is priced from the catalog again, since /transactions/create stores
+// documents as sent.
+func chargeAmount(ctx context.Context, transaction Transaction) (float64, error) {
+	if transaction.SubscriptionID != "" {
+		subscriptionID, err := primitive.ObjectIDFromHex(transaction.SubscriptionID)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid subscription ID", ErrInvalidCart)
+		}
+		count, err := subscriptions().CountDocuments(ctx, bson.M{"_id": subscriptionID, "pending_transaction_id": transaction.ID.Hex()})
+		if err != nil {
+			return 0, err
+		}
+		if count == 0 {
+			return 0, fmt.Errorf("%w: no subscription is waiting for this charge", ErrInvalidCart)
+		}
+		return transaction.Total, nil
+	}
+	_, total, err := priceCart(ctx, transaction.CartItems)
+	return total, err
+}
+
+// validateEntitlementName rejects anything that would let a purchase grant privileges.
+func validateEntitlementName(name string) error {
+	lower := strings.ToLower(strings.TrimSpace(name))
+	if lower == "" {
+		return fmt.Errorf("entitlement name is required")
+	}
+	if lower == "admin" || strings.HasPrefix(lower, "role:") || strings.HasPrefix(lower, "admin") {
+		return fmt.Errorf("entitlement %q cannot be purchased", name)
+	}
+	return nil
+}
+
+func ensureEntitlementIndexes(ctx context.Context) error {
+	collection := client.Database(dbName).Collection(entitlementsCollection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}}},
+		{Keys: bson.D{{Key: "transaction_id", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return err
+	}
+
+	catalog := client.Database(dbName).Collection(productEntitlementsCollection)
+	for _, product := range defaultProductEntitlements {
+		_, err := catalog.UpdateOne(ctx,
+			bson.M{"_id": product.ProductID},
+			bson.M{"$setOnInsert": product},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grantEntitlements grants everything the purchased products define.
+// It is idempotent per transaction, so retrying a payment never duplicates grants.
+func grantEntitlements(ctx context.Context, transaction Transaction) error {
+	catalog := client.Database(dbName).Collection(productEntitlementsCollection)
+	collection := client.Database(dbName).Collection(entitlementsCollection)
+	now := time.Now()
+
+	for _, item := range transaction.CartItems {
+		var product ProductEntitlements
+		err := catalog.FindOne(ctx, bson.M{"_id": item.ID}).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			log.Printf("Product %s grants no entitlements", item.ID)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var expiresAt *time.Time
+		if product.DurationDays > 0 {
+			quantity := item.Quantity
+			if quantity < 1 {
+				quantity = 1
+			}
+			t := now.AddDate(0, 0, product.DurationDays*quantity)
+			expiresAt = &t
+		}
+
+		for _, name := range product.Entitlements {
+			if err := validateEntitlementName(name); err != nil {
+				log.Printf("Skipping entitlement for product %s: %v", product.ProductID, err)
+				continue
+			}
+
+			entitlement := Entitlement{
+				UserID:        transaction.Customer.ID,
+				Name:          name,
+				ProductID:     product.ProductID,
+				TransactionID: transaction.ID.Hex(),
+				GrantedAt:     now,
+				ExpiresAt:     expiresAt,
+			}
+			_, err := collection.UpdateOne(ctx,
+				bson.M{"transaction_id": entitlement.TransactionID, "name": name},
+				bson.M{"$setOnInsert": entitlement},
+				options.Update().SetUpsert(true),
+			)
+			if err != nil {
+				return fmt.Errorf("failed to grant %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// revokeEntitlements revokes every entitlement granted by a transaction.
+func revokeEntitlements(ctx context.Context, transactionID, reason string) error {
+	collection := client.Database(dbName).Collection(entitlementsCollection)
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"transaction_id": transactionID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoke_reason": reason}},
+	)
+	return err
+}
+
+// expireEntitlements marks entitlements past their expiry as revoked.
+func expireEntitlements(ctx context.Context) (int64, error) {
+	collection := client.Database(dbName).Collection(entitlementsCollection)
+	now := time.Now()
+	res, err := collection.UpdateMany(ctx,
+		bson.M{"revoked_at": bson.M{"$exists": false}, "expires_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"revoked_at": now, "revoke_reason": "expired"}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func runEntitlementExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		count, err := expireEntitlements(context.Background())
+		if err != nil {
+			log.Println("Error expiring entitlements:", err)
+			continue
+		}
+		if count > 0 {
+			log.Printf("Expired %d entitlements", count)
+		}
+	}
+}
+
+func activeEntitlementFilter(userID string) bson.M {
+	return bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"expires_at": bson.M{"$exists": false}},
+			{"expires_at": bson.M{"$gt": time.Now()}},
+		},
+	}
+}
+
+// ActiveEntitlement returns the user's active entitlement with the given name,
+// or nil when the user does not hold it.
+func ActiveEntitlement(ctx context.Context, userID, name string) (*Entitlement, error) {
+	if client == nil {
+		return nil, fmt.Errorf("transaction service is not initialized")
+	}
+
+	filter := activeEntitlementFilter(userID)
+	filter["name"] = name
+
+	var entitlement Entitlement
+	opts := options.FindOne().SetSort(bson.D{{Key: "expires_at", Value: -1}})
+	err := client.Database(dbName).Collection(entitlementsCollection).FindOne(ctx, filter, opts).Decode(&entitlement)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entitlement, nil
+}
+
+// HasEntitlement reports whether the user currently holds the named entitlement.
+func HasEntitlement(ctx context.Context, userID, name string) (bool, error) {
+	entitlement, err := ActiveEntitlement(ctx, userID, name)
+	return entitlement != nil, err
+}
+
+// authorizeUserAccess allows a user to act on their own data, and admins and
+// API keys on anyone's.
+func authorizeUserAccess(r *http.Request, userID string) (*auth.Claims, int, error) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	if !claims.CanActFor(userID) {
+		return nil, http.StatusForbidden, fmt.Errorf("access denied")
+	}
+	return claims, http.StatusOK, nil
+}
+
+func handleEntitlements(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if _, code, err := authorizeUserAccess(r, userID); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	cursor, err := client.Database(dbName).Collection(entitlementsCollection).Find(r.Context(), activeEntitlementFilter(userID))
+	if err != nil {
+		http.Error(w, "Failed to load entitlements", http.StatusInternalServerError)
+		log.Println("Error finding entitlements:", err)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	entitlements := []Entitlement{}
+	if err := cursor.All(r.Context(), &entitlements); err != nil {
+		http.Error(w, "Failed to load entitlements", http.StatusInternalServerError)
+		log.Println("Error decoding entitlements:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entitlements)
+}
+
+func handleEntitlementCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	name := r.URL.Query().Get("name")
+	if _, code, err := authorizeUserAccess(r, userID); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	entitlement, err := ActiveEntitlement(r.Context(), userID, name)
+	if err != nil {
+		http.Error(w, "Failed to check entitlement", http.StatusInternalServerError)
+		log.Println("Error checking entitlement:", err)
+		return
+	}
+
+	response := map[string]interface{}{"entitled": entitlement != nil}
+	if entitlement != nil && entitlement.ExpiresAt != nil {
+		response["expiresAt"] = entitlement.ExpiresAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func handleProductEntitlements(w http.ResponseWriter, r *http.Request) {
+	catalog := client.Database(dbName).Collection(productEntitlementsCollection)
+
+	switch r.Method {
+	case http.MethodGet:
+		cursor, err := catalog.Find(r.Context(), bson.M{})
+		if err != nil {
+			http.Error(w, "Failed to load products", http.StatusInternalServerError)
+			return
+		}
+		defer cursor.Close(r.Context())
+
+		products := []ProductEntitlements{}
+		if err := cursor.All(r.Context(), &products); err != nil {
+			http.Error(w, "Failed to load products", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(products)
+
+	case http.MethodPut:
+		claims, err := auth.FromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !claims.Can(auth.PermProductsManage) {
+			http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+
+		var product ProductEntitlements
+		if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		if product.ProductID == "" || product.DurationDays < 0 || product.Price < 0 {
+			http.Error(w, "productId is required and price and durationDays must not be negative", http.StatusBadRequest)
+			return
+		}
+		for _, name := range product.Entitlements {
+			if err := validateEntitlementName(name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var before interface{}
+		var existing ProductEntitlements
+		err = catalog.FindOne(r.Context(), bson.M{"_id": product.ProductID}).Decode(&existing)
+		if err == nil {
+			before = existing
+		} else if err != mongo.ErrNoDocuments {
+			http.Error(w, "Failed to load product", http.StatusInternalServerError)
+			log.Println("Error loading product entitlements:", err)
+			return
+		}
+
+		_, err = catalog.ReplaceOne(r.Context(), bson.M{"_id": product.ProductID}, product, options.Replace().SetUpsert(true))
+		if err != nil {
+			http.Error(w, "Failed to save product", http.StatusInternalServerError)
+			log.Println("Error saving product entitlements:", err)
+			return
+		}
+		auditLog.Record(audit.FromHTTP(r), "product.save", "product:"+product.ProductID, before, product)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(product)
+
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleRefund(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !claims.Can(auth.PermTransactionsRefund) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	transaction, ok := transactionFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if transaction.Status != "Completed" && transaction.Status != "Paid" {
+		http.Error(w, fmt.Sprintf("Transaction in status %q cannot be refunded", transaction.Status), http.StatusConflict)
+		return
+	}
+
+	if transaction.PaymentMethod == "wallet" {
+		if err := refundToWallet(r.Context(), transaction); err != nil {
+			http.Error(w, "Failed to refund transaction", http.StatusInternalServerError)
+			log.Println("Error refunding to wallet:", err)
+			return
+		}
+	} else {
+		if transaction.AuthorizationID != "" {
+			if err := gateway.Refund(r.Context(), transaction.AuthorizationID); err != nil {
+				http.Error(w, "Gateway refused the refund", http.StatusBadGateway)
+				log.Println("Error refunding payment:", err)
+				return
+			}
+		}
+		if err := markTransactionRefunded(r.Context(), transaction.ID); err != nil {
+			http.Error(w, "Failed to refund transaction", http.StatusInternalServerError)
+			log.Println("Error updating transaction status in MongoDB:", err)
+			return
+		}
+	}
+
+	if err := revokeEntitlements(r.Context(), transaction.ID.Hex(), "refunded"); err != nil {
+		http.Error(w, "Failed to revoke entitlements", http.StatusInternalServerError)
+		log.Println("Error revoking entitlements:", err)
+		return
+	}
+
+	auditTransaction(audit.FromHTTP(r), "transaction.refund", transaction.ID, &transaction)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Transaction Refunded"})
+}
@@ -0,0 +1,229 @@
+package transaction
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var gatewayPaymentsCollection = "gateway_payments"
+
+var gateway PaymentGateway
+
+// AuthorizationResult is the gateway's answer to an authorization request.
+type AuthorizationResult struct {
+	Approved        bool
+	AuthorizationID string
+	Last4           string
+	DeclineReason   string
+}
+
+// ErrAlreadyCaptured is returned by Void for an authorization whose funds
+// were already moved; those can only be refunded.
+var ErrAlreadyCaptured = errors.New("authorization was already captured")
+
+// PaymentGateway charges tokenized cards. Implementations resolve the token
+// through the vault themselves, so callers never handle card data.
+// An authorization only reserves funds; Capture moves them, Void releases an
+// uncaptured authorization and Refund returns captured funds. Voiding an
+// authorization that was already refunded does nothing.
+// AuthorizeRecurring is a merchant-initiated charge of a card the customer
+// stored earlier, so it does not need the CVV. Repeating an authorization with
+// the same non-empty idempotencyKey returns the first result instead of
+// reserving the funds again.
+type PaymentGateway interface {
+	Authorize(ctx context.Context, idempotencyKey, transactionID, cardToken string, amount float64) (*AuthorizationResult, error)
+	AuthorizeRecurring(ctx context.Context, idempotencyKey, transactionID, cardToken string, amount float64) (*AuthorizationResult, error)
+	Capture(ctx context.Context, authorizationID string) error
+	Void(ctx context.Context, authorizationID string) error
+	Refund(ctx context.Context, authorizationID string) error
+}
+
+// gatewayPayment is the gateway-side record of an operation, kept for reconciliation.
+type gatewayPayment struct {
+	TransactionID   string    `bson:"transaction_id"`
+	CardToken       string    `bson:"card_token"`
+	Last4           string    `bson:"last4"`
+	Amount          float64   `bson:"amount"`
+	Status          string    `bson:"status"`
+	AuthorizationID string    `bson:"authorization_id,omitempty"`
+	DeclineReason   string    `bson:"decline_reason,omitempty"`
+	IdempotencyKey  string    `bson:"idempotency_key,omitempty"`
+	CreatedAt       time.Time `bson:"created_at"`
+}
+
+// ensureGatewayIndexes makes idempotency keys unique, so two concurrent
+// authorizations with the same key can't both be recorded.
+func ensureGatewayIndexes(ctx context.Context) error {
+	_, err := client.Database(dbName).Collection(gatewayPaymentsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "idempotency_key", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	return err
+}
+
+// mockGateway approves every valid card except the well-known decline test number.
+type mockGateway struct {
+	vault   *CardVault
+	records *mongo.Collection
+}
+
+// declineTestCard always gets declined by the mock gateway.
+const declineTestCard = "4000000000000002"
+
+func newMockGateway(vault *CardVault, records *mongo.Collection) PaymentGateway {
+	return &mockGateway{vault: vault, records: records}
+}
+
+func (g *mockGateway) Authorize(ctx context.Context, idempotencyKey, transactionID, cardToken string, amount float64) (*AuthorizationResult, error) {
+	return g.authorize(ctx, idempotencyKey, transactionID, cardToken, amount, true)
+}
+
+func (g *mockGateway) AuthorizeRecurring(ctx context.Context, idempotencyKey, transactionID, cardToken string, amount float64) (*AuthorizationResult, error) {
+	return g.authorize(ctx, idempotencyKey, transactionID, cardToken, amount, false)
+}
+
+// previousAuthorization returns the result recorded for idempotencyKey, or nil
+// if no authorization was made with it yet.
+func (g *mockGateway) previousAuthorization(ctx context.Context, idempotencyKey string) (*AuthorizationResult, error) {
+	if idempotencyKey == "" {
+		return nil, nil
+	}
+	var record gatewayPayment
+	err := g.records.FindOne(ctx, bson.M{"idempotency_key": idempotencyKey}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &AuthorizationResult{
+		Approved:        record.AuthorizationID != "",
+		AuthorizationID: record.AuthorizationID,
+		Last4:           record.Last4,
+		DeclineReason:   record.DeclineReason,
+	}, nil
+}
+
+func (g *mockGateway) authorize(ctx context.Context, idempotencyKey, transactionID, cardToken string, amount float64, requireCVV bool) (*AuthorizationResult, error) {
+	if previous, err := g.previousAuthorization(ctx, idempotencyKey); previous != nil || err != nil {
+		return previous, err
+	}
+
+	card, err := g.vault.reveal(ctx, cardToken)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AuthorizationResult{Last4: card.Number[len(card.Number)-4:]}
+	switch {
+	case requireCVV && card.CVV == "":
+		result.DeclineReason = "cvv_required"
+	case card.Number == declineTestCard:
+		result.DeclineReason = "card_declined"
+	case amount <= 0:
+		result.DeclineReason = "invalid_amount"
+	default:
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		result.Approved = true
+		result.AuthorizationID = "auth_" + hex.EncodeToString(id)
+	}
+
+	status := "declined"
+	if result.Approved {
+		status = "authorized"
+	}
+	_, err = g.records.InsertOne(ctx, gatewayPayment{
+		TransactionID:   transactionID,
+		CardToken:       cardToken,
+		Last4:           result.Last4,
+		Amount:          amount,
+		Status:          status,
+		AuthorizationID: result.AuthorizationID,
+		DeclineReason:   result.DeclineReason,
+		IdempotencyKey:  idempotencyKey,
+		CreatedAt:       time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent call with the same key got there first
+		return g.previousAuthorization(ctx, idempotencyKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record authorization: %w", err)
+	}
+
+	// The CVV may only be used for one successful authorization; after a
+	// decline the customer can retry until it expires
+	if result.Approved && card.CVV != "" {
+		if err := g.vault.DiscardCVV(ctx, cardToken); err != nil {
+			log.Printf("Failed to discard CVV for %s: %v", cardToken, err)
+		}
+	}
+	return result, nil
+}
+
+// transition moves an authorization from one of the allowed states to the next.
+// Repeating a transition that already happened is not an error.
+func (g *mockGateway) transition(ctx context.Context, authorizationID string, from []string, to string) error {
+	res, err := g.records.UpdateOne(ctx,
+		bson.M{"authorization_id": authorizationID, "status": bson.M{"$in": from}},
+		bson.M{"$set": bson.M{"status": to, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	var record gatewayPayment
+	err = g.records.FindOne(ctx, bson.M{"authorization_id": authorizationID}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("unknown authorization %s", authorizationID)
+	}
+	if err != nil {
+		return err
+	}
+	if record.Status == to {
+		return nil
+	}
+	return fmt.Errorf("authorization %s is %s, cannot move to %s", authorizationID, record.Status, to)
+}
+
+func (g *mockGateway) Capture(ctx context.Context, authorizationID string) error {
+	return g.transition(ctx, authorizationID, []string{"authorized"}, "captured")
+}
+
+func (g *mockGateway) Void(ctx context.Context, authorizationID string) error {
+	err := g.transition(ctx, authorizationID, []string{"authorized"}, "voided")
+	if err == nil {
+		return nil
+	}
+
+	var record gatewayPayment
+	if findErr := g.records.FindOne(ctx, bson.M{"authorization_id": authorizationID}).Decode(&record); findErr != nil {
+		return err
+	}
+	switch record.Status {
+	case "refunded":
+		return nil
+	case "captured":
+		return ErrAlreadyCaptured
+	}
+	return err
+}
+
+func (g *mockGateway) Refund(ctx context.Context, authorizationID string) error {
+	return g.transition(ctx, authorizationID, []string{"captured"}, "refunded")
+}
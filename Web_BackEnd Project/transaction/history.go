@@ -0,0 +1,364 @@
+package transaction
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/auth"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+// historySortFields maps the public sort names to document fields.
+var historySortFields = map[string]string{
+	"created_at": "created_at",
+	"total":      "total",
+	"status":     "status",
+}
+
+// HistoryQuery is a parsed order-history request.
+type HistoryQuery struct {
+	Filter    bson.M
+	SortField string
+	Direction int
+	Limit     int
+	Cursor    *historyCursor
+}
+
+// historyCursor is the position after the last document of a page. It
+// records the sort it was issued for, since its value is meaningless under
+// another one.
+type historyCursor struct {
+	SortField string             `bson:"s"`
+	Direction int                `bson:"d"`
+	Value     interface{}        `bson:"v"`
+	ID        primitive.ObjectID `bson:"id"`
+}
+
+func encodeHistoryCursor(q *HistoryQuery, value interface{}, id primitive.ObjectID) (string, error) {
+	data, err := bson.Marshal(historyCursor{SortField: q.SortField, Direction: q.Direction, Value: value, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeHistoryCursor(s string) (*historyCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor historyCursor
+	if err := bson.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+func parseHistoryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// parseHistoryQuery builds the MongoDB filter for an order-history request.
+// Customers are always restricted to their own transactions; only callers
+// with transactions.read (or an API key with transactions:read) may search by
+// customer.
+func parseHistoryQuery(query url.Values, claims *auth.Claims) (*HistoryQuery, error) {
+	filter := bson.M{}
+
+	if claims.Can(auth.PermTransactionsRead) {
+		if email := query.Get("email"); email != "" {
+			filter["customer.email"] = strings.TrimSpace(email)
+		}
+		if customerID := query.Get("customer_id"); customerID != "" {
+			filter["customer.id"] = customerID
+		}
+	} else {
+		filter["customer.id"] = claims.UserID
+	}
+
+	if status := query.Get("status"); status != "" {
+		filter["status"] = status
+	}
+	if product := query.Get("product"); product != "" {
+		filter["$or"] = []bson.M{{"cartItems.id": product}, {"cartItems.name": product}}
+	}
+
+	created := bson.M{}
+	if from := query.Get("from"); from != "" {
+		t, err := parseHistoryTime(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from date")
+		}
+		created["$gte"] = t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := parseHistoryTime(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to date")
+		}
+		created["$lte"] = t
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	amount := bson.M{}
+	if minAmount := query.Get("min_amount"); minAmount != "" {
+		v, err := strconv.ParseFloat(minAmount, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_amount")
+		}
+		amount["$gte"] = v
+	}
+	if maxAmount := query.Get("max_amount"); maxAmount != "" {
+		v, err := strconv.ParseFloat(maxAmount, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_amount")
+		}
+		amount["$lte"] = v
+	}
+	if len(amount) > 0 {
+		filter["total"] = amount
+	}
+
+	q := &HistoryQuery{Filter: filter, SortField: "created_at", Direction: -1, Limit: defaultHistoryLimit}
+
+	if sortBy := query.Get("sort_by"); sortBy != "" {
+		field, ok := historySortFields[sortBy]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %q", sortBy)
+		}
+		q.SortField = field
+	}
+	if query.Get("sort_order") == "asc" {
+		q.Direction = 1
+	}
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 {
+			return nil, fmt.Errorf("invalid limit")
+		}
+		if l > maxHistoryLimit {
+			l = maxHistoryLimit
+		}
+		q.Limit = l
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		c, err := decodeHistoryCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.SortField != q.SortField || c.Direction != q.Direction {
+			return nil, fmt.Errorf("cursor does not match sort_by and sort_order")
+		}
+		q.Cursor = c
+	}
+
+	return q, nil
+}
+
+// pageFilter restricts the filter to documents after the cursor position.
+// Documents missing the sort field sort before every value, so a cursor on
+// such a document continues with the rest of them and then, ascending, with
+// all documents that have the field.
+func (q *HistoryQuery) pageFilter() bson.M {
+	if q.Cursor == nil {
+		return q.Filter
+	}
+
+	op := "$lt"
+	if q.Direction > 0 {
+		op = "$gt"
+	}
+	var after bson.M
+	switch {
+	case q.Cursor.Value != nil:
+		after = bson.M{"$or": []bson.M{
+			{q.SortField: bson.M{op: q.Cursor.Value}},
+			{q.SortField: q.Cursor.Value, "_id": bson.M{op: q.Cursor.ID}},
+		}}
+	case q.Direction > 0:
+		after = bson.M{"$or": []bson.M{
+			{q.SortField: bson.M{"$ne": nil}},
+			{q.SortField: nil, "_id": bson.M{op: q.Cursor.ID}},
+		}}
+	default:
+		after = bson.M{q.SortField: nil, "_id": bson.M{op: q.Cursor.ID}}
+	}
+	return bson.M{"$and": []bson.M{q.Filter, after}}
+}
+
+func (q *HistoryQuery) sort() bson.D {
+	return bson.D{{Key: q.SortField, Value: q.Direction}, {Key: "_id", Value: q.Direction}}
+}
+
+// FindHistoryPage returns one page of transactions and the cursor for the next one.
+func FindHistoryPage(ctx context.Context, collection *mongo.Collection, q *HistoryQuery) ([]bson.M, string, error) {
+	opts := options.Find().SetSort(q.sort()).SetLimit(int64(q.Limit + 1))
+	cursor, err := collection.Find(ctx, q.pageFilter(), opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	transactions := []bson.M{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, "", err
+	}
+
+	if len(transactions) <= q.Limit {
+		return transactions, "", nil
+	}
+
+	transactions = transactions[:q.Limit]
+	last := transactions[len(transactions)-1]
+	id, _ := last["_id"].(primitive.ObjectID)
+	next, err := encodeHistoryCursor(q, last[q.SortField], id)
+	if err != nil {
+		return nil, "", err
+	}
+	return transactions, next, nil
+}
+
+// backfillHistoryFields fills total and created_at on transactions created by
+// the Node app, which stores neither, so they can be filtered and sorted.
+func backfillHistoryFields(ctx context.Context, collection *mongo.Collection) (int64, error) {
+	totals, err := collection.UpdateMany(ctx, bson.M{"total": bson.M{"$exists": false}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"total": bson.M{"$sum": bson.M{"$map": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$cartItems", bson.A{}}},
+			"in": bson.M{"$multiply": bson.A{
+				bson.M{"$ifNull": bson.A{"$$this.price", 0}},
+				bson.M{"$ifNull": bson.A{"$$this.quantity", 0}},
+			}},
+		}}}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	dates, err := collection.UpdateMany(ctx, bson.M{"created_at": bson.M{"$exists": false}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"created_at": bson.M{"$ifNull": bson.A{"$createdAt", bson.M{"$toDate": "$_id"}}}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return totals.ModifiedCount + dates.ModifiedCount, nil
+}
+
+// runHistoryBackfill keeps backfilling transactions the Node app keeps creating.
+func runHistoryBackfill(collection *mongo.Collection, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := backfillHistoryFields(context.Background(), collection); err != nil {
+			log.Println("Error backfilling transaction history fields:", err)
+		}
+	}
+}
+
+// EnsureHistoryIndexes backfills the sortable fields and creates the indexes
+// backing the order-history filters.
+func EnsureHistoryIndexes(ctx context.Context, collection *mongo.Collection) error {
+	if _, err := backfillHistoryFields(ctx, collection); err != nil {
+		return err
+	}
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "customer.id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "customer.email", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "total", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "cartItems.id", Value: 1}}},
+	})
+	return err
+}
+
+// ExportHistory streams every matching transaction as CSV or JSON.
+func ExportHistory(ctx context.Context, w http.ResponseWriter, collection *mongo.Collection, q *HistoryQuery, format string) error {
+	cursor, err := collection.Find(ctx, q.Filter, options.Find().SetSort(q.sort()))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	stamp := time.Now().Format("20060102")
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=transactions_%s.json", stamp))
+		w.Write([]byte("["))
+		first := true
+		for cursor.Next(ctx) {
+			var transaction bson.M
+			if err := cursor.Decode(&transaction); err != nil {
+				return err
+			}
+			data, err := json.Marshal(transaction)
+			if err != nil {
+				return err
+			}
+			if !first {
+				w.Write([]byte(","))
+			}
+			first = false
+			w.Write(data)
+		}
+		w.Write([]byte("]"))
+		return cursor.Err()
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=transactions_%s.csv", stamp))
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "created_at", "status", "customer_id", "customer_email", "customer_name", "total", "products"})
+	for cursor.Next(ctx) {
+		var transaction Transaction
+		if err := cursor.Decode(&transaction); err != nil {
+			return err
+		}
+		total := transaction.Total
+		if total == 0 {
+			total = calculateTotal(transaction.CartItems)
+		}
+		var products []string
+		for _, item := range transaction.CartItems {
+			products = append(products, fmt.Sprintf("%s x%d", item.ID, item.Quantity))
+		}
+		writer.Write([]string{
+			transaction.ID.Hex(),
+			transaction.CreatedAt.Format(time.RFC3339),
+			transaction.Status,
+			transaction.Customer.ID,
+			transaction.Customer.Email,
+			transaction.Customer.Name,
+			strconv.FormatFloat(total, 'f', 2, 64),
+			strings.Join(products, "; "),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,592 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+	"web_backend_project/pkg/webhook"
+)
+
+var journalCollection = "ledger_journal"
+var ledgerAccountsCollection = "ledger_accounts"
+var ledgerChecksCollection = "ledger_checks"
+
+// Ledger accounts. Wallet accounts are per user: "wallet:<userID>".
+const (
+	AccountRevenue          = "revenue"
+	AccountRefunds          = "refunds"
+	AccountPaymentsClearing = "payments_clearing"
+)
+
+// ErrInsufficientFunds is returned when a wallet would go below zero.
+var ErrInsufficientFunds = errors.New("insufficient wallet balance")
+
+// WalletAccount returns the ledger account holding a user's wallet.
+func WalletAccount(userID string) string {
+	return "wallet:" + userID
+}
+
+// JournalLine moves Amount (in cents) into Account; negative amounts move money out.
+// A wallet's balance is the sum of its lines, so a positive wallet balance is
+// money we owe the user.
+type JournalLine struct {
+	Account string `bson:"account" json:"account"`
+	Amount  int64  `bson:"amount" json:"amount"`
+}
+
+// JournalEntry is an immutable, balanced set of lines. Entries are only ever
+// inserted; mistakes are corrected with a reversing entry.
+type JournalEntry struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind        string             `bson:"kind" json:"kind"`
+	Reference   string             `bson:"reference,omitempty" json:"reference,omitempty"`
+	Description string             `bson:"description" json:"description"`
+	Lines       []JournalLine      `bson:"lines" json:"lines"`
+	CreatedAt   time.Time          `bson:"created_at" json:"createdAt"`
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func validateJournalEntry(entry JournalEntry) error {
+	if len(entry.Lines) < 2 {
+		return fmt.Errorf("journal entry needs at least two lines")
+	}
+	var sum int64
+	for _, line := range entry.Lines {
+		if line.Account == "" || line.Amount == 0 {
+			return fmt.Errorf("journal lines need an account and a non-zero amount")
+		}
+		sum += line.Amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("journal entry does not balance: off by %d", sum)
+	}
+	return nil
+}
+
+// postJournalEntry must run inside a MongoDB transaction. It updates the
+// materialized account balances and refuses to overdraw any wallet.
+func postJournalEntry(sc mongo.SessionContext, entry JournalEntry) (primitive.ObjectID, error) {
+	if err := validateJournalEntry(entry); err != nil {
+		return primitive.NilObjectID, err
+	}
+	entry.CreatedAt = time.Now()
+
+	accounts := client.Database(dbName).Collection(ledgerAccountsCollection)
+	for _, line := range entry.Lines {
+		filter := bson.M{"_id": line.Account}
+		opts := options.Update().SetUpsert(true)
+		if strings.HasPrefix(line.Account, "wallet:") && line.Amount < 0 {
+			filter["balance"] = bson.M{"$gte": -line.Amount}
+			opts.SetUpsert(false)
+		}
+
+		res, err := accounts.UpdateOne(sc, filter, bson.M{
+			"$inc": bson.M{"balance": line.Amount},
+			"$set": bson.M{"updated_at": entry.CreatedAt},
+		}, opts)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		if res.MatchedCount == 0 && res.UpsertedCount == 0 {
+			return primitive.NilObjectID, ErrInsufficientFunds
+		}
+	}
+
+	res, err := client.Database(dbName).Collection(journalCollection).InsertOne(sc, entry)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return res.InsertedID.(primitive.ObjectID), nil
+}
+
+func ensureLedgerIndexes(ctx context.Context) error {
+	_, err := client.Database(dbName).Collection(journalCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "lines.account", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "reference", Value: 1}, {Key: "kind", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+	})
+	return err
+}
+
+// BalanceAsOf sums the journal for account up to and including asOf.
+func BalanceAsOf(ctx context.Context, account string, asOf time.Time) (int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"lines.account": account, "created_at": bson.M{"$lte": asOf}}}},
+		{{Key: "$unwind", Value: "$lines"}},
+		{{Key: "$match", Value: bson.M{"lines.account": account}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "balance": bson.M{"$sum": "$lines.amount"}}}},
+	}
+
+	cursor, err := client.Database(dbName).Collection(journalCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Balance int64 `bson:"balance"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return result[0].Balance, nil
+}
+
+// LedgerCheck is the outcome of a consistency check.
+type LedgerCheck struct {
+	RanAt              time.Time           `bson:"ran_at" json:"ranAt"`
+	OK                 bool                `bson:"ok" json:"ok"`
+	UnbalancedEntries  []string            `bson:"unbalanced_entries" json:"unbalancedEntries"`
+	TotalImbalance     int64               `bson:"total_imbalance" json:"totalImbalance"`
+	MismatchedAccounts map[string][2]int64 `bson:"mismatched_accounts" json:"mismatchedAccounts"`
+}
+
+// checkLedger verifies every entry balances, the journal sums to zero and
+// the materialized balances agree with the journal.
+func checkLedger(ctx context.Context) (*LedgerCheck, error) {
+	journal := client.Database(dbName).Collection(journalCollection)
+	check := &LedgerCheck{RanAt: time.Now(), UnbalancedEntries: []string{}, MismatchedAccounts: map[string][2]int64{}}
+
+	cursor, err := journal.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{"sum": bson.M{"$sum": "$lines.amount"}}}},
+		{{Key: "$match", Value: bson.M{"sum": bson.M{"$ne": 0}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var unbalanced []struct {
+		ID  primitive.ObjectID `bson:"_id"`
+		Sum int64              `bson:"sum"`
+	}
+	if err := cursor.All(ctx, &unbalanced); err != nil {
+		return nil, err
+	}
+	for _, entry := range unbalanced {
+		check.UnbalancedEntries = append(check.UnbalancedEntries, entry.ID.Hex())
+		check.TotalImbalance += entry.Sum
+	}
+
+	cursor, err = journal.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$unwind", Value: "$lines"}},
+		{{Key: "$group", Value: bson.M{"_id": "$lines.account", "balance": bson.M{"$sum": "$lines.amount"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var journalBalances []struct {
+		Account string `bson:"_id"`
+		Balance int64  `bson:"balance"`
+	}
+	if err := cursor.All(ctx, &journalBalances); err != nil {
+		return nil, err
+	}
+	expected := map[string]int64{}
+	for _, b := range journalBalances {
+		expected[b.Account] = b.Balance
+	}
+
+	cursor, err = client.Database(dbName).Collection(ledgerAccountsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var accounts []struct {
+		Account string `bson:"_id"`
+		Balance int64  `bson:"balance"`
+	}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		if account.Balance != expected[account.Account] {
+			check.MismatchedAccounts[account.Account] = [2]int64{account.Balance, expected[account.Account]}
+		}
+		delete(expected, account.Account)
+	}
+	for account, balance := range expected {
+		if balance != 0 {
+			check.MismatchedAccounts[account] = [2]int64{0, balance}
+		}
+	}
+
+	check.OK = len(check.UnbalancedEntries) == 0 && len(check.MismatchedAccounts) == 0
+	if _, err := client.Database(dbName).Collection(ledgerChecksCollection).InsertOne(ctx, check); err != nil {
+		return check, err
+	}
+	return check, nil
+}
+
+// untilDailyRun returns how long to wait for the next hour:00 UTC.
+func untilDailyRun(hour int) time.Duration {
+	now := time.Now().UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next.Sub(now)
+}
+
+// runNightlyLedgerCheck runs checkLedger once a day at LEDGER_CHECK_HOUR (UTC).
+func runNightlyLedgerCheck() {
+	hour := 2
+	fmt.Sscanf(getEnv("LEDGER_CHECK_HOUR", "2"), "%d", &hour)
+
+	for {
+		time.Sleep(untilDailyRun(hour))
+
+		check, err := checkLedger(context.Background())
+		if err != nil {
+			log.Println("Error checking ledger:", err)
+			continue
+		}
+		if !check.OK {
+			log.Printf("Ledger inconsistency: %d unbalanced entries, %d mismatched accounts",
+				len(check.UnbalancedEntries), len(check.MismatchedAccounts))
+		}
+	}
+}
+
+const walletCheckoutSaga = "wallet_checkout"
+
+// walletCheckoutDefinition pays a pending transaction from the customer's
+// wallet. It shares every step with checkout except the payment itself.
+func walletCheckoutDefinition() SagaDefinition {
+	return SagaDefinition{
+		Name: walletCheckoutSaga,
+		Steps: []SagaStep{
+			{Name: "reserve_transaction", Action: reserveTransaction, Compensate: releaseTransaction},
+			{Name: "debit_wallet", Action: debitWallet, Compensate: creditWalletBack},
+			{Name: "generate_receipt", Action: generateReceiptStep},
+			{Name: "grant_entitlements", Action: grantEntitlementsStep, Compensate: revokeEntitlementsStep},
+			{Name: "notify_customer", Action: notifyCustomerStep},
+		},
+	}
+}
+
+// debitWallet books the purchase, marks the transaction Paid and queues the
+// paid webhook in one MongoDB transaction.
+func debitWallet(ctx context.Context, data map[string]string) error {
+	id, err := sagaTransactionID(data)
+	if err != nil {
+		return err
+	}
+	transaction, err := findTransaction(ctx, id)
+	if err != nil {
+		return err
+	}
+	total, err := chargeAmount(ctx, transaction)
+	if err != nil {
+		return err
+	}
+	amount := toCents(total)
+	// A non-positive amount would turn the debit into a credit to the wallet
+	if amount <= 0 {
+		return fmt.Errorf("transaction total must be positive")
+	}
+
+	return withMongoTransaction(ctx, func(sc mongo.SessionContext) error {
+		ok, err := setTransactionStatus(sc, id, []string{"Processing"}, bson.M{"status": "Paid", "payment_method": "wallet", "total": total})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("transaction is not being processed")
+		}
+		if _, err := postJournalEntry(sc, JournalEntry{
+			Kind:        "purchase",
+			Reference:   id.Hex(),
+			Description: "Wallet payment for transaction " + id.Hex(),
+			Lines: []JournalLine{
+				{Account: WalletAccount(transaction.Customer.ID), Amount: -amount},
+				{Account: AccountRevenue, Amount: amount},
+			},
+		}); err != nil {
+			return err
+		}
+		return enqueueTransactionWebhook(sc, webhook.EventTransactionPaid, id)
+	})
+}
+
+func creditWalletBack(ctx context.Context, data map[string]string) error {
+	id, err := sagaTransactionID(data)
+	if err != nil {
+		return err
+	}
+	transaction, err := findTransaction(ctx, id)
+	if err != nil {
+		return err
+	}
+	return refundToWallet(ctx, transaction)
+}
+
+// refundToWallet returns a wallet payment to the customer's wallet and marks
+// the transaction Refunded. Refunding twice is a no-op.
+func refundToWallet(ctx context.Context, transaction Transaction) error {
+	amount := toCents(calculateTotal(transaction.CartItems))
+
+	return withMongoTransaction(ctx, func(sc mongo.SessionContext) error {
+		ok, err := setTransactionStatus(sc, transaction.ID, []string{"Paid", "Completed"}, bson.M{"status": "Refunded"})
+		if err != nil || !ok {
+			return err
+		}
+		_, err = postJournalEntry(sc, JournalEntry{
+			Kind:        "refund",
+			Reference:   transaction.ID.Hex(),
+			Description: "Refund of transaction " + transaction.ID.Hex(),
+			Lines: []JournalLine{
+				{Account: AccountRefunds, Amount: -amount},
+				{Account: WalletAccount(transaction.Customer.ID), Amount: amount},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		return enqueueTransactionWebhook(sc, webhook.EventTransactionRefunded, transaction.ID)
+	})
+}
+
+type walletTopUpRequest struct {
+	CardToken string  `json:"cardToken"`
+	Amount    float64 `json:"amount"`
+}
+
+func handleWalletTopUp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var req walletTopUpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CardToken == "" || toCents(req.Amount) <= 0 {
+		http.Error(w, "cardToken and a positive amount are required", http.StatusBadRequest)
+		return
+	}
+	amount := toCents(req.Amount)
+	reference := "topup:" + primitive.NewObjectID().Hex()
+
+	result, err := gateway.Authorize(r.Context(), reference, reference, req.CardToken, float64(amount)/100)
+	if err != nil {
+		http.Error(w, "Failed to process payment", http.StatusBadGateway)
+		log.Printf("Error authorizing top-up with token %s: %v", req.CardToken, err)
+		return
+	}
+	if !result.Approved {
+		http.Error(w, "Payment declined: "+result.DeclineReason, http.StatusPaymentRequired)
+		return
+	}
+	if err := gateway.Capture(r.Context(), result.AuthorizationID); err != nil {
+		gateway.Void(r.Context(), result.AuthorizationID)
+		http.Error(w, "Failed to capture payment", http.StatusBadGateway)
+		log.Println("Error capturing top-up:", err)
+		return
+	}
+
+	err = withMongoTransaction(r.Context(), func(sc mongo.SessionContext) error {
+		_, err := postJournalEntry(sc, JournalEntry{
+			Kind:        "topup",
+			Reference:   result.AuthorizationID,
+			Description: fmt.Sprintf("Wallet top-up by card ending %s", result.Last4),
+			Lines: []JournalLine{
+				{Account: AccountPaymentsClearing, Amount: -amount},
+				{Account: WalletAccount(claims.UserID), Amount: amount},
+			},
+		})
+		return err
+	})
+	if err != nil {
+		// The money was taken but not booked: give it back
+		if refundErr := gateway.Refund(r.Context(), result.AuthorizationID); refundErr != nil {
+			log.Printf("Top-up %s captured but neither booked nor refunded: %v", result.AuthorizationID, refundErr)
+		}
+		http.Error(w, "Failed to credit wallet", http.StatusInternalServerError)
+		log.Println("Error posting top-up:", err)
+		return
+	}
+
+	auditLog.Record(audit.FromHTTP(r), "wallet.topup", WalletAccount(claims.UserID), nil, bson.M{
+		"amount":    float64(amount) / 100,
+		"reference": result.AuthorizationID,
+	})
+
+	balance, _ := BalanceAsOf(r.Context(), WalletAccount(claims.UserID), time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"balance": float64(balance) / 100})
+}
+
+func handleWalletBalance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if _, code, err := authorizeUserAccess(r, userID); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	asOf := time.Now()
+	if value := r.URL.Query().Get("as_of"); value != "" {
+		t, err := parseHistoryTime(value)
+		if err != nil {
+			http.Error(w, "Invalid as_of date", http.StatusBadRequest)
+			return
+		}
+		asOf = t
+	}
+
+	balance, err := BalanceAsOf(r.Context(), WalletAccount(userID), asOf)
+	if err != nil {
+		http.Error(w, "Failed to compute balance", http.StatusInternalServerError)
+		log.Println("Error computing balance:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"userId":  userID,
+		"asOf":    asOf,
+		"balance": float64(balance) / 100,
+	})
+}
+
+func handleWalletPay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		TransactionID string `json:"transactionID"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	transactionID, err := primitive.ObjectIDFromHex(req.TransactionID)
+	if err != nil {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	transaction, err := findTransaction(r.Context(), transactionID)
+	if err != nil {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	if code, err := authorizeTransactionAccess(r, transaction); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	state, err := orchestrator.Start(r.Context(), walletCheckoutSaga, map[string]string{
+		"transaction_id": transactionID.Hex(),
+	})
+	if err != nil {
+		http.Error(w, "Failed to process payment", http.StatusInternalServerError)
+		log.Println("Error running wallet checkout saga:", err)
+		return
+	}
+	auditTransaction(audit.FromHTTP(r), "transaction.pay", transactionID, &transaction)
+	if state.Status != SagaCompleted {
+		http.Error(w, "Failed to process payment: "+state.Error, http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Transaction Completed"})
+}
+
+func handleJournal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !claims.Can(auth.PermTransactionsRead) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	filter := bson.M{}
+	if account := r.URL.Query().Get("account"); account != "" {
+		filter["lines.account"] = account
+	}
+	if reference := r.URL.Query().Get("reference"); reference != "" {
+		filter["reference"] = reference
+	}
+
+	cursor, err := client.Database(dbName).Collection(journalCollection).Find(r.Context(), filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(500))
+	if err != nil {
+		http.Error(w, "Failed to load journal", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	entries := []JournalEntry{}
+	if err := cursor.All(r.Context(), &entries); err != nil {
+		http.Error(w, "Failed to load journal", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func handleLedgerCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !claims.Can(auth.PermTransactionsRead) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	check, err := checkLedger(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to check ledger", http.StatusInternalServerError)
+		log.Println("Error checking ledger:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(check)
+}
@@ -0,0 +1,331 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var outboxCollection = "outbox"
+var processedEventsCollection = "processed_events"
+var emailLogCollection = "email_log"
+
+var natsConn *nats.Conn
+var jetStream nats.JetStreamContext
+
+const (
+	outboxStream        = "OUTBOX"
+	outboxSubjectPrefix = "outbox."
+	outboxClaimTTL      = 30 * time.Second
+	outboxMaxDeliver    = 10
+)
+
+// Event types written to the outbox. Payment itself is not an outbox event:
+// the checkout sagas generate the receipt and grant entitlements as steps,
+// and partners learn about it from the transaction.paid webhook.
+const (
+	EventReceiptGenerated = "receipt.generated"
+)
+
+// OutboxEvent is a side effect recorded in the same MongoDB transaction as
+// the state change that caused it. The relay publishes it to NATS afterwards.
+type OutboxEvent struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type         string             `bson:"type" json:"type"`
+	AggregateID  string             `bson:"aggregate_id" json:"aggregate_id"`
+	Payload      map[string]string  `bson:"payload,omitempty" json:"payload,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	PublishedAt  *time.Time         `bson:"published_at,omitempty" json:"-"`
+	ClaimedUntil *time.Time         `bson:"claimed_until,omitempty" json:"-"`
+}
+
+// insertOutboxEvent must be called with the session context of the
+// transaction that performs the matching state change. The one exception is
+// notifyCustomerStep, which catches up sagas started before that was so.
+func insertOutboxEvent(ctx context.Context, eventType, aggregateID string, payload map[string]string) error {
+	event := OutboxEvent{
+		Type:        eventType,
+		AggregateID: aggregateID,
+		Payload:     payload,
+		CreatedAt:   time.Now(),
+	}
+	_, err := client.Database(dbName).Collection(outboxCollection).InsertOne(ctx, event)
+	return err
+}
+
+// withMongoTransaction runs fn inside a MongoDB multi-document transaction.
+func withMongoTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+func setupOutbox(ctx context.Context) error {
+	var err error
+	natsConn, err = nats.Connect(getEnv("NATS_URL", nats.DefaultURL))
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	jetStream, err = natsConn.JetStream()
+	if err != nil {
+		return fmt.Errorf("failed to open JetStream context: %w", err)
+	}
+
+	if _, err := jetStream.StreamInfo(outboxStream); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = jetStream.AddStream(&nats.StreamConfig{
+			Name:       outboxStream,
+			Subjects:   []string{outboxSubjectPrefix + ">"},
+			Storage:    nats.FileStorage,
+			Duplicates: 10 * time.Minute,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create outbox stream: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to look up outbox stream: %w", err)
+	}
+
+	_, err = client.Database(dbName).Collection(outboxCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "published_at", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "aggregate_id", Value: 1}, {Key: "type", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Database(dbName).Collection(processedEventsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "event_id", Value: 1}, {Key: "consumer", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// claimOutboxEvent leases the oldest unpublished event so that several
+// relays can run side by side without publishing the same event concurrently.
+func claimOutboxEvent(ctx context.Context) (*OutboxEvent, error) {
+	now := time.Now()
+	until := now.Add(outboxClaimTTL)
+	filter := bson.M{
+		"published_at": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"claimed_until": bson.M{"$exists": false}},
+			{"claimed_until": bson.M{"$lt": now}},
+		},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var event OutboxEvent
+	err := client.Database(dbName).Collection(outboxCollection).
+		FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"claimed_until": until}}, opts).
+		Decode(&event)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &event, err
+}
+
+// relayOutbox publishes pending events until the outbox is empty. An event is
+// marked published only after JetStream has acknowledged it; the event ID is
+// used as the message ID so a republish after a crash is deduplicated.
+func relayOutbox(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		event, err := claimOutboxEvent(ctx)
+		if err != nil || event == nil {
+			return published, err
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return published, err
+		}
+
+		if _, err := jetStream.Publish(outboxSubjectPrefix+event.Type, data, nats.MsgId(event.ID.Hex())); err != nil {
+			return published, fmt.Errorf("failed to publish event %s: %w", event.ID.Hex(), err)
+		}
+
+		_, err = client.Database(dbName).Collection(outboxCollection).UpdateOne(ctx,
+			bson.M{"_id": event.ID},
+			bson.M{"$set": bson.M{"published_at": time.Now()}, "$unset": bson.M{"claimed_until": ""}},
+		)
+		if err != nil {
+			return published, err
+		}
+		published++
+	}
+}
+
+func runOutboxRelay(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		count, err := relayOutbox(context.Background())
+		if err != nil {
+			log.Println("Error relaying outbox:", err)
+		}
+		if count > 0 {
+			log.Printf("Relayed %d outbox events", count)
+		}
+	}
+}
+
+// subscribeOutboxConsumer attaches an idempotent consumer to an event type.
+// Each consumer has its own durable, so every consumer sees every event at
+// least once; processed events are remembered per consumer and skipped.
+func subscribeOutboxConsumer(consumer, eventType string, handle func(ctx context.Context, event OutboxEvent) error) error {
+	_, err := jetStream.QueueSubscribe(outboxSubjectPrefix+eventType, consumer, func(msg *nats.Msg) {
+		var event OutboxEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			log.Printf("Consumer %s dropping malformed event: %v", consumer, err)
+			msg.Term()
+			return
+		}
+
+		ctx := context.Background()
+		processed := client.Database(dbName).Collection(processedEventsCollection)
+
+		count, err := processed.CountDocuments(ctx, bson.M{"event_id": event.ID, "consumer": consumer})
+		if err != nil {
+			log.Printf("Consumer %s failed to check event %s: %v", consumer, event.ID.Hex(), err)
+			msg.NakWithDelay(5 * time.Second)
+			return
+		}
+		if count > 0 {
+			msg.Ack()
+			return
+		}
+
+		if err := handle(ctx, event); err != nil {
+			log.Printf("Consumer %s failed on event %s: %v", consumer, event.ID.Hex(), err)
+			msg.NakWithDelay(10 * time.Second)
+			return
+		}
+
+		_, err = processed.InsertOne(ctx, bson.M{"event_id": event.ID, "consumer": consumer, "processed_at": time.Now()})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			log.Printf("Consumer %s failed to record event %s: %v", consumer, event.ID.Hex(), err)
+		}
+		msg.Ack()
+	},
+		nats.Durable(consumer),
+		nats.ManualAck(),
+		nats.AckWait(time.Minute),
+		nats.MaxDeliver(outboxMaxDeliver),
+		nats.DeliverAll(),
+	)
+	return err
+}
+
+func startOutboxConsumers() error {
+	consumers := []struct {
+		name      string
+		eventType string
+		handle    func(ctx context.Context, event OutboxEvent) error
+	}{
+		{"receipt-emails", EventReceiptGenerated, consumeSendReceiptEmail},
+	}
+
+	for _, c := range consumers {
+		if err := subscribeOutboxConsumer(c.name, c.eventType, c.handle); err != nil {
+			return fmt.Errorf("failed to start consumer %s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// findCustomer fetches the user's email from MongoDB using the customer ID.
+func findCustomer(ctx context.Context, userID string) (Customer, error) {
+	var customer Customer
+	customerID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return customer, fmt.Errorf("invalid customer ID: %w", err)
+	}
+	err = client.Database(dbName).Collection(usersCollection).FindOne(ctx, bson.M{"_id": customerID}).Decode(&customer)
+	if err != nil {
+		return customer, fmt.Errorf("failed to find customer: %w", err)
+	}
+	return customer, nil
+}
+
+func eventTransaction(ctx context.Context, event OutboxEvent) (Transaction, error) {
+	id, err := primitive.ObjectIDFromHex(event.AggregateID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return findTransaction(ctx, id)
+}
+
+// consumeSendReceiptEmail sends the receipt once per transaction; the email
+// log doubles as the idempotency record.
+func consumeSendReceiptEmail(ctx context.Context, event OutboxEvent) error {
+	transaction, err := eventTransaction(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	emails := client.Database(dbName).Collection(emailLogCollection)
+	count, err := emails.CountDocuments(ctx, bson.M{"kind": "receipt", "transaction_id": event.AggregateID})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	customer, err := findCustomer(ctx, transaction.Customer.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := sendReceiptEmail(customer.Email, transaction.ID.Hex()); err != nil {
+		return err
+	}
+
+	_, err = emails.InsertOne(ctx, bson.M{
+		"kind":           "receipt",
+		"transaction_id": event.AggregateID,
+		"user_id":        transaction.Customer.ID,
+		"to":             customer.Email,
+		"subject":        "Your Fiscal Receipt",
+		"sent_at":        time.Now(),
+	})
+	return err
+}
+
+// enqueueTransactionWebhook queues a webhook for partners. Called with the
+// session of the status change, so the event is only sent if the change commits.
+func enqueueTransactionWebhook(ctx context.Context, event string, id primitive.ObjectID) error {
+	transaction, err := findTransaction(ctx, id)
+	if err != nil {
+		return err
+	}
+	return webhooks.Enqueue(ctx, event, transaction.Customer.ID, map[string]interface{}{
+		"transaction_id":  transaction.ID.Hex(),
+		"status":          transaction.Status,
+		"total":           calculateTotal(transaction.CartItems),
+		"customer_id":     transaction.Customer.ID,
+		"payment_method":  transaction.PaymentMethod,
+		"subscription_id": transaction.SubscriptionID,
+		"updated_at":      transaction.UpdatedAt,
+	})
+}
@@ -0,0 +1,59 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errHistoryNotConnected is returned before StartTransactionService connects
+var errHistoryNotConnected = errors.New("order history store is not connected")
+
+// orderHistory is the order history store served by StartTransactionService
+func orderHistory() (*mongo.Collection, error) {
+	if client == nil {
+		return nil, errHistoryNotConnected
+	}
+	return client.Database(dbName).Collection(transactionCollection), nil
+}
+
+// ExportUserTransactions returns every order of the user for a personal data
+// export.
+func ExportUserTransactions(ctx context.Context, userID string) ([]bson.M, error) {
+	collection, err := orderHistory()
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Find(ctx, bson.M{"customer.id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	orders := []bson.M{}
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// EraseUserTransactions anonymizes the customer on the user's orders.
+// Amounts, items and dates stay: financial records must be kept.
+func EraseUserTransactions(ctx context.Context, userID string) error {
+	collection, err := orderHistory()
+	if err != nil {
+		return err
+	}
+	_, err = collection.UpdateMany(ctx,
+		bson.M{"customer.id": userID},
+		bson.M{"$set": bson.M{
+			"customer.name":  anonymizedCustomerName,
+			"customer.email": "",
+			"updated_at":     time.Now(),
+		}},
+	)
+	return err
+}
@@ -0,0 +1,151 @@
+package transaction
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrReceiptNotFound is returned by a ReceiptStore when no receipt is stored for a transaction.
+var ErrReceiptNotFound = errors.New("receipt not found")
+
+// ReceiptStore persists generated fiscal receipts so they survive restarts
+// and can be streamed back over HTTP.
+type ReceiptStore interface {
+	Save(ctx context.Context, transactionID string, pdf []byte) error
+	Open(ctx context.Context, transactionID string) (io.ReadCloser, error)
+	Exists(ctx context.Context, transactionID string) (bool, error)
+}
+
+func receiptFileName(transactionID string) string {
+	return fmt.Sprintf("receipt_%s.pdf", transactionID)
+}
+
+type fileReceiptStore struct {
+	dir string
+}
+
+// NewFileReceiptStore stores receipts as files in dir.
+func NewFileReceiptStore(dir string) (ReceiptStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create receipt directory: %w", err)
+	}
+	return &fileReceiptStore{dir: dir}, nil
+}
+
+func (s *fileReceiptStore) path(transactionID string) string {
+	return filepath.Join(s.dir, receiptFileName(filepath.Base(transactionID)))
+}
+
+func (s *fileReceiptStore) Save(ctx context.Context, transactionID string, pdf []byte) error {
+	tmp := s.path(transactionID) + ".tmp"
+	if err := os.WriteFile(tmp, pdf, 0o640); err != nil {
+		return fmt.Errorf("failed to write receipt: %w", err)
+	}
+	return os.Rename(tmp, s.path(transactionID))
+}
+
+func (s *fileReceiptStore) Open(ctx context.Context, transactionID string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(transactionID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrReceiptNotFound
+	}
+	return f, err
+}
+
+func (s *fileReceiptStore) Exists(ctx context.Context, transactionID string) (bool, error) {
+	_, err := os.Stat(s.path(transactionID))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type gridFSReceiptStore struct {
+	bucket *gridfs.Bucket
+	files  *mongo.Collection
+}
+
+// NewGridFSReceiptStore stores receipts in a MongoDB GridFS bucket.
+func NewGridFSReceiptStore(db *mongo.Database, bucketName string) (ReceiptStore, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GridFS bucket: %w", err)
+	}
+	return &gridFSReceiptStore{
+		bucket: bucket,
+		files:  db.Collection(bucketName + ".files"),
+	}, nil
+}
+
+func (s *gridFSReceiptStore) Save(ctx context.Context, transactionID string, pdf []byte) error {
+	name := receiptFileName(transactionID)
+
+	// Keep a single revision per transaction: upload the new file first,
+	// then drop the older revisions.
+	var previous []bson.M
+	cursor, err := s.files.Find(ctx, bson.M{"filename": name})
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &previous); err != nil {
+		return err
+	}
+
+	opts := options.GridFSUpload().SetMetadata(bson.M{"transaction_id": transactionID, "content_type": "application/pdf"})
+	if _, err := s.bucket.UploadFromStream(name, bytes.NewReader(pdf), opts); err != nil {
+		return fmt.Errorf("failed to upload receipt: %w", err)
+	}
+
+	for _, file := range previous {
+		if err := s.bucket.Delete(file["_id"]); err != nil {
+			return fmt.Errorf("failed to remove old receipt revision: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *gridFSReceiptStore) Open(ctx context.Context, transactionID string) (io.ReadCloser, error) {
+	stream, err := s.bucket.OpenDownloadStreamByName(receiptFileName(transactionID))
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrReceiptNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (s *gridFSReceiptStore) Exists(ctx context.Context, transactionID string) (bool, error) {
+	count, err := s.files.CountDocuments(ctx, bson.M{"filename": receiptFileName(transactionID)})
+	return count > 0, err
+}
+
+// newReceiptStore picks the backend configured by RECEIPT_STORE ("gridfs" or "fs").
+func newReceiptStore(db *mongo.Database) (ReceiptStore, error) {
+	switch getEnv("RECEIPT_STORE", "gridfs") {
+	case "fs":
+		return NewFileReceiptStore(getEnv("RECEIPT_DIR", "receipts"))
+	case "gridfs":
+		return NewGridFSReceiptStore(db, "receipts")
+	default:
+		return nil, fmt.Errorf("unknown RECEIPT_STORE %q", os.Getenv("RECEIPT_STORE"))
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,226 @@
+package transaction
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+)
+
+var receiptStore ReceiptStore
+
+// receiptLinkTTL is how long the signed receipt link sent by email stays valid.
+const receiptLinkTTL = 7 * 24 * time.Hour
+
+func receiptURL(transactionID string) string {
+	return fmt.Sprintf("%s/receipt?id=%s", serviceBaseURL(), transactionID)
+}
+
+// serviceBaseURL is the public address of this service, used in emailed links.
+func serviceBaseURL() string {
+	return getEnv("RECEIPT_BASE_URL", "http://localhost:8081")
+}
+
+func renderFiscalReceipt(transaction Transaction) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	pdf.SetFont("Arial", "B", 16)
+
+	// Company/Project name
+	pdf.Cell(40, 10, "Company/Project Name: XYZ Inc.")
+	pdf.Ln(12)
+
+	// Transaction ID
+	pdf.Cell(40, 10, fmt.Sprintf("Transaction ID: %s", transaction.ID.Hex()))
+	pdf.Ln(12)
+
+	// Order date and time
+	pdf.Cell(40, 10, fmt.Sprintf("Order Date: %s", transaction.CreatedAt.Format("2006-01-02 15:04:05")))
+	pdf.Ln(12)
+
+	// Items
+	pdf.Cell(40, 10, "Items:")
+	pdf.Ln(12)
+
+	for _, item := range transaction.CartItems {
+		pdf.Cell(40, 10, fmt.Sprintf("Product: %s - Price: $%.2f - Quantity: %d", item.Name, item.Price, item.Quantity))
+		pdf.Ln(6)
+	}
+
+	// Total amount
+	pdf.Cell(40, 10, fmt.Sprintf("Total Amount: $%.2f", calculateTotal(transaction.CartItems)))
+	pdf.Ln(12)
+
+	// Customer's full name
+	pdf.Cell(40, 10, fmt.Sprintf("Customer: %s", transaction.Customer.Name))
+	pdf.Ln(12)
+
+	// Payment method, only the last four digits are ever stored
+	if transaction.CardLast4 != "" {
+		pdf.Cell(40, 10, fmt.Sprintf("Payment Method: **** **** **** %s", transaction.CardLast4))
+		pdf.Ln(12)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// regenerateReceipt renders the receipt from the stored transaction data and
+// replaces whatever the receipt store currently holds for it.
+func regenerateReceipt(ctx context.Context, transaction Transaction) ([]byte, error) {
+	pdf, err := renderFiscalReceipt(transaction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render receipt: %w", err)
+	}
+	if err := receiptStore.Save(ctx, transaction.ID.Hex(), pdf); err != nil {
+		return nil, fmt.Errorf("failed to store receipt: %w", err)
+	}
+	return pdf, nil
+}
+
+// loadReceipt returns the stored receipt, regenerating it when the store has lost it.
+func loadReceipt(ctx context.Context, transaction Transaction) (io.ReadCloser, error) {
+	rc, err := receiptStore.Open(ctx, transaction.ID.Hex())
+	if errors.Is(err, ErrReceiptNotFound) {
+		pdf, err := regenerateReceipt(ctx, transaction)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(pdf)), nil
+	}
+	return rc, err
+}
+
+func findTransaction(ctx context.Context, id primitive.ObjectID) (Transaction, error) {
+	var transaction Transaction
+	err := client.Database(dbName).Collection(transactionCollection).FindOne(ctx, bson.M{"_id": id}).Decode(&transaction)
+	return transaction, err
+}
+
+// authorizeTransactionAccess allows the transaction owner and callers with
+// transactions.read (for API keys, the transactions:read scope).
+func authorizeTransactionAccess(r *http.Request, transaction Transaction) (int, error) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	owner := !claims.IsAPIKey() && claims.UserID != "" && claims.UserID == transaction.Customer.ID
+	if !owner && !claims.Can(auth.PermTransactionsRead) {
+		return http.StatusForbidden, fmt.Errorf("access denied")
+	}
+	return http.StatusOK, nil
+}
+
+func transactionFromRequest(w http.ResponseWriter, r *http.Request) (Transaction, bool) {
+	transactionID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return Transaction{}, false
+	}
+
+	transaction, err := findTransaction(r.Context(), transactionID)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return Transaction{}, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to load transaction", http.StatusInternalServerError)
+		log.Println("Error finding transaction:", err)
+		return Transaction{}, false
+	}
+	return transaction, true
+}
+
+func handleReceiptDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Signed links from the receipt email carry their own authorization
+	if auth.IsSignedLink(r.URL) {
+		if err := auth.VerifyLink(auth.Secret(), r.URL); err != nil {
+			http.Error(w, "Invalid or expired link", http.StatusForbidden)
+			return
+		}
+	}
+
+	transaction, ok := transactionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !auth.IsSignedLink(r.URL) {
+		if code, err := authorizeTransactionAccess(r, transaction); err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+	}
+
+	if transaction.ReceiptURL == "" {
+		http.Error(w, "Receipt not available", http.StatusNotFound)
+		return
+	}
+
+	rc, err := loadReceipt(r.Context(), transaction)
+	if err != nil {
+		http.Error(w, "Failed to load receipt", http.StatusInternalServerError)
+		log.Println("Error loading receipt:", err)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", receiptFileName(transaction.ID.Hex())))
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Println("Error streaming receipt:", err)
+	}
+}
+
+func handleReceiptRegenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	transaction, ok := transactionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if code, err := authorizeTransactionAccess(r, transaction); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	if transaction.ReceiptURL == "" {
+		http.Error(w, "Transaction has not been paid", http.StatusConflict)
+		return
+	}
+
+	if _, err := regenerateReceipt(r.Context(), transaction); err != nil {
+		http.Error(w, "Failed to regenerate receipt", http.StatusInternalServerError)
+		log.Println("Error regenerating receipt:", err)
+		return
+	}
+	auditTransaction(audit.FromHTTP(r), "receipt.regenerate", transaction.ID, &transaction)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"receipt_url": transaction.ReceiptURL})
+}
@@ -0,0 +1,415 @@
+package transaction
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"web_backend_project/pkg/auth"
+)
+
+var reconciliationCollection = "reconciliation_reports"
+
+// duplicateWindow is how close two identical orders must be to count as duplicates.
+const duplicateWindow = 5 * time.Minute
+
+// ReconciliationIssue is a single mismatch found for a transaction.
+type ReconciliationIssue struct {
+	TransactionID string `bson:"transaction_id" json:"transactionId"`
+	Status        string `bson:"status" json:"status"`
+	Kind          string `bson:"kind" json:"kind"`
+	Detail        string `bson:"detail" json:"detail"`
+}
+
+// ReconciliationReport covers transactions created in [From, To).
+type ReconciliationReport struct {
+	ID          primitive.ObjectID    `bson:"_id,omitempty" json:"id"`
+	From        time.Time             `bson:"from" json:"from"`
+	To          time.Time             `bson:"to" json:"to"`
+	GeneratedAt time.Time             `bson:"generated_at" json:"generatedAt"`
+	Checked     int                   `bson:"checked" json:"checked"`
+	Issues      []ReconciliationIssue `bson:"issues" json:"issues"`
+	EmailedTo   string                `bson:"emailed_to,omitempty" json:"emailedTo,omitempty"`
+}
+
+// reconcile cross-checks every transaction in the window against the gateway
+// records, the receipt store, the email log, entitlements and the ledger.
+func reconcile(ctx context.Context, from, to time.Time) (*ReconciliationReport, error) {
+	db := client.Database(dbName)
+	report := &ReconciliationReport{From: from, To: to, GeneratedAt: time.Now(), Issues: []ReconciliationIssue{}}
+
+	cursor, err := db.Collection(transactionCollection).Find(ctx, bson.M{"created_at": bson.M{"$gte": from, "$lt": to}})
+	if err != nil {
+		return nil, err
+	}
+	var transactions []Transaction
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+	report.Checked = len(transactions)
+
+	ids := make([]string, 0, len(transactions))
+	for _, transaction := range transactions {
+		ids = append(ids, transaction.ID.Hex())
+	}
+
+	payments := map[string][]gatewayPayment{}
+	cursor, err = db.Collection(gatewayPaymentsCollection).Find(ctx, bson.M{"transaction_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var records []gatewayPayment
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		payments[record.TransactionID] = append(payments[record.TransactionID], record)
+	}
+
+	emailed, err := distinctSet(ctx, emailLogCollection, "transaction_id", bson.M{"kind": "receipt", "transaction_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	entitled, err := distinctSet(ctx, entitlementsCollection, "transaction_id", bson.M{"transaction_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	booked, err := distinctSet(ctx, journalCollection, "reference", bson.M{"kind": "purchase", "reference": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	grantingProducts, err := distinctSet(ctx, productEntitlementsCollection, "_id", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	flag := func(transaction Transaction, kind, detail string) {
+		report.Issues = append(report.Issues, ReconciliationIssue{
+			TransactionID: transaction.ID.Hex(),
+			Status:        transaction.Status,
+			Kind:          kind,
+			Detail:        detail,
+		})
+	}
+
+	for _, transaction := range transactions {
+		if transaction.PaymentMethod == "credit" {
+			// Plan changes paid entirely by credit charge nothing
+			continue
+		}
+		id := transaction.ID.Hex()
+		paid := transaction.Status == "Paid" || transaction.Status == "Completed"
+		total := calculateTotal(transaction.CartItems)
+
+		if paid && transaction.ReceiptURL == "" {
+			flag(transaction, "missing_receipt_url", "paid transaction has no receipt_url")
+		}
+		if transaction.Status == "Completed" {
+			exists, err := receiptStore.Exists(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				flag(transaction, "missing_receipt", "receipt is not in the receipt store")
+			}
+			if !emailed[id] {
+				flag(transaction, "receipt_not_emailed", "no receipt email was logged")
+			}
+			if !entitled[id] && grantsEntitlements(transaction, grantingProducts) {
+				flag(transaction, "missing_entitlement", "no entitlement was granted for the purchase")
+			}
+		}
+
+		if transaction.PaymentMethod == "wallet" {
+			if paid && !booked[id] {
+				flag(transaction, "missing_ledger_entry", "wallet payment has no journal entry")
+			}
+			continue
+		}
+
+		var captured []gatewayPayment
+		for _, record := range payments[id] {
+			if record.Status == "captured" {
+				captured = append(captured, record)
+			}
+		}
+		switch {
+		case len(captured) > 1:
+			flag(transaction, "duplicate_capture", fmt.Sprintf("%d captured gateway payments", len(captured)))
+		case paid && len(captured) == 0:
+			flag(transaction, "not_captured", "paid transaction has no captured gateway payment")
+		case !paid && len(captured) > 0:
+			flag(transaction, "captured_not_paid", "gateway captured money for a transaction that is not paid")
+		}
+		for _, record := range captured {
+			if math.Abs(record.Amount-total) > 0.005 {
+				flag(transaction, "amount_mismatch", fmt.Sprintf("gateway captured %.2f, transaction total is %.2f", record.Amount, total))
+			}
+		}
+	}
+
+	for _, pair := range findDuplicateTransactions(transactions) {
+		flag(pair[1], "duplicate_transaction", "same customer and cart as "+pair[0].ID.Hex())
+	}
+
+	return report, nil
+}
+
+func distinctSet(ctx context.Context, collection, field string, filter bson.M) (map[string]bool, error) {
+	values, err := client.Database(dbName).Collection(collection).Distinct(ctx, field, filter)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			set[s] = true
+		}
+	}
+	return set, nil
+}
+
+func grantsEntitlements(transaction Transaction, products map[string]bool) bool {
+	for _, item := range transaction.CartItems {
+		if products[item.ID] {
+			return true
+		}
+	}
+	return false
+}
+
+// findDuplicateTransactions pairs orders by the same customer for the same
+// cart placed within duplicateWindow of each other.
+func findDuplicateTransactions(transactions []Transaction) [][2]Transaction {
+	byKey := map[string][]Transaction{}
+	for _, transaction := range transactions {
+		if transaction.Status == "Declined" || transaction.Status == "Cancelled" {
+			continue
+		}
+		items := make([]string, 0, len(transaction.CartItems))
+		for _, item := range transaction.CartItems {
+			items = append(items, fmt.Sprintf("%s:%d", item.ID, item.Quantity))
+		}
+		sort.Strings(items)
+		key := transaction.Customer.ID + "|" + strings.Join(items, ",")
+		byKey[key] = append(byKey[key], transaction)
+	}
+
+	var pairs [][2]Transaction
+	for _, group := range byKey {
+		sort.Slice(group, func(i, j int) bool { return group[i].CreatedAt.Before(group[j].CreatedAt) })
+		for i := 1; i < len(group); i++ {
+			if group[i].CreatedAt.Sub(group[i-1].CreatedAt) <= duplicateWindow {
+				pairs = append(pairs, [2]Transaction{group[i-1], group[i]})
+			}
+		}
+	}
+	return pairs
+}
+
+func renderReconciliationPDF(report *ReconciliationReport) ([]byte, error) {
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	pdf.AddPage()
+	pdf.SetFont("Arial", "B", 16)
+	pdf.Cell(40, 10, "Reconciliation Report")
+	pdf.Ln(12)
+
+	pdf.SetFont("Arial", "", 11)
+	pdf.Cell(40, 8, fmt.Sprintf("Period: %s - %s", report.From.Format("2006-01-02 15:04"), report.To.Format("2006-01-02 15:04")))
+	pdf.Ln(8)
+	pdf.Cell(40, 8, fmt.Sprintf("Transactions checked: %d, issues found: %d", report.Checked, len(report.Issues)))
+	pdf.Ln(12)
+
+	pdf.SetFont("Arial", "B", 10)
+	for _, header := range []struct {
+		title string
+		width float64
+	}{{"Transaction ID", 60}, {"Status", 30}, {"Issue", 50}, {"Detail", 137}} {
+		pdf.CellFormat(header.width, 8, header.title, "1", 0, "", false, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Arial", "", 9)
+	for _, issue := range report.Issues {
+		pdf.CellFormat(60, 7, issue.TransactionID, "1", 0, "", false, 0, "")
+		pdf.CellFormat(30, 7, issue.Status, "1", 0, "", false, 0, "")
+		pdf.CellFormat(50, 7, issue.Kind, "1", 0, "", false, 0, "")
+		pdf.CellFormat(137, 7, issue.Detail, "1", 0, "", false, 0, "")
+		pdf.Ln(-1)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeReconciliationCSV(w io.Writer, report *ReconciliationReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"transaction_id", "status", "issue", "detail"})
+	for _, issue := range report.Issues {
+		writer.Write([]string{issue.TransactionID, issue.Status, issue.Kind, issue.Detail})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// reconciliationURL is the admin download link of a stored report.
+func reconciliationURL(id primitive.ObjectID, format string) string {
+	return fmt.Sprintf("%s/admin/reconciliation?id=%s&format=%s", serviceBaseURL(), id.Hex(), format)
+}
+
+// emailReconciliationReport tells finance about a stored report. Mail goes
+// through the notification service, which can't carry attachments, so the
+// PDF and CSV are linked; downloading them requires transactions.read.
+func emailReconciliationReport(to string, report *ReconciliationReport) error {
+	stamp := report.From.Format("2006-01-02")
+	subject := fmt.Sprintf("Reconciliation report %s: %d issues", stamp, len(report.Issues))
+	body := fmt.Sprintf("Checked %d transactions between %s and %s, found %d issues.\n\nPDF: %s\nCSV: %s",
+		report.Checked, report.From.Format(time.RFC3339), report.To.Format(time.RFC3339), len(report.Issues),
+		reconciliationURL(report.ID, "pdf"), reconciliationURL(report.ID, "csv"))
+	if err := publishEmailNotification(to, subject, body); err != nil {
+		return fmt.Errorf("failed to send reconciliation report: %w", err)
+	}
+	return nil
+}
+
+// runReconciliation reconciles the window, stores the report and, when
+// FINANCE_EMAIL is set, emails it.
+func runReconciliation(ctx context.Context, from, to time.Time) (*ReconciliationReport, error) {
+	report, err := reconcile(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := client.Database(dbName).Collection(reconciliationCollection)
+	res, err := reports.InsertOne(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+	report.ID = res.InsertedID.(primitive.ObjectID)
+
+	// The email links to the stored report, so it is sent after the insert
+	if financeEmail := getEnv("FINANCE_EMAIL", ""); financeEmail != "" {
+		if err := emailReconciliationReport(financeEmail, report); err != nil {
+			log.Println("Error emailing reconciliation report:", err)
+		} else if _, err := reports.UpdateOne(ctx, bson.M{"_id": report.ID}, bson.M{"$set": bson.M{"emailed_to": financeEmail}}); err != nil {
+			log.Println("Error recording reconciliation email:", err)
+		} else {
+			report.EmailedTo = financeEmail
+		}
+	}
+	return report, nil
+}
+
+// runDailyReconciliation reconciles the previous UTC day at RECONCILIATION_HOUR.
+func runDailyReconciliation() {
+	hour := 3
+	fmt.Sscanf(getEnv("RECONCILIATION_HOUR", "3"), "%d", &hour)
+
+	for {
+		time.Sleep(untilDailyRun(hour))
+
+		to := time.Now().UTC().Truncate(24 * time.Hour)
+		report, err := runReconciliation(context.Background(), to.AddDate(0, 0, -1), to)
+		if err != nil {
+			log.Println("Error running reconciliation:", err)
+			continue
+		}
+		log.Printf("Reconciliation for %s: %d transactions, %d issues",
+			report.From.Format("2006-01-02"), report.Checked, len(report.Issues))
+	}
+}
+
+// handleReconciliation runs a reconciliation on demand (POST) or downloads a
+// stored report as JSON, PDF or CSV (GET ?id=&format=).
+func handleReconciliation(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !claims.Can(auth.PermTransactionsRead) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	var report *ReconciliationReport
+	switch r.Method {
+	case http.MethodPost:
+		to := time.Now().UTC()
+		from := to.AddDate(0, 0, -1)
+		if value := r.URL.Query().Get("from"); value != "" {
+			if from, err = parseHistoryTime(value); err != nil {
+				http.Error(w, "Invalid from date", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := r.URL.Query().Get("to"); value != "" {
+			if to, err = parseHistoryTime(value); err != nil {
+				http.Error(w, "Invalid to date", http.StatusBadRequest)
+				return
+			}
+		}
+		if !from.Before(to) {
+			http.Error(w, "from must be before to", http.StatusBadRequest)
+			return
+		}
+
+		report, err = runReconciliation(r.Context(), from, to)
+		if err != nil {
+			http.Error(w, "Failed to run reconciliation", http.StatusInternalServerError)
+			log.Println("Error running reconciliation:", err)
+			return
+		}
+	case http.MethodGet:
+		id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid report ID", http.StatusBadRequest)
+			return
+		}
+		report = &ReconciliationReport{}
+		err = client.Database(dbName).Collection(reconciliationCollection).FindOne(r.Context(), bson.M{"_id": id}).Decode(report)
+		if err != nil {
+			http.Error(w, "Report not found", http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stamp := report.From.Format("2006-01-02")
+	switch r.URL.Query().Get("format") {
+	case "pdf":
+		data, err := renderReconciliationPDF(report)
+		if err != nil {
+			http.Error(w, "Failed to render report", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", "attachment; filename=reconciliation_"+stamp+".pdf")
+		w.Write(data)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=reconciliation_"+stamp+".csv")
+		writeReconciliationCSV(w, report)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
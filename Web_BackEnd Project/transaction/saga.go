@@ -0,0 +1,426 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var sagasCollection = "sagas"
+
+// Saga statuses.
+const (
+	SagaRunning      = "running"
+	SagaCompensating = "compensating"
+	SagaCompleted    = "completed"
+	SagaCompensated  = "compensated"
+	SagaFailed       = "failed"
+)
+
+const (
+	// sagaLockTTL covers the slowest step; the lock is renewed every
+	// sagaLockRenewal while a step runs, so only a dead runner loses it.
+	sagaLockTTL             = 5 * time.Minute
+	sagaLockRenewal         = sagaLockTTL / 3
+	sagaCompensationRetries = 5
+)
+
+// SagaStep is one step of a saga together with the action that undoes it.
+// Actions may record values in data for later steps; data is persisted
+// after every step, whether it succeeded or not.
+type SagaStep struct {
+	Name       string
+	Action     func(ctx context.Context, data map[string]string) error
+	Compensate func(ctx context.Context, data map[string]string) error
+}
+
+// SagaDefinition is an ordered list of steps.
+type SagaDefinition struct {
+	Name  string
+	Steps []SagaStep
+}
+
+// SagaEvent records one step execution in the saga history.
+type SagaEvent struct {
+	Step    string    `bson:"step" json:"step"`
+	Action  string    `bson:"action" json:"action"`
+	Outcome string    `bson:"outcome" json:"outcome"`
+	Error   string    `bson:"error,omitempty" json:"error,omitempty"`
+	At      time.Time `bson:"at" json:"at"`
+}
+
+// SagaState is the persisted progress of a saga. Step is the index of the
+// next step to execute while running, and of the next step to undo while
+// compensating.
+type SagaState struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Saga        string             `bson:"saga" json:"saga"`
+	Status      string             `bson:"status" json:"status"`
+	Step        int                `bson:"step" json:"step"`
+	Data        map[string]string  `bson:"data" json:"data"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	History     []SagaEvent        `bson:"history" json:"history"`
+	CreatedAt   time.Time          `bson:"created_at" json:"createdAt"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updatedAt"`
+	LockedUntil *time.Time         `bson:"locked_until,omitempty" json:"-"`
+}
+
+// StepRunner executes saga steps, either in-process or on remote workers.
+type StepRunner interface {
+	Run(ctx context.Context, step SagaStep, saga string, compensate bool, data map[string]string) (map[string]string, error)
+}
+
+// inProcessRunner calls the step functions directly.
+type inProcessRunner struct{}
+
+func (inProcessRunner) Run(ctx context.Context, step SagaStep, saga string, compensate bool, data map[string]string) (map[string]string, error) {
+	fn := step.Action
+	if compensate {
+		fn = step.Compensate
+	}
+	err := fn(ctx, data)
+	return data, err
+}
+
+type sagaStepMessage struct {
+	Data  map[string]string `json:"data"`
+	Error string            `json:"error,omitempty"`
+}
+
+func sagaStepSubject(saga, step string, compensate bool) string {
+	action := "execute"
+	if compensate {
+		action = "compensate"
+	}
+	return fmt.Sprintf("saga.%s.%s.%s", saga, step, action)
+}
+
+// natsStepRunner sends each step to a worker over NATS request/reply.
+type natsStepRunner struct {
+	conn    *nats.Conn
+	timeout time.Duration
+}
+
+func (r natsStepRunner) Run(ctx context.Context, step SagaStep, saga string, compensate bool, data map[string]string) (map[string]string, error) {
+	payload, err := json.Marshal(sagaStepMessage{Data: data})
+	if err != nil {
+		return data, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	msg, err := r.conn.RequestWithContext(ctx, sagaStepSubject(saga, step.Name, compensate), payload)
+	if err != nil {
+		return data, fmt.Errorf("saga worker unavailable: %w", err)
+	}
+
+	var reply sagaStepMessage
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		return data, err
+	}
+	if reply.Data == nil {
+		reply.Data = data
+	}
+	if reply.Error != "" {
+		return reply.Data, errors.New(reply.Error)
+	}
+	return reply.Data, nil
+}
+
+// SagaOrchestrator drives sagas step by step and persists their state in
+// MongoDB, so a saga interrupted by a restart is resumed where it stopped.
+type SagaOrchestrator struct {
+	collection  *mongo.Collection
+	runner      StepRunner
+	definitions map[string]SagaDefinition
+}
+
+// NewSagaOrchestrator creates an orchestrator that stores state in collection.
+func NewSagaOrchestrator(collection *mongo.Collection, runner StepRunner) *SagaOrchestrator {
+	return &SagaOrchestrator{
+		collection:  collection,
+		runner:      runner,
+		definitions: map[string]SagaDefinition{},
+	}
+}
+
+// Register makes a saga definition available to Start and Resume.
+func (o *SagaOrchestrator) Register(definition SagaDefinition) {
+	o.definitions[definition.Name] = definition
+}
+
+// ServeSteps answers step requests sent by a natsStepRunner, so this
+// process can act as a saga worker.
+func (o *SagaOrchestrator) ServeSteps(conn *nats.Conn) error {
+	for _, definition := range o.definitions {
+		for _, step := range definition.Steps {
+			for _, compensate := range []bool{false, true} {
+				if compensate && step.Compensate == nil {
+					continue
+				}
+				step, compensate := step, compensate
+				_, err := conn.QueueSubscribe(sagaStepSubject(definition.Name, step.Name, compensate), "saga-workers", func(msg *nats.Msg) {
+					var request sagaStepMessage
+					if err := json.Unmarshal(msg.Data, &request); err != nil {
+						log.Println("Error decoding saga step request:", err)
+						return
+					}
+					if request.Data == nil {
+						request.Data = map[string]string{}
+					}
+
+					data, err := inProcessRunner{}.Run(context.Background(), step, definition.Name, compensate, request.Data)
+					reply := sagaStepMessage{Data: data}
+					if err != nil {
+						reply.Error = err.Error()
+					}
+					payload, _ := json.Marshal(reply)
+					msg.Respond(payload)
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Start creates a saga and runs it to completion or compensation.
+func (o *SagaOrchestrator) Start(ctx context.Context, name string, data map[string]string) (*SagaState, error) {
+	if _, ok := o.definitions[name]; !ok {
+		return nil, fmt.Errorf("unknown saga %q", name)
+	}
+
+	now := time.Now()
+	lockedUntil := now.Add(sagaLockTTL)
+	id := primitive.NewObjectID()
+	if data == nil {
+		data = map[string]string{}
+	}
+	// Steps use the saga ID as an idempotency key for external calls
+	data["saga_id"] = id.Hex()
+	state := &SagaState{
+		ID:          id,
+		Saga:        name,
+		Status:      SagaRunning,
+		Data:        data,
+		History:     []SagaEvent{},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		LockedUntil: &lockedUntil,
+	}
+
+	if _, err := o.collection.InsertOne(ctx, state); err != nil {
+		return nil, fmt.Errorf("failed to persist saga: %w", err)
+	}
+
+	return o.run(ctx, state)
+}
+
+func (o *SagaOrchestrator) save(ctx context.Context, state *SagaState, event SagaEvent) error {
+	state.UpdatedAt = time.Now()
+	lockedUntil := state.UpdatedAt.Add(sagaLockTTL)
+	state.LockedUntil = &lockedUntil
+	state.History = append(state.History, event)
+
+	set := bson.M{
+		"status":       state.Status,
+		"step":         state.Step,
+		"data":         state.Data,
+		"error":        state.Error,
+		"updated_at":   state.UpdatedAt,
+		"locked_until": lockedUntil,
+	}
+	update := bson.M{"$set": set, "$push": bson.M{"history": event}}
+	if state.Status == SagaCompleted || state.Status == SagaCompensated || state.Status == SagaFailed {
+		delete(set, "locked_until")
+		update["$unset"] = bson.M{"locked_until": ""}
+	}
+
+	_, err := o.collection.UpdateOne(ctx, bson.M{"_id": state.ID}, update)
+	return err
+}
+
+func (o *SagaOrchestrator) run(ctx context.Context, state *SagaState) (*SagaState, error) {
+	definition := o.definitions[state.Saga]
+
+	for state.Status == SagaRunning && state.Step < len(definition.Steps) {
+		step := definition.Steps[state.Step]
+		data, err := o.runStep(ctx, state, step, false)
+		state.Data = data
+
+		event := SagaEvent{Step: step.Name, Action: "execute", Outcome: "ok", At: time.Now()}
+		if err != nil {
+			// Undo everything that completed before the failing step
+			event.Outcome = "failed"
+			event.Error = err.Error()
+			state.Error = fmt.Sprintf("%s: %v", step.Name, err)
+			state.Status = SagaCompensating
+			state.Step--
+		} else {
+			state.Step++
+			if state.Step == len(definition.Steps) {
+				state.Status = SagaCompleted
+			}
+		}
+
+		if err := o.save(ctx, state, event); err != nil {
+			return state, fmt.Errorf("failed to persist saga progress: %w", err)
+		}
+	}
+
+	for state.Status == SagaCompensating {
+		if state.Step < 0 {
+			state.Status = SagaCompensated
+			if err := o.save(ctx, state, SagaEvent{Action: "compensate", Outcome: "ok", At: time.Now()}); err != nil {
+				return state, err
+			}
+			break
+		}
+
+		step := definition.Steps[state.Step]
+		if step.Compensate == nil {
+			state.Step--
+			continue
+		}
+
+		var err error
+		for attempt := 0; attempt < sagaCompensationRetries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+			state.Data, err = o.runStep(ctx, state, step, true)
+			if err == nil {
+				break
+			}
+		}
+
+		event := SagaEvent{Step: step.Name, Action: "compensate", Outcome: "ok", At: time.Now()}
+		if err != nil {
+			// Leave the saga for an operator; it shows up in the stuck list
+			event.Outcome = "failed"
+			event.Error = err.Error()
+			state.Status = SagaFailed
+			state.Error = fmt.Sprintf("compensating %s: %v", step.Name, err)
+		} else {
+			state.Step--
+		}
+
+		if err := o.save(ctx, state, event); err != nil {
+			return state, fmt.Errorf("failed to persist saga progress: %w", err)
+		}
+	}
+
+	return state, nil
+}
+
+// runStep runs one step while renewing the saga lock, so a slow step doesn't
+// let another process claim the saga and run it concurrently.
+func (o *SagaOrchestrator) runStep(ctx context.Context, state *SagaState, step SagaStep, compensate bool) (map[string]string, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go o.renewLock(state.ID, done)
+	return o.runner.Run(ctx, step, state.Saga, compensate, state.Data)
+}
+
+// renewLock extends the saga lock every sagaLockRenewal until done is closed.
+func (o *SagaOrchestrator) renewLock(id primitive.ObjectID, done <-chan struct{}) {
+	ticker := time.NewTicker(sagaLockRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, err := o.collection.UpdateOne(context.Background(), bson.M{"_id": id},
+				bson.M{"$set": bson.M{"locked_until": time.Now().Add(sagaLockTTL)}})
+			if err != nil {
+				log.Printf("Error renewing lock of saga %s: %v", id.Hex(), err)
+			}
+		}
+	}
+}
+
+// claim leases an interrupted saga whose previous runner stopped renewing its lock.
+func (o *SagaOrchestrator) claim(ctx context.Context) (*SagaState, error) {
+	now := time.Now()
+	filter := bson.M{
+		"status": bson.M{"$in": []string{SagaRunning, SagaCompensating}},
+		"$or": []bson.M{
+			{"locked_until": bson.M{"$exists": false}},
+			{"locked_until": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"locked_until": now.Add(sagaLockTTL)}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetSort(bson.D{{Key: "updated_at", Value: 1}})
+
+	var state SagaState
+	err := o.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &state, err
+}
+
+// Resume continues every saga left unfinished by a crashed or restarted process.
+func (o *SagaOrchestrator) Resume(ctx context.Context) error {
+	for {
+		state, err := o.claim(ctx)
+		if err != nil || state == nil {
+			return err
+		}
+		if _, ok := o.definitions[state.Saga]; !ok {
+			log.Printf("Cannot resume saga %s: unknown definition %q", state.ID.Hex(), state.Saga)
+			continue
+		}
+
+		log.Printf("Resuming saga %s (%s) at step %d", state.ID.Hex(), state.Status, state.Step)
+		if _, err := o.run(ctx, state); err != nil {
+			log.Printf("Error resuming saga %s: %v", state.ID.Hex(), err)
+		}
+	}
+}
+
+func (o *SagaOrchestrator) runResumer(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := o.Resume(context.Background()); err != nil {
+			log.Println("Error resuming sagas:", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Stuck lists sagas that failed to compensate, or that have not progressed for olderThan.
+func (o *SagaOrchestrator) Stuck(ctx context.Context, olderThan time.Duration) ([]SagaState, error) {
+	filter := bson.M{"$or": []bson.M{
+		{"status": SagaFailed},
+		{
+			"status":     bson.M{"$in": []string{SagaRunning, SagaCompensating}},
+			"updated_at": bson.M{"$lt": time.Now().Add(-olderThan)},
+		},
+	}}
+	cursor, err := o.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "updated_at", Value: 1}}).SetLimit(200))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sagas := []SagaState{}
+	err = cursor.All(ctx, &sagas)
+	return sagas, err
+}
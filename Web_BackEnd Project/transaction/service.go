@@ -8,40 +8,148 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/rs/cors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+	"web_backend_project/pkg/webhook"
 )
 
-var transactionClient *mongo.Client
+// StartTransactionService подключается к MongoDB, запускает фоновые задачи и
+// регистрирует маршруты, после чего слушает :8081 в отдельной горутине.
+// Журнал аудита и диспетчер вебхуков общие с сервисом пользователей: их
+// маршруты уже зарегистрированы в main.
+func StartTransactionService(audits *audit.Log, hooks *webhook.Dispatcher) {
+	auditLog = audits
+	webhooks = hooks
 
-func StartTransactionService() {
-	// Подключение к MongoDB
+	// Подключение к MongoDB: один клиент и одна база для всех коллекций сервиса
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	clientOptions := options.Client().ApplyURI("mongodb://localhost:27017")
+	clientOptions := options.Client().ApplyURI(getEnv("MONGO_URI", "mongodb://localhost:27017"))
 	var err error
-	transactionClient, err = mongo.Connect(ctx, clientOptions)
+	client, err = mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	// Проверка подключения
-	err = transactionClient.Ping(ctx, nil)
+	err = client.Ping(ctx, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	fmt.Println("Transaction service connected to MongoDB")
 
+	// Индексы для истории заказов
+	if err := EnsureHistoryIndexes(ctx, client.Database(dbName).Collection(transactionCollection)); err != nil {
+		log.Printf("Warning: failed to create transaction indexes: %v", err)
+	}
+	go runHistoryBackfill(client.Database(dbName).Collection(transactionCollection), time.Minute)
+
+	if err := setupOutbox(context.Background()); err != nil {
+		log.Fatal("Error setting up outbox:", err)
+	}
+	if err := startOutboxConsumers(); err != nil {
+		log.Fatal("Error starting outbox consumers:", err)
+	}
+	go runOutboxRelay(time.Second)
+
+	receiptStore, err = newReceiptStore(client.Database(dbName))
+	if err != nil {
+		log.Fatal("Error initializing receipt store:", err)
+	}
+
+	if err := ensureEntitlementIndexes(context.Background()); err != nil {
+		log.Fatal("Error preparing entitlements:", err)
+	}
+	go runEntitlementExpiry(10 * time.Minute)
+
+	vaultKey, err := loadVaultKey()
+	if err != nil {
+		log.Fatal("Error loading card vault key:", err)
+	}
+	vault, err = NewCardVault(client.Database(dbName).Collection(cardVaultCollection), vaultKey)
+	if err != nil {
+		log.Fatal("Error initializing card vault:", err)
+	}
+	if err := vault.EnsureIndexes(context.Background()); err != nil {
+		log.Fatal("Error preparing card vault:", err)
+	}
+	go runCVVExpiry(time.Minute)
+	gateway = newMockGateway(vault, client.Database(dbName).Collection(gatewayPaymentsCollection))
+	if err := ensureGatewayIndexes(context.Background()); err != nil {
+		log.Fatal("Error preparing payment gateway:", err)
+	}
+
+	if err := ensureLedgerIndexes(context.Background()); err != nil {
+		log.Fatal("Error preparing ledger:", err)
+	}
+	go runNightlyLedgerCheck()
+	go runDailyReconciliation()
+
+	if err := ensureSubscriptionIndexes(context.Background()); err != nil {
+		log.Fatal("Error preparing subscriptions:", err)
+	}
+	go runSubscriptionRenewals(time.Minute)
+
+	if err := subscribeUserPurges(); err != nil {
+		log.Fatal("Error subscribing to user purges:", err)
+	}
+
+	orchestrator = NewSagaOrchestrator(client.Database(dbName).Collection(sagasCollection), newSagaRunner())
+	orchestrator.Register(checkoutDefinition())
+	orchestrator.Register(walletCheckoutDefinition())
+	if err := orchestrator.ServeSteps(natsConn); err != nil {
+		log.Fatal("Error serving saga steps:", err)
+	}
+	go orchestrator.runResumer(30 * time.Second)
+
 	// Настройка маршрутов
 	http.HandleFunc("/transactions", handleTransactions)
+	http.HandleFunc("/transactions/export", handleExportTransactions)
 	http.HandleFunc("/transactions/create", handleCreateTransaction)
+	http.HandleFunc("/cards/tokenize", handleTokenizeCard)
+	http.HandleFunc("/transaction", handleTransaction)
+	http.HandleFunc("/payment", handlePayment)
+	http.HandleFunc("/receipt", handleReceiptDownload)
+	http.HandleFunc("/receipt/regenerate", handleReceiptRegenerate)
+	http.HandleFunc("/refund", handleRefund)
+	http.HandleFunc("/admin/sagas", handleStuckSagas)
+	http.HandleFunc("/entitlements", handleEntitlements)
+	http.HandleFunc("/entitlements/check", handleEntitlementCheck)
+	http.HandleFunc("/entitlements/products", handleProductEntitlements)
+	http.HandleFunc("/wallet/topup", handleWalletTopUp)
+	http.HandleFunc("/wallet/balance", handleWalletBalance)
+	http.HandleFunc("/wallet/pay", handleWalletPay)
+	http.HandleFunc("/admin/ledger", handleJournal)
+	http.HandleFunc("/admin/ledger/check", handleLedgerCheck)
+	http.HandleFunc("/admin/reconciliation", handleReconciliation)
+	http.HandleFunc("/subscriptions", handleSubscriptions)
+	http.HandleFunc("/subscriptions/plans", handleSubscriptionPlans)
+	http.HandleFunc("/subscriptions/cancel", handleCancelSubscription)
+	http.HandleFunc("/subscriptions/change", handleChangeSubscription)
+	auth.RegisterScope("transactions", "/transactions", "/transaction", "/receipt", "/entitlements")
+	// Оплата недоступна токенам имперсонации
+	auth.BlockWhileImpersonating("/transactions/create", "/cards/tokenize", "/transaction", "/payment", "/refund", "/wallet", "/subscriptions")
+
+	c := cors.New(cors.Options{
+		AllowedOrigins:   []string{"http://localhost:3000"},
+		AllowCredentials: true,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", auth.APIKeyHeader},
+	})
 
 	// Запуск сервера
-	fmt.Println("Transaction service started on :8081")
-	log.Fatal(http.ListenAndServe(":8081", nil))
+	go func() {
+		fmt.Println("Transaction service started on :8081")
+		log.Fatal(http.ListenAndServe(":8081", c.Handler(auth.ImpersonationGuard(http.DefaultServeMux))))
+	}()
 }
 
 func handleTransactions(w http.ResponseWriter, r *http.Request) {
@@ -50,22 +158,68 @@ func handleTransactions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collection := transactionClient.Database("quiz_db").Collection("transactions")
-	cursor, err := collection.Find(context.Background(), bson.M{})
+	claims, err := auth.FromRequest(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Покупатель видит только свои транзакции, администратор может искать по всем
+	query, err := parseHistoryQuery(r.URL.Query(), claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	var transactions []bson.M
-	if err = cursor.All(context.Background(), &transactions); err != nil {
+	collection := client.Database(dbName).Collection(transactionCollection)
+	transactions, nextCursor, err := FindHistoryPage(r.Context(), collection, query)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(transactions)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"transactions": transactions,
+		"next_cursor":  nextCursor,
+	})
+}
+
+func handleExportTransactions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !claims.Can(auth.PermTransactionsRead) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	query, err := parseHistoryQuery(r.URL.Query(), claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collection := client.Database(dbName).Collection(transactionCollection)
+	if err := ExportHistory(r.Context(), w, collection, query, format); err != nil {
+		log.Println("Error exporting transactions:", err)
+	}
 }
 
 func handleCreateTransaction(w http.ResponseWriter, r *http.Request) {
@@ -80,15 +234,40 @@ func handleCreateTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collection := transactionClient.Database("quiz_db").Collection("transactions")
+	// Сумма и дата нужны для фильтров истории заказов
+	if _, ok := transaction["created_at"]; !ok {
+		transaction["created_at"] = time.Now()
+	}
+	if _, ok := transaction["total"]; !ok {
+		transaction["total"] = totalFromDocument(transaction)
+	}
+
+	collection := client.Database(dbName).Collection(transactionCollection)
 	result, err := collection.InsertOne(context.Background(), transaction)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		auditLog.Record(audit.FromHTTP(r), "transaction.create", "transaction:"+id.Hex(), nil, transaction)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"id": result.InsertedID,
 	})
 }
+
+// totalFromDocument считает сумму заказа по cartItems произвольного документа
+func totalFromDocument(transaction bson.M) float64 {
+	data, err := bson.Marshal(transaction)
+	if err != nil {
+		return 0
+	}
+	var parsed Transaction
+	if err := bson.Unmarshal(data, &parsed); err != nil {
+		return 0
+	}
+	return calculateTotal(parsed.CartItems)
+}
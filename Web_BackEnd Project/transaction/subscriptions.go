@@ -0,0 +1,706 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"web_backend_project/internal/service"
+	"web_backend_project/pkg/audit"
+	"web_backend_project/pkg/auth"
+)
+
+var subscriptionsCollection = "subscriptions"
+
+// SubscriptionPlan is a recurring product. Its ID doubles as the product ID
+// in the entitlement catalog.
+type SubscriptionPlan struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Interval string  `json:"interval"`
+	Price    float64 `json:"price"`
+}
+
+var subscriptionPlans = map[string]SubscriptionPlan{
+	"premium_monthly": {ID: "premium_monthly", Name: "Premium Access (monthly)", Interval: "month", Price: 4.99},
+	"premium_yearly":  {ID: "premium_yearly", Name: "Premium Access (yearly)", Interval: "year", Price: 49.99},
+}
+
+func (p SubscriptionPlan) periodEnd(start time.Time) time.Time {
+	if p.Interval == "year" {
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+// Subscription statuses.
+const (
+	SubscriptionIncomplete = "incomplete"
+	SubscriptionActive     = "active"
+	SubscriptionPastDue    = "past_due"
+	SubscriptionCanceled   = "canceled"
+	SubscriptionUnpaid     = "unpaid"
+)
+
+// liveStatuses are the statuses of a subscription that still renews; a user
+// can have at most one subscription in them.
+var liveStatuses = []string{SubscriptionIncomplete, SubscriptionActive, SubscriptionPastDue}
+
+// renewalBackoff is the wait before each retry of a failed renewal; once it
+// is exhausted the subscription becomes unpaid.
+var renewalBackoff = []time.Duration{time.Hour, 24 * time.Hour, 3 * 24 * time.Hour, 5 * 24 * time.Hour}
+
+// renewalLease keeps other renewal workers away from a subscription being charged.
+const renewalLease = 5 * time.Minute
+
+// ErrSubscriptionExists is returned when a user already has a live subscription.
+var ErrSubscriptionExists = errors.New("user already has an active subscription")
+
+// ErrSubscriptionBusy is returned when a renewal is charging the subscription.
+var ErrSubscriptionBusy = errors.New("subscription is being renewed, try again shortly")
+
+type Subscription struct {
+	ID                   primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID               string             `bson:"user_id" json:"userId"`
+	PlanID               string             `bson:"plan_id" json:"planId"`
+	CardToken            string             `bson:"card_token" json:"-"`
+	Status               string             `bson:"status" json:"status"`
+	CurrentPeriodStart   time.Time          `bson:"current_period_start" json:"currentPeriodStart"`
+	CurrentPeriodEnd     time.Time          `bson:"current_period_end" json:"currentPeriodEnd"`
+	CancelAtPeriodEnd    bool               `bson:"cancel_at_period_end" json:"cancelAtPeriodEnd"`
+	CanceledAt           *time.Time         `bson:"canceled_at,omitempty" json:"canceledAt,omitempty"`
+	FailedAttempts       int                `bson:"failed_attempts" json:"failedAttempts"`
+	NextAttemptAt        time.Time          `bson:"next_attempt_at" json:"nextAttemptAt"`
+	PendingTransactionID string             `bson:"pending_transaction_id,omitempty" json:"-"`
+	LastTransactionID    string             `bson:"last_transaction_id,omitempty" json:"lastTransactionId,omitempty"`
+	LastError            string             `bson:"last_error,omitempty" json:"lastError,omitempty"`
+	CreatedAt            time.Time          `bson:"created_at" json:"createdAt"`
+	UpdatedAt            time.Time          `bson:"updated_at" json:"updatedAt"`
+	// LiveUserID repeats UserID while the subscription is live and is removed
+	// once it is canceled or unpaid; a unique index on it allows one live
+	// subscription per user.
+	LiveUserID string `bson:"live_user_id,omitempty" json:"-"`
+}
+
+func subscriptions() *mongo.Collection {
+	return client.Database(dbName).Collection(subscriptionsCollection)
+}
+
+func ensureSubscriptionIndexes(ctx context.Context) error {
+	// Subscriptions created before live_user_id existed get it before the
+	// unique index is built
+	_, err := subscriptions().UpdateMany(ctx,
+		bson.M{"status": bson.M{"$in": liveStatuses}, "live_user_id": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"live_user_id": "$user_id"}}}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = subscriptions().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "live_user_id", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Plans grant the same rights as the one-off premium product
+	catalog := client.Database(dbName).Collection(productEntitlementsCollection)
+	for _, plan := range subscriptionPlans {
+		_, err := catalog.UpdateOne(ctx,
+			bson.M{"_id": plan.ID},
+			bson.M{"$setOnInsert": ProductEntitlements{ProductID: plan.ID, Entitlements: []string{"premium_quizzes", "ad_free"}}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func updateSubscription(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	set["updated_at"] = time.Now()
+	update := bson.M{"$set": set}
+	if status := set["status"]; status == SubscriptionCanceled || status == SubscriptionUnpaid {
+		update["$unset"] = bson.M{"live_user_id": ""}
+	}
+	_, err := subscriptions().UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// publishEmailNotification hands an email to the notification service.
+func publishEmailNotification(to, subject, body string) error {
+	data, err := json.Marshal(map[string]string{"to": to, "subject": subject, "body": body})
+	if err != nil {
+		return err
+	}
+	return natsConn.Publish(service.EmailNotificationsSubject, data)
+}
+
+func notifySubscriber(ctx context.Context, sub *Subscription, subject, body string) {
+	customer, err := findCustomer(ctx, sub.UserID)
+	if err != nil {
+		log.Printf("Cannot notify subscriber of %s: %v", sub.ID.Hex(), err)
+		return
+	}
+	if err := publishEmailNotification(customer.Email, subject, body); err != nil {
+		log.Printf("Error publishing email for subscription %s: %v", sub.ID.Hex(), err)
+	}
+}
+
+// setEntitlementExpiry aligns what a subscription charge granted with the paid period.
+func setEntitlementExpiry(ctx context.Context, transactionID string, expiresAt time.Time) error {
+	_, err := client.Database(dbName).Collection(entitlementsCollection).UpdateMany(ctx,
+		bson.M{"transaction_id": transactionID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}},
+	)
+	return err
+}
+
+// chargeSubscription bills amount through the checkout saga. A transaction
+// left pending by a failed attempt is retried rather than duplicated.
+func chargeSubscription(ctx context.Context, sub *Subscription, item CartItem, recurring bool) (string, error) {
+	if sub.PendingTransactionID != "" {
+		id, err := primitive.ObjectIDFromHex(sub.PendingTransactionID)
+		if err != nil {
+			return "", err
+		}
+		transaction, err := findTransaction(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		// A saga that finished after the last attempt gave up
+		if transaction.Status == "Completed" || transaction.Status == "Paid" {
+			return sub.PendingTransactionID, nil
+		}
+	} else {
+		transaction := Transaction{
+			CartItems:      []CartItem{item},
+			Customer:       Customer{ID: sub.UserID},
+			Status:         "Pending Payment",
+			Total:          calculateTotal([]CartItem{item}),
+			SubscriptionID: sub.ID.Hex(),
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		}
+		if customer, err := findCustomer(ctx, sub.UserID); err == nil {
+			transaction.Customer.Name = customer.Name
+			transaction.Customer.Email = customer.Email
+		}
+
+		res, err := client.Database(dbName).Collection(transactionCollection).InsertOne(ctx, transaction)
+		if err != nil {
+			return "", err
+		}
+		sub.PendingTransactionID = res.InsertedID.(primitive.ObjectID).Hex()
+		if err := updateSubscription(ctx, sub.ID, bson.M{"pending_transaction_id": sub.PendingTransactionID}); err != nil {
+			return "", err
+		}
+	}
+
+	data := map[string]string{"transaction_id": sub.PendingTransactionID, "card_token": sub.CardToken}
+	if recurring {
+		data["recurring"] = "true"
+	}
+	state, err := orchestrator.Start(ctx, checkoutSaga, data)
+	if err != nil {
+		return "", err
+	}
+	if state.Status != SagaCompleted {
+		return "", fmt.Errorf("payment failed: %s", state.Error)
+	}
+	return sub.PendingTransactionID, nil
+}
+
+// startPeriod records a successful charge and schedules the next renewal.
+func startPeriod(ctx context.Context, sub *Subscription, plan SubscriptionPlan, transactionID string, start time.Time) error {
+	end := plan.periodEnd(start)
+	if err := setEntitlementExpiry(ctx, transactionID, end); err != nil {
+		return err
+	}
+
+	sub.PlanID = plan.ID
+	sub.Status = SubscriptionActive
+	sub.CurrentPeriodStart = start
+	sub.CurrentPeriodEnd = end
+	sub.NextAttemptAt = end
+	sub.FailedAttempts = 0
+	sub.LastTransactionID = transactionID
+	sub.PendingTransactionID = ""
+	sub.LastError = ""
+	return updateSubscription(ctx, sub.ID, bson.M{
+		"plan_id":                plan.ID,
+		"status":                 sub.Status,
+		"current_period_start":   start,
+		"current_period_end":     end,
+		"next_attempt_at":        end,
+		"failed_attempts":        0,
+		"last_transaction_id":    transactionID,
+		"pending_transaction_id": "",
+		"last_error":             "",
+	})
+}
+
+// renewSubscription ends or renews a subscription whose period is over,
+// or retries a failed renewal.
+func renewSubscription(ctx context.Context, sub *Subscription) error {
+	if sub.CancelAtPeriodEnd {
+		now := time.Now()
+		if err := updateSubscription(ctx, sub.ID, bson.M{"status": SubscriptionCanceled, "canceled_at": now}); err != nil {
+			return err
+		}
+		notifySubscriber(ctx, sub, "Your subscription has ended",
+			"Your premium subscription has ended. You can subscribe again at any time.")
+		return nil
+	}
+
+	plan, ok := subscriptionPlans[sub.PlanID]
+	if !ok {
+		return fmt.Errorf("unknown plan %q", sub.PlanID)
+	}
+
+	item := CartItem{ID: plan.ID, Name: plan.Name, Price: plan.Price, Quantity: 1}
+	transactionID, err := chargeSubscription(ctx, sub, item, true)
+	if err != nil {
+		attempts := sub.FailedAttempts + 1
+		if attempts > len(renewalBackoff) {
+			if updateErr := updateSubscription(ctx, sub.ID, bson.M{"status": SubscriptionUnpaid, "failed_attempts": attempts, "last_error": err.Error()}); updateErr != nil {
+				return updateErr
+			}
+			notifySubscriber(ctx, sub, "Your subscription was suspended",
+				"We could not charge your card after several attempts, so your premium subscription was suspended. Please subscribe again with a new card.")
+			return nil
+		}
+
+		next := time.Now().Add(renewalBackoff[attempts-1])
+		if updateErr := updateSubscription(ctx, sub.ID, bson.M{
+			"status":          SubscriptionPastDue,
+			"failed_attempts": attempts,
+			"next_attempt_at": next,
+			"last_error":      err.Error(),
+		}); updateErr != nil {
+			return updateErr
+		}
+		notifySubscriber(ctx, sub, "Payment failed for your subscription",
+			fmt.Sprintf("We could not renew your %s subscription. We will try again on %s; please make sure your card is valid.",
+				plan.Name, next.Format("2006-01-02 15:04 MST")))
+		return nil
+	}
+
+	wasPastDue := sub.Status == SubscriptionPastDue
+	start := sub.CurrentPeriodEnd
+	if start.Before(time.Now().Add(-24 * time.Hour)) {
+		// Don't bill for time the subscription spent past due
+		start = time.Now()
+	}
+	if err := startPeriod(ctx, sub, plan, transactionID, start); err != nil {
+		return err
+	}
+	if wasPastDue {
+		notifySubscriber(ctx, sub, "Your subscription is active again",
+			fmt.Sprintf("Your payment went through and your %s subscription has been renewed.", plan.Name))
+	}
+	return nil
+}
+
+// runSubscriptionRenewals charges due subscriptions every interval. Each
+// subscription is claimed with a short lease so parallel workers don't double-charge.
+func runSubscriptionRenewals(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := context.Background()
+		now := time.Now()
+		cursor, err := subscriptions().Find(ctx, bson.M{
+			"status":          bson.M{"$in": []string{SubscriptionActive, SubscriptionPastDue}},
+			"next_attempt_at": bson.M{"$lte": now},
+		})
+		if err != nil {
+			log.Println("Error finding due subscriptions:", err)
+			continue
+		}
+		var due []Subscription
+		if err := cursor.All(ctx, &due); err != nil {
+			log.Println("Error decoding due subscriptions:", err)
+			continue
+		}
+
+		for i := range due {
+			sub := &due[i]
+			claimed, err := claimSubscription(ctx, sub, now.Add(renewalLease))
+			if err != nil || !claimed {
+				continue
+			}
+			if err := renewSubscription(ctx, sub); err != nil {
+				log.Printf("Error renewing subscription %s: %v", sub.ID.Hex(), err)
+			}
+		}
+	}
+}
+
+// claimSubscription takes the renewal lease on sub until the given time. It
+// fails if next_attempt_at moved since sub was loaded, which means a renewal
+// or a plan change is already charging it.
+func claimSubscription(ctx context.Context, sub *Subscription, until time.Time) (bool, error) {
+	res, err := subscriptions().UpdateOne(ctx,
+		bson.M{"_id": sub.ID, "next_attempt_at": sub.NextAttemptAt},
+		bson.M{"$set": bson.M{"next_attempt_at": until}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// prorationCredit is the unused part of the current period, in the plan's currency.
+func prorationCredit(sub *Subscription, plan SubscriptionPlan, now time.Time) float64 {
+	period := sub.CurrentPeriodEnd.Sub(sub.CurrentPeriodStart)
+	remaining := sub.CurrentPeriodEnd.Sub(now)
+	if period <= 0 || remaining <= 0 {
+		return 0
+	}
+	return math.Round(plan.Price*float64(remaining)/float64(period)*100) / 100
+}
+
+// changeSubscriptionPlan switches plans immediately and starts a new period.
+// It holds the renewal lease meanwhile, so a renewal falling due can't
+// charge the card as well; ErrSubscriptionBusy means a renewal holds it.
+func changeSubscriptionPlan(ctx context.Context, sub *Subscription, newPlan SubscriptionPlan) error {
+	nextAttemptAt := sub.NextAttemptAt
+	claimed, err := claimSubscription(ctx, sub, time.Now().Add(renewalLease))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrSubscriptionBusy
+	}
+
+	if err := applyPlanChange(ctx, sub, newPlan); err != nil {
+		// Hand the subscription back to the renewal worker as it was
+		if releaseErr := updateSubscription(ctx, sub.ID, bson.M{"next_attempt_at": nextAttemptAt}); releaseErr != nil {
+			log.Printf("Error releasing subscription %s: %v", sub.ID.Hex(), releaseErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// applyPlanChange charges for the new plan and starts its period. The unused
+// part of the old plan is credited against the new one; any credit left over
+// goes to the user's wallet.
+func applyPlanChange(ctx context.Context, sub *Subscription, newPlan SubscriptionPlan) error {
+	oldPlan, ok := subscriptionPlans[sub.PlanID]
+	if !ok {
+		return fmt.Errorf("unknown plan %q", sub.PlanID)
+	}
+
+	now := time.Now()
+	amount := math.Round((newPlan.Price-prorationCredit(sub, oldPlan, now))*100) / 100
+	previousTransactionID := sub.LastTransactionID
+
+	var transactionID string
+	if amount > 0 {
+		item := CartItem{ID: newPlan.ID, Name: newPlan.Name + " (prorated)", Price: amount, Quantity: 1}
+		var err error
+		transactionID, err = chargeSubscription(ctx, sub, item, true)
+		if err != nil {
+			// Renewals must not retry the declined prorated charge
+			updateSubscription(ctx, sub.ID, bson.M{"pending_transaction_id": ""})
+			return err
+		}
+	} else {
+		// Nothing to charge: record the plan change as a zero-value transaction
+		transaction := Transaction{
+			CartItems:      []CartItem{{ID: newPlan.ID, Name: newPlan.Name + " (prorated)", Quantity: 1}},
+			Customer:       Customer{ID: sub.UserID},
+			Status:         "Completed",
+			PaymentMethod:  "credit",
+			SubscriptionID: sub.ID.Hex(),
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		err := withMongoTransaction(ctx, func(sc mongo.SessionContext) error {
+			res, err := client.Database(dbName).Collection(transactionCollection).InsertOne(sc, transaction)
+			if err != nil {
+				return err
+			}
+			transaction.ID = res.InsertedID.(primitive.ObjectID)
+			credit := toCents(-amount)
+			if credit == 0 {
+				return nil
+			}
+			_, err = postJournalEntry(sc, JournalEntry{
+				Kind:        "proration_credit",
+				Reference:   sub.ID.Hex(),
+				Description: fmt.Sprintf("Unused %s credited on change to %s", oldPlan.Name, newPlan.Name),
+				Lines: []JournalLine{
+					{Account: AccountRefunds, Amount: -credit},
+					{Account: WalletAccount(sub.UserID), Amount: credit},
+				},
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err := grantEntitlements(ctx, transaction); err != nil {
+			return err
+		}
+		transactionID = transaction.ID.Hex()
+	}
+
+	if err := startPeriod(ctx, sub, newPlan, transactionID, now); err != nil {
+		return err
+	}
+	if previousTransactionID != "" {
+		if err := revokeEntitlements(ctx, previousTransactionID, "plan_changed"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func subscriptionFromRequest(w http.ResponseWriter, r *http.Request, id string) (*Subscription, bool) {
+	subscriptionID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var sub Subscription
+	err = subscriptions().FindOne(r.Context(), bson.M{"_id": subscriptionID}).Decode(&sub)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to load subscription", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if _, code, err := authorizeUserAccess(r, sub.UserID); err != nil {
+		http.Error(w, err.Error(), code)
+		return nil, false
+	}
+	return &sub, true
+}
+
+func handleSubscriptionPlans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	plans := make([]SubscriptionPlan, 0, len(subscriptionPlans))
+	for _, plan := range subscriptionPlans {
+		plans = append(plans, plan)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plans)
+}
+
+// handleSubscriptions lists a user's subscriptions (GET ?user_id=) or
+// subscribes the caller to a plan, charging the first period right away (POST).
+func handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("user_id")
+		if _, code, err := authorizeUserAccess(r, userID); err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		cursor, err := subscriptions().Find(r.Context(), bson.M{"user_id": userID},
+			options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+		if err != nil {
+			http.Error(w, "Failed to load subscriptions", http.StatusInternalServerError)
+			return
+		}
+		list := []Subscription{}
+		if err := cursor.All(r.Context(), &list); err != nil {
+			http.Error(w, "Failed to load subscriptions", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	case http.MethodPost:
+		var req struct {
+			UserID    string `json:"userId"`
+			PlanID    string `json:"planID"`
+			CardToken string `json:"cardToken"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CardToken == "" {
+			http.Error(w, "planID and cardToken are required", http.StatusBadRequest)
+			return
+		}
+		// Users subscribe themselves; admins and API keys name the user
+		if req.UserID == "" {
+			if claims, err := auth.FromRequest(r); err == nil && !claims.IsAPIKey() {
+				req.UserID = claims.UserID
+			}
+		}
+		if _, code, err := authorizeUserAccess(r, req.UserID); err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		if req.UserID == "" {
+			http.Error(w, "userId is required", http.StatusBadRequest)
+			return
+		}
+		plan, ok := subscriptionPlans[req.PlanID]
+		if !ok {
+			http.Error(w, "Unknown plan", http.StatusBadRequest)
+			return
+		}
+
+		sub, err := createSubscription(r.Context(), req.UserID, plan, req.CardToken)
+		if err == ErrSubscriptionExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to subscribe: "+err.Error(), http.StatusPaymentRequired)
+			log.Println("Error creating subscription:", err)
+			return
+		}
+
+		auditSubscription(audit.FromHTTP(r), "subscription.create", sub.ID, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sub)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func createSubscription(ctx context.Context, userID string, plan SubscriptionPlan, cardToken string) (*Subscription, error) {
+	now := time.Now()
+	sub := &Subscription{
+		UserID:     userID,
+		PlanID:     plan.ID,
+		CardToken:  cardToken,
+		Status:     SubscriptionIncomplete,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		LiveUserID: userID,
+	}
+	// The unique index on live_user_id rejects a second live subscription,
+	// even when two requests race
+	res, err := subscriptions().InsertOne(ctx, sub)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrSubscriptionExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	sub.ID = res.InsertedID.(primitive.ObjectID)
+
+	// The customer is present for the first charge, so it goes through with the CVV
+	item := CartItem{ID: plan.ID, Name: plan.Name, Price: plan.Price, Quantity: 1}
+	transactionID, err := chargeSubscription(ctx, sub, item, false)
+	if err != nil {
+		updateSubscription(ctx, sub.ID, bson.M{"status": SubscriptionCanceled, "canceled_at": now, "last_error": err.Error()})
+		return nil, err
+	}
+	if err := startPeriod(ctx, sub, plan, transactionID, now); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// handleCancelSubscription stops renewal; access continues until the period ends.
+func handleCancelSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sub, ok := subscriptionFromRequest(w, r, r.URL.Query().Get("id"))
+	if !ok {
+		return
+	}
+	if sub.Status != SubscriptionActive && sub.Status != SubscriptionPastDue {
+		http.Error(w, fmt.Sprintf("Subscription in status %q cannot be canceled", sub.Status), http.StatusConflict)
+		return
+	}
+
+	if sub.Status == SubscriptionPastDue {
+		// Nothing was paid for the current period, so end it now
+		if err := updateSubscription(r.Context(), sub.ID, bson.M{"status": SubscriptionCanceled, "canceled_at": time.Now()}); err != nil {
+			http.Error(w, "Failed to cancel subscription", http.StatusInternalServerError)
+			return
+		}
+	} else if err := updateSubscription(r.Context(), sub.ID, bson.M{"cancel_at_period_end": true, "next_attempt_at": sub.CurrentPeriodEnd}); err != nil {
+		http.Error(w, "Failed to cancel subscription", http.StatusInternalServerError)
+		return
+	}
+
+	auditSubscription(audit.FromHTTP(r), "subscription.cancel", sub.ID, sub)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Subscription canceled",
+		"activeTo": sub.CurrentPeriodEnd,
+	})
+}
+
+func handleChangeSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		SubscriptionID string `json:"subscriptionID"`
+		PlanID         string `json:"planID"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	sub, ok := subscriptionFromRequest(w, r, req.SubscriptionID)
+	if !ok {
+		return
+	}
+	if sub.Status != SubscriptionActive {
+		http.Error(w, "Only active subscriptions can change plan", http.StatusConflict)
+		return
+	}
+	plan, ok := subscriptionPlans[req.PlanID]
+	if !ok || plan.ID == sub.PlanID {
+		http.Error(w, "Choose a different, existing plan", http.StatusBadRequest)
+		return
+	}
+
+	before := *sub
+	err := changeSubscriptionPlan(r.Context(), sub, plan)
+	if err == ErrSubscriptionBusy {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to change plan: "+err.Error(), http.StatusPaymentRequired)
+		log.Println("Error changing subscription plan:", err)
+		return
+	}
+	auditSubscription(audit.FromHTTP(r), "subscription.change_plan", sub.ID, &before)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sub)
+}
@@ -0,0 +1,99 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"web_backend_project/pkg/audit"
+)
+
+// usersPurgedSubject is published by the user service once deleted accounts
+// pass their retention period and are removed for good.
+const usersPurgedSubject = "users.purged"
+
+// anonymizedCustomerName replaces the name on transactions of purged users.
+// The transactions themselves stay: the ledger and reconciliation need them.
+const anonymizedCustomerName = "Deleted user"
+
+// userPurgesConsumer is the durable JetStream consumer of users.purged. The
+// user service deletes accounts only after the stream has stored the event,
+// so an event missed while this service was down is delivered on restart.
+const userPurgesConsumer = "transaction-user-purges"
+
+// subscribeUserPurges anonymizes transactions and cancels subscriptions of
+// purged users. The queue group keeps replicas from doing the work twice;
+// the message is acknowledged only once the work is done.
+func subscribeUserPurges() error {
+	_, err := jetStream.QueueSubscribe(usersPurgedSubject, userPurgesConsumer, func(msg *nats.Msg) {
+		var event struct {
+			IDs []string `json:"ids"`
+		}
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			log.Printf("Invalid %s message: %v", usersPurgedSubject, err)
+			msg.Term()
+			return
+		}
+		if len(event.IDs) == 0 {
+			msg.Ack()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		ctx = audit.WithMeta(ctx, audit.Meta{
+			Actor:     "user-service",
+			RequestID: msg.Header.Get(audit.RequestIDHeader),
+			Source:    audit.SourceNATS,
+		})
+		if err := anonymizeUsers(ctx, event.IDs); err != nil {
+			log.Printf("Failed to anonymize purged users: %v", err)
+			msg.NakWithDelay(30 * time.Second)
+			return
+		}
+		msg.Ack()
+	},
+		nats.Durable(userPurgesConsumer),
+		nats.ManualAck(),
+		nats.AckWait(time.Minute),
+	)
+	return err
+}
+
+func anonymizeUsers(ctx context.Context, userIDs []string) error {
+	now := time.Now()
+	collection := client.Database(dbName).Collection(transactionCollection)
+	result, err := collection.UpdateMany(ctx,
+		bson.M{"customer.id": bson.M{"$in": userIDs}},
+		bson.M{"$set": bson.M{
+			"customer.name":  anonymizedCustomerName,
+			"customer.email": "",
+			"updated_at":     now,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+
+	canceled, err := subscriptions().UpdateMany(ctx,
+		bson.M{"user_id": bson.M{"$in": userIDs}, "status": bson.M{"$ne": SubscriptionCanceled}},
+		bson.M{
+			"$set":   bson.M{"status": SubscriptionCanceled, "canceled_at": now, "updated_at": now},
+			"$unset": bson.M{"live_user_id": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		auditLog.Record(ctx, "transaction.anonymize", "user:"+userID, nil, nil)
+	}
+	log.Printf("Anonymized %d transactions and canceled %d subscriptions of %d purged users",
+		result.ModifiedCount, canceled.ModifiedCount, len(userIDs))
+	return nil
+}
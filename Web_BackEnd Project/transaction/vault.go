@@ -0,0 +1,330 @@
+package transaction
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var cardVaultCollection = "card_vault"
+
+var vault *CardVault
+
+// cvvTTL is how long an unused CVV is kept after tokenization. Checkout
+// normally follows within seconds; a CVV left behind by an abandoned checkout
+// is dropped by runCVVExpiry and ignored by reveal once it expires.
+const cvvTTL = 15 * time.Minute
+
+var (
+	// ErrCardTokenNotFound is returned when a token is unknown to the vault.
+	ErrCardTokenNotFound = errors.New("card token not found")
+	// ErrInvalidCard is returned when card details fail validation.
+	ErrInvalidCard = errors.New("invalid card")
+)
+
+// CardDetails are raw card data. They only exist in memory while a card is
+// being tokenized or authorized and must never be stored or logged.
+type CardDetails struct {
+	Number         string `json:"cardNumber"`
+	ExpirationDate string `json:"expirationDate"`
+	CVV            string `json:"cvv"`
+	Name           string `json:"name"`
+}
+
+// vaultRecord is what the vault persists: everything sensitive is encrypted,
+// only last4 is kept in the clear for receipts and support.
+type vaultRecord struct {
+	Token string `bson:"_id"`
+	Last4 string `bson:"last4"`
+	Card  []byte `bson:"card"`
+	CVV   []byte `bson:"cvv,omitempty"`
+	// CVVExpiresAt is when the CVV is dropped if no authorization used it
+	CVVExpiresAt *time.Time `bson:"cvv_expires_at,omitempty"`
+	CreatedAt    time.Time  `bson:"created_at"`
+}
+
+type sealedCard struct {
+	Number         string `json:"number"`
+	ExpirationDate string `json:"expiration_date"`
+	Name           string `json:"name"`
+}
+
+// CardVault tokenizes card details and keeps them encrypted with a locally
+// configured AES-256-GCM key.
+type CardVault struct {
+	collection *mongo.Collection
+	aead       cipher.AEAD
+}
+
+// NewCardVault creates a vault backed by collection and encrypted with key (32 bytes).
+func NewCardVault(collection *mongo.Collection, key []byte) (*CardVault, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("card vault key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &CardVault{collection: collection, aead: aead}, nil
+}
+
+// EnsureIndexes creates the index runCVVExpiry uses to find expired CVVs and
+// gives CVVs stored before they expired a deadline.
+func (v *CardVault) EnsureIndexes(ctx context.Context) error {
+	_, err := v.collection.UpdateMany(ctx,
+		bson.M{"cvv": bson.M{"$exists": true}, "cvv_expires_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"cvv_expires_at": time.Now().Add(cvvTTL)}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = v.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "cvv_expires_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+// loadVaultKey reads the base64-encoded key from CARD_VAULT_KEY.
+func loadVaultKey() ([]byte, error) {
+	encoded := getEnv("CARD_VAULT_KEY", "")
+	if encoded == "" {
+		return nil, fmt.Errorf("CARD_VAULT_KEY is not set")
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+func (v *CardVault) seal(token string, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// The token is bound as additional data so ciphertexts can't be swapped between records
+	return v.aead.Seal(nonce, nonce, plaintext, []byte(token)), nil
+}
+
+func (v *CardVault) open(token string, ciphertext []byte) ([]byte, error) {
+	size := v.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return v.aead.Open(nil, ciphertext[:size], ciphertext[size:], []byte(token))
+}
+
+func newCardToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "tok_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validateCard performs the checks we can do without talking to a gateway.
+func validateCard(card *CardDetails) error {
+	card.Number = strings.NewReplacer(" ", "", "-", "").Replace(card.Number)
+	if len(card.Number) < 12 || len(card.Number) > 19 || !luhnValid(card.Number) {
+		return fmt.Errorf("%w: bad card number", ErrInvalidCard)
+	}
+
+	if len(card.CVV) < 3 || len(card.CVV) > 4 {
+		return fmt.Errorf("%w: bad CVV", ErrInvalidCard)
+	}
+	if _, err := strconv.Atoi(card.CVV); err != nil {
+		return fmt.Errorf("%w: bad CVV", ErrInvalidCard)
+	}
+
+	expires, err := time.Parse("01/06", strings.TrimSpace(card.ExpirationDate))
+	if err != nil {
+		return fmt.Errorf("%w: expiration date must be MM/YY", ErrInvalidCard)
+	}
+	// Cards are valid through the last day of the expiration month
+	if time.Now().After(expires.AddDate(0, 1, 0)) {
+		return fmt.Errorf("%w: card has expired", ErrInvalidCard)
+	}
+	return nil
+}
+
+func luhnValid(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// Tokenize stores the card and returns an opaque token plus the last four digits.
+func (v *CardVault) Tokenize(ctx context.Context, card CardDetails) (string, string, error) {
+	if err := validateCard(&card); err != nil {
+		return "", "", err
+	}
+
+	token, err := newCardToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	plaintext, err := json.Marshal(sealedCard{Number: card.Number, ExpirationDate: card.ExpirationDate, Name: card.Name})
+	if err != nil {
+		return "", "", err
+	}
+	sealed, err := v.seal(token, plaintext)
+	if err != nil {
+		return "", "", err
+	}
+	sealedCVV, err := v.seal(token, []byte(card.CVV))
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	cvvExpiresAt := now.Add(cvvTTL)
+	record := vaultRecord{
+		Token:        token,
+		Last4:        card.Number[len(card.Number)-4:],
+		Card:         sealed,
+		CVV:          sealedCVV,
+		CVVExpiresAt: &cvvExpiresAt,
+		CreatedAt:    now,
+	}
+	if _, err := v.collection.InsertOne(ctx, record); err != nil {
+		return "", "", fmt.Errorf("failed to store card: %w", err)
+	}
+	return token, record.Last4, nil
+}
+
+// reveal decrypts the card for the gateway. The CVV is empty once it has been
+// discarded or has expired.
+func (v *CardVault) reveal(ctx context.Context, token string) (*CardDetails, error) {
+	var record vaultRecord
+	err := v.collection.FindOne(ctx, bson.M{"_id": token}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCardTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := v.open(token, record.Card)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt card: %w", err)
+	}
+	var sealed sealedCard
+	if err := json.Unmarshal(plaintext, &sealed); err != nil {
+		return nil, err
+	}
+
+	card := &CardDetails{Number: sealed.Number, ExpirationDate: sealed.ExpirationDate, Name: sealed.Name}
+	cvvExpired := record.CVVExpiresAt != nil && time.Now().After(*record.CVVExpiresAt)
+	if len(record.CVV) > 0 && !cvvExpired {
+		cvv, err := v.open(token, record.CVV)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt CVV: %w", err)
+		}
+		card.CVV = string(cvv)
+	}
+	return card, nil
+}
+
+// Last4 returns the last four digits of the tokenized card.
+func (v *CardVault) Last4(ctx context.Context, token string) (string, error) {
+	var record vaultRecord
+	err := v.collection.FindOne(ctx, bson.M{"_id": token}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrCardTokenNotFound
+	}
+	return record.Last4, err
+}
+
+// DiscardCVV removes the CVV once an authorization with it succeeded.
+// The token stays usable for merchant-initiated charges.
+func (v *CardVault) DiscardCVV(ctx context.Context, token string) error {
+	_, err := v.collection.UpdateOne(ctx, bson.M{"_id": token}, bson.M{"$unset": bson.M{"cvv": "", "cvv_expires_at": ""}})
+	return err
+}
+
+// expireCVVs drops CVVs that no authorization used within cvvTTL.
+func (v *CardVault) expireCVVs(ctx context.Context) (int64, error) {
+	res, err := v.collection.UpdateMany(ctx,
+		bson.M{"cvv_expires_at": bson.M{"$lte": time.Now()}},
+		bson.M{"$unset": bson.M{"cvv": "", "cvv_expires_at": ""}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func runCVVExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		count, err := vault.expireCVVs(context.Background())
+		if err != nil {
+			log.Println("Error expiring CVVs:", err)
+			continue
+		}
+		if count > 0 {
+			log.Printf("Dropped %d unused CVVs", count)
+		}
+	}
+}
+
+func handleTokenizeCard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var card CardDetails
+	if err := json.NewDecoder(r.Body).Decode(&card); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	token, last4, err := vault.Tokenize(r.Context(), card)
+	if errors.Is(err, ErrInvalidCard) {
+		// Validation errors never contain card data
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to tokenize card", http.StatusInternalServerError)
+		log.Println("Error tokenizing card:", err)
+		return
+	}
+
+	log.Printf("Card tokenized: %s (last4 %s)", token, last4)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"cardToken": token, "last4": last4})
+}